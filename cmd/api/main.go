@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -17,6 +18,7 @@ import (
 	"jd7008911/canlan.org/internal/config"
 	"jd7008911/canlan.org/internal/db"
 	"jd7008911/canlan.org/internal/handlers"
+	"jd7008911/canlan.org/internal/oracle"
 	"jd7008911/canlan.org/internal/services"
 )
 
@@ -44,28 +46,86 @@ func main() {
 	}
 
 	// Auth
-	walletAuth := auth.NewWalletAuth(cfg.JWT.Secret, redisClient)
+	authStore := auth.NewRedisStore(redisClient)
+	walletAuth := auth.NewWalletAuth(auth.WalletAuthOptions{
+		JWTSecret:         cfg.JWT.Secret,
+		Store:             authStore,
+		AccessExpiration:  cfg.JWT.AccessDuration,
+		RefreshExpiration: cfg.JWT.RefreshDuration,
+	})
+
+	// Price oracle
+	priceUpdater, err := newPriceUpdater(cfg, database)
+	if err != nil {
+		log.Fatal("failed to configure price oracle:", err)
+	}
+	oracleCtx, stopOracle := context.WithCancel(context.Background())
+	defer stopOracle()
+	go priceUpdater.Run(oracleCtx)
+
+	emission, err := services.EmissionScheduleFromConfig(cfg.Emission)
+	if err != nil {
+		log.Fatal("failed to load emission schedule:", err)
+	}
 
 	// Services
-	referralSvc := services.NewReferralService(database.Queries)
-	combatSvc := services.NewCombatPowerService(database.Queries)
-	burnSvc := services.NewBurnService(database.Queries, combatSvc)
-	blockRewardSvc := services.NewBlockRewardService(database.Queries)
-	miningSvc := services.NewMiningService(database.Queries)
-	lpSvc := services.NewLPService(database.Queries)
-	purchaseSvc := services.NewPurchaseService(database.Queries)
-	swapSvc := services.NewSwapService(database.Queries)
-	assetSvc := services.NewAssetService(database.Queries)
-	withdrawalSvc := services.NewWithdrawalService(database.Queries)
-	nodeSvc := services.NewNodeService(database.Queries, referralSvc)
-	badgeSvc := services.NewBadgeService(database.Queries)
-	governanceSvc := services.NewGovernanceService(database.Queries)
+	ledgerSvc := services.NewLedgerService(database.Queries, database)
+	vestingSvc := services.NewVestingService(database.Queries, database, cfg, ledgerSvc)
+	assetSvc := services.NewAssetService(database.Queries, database, cfg, priceUpdater)
+	referralSvc := services.NewReferralService(database.Queries, database, cfg)
+	riskSvc := services.NewReferralRiskService(database.Queries, database, cfg, vestingSvc)
+	combatSvc := services.NewCombatPowerService(database.Queries, database, cfg)
+	nodeSvc := services.NewNodeService(database.Queries, referralSvc, combatSvc, cfg)
+	badgeSvc := services.NewBadgeService(database.Queries, database, assetSvc, combatSvc, ledgerSvc)
+	burnSvc := services.NewBurnService(database.Queries, database, combatSvc, ledgerSvc)
+	commissionSvc := services.NewCommissionService(database.Queries, database, cfg, badgeSvc, vestingSvc, riskSvc, nodeSvc)
+	blockRewardSvc := services.NewBlockRewardService(database.Queries, database, cfg, emission, ledgerSvc, vestingSvc, commissionSvc)
+	miningSvc := services.NewMiningService(database.Queries, assetSvc, combatSvc, badgeSvc, ledgerSvc, vestingSvc, commissionSvc, nodeSvc)
+	lpSvc := services.NewLPService(database.Queries, database, assetSvc, combatSvc, ledgerSvc)
+	purchaseSvc := services.NewPurchaseService(database.Queries, database, assetSvc, nodeSvc, badgeSvc, combatSvc, ledgerSvc, vestingSvc, commissionSvc)
+	swapSvc := services.NewSwapService(database.Queries, database, cfg, assetSvc, ledgerSvc, nodeSvc)
+	withdrawalSvc := services.NewWithdrawalService(database.Queries, database, assetSvc, ledgerSvc)
+	governanceSvc := services.NewGovernanceService(database.Queries, database, badgeSvc, combatSvc, blockRewardSvc, nodeSvc)
+
+	// Background jobs
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	go assetSvc.RunPortfolioSnapshots(jobsCtx)
+	go vestingSvc.RunReleases(jobsCtx)
+	go combatSvc.RunCombatPowerSnapshots(jobsCtx)
+	go nodeSvc.RunTeamStatsRepair(jobsCtx)
+
+	// Block producer; on shutdown wait for it to finish the block in hand
+	// and release its lock so another replica can take over straight away.
+	producerDone := make(chan struct{})
+	if cfg.App.MintProducer {
+		producer := services.NewBlockProducer(database.Queries, database, blockRewardSvc, cfg)
+		go func() {
+			defer close(producerDone)
+			producer.Run(jobsCtx)
+		}()
+	} else {
+		close(producerDone)
+	}
 
 	// Handlers
-	authHandler := handlers.NewAuthHandler(walletAuth, database.Queries, referralSvc)
+	authHandler := handlers.NewAuthHandler(walletAuth, database.Queries, referralSvc, riskSvc, combatSvc, cfg.Server.TrustedProxies)
 	dashboardHandler := handlers.NewDashboardHandler(database.Queries, combatSvc, blockRewardSvc, assetSvc)
 	burnHandler := handlers.NewBurnHandler(burnSvc, database.Queries)
-	// ... initialize all handlers
+	assetHandler := handlers.NewAssetHandler(assetSvc, ledgerSvc)
+	vestingHandler := handlers.NewVestingHandler(vestingSvc)
+	referralRiskHandler := handlers.NewReferralRiskHandler(riskSvc)
+	swapHandler := handlers.NewSwapHandler(swapSvc)
+	lpHandler := handlers.NewLPHandler(lpSvc)
+	blockRewardHandler := handlers.NewBlockRewardHandler(blockRewardSvc)
+	governanceHandler := handlers.NewGovernanceHandler(governanceSvc, walletAuth, nodeSvc)
+	combatHandler := handlers.NewCombatHandler(combatSvc)
+	nodeHandler := handlers.NewNodeHandler(nodeSvc)
+	referralHandler := handlers.NewReferralHandler(referralSvc, nodeSvc)
+	purchaseHandler := handlers.NewPurchaseHandler(purchaseSvc)
+	withdrawalHandler := handlers.NewWithdrawalHandler(withdrawalSvc)
+	badgeHandler := handlers.NewBadgeHandler(badgeSvc)
+	miningHandler := handlers.NewMiningHandler(miningSvc)
 
 	// Router
 	r := chi.NewRouter()
@@ -75,7 +135,8 @@ func main() {
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins: []string{"*"},
 		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders: []string{"Accept", "Authorization", "Content-Type"},
+		AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", auth.IdempotencyKeyHeader},
+		ExposedHeaders: []string{auth.IdempotentReplayedHeader},
 	}))
 
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -85,13 +146,38 @@ func main() {
 
 	r.Route("/api/v1", func(r chi.Router) {
 		authHandler.RegisterRoutes(r)
+		badgeHandler.RegisterPublicRoutes(r)
+		swapHandler.RegisterPublicRoutes(r)
+		lpHandler.RegisterPublicRoutes(r)
+		blockRewardHandler.RegisterPublicRoutes(r)
+		governanceHandler.RegisterPublicRoutes(r)
+		nodeHandler.RegisterPublicRoutes(r)
+		referralHandler.RegisterPublicRoutes(r)
 
 		r.Group(func(r chi.Router) {
 			r.Use(walletAuth.AuthMiddleware)
+			// Retried POSTs (swaps, liquidity, purchases, withdrawals, burns)
+			// replay the first response instead of running again. Handlers
+			// that move funds must be registered here to get the same
+			// protection.
+			r.Use(auth.Idempotency(authStore, cfg.App.IdempotencyTTL))
 
 			dashboardHandler.RegisterRoutes(r)
 			burnHandler.RegisterRoutes(r)
-			// ... register other handlers
+			assetHandler.RegisterRoutes(r)
+			vestingHandler.RegisterRoutes(r)
+			referralRiskHandler.RegisterRoutes(r)
+			swapHandler.RegisterRoutes(r)
+			lpHandler.RegisterRoutes(r)
+			blockRewardHandler.RegisterRoutes(r)
+			governanceHandler.RegisterRoutes(r)
+			combatHandler.RegisterRoutes(r)
+			nodeHandler.RegisterRoutes(r)
+			referralHandler.RegisterRoutes(r)
+			purchaseHandler.RegisterRoutes(r)
+			withdrawalHandler.RegisterRoutes(r)
+			badgeHandler.RegisterRoutes(r)
+			miningHandler.RegisterRoutes(r)
 		})
 	})
 
@@ -113,6 +199,9 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
+	stopOracle()
+	stopJobs()
+	<-producerDone
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -120,3 +209,35 @@ func main() {
 		log.Fatal("server forced to shutdown:", err)
 	}
 }
+
+// newPriceUpdater builds the oracle feeds named in ORACLE_FEEDS and the
+// updater that aggregates them into token prices.
+func newPriceUpdater(cfg *config.Config, database *db.Database) (*oracle.Updater, error) {
+	var feeds []oracle.PriceFeed
+	for _, name := range cfg.Oracle.Feeds {
+		switch name {
+		case "static":
+			prices, err := oracle.ParseStaticPrices(cfg.Oracle.StaticPrices)
+			if err != nil {
+				return nil, err
+			}
+			feeds = append(feeds, oracle.NewStaticFeed(prices))
+		case "amm":
+			feeds = append(feeds, oracle.NewAMMFeed(database.Queries, cfg.Oracle.AnchorSymbol, cfg.Oracle.TWAPWindow))
+		case "http":
+			if cfg.Oracle.HTTPURL == "" {
+				return nil, fmt.Errorf("ORACLE_HTTP_URL is required for the http feed")
+			}
+			feeds = append(feeds, oracle.NewHTTPFeed("http", cfg.Oracle.HTTPURL, cfg.Oracle.HTTPTimeout))
+		default:
+			return nil, fmt.Errorf("unknown price feed %q", name)
+		}
+	}
+
+	agg := oracle.NewAggregator(feeds, oracle.AggregatorOptions{
+		MaxAge:          cfg.Oracle.MaxPriceAge,
+		MaxDeviationBps: cfg.Oracle.MaxDeviationBps,
+		MinSources:      cfg.Oracle.MinSources,
+	})
+	return oracle.NewUpdater(database.Queries, database, agg, cfg.Oracle.Interval), nil
+}
@@ -0,0 +1,105 @@
+// Command combat-recompute re-evaluates every user's personal combat power
+// under a formula and reports what changes. By default it is a dry run
+// against the active formula; -dry-run=false stores the new values.
+//
+// Burn power decays with age when the formula sets a burn half-life, while
+// stored combat power only changes when a user is recomputed, so run this
+// periodically (e.g. daily) to keep decayed burns current.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"jd7008911/canlan.org/internal/config"
+	"jd7008911/canlan.org/internal/db"
+	"jd7008911/canlan.org/internal/services"
+)
+
+func main() {
+	version := flag.Int("version", 0, "stored formula version to evaluate (default: the active one)")
+	file := flag.String("file", "", "evaluate an unsaved formula definition from this JSON file (dry run only)")
+	dryRun := flag.Bool("dry-run", true, "report changes without storing them")
+	top := flag.Int("top", 20, "how many of the largest changes to list (-1 for all)")
+	asJSON := flag.Bool("json", false, "print the full report as JSON")
+	timeout := flag.Duration("timeout", 30*time.Minute, "maximum time to spend recomputing")
+	flag.Parse()
+
+	if *file != "" && *version != 0 {
+		log.Fatal("-file and -version are mutually exclusive")
+	}
+	if *file != "" && !*dryRun {
+		log.Fatal("an unsaved formula can only be dry run; activate it through governance first")
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("failed to load config:", err)
+	}
+
+	database, err := db.NewDatabase(&cfg.Database)
+	if err != nil {
+		log.Fatal("failed to connect to db:", err)
+	}
+	defer database.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	combatSvc := services.NewCombatPowerService(database.Queries, database, cfg)
+
+	var formula *services.VersionedFormula
+	switch {
+	case *file != "":
+		raw, err := os.ReadFile(*file)
+		if err != nil {
+			log.Fatal("failed to read formula:", err)
+		}
+		f, err := services.DecodeCombatFormula(raw)
+		if err != nil {
+			log.Fatal(err)
+		}
+		formula = &services.VersionedFormula{CombatFormula: *f}
+	case *version != 0:
+		formula, err = combatSvc.GetFormula(ctx, int32(*version))
+	default:
+		formula, err = combatSvc.ActiveFormula(ctx)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report, err := combatSvc.RecomputeAll(ctx, formula, *dryRun, *top)
+	if err != nil {
+		log.Fatal("recompute failed:", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		for _, c := range report.TopChanges {
+			fmt.Printf("user=%s before=%s after=%s delta=%s\n",
+				c.UserID, c.Before.String(), c.After.String(), c.Delta.String())
+		}
+	}
+
+	mode := "applied"
+	if report.DryRun {
+		mode = "dry run"
+	}
+	fmt.Printf("formula v%d (%s): %d users, %d changed, %d failed, total %s -> %s\n",
+		report.FormulaVersion, mode, report.Users, report.Changed, report.Failed,
+		report.TotalBefore.String(), report.TotalAfter.String())
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -789,6 +789,32 @@ func main() {
 			json.NewEncoder(w).Encode(history)
 		})
 
+		r.Get("/combat/explain", func(w http.ResponseWriter, r *http.Request) {
+			u, err := parseUser(r)
+			if err != nil {
+				web.Unauthorized(w, "user not authenticated")
+				return
+			}
+			// breakdown of the fabricated /combat/personal record
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"user_id":             u["id"],
+				"formula_version":     1,
+				"holdings":            []map[string]string{{"symbol": "CAN", "balance": "108.45", "weight": "1", "power": "108.45"}},
+				"holdings_power":      "108.45",
+				"lp_pools":            []map[string]string{},
+				"lp_weight":           "10.00",
+				"burns":               []map[string]string{},
+				"burn_power":          "5.00",
+				"base_power":          "123.45",
+				"badges":              []map[string]string{},
+				"stacking":            "multiplicative",
+				"multiplier_cap":      "0",
+				"uncapped_multiplier": "1",
+				"multiplier":          "1",
+				"personal_power":      "123.45",
+			})
+		})
+
 		r.Post("/combat/refresh", func(w http.ResponseWriter, r *http.Request) {
 			u, err := parseUser(r)
 			if err != nil {
@@ -0,0 +1,56 @@
+// cmd/pricefeed-stub serves fake prices in the format the oracle's http feed
+// expects, for running the API locally without an external price provider:
+//
+//	go run ./cmd/pricefeed-stub -prices "USDT=1,CAN=0.25" -jitter 50
+//	ORACLE_FEEDS=static,amm,http ORACLE_HTTP_URL=http://localhost:8090/prices
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/oracle"
+)
+
+func main() {
+	addr := flag.String("addr", ":8090", "listen address")
+	spec := flag.String("prices", "USDT=1,CAN=0.25,LAN=0.1", "base prices as SYMBOL=PRICE,...")
+	jitter := flag.Int("jitter", 0, "random deviation applied to each price, in basis points")
+	flag.Parse()
+
+	prices, err := oracle.ParseStaticPrices(*spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	http.HandleFunc("/prices", func(w http.ResponseWriter, r *http.Request) {
+		out := make(map[string]decimal.Decimal)
+		for _, symbol := range strings.Split(r.URL.Query().Get("symbols"), ",") {
+			symbol = strings.ToUpper(strings.TrimSpace(symbol))
+			price, ok := prices[symbol]
+			if !ok {
+				continue
+			}
+			if *jitter > 0 {
+				bps := rand.Intn(2**jitter+1) - *jitter
+				price = price.Mul(decimal.NewFromInt(int64(10000 + bps))).Div(decimal.NewFromInt(10000))
+			}
+			out[symbol] = price
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"timestamp": time.Now().Unix(),
+			"prices":    out,
+		})
+	})
+
+	log.Printf("price feed stub listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
@@ -0,0 +1,68 @@
+// Command reconcile proves that every user balance equals the sum of its
+// ledger postings and that every journal entry balances. It exits non-zero
+// when any discrepancy is found, so it can run as a scheduled check.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"jd7008911/canlan.org/internal/config"
+	"jd7008911/canlan.org/internal/db"
+	"jd7008911/canlan.org/internal/services"
+)
+
+func main() {
+	asJSON := flag.Bool("json", false, "print the full report as JSON")
+	timeout := flag.Duration("timeout", 5*time.Minute, "maximum time to spend reconciling")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("failed to load config:", err)
+	}
+
+	database, err := db.NewDatabase(&cfg.Database)
+	if err != nil {
+		log.Fatal("failed to connect to db:", err)
+	}
+	defer database.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	ledgerSvc := services.NewLedgerService(database.Queries, database)
+	report, err := ledgerSvc.Reconcile(ctx)
+	if err != nil {
+		log.Fatal("reconciliation failed:", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		for _, m := range report.BalanceMismatches {
+			fmt.Printf("balance mismatch: user=%s token=%s projected=%s ledger=%s\n",
+				m.UserID, m.TokenID, m.ProjectedBalance.String(), m.LedgerBalance.String())
+		}
+		for _, e := range report.UnbalancedEntries {
+			fmt.Printf("unbalanced entry: entry=%s token=%s imbalance=%s\n",
+				e.EntryID, e.TokenID, e.Imbalance.String())
+		}
+	}
+
+	if !report.OK() {
+		fmt.Printf("reconciliation FAILED: %d balance mismatches, %d unbalanced entries\n",
+			len(report.BalanceMismatches), len(report.UnbalancedEntries))
+		os.Exit(1)
+	}
+	fmt.Println("reconciliation OK: all balances match their postings")
+}
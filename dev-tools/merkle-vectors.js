@@ -0,0 +1,85 @@
+// dev-tools/merkle-vectors.js
+//
+// Regenerates internal/merkle/testdata/vectors.json, the golden vectors the
+// Go Merkle tree is checked against. Leaves and inner nodes are hashed with
+// ethers' solidityPackedKeccak256, which is Solidity's
+// keccak256(abi.encodePacked(...)), so the vectors pin the encoding the
+// claim contract verifies:
+//
+//   leaf = keccak256(abi.encodePacked(address account, uint256 cumulativeAmount))
+//   node = keccak256(abi.encodePacked(min(a, b), max(a, b)))
+//
+// A node without a sibling is carried up to the next level unchanged.
+//
+// Usage: node dev-tools/merkle-vectors.js > internal/merkle/testdata/vectors.json
+const { solidityPackedKeccak256, getAddress, MaxUint256 } = require("ethers");
+
+function leaf(account, amount) {
+  return solidityPackedKeccak256(["address", "uint256"], [account, amount]);
+}
+
+function hashPair(a, b) {
+  const [lo, hi] = BigInt(a) <= BigInt(b) ? [a, b] : [b, a];
+  return solidityPackedKeccak256(["bytes32", "bytes32"], [lo, hi]);
+}
+
+function levels(leaves) {
+  const out = [leaves];
+  let level = leaves;
+  while (level.length > 1) {
+    const next = [];
+    for (let i = 0; i < level.length; i += 2) {
+      next.push(i + 1 === level.length ? level[i] : hashPair(level[i], level[i + 1]));
+    }
+    out.push(next);
+    level = next;
+  }
+  return out;
+}
+
+function proof(tree, i) {
+  const siblings = [];
+  for (const level of tree.slice(0, -1)) {
+    const sibling = i ^ 1;
+    if (sibling < level.length) siblings.push(level[sibling]);
+    i = Math.floor(i / 2);
+  }
+  return siblings;
+}
+
+const accounts = [
+  "0x1111111111111111111111111111111111111111",
+  "0x2222222222222222222222222222222222222222",
+  "0x0000000000000000000000000000000000000001",
+  "0xffffffffffffffffffffffffffffffffffffffff",
+  "0xAb5801a7D398351b8bE11C439e05C5B3259aeC9B",
+  "0x5B38Da6a701c568545dCfcB03FcB875f56beddC4",
+  "0x70997970C51812dc3A010C7d01b50e0d17dc79C8",
+];
+
+const amounts = [
+  "1000000000000000000", // 1 token
+  "0",
+  "1",
+  MaxUint256.toString(),
+  "123456789012345678901234567890",
+  "500000000000000000", // 0.5 token
+  "42",
+];
+
+const cases = [1, 2, 3, 5, 7].map((n) => {
+  const entries = accounts.slice(0, n).map((account, i) => ({
+    account: getAddress(account),
+    amount: amounts[i],
+    leaf: leaf(account, amounts[i]),
+  }));
+  const tree = levels(entries.map((e) => e.leaf));
+  return {
+    name: n === 1 ? "1 leaf" : `${n} leaves`,
+    leaves: entries,
+    root: tree[tree.length - 1][0],
+    proofs: entries.map((_, i) => proof(tree, i)),
+  };
+});
+
+process.stdout.write(JSON.stringify({ cases }, null, 2) + "\n");
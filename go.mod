@@ -8,6 +8,7 @@ require (
 	github.com/go-chi/cors v1.2.1
 	github.com/golang-jwt/jwt/v5 v5.0.0
 	github.com/google/uuid v1.3.1
+	github.com/jackc/pgx/v5 v5.5.0
 	github.com/redis/go-redis/v9 v9.2.1
 	github.com/shopspring/decimal v1.3.1
 )
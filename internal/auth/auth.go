@@ -121,6 +121,20 @@ func (a *WalletAuth) GenerateNonce(ctx context.Context, wallet string) (string,
 	return nonce, nil
 }
 
+// ConsumeNonce returns the wallet's pending nonce and deletes it, so each
+// nonce can be signed for at most one login.
+func (a *WalletAuth) ConsumeNonce(ctx context.Context, wallet string) (string, error) {
+	key := fmt.Sprintf("nonce:%s", strings.ToLower(wallet))
+	nonce, err := a.store.Get(ctx, key)
+	if err != nil || nonce == "" {
+		return "", ErrNonceExpired
+	}
+	if err := a.store.Delete(ctx, key); err != nil {
+		return "", fmt.Errorf("failed to delete nonce: %w", err)
+	}
+	return nonce, nil
+}
+
 // VerifySignature checks that the signature was signed by the wallet's private key.
 func (a *WalletAuth) VerifySignature(wallet, signature, nonce string) error {
 	// Prepare Ethereum signed message hash
@@ -366,30 +380,48 @@ func GetWallet(ctx context.Context) (string, bool) {
 // ---------------------------------------------------------------------
 
 type MemoryStore struct {
-	data map[string]string
+	data map[string]memoryEntry
 	mu   sync.RWMutex
 }
 
+// memoryEntry is a stored value and when it expires; a zero expiry never
+// expires, like a Redis key set without a TTL.
+type memoryEntry struct {
+	value     string
+	expiresAt time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
 func NewMemoryStore() *MemoryStore {
-	return &MemoryStore{data: make(map[string]string)}
+	return &MemoryStore{data: make(map[string]memoryEntry)}
+}
+
+func newMemoryEntry(value interface{}, ttl time.Duration) memoryEntry {
+	e := memoryEntry{value: fmt.Sprintf("%v", value)}
+	if ttl > 0 {
+		e.expiresAt = time.Now().Add(ttl)
+	}
+	return e
 }
 
 func (m *MemoryStore) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.data[key] = fmt.Sprintf("%v", value)
-	// In a real implementation you would handle TTL; for simplicity we ignore.
+	m.data[key] = newMemoryEntry(value, ttl)
 	return nil
 }
 
 func (m *MemoryStore) Get(ctx context.Context, key string) (string, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	val, ok := m.data[key]
-	if !ok {
+	e, ok := m.data[key]
+	if !ok || e.expired(time.Now()) {
 		return "", nil
 	}
-	return val, nil
+	return e.value, nil
 }
 
 func (m *MemoryStore) Delete(ctx context.Context, key string) error {
@@ -398,3 +430,13 @@ func (m *MemoryStore) Delete(ctx context.Context, key string) error {
 	delete(m.data, key)
 	return nil
 }
+
+func (m *MemoryStore) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.data[key]; ok && !e.expired(time.Now()) {
+		return false, nil
+	}
+	m.data[key] = newMemoryEntry(value, ttl)
+	return true, nil
+}
@@ -0,0 +1,221 @@
+// internal/auth/idempotency.go
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------
+// Idempotency Keys
+// ---------------------------------------------------------------------
+
+const (
+	// IdempotencyKeyHeader is the request header carrying the client's key.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on responses served from the store.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+	maxIdempotentBodySize   = 1 << 20 // 1 MiB
+)
+
+// AtomicStore is implemented by stores that can create a key only if it is
+// absent. RedisStore and MemoryStore both implement it; with a plain Store
+// two simultaneous requests with the same key could both be executed.
+type AtomicStore interface {
+	SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error)
+}
+
+// idempotencyRecord is what is stored under an idempotency key. While the
+// first request is running it holds only the fingerprint; once it finishes
+// it holds the response that is replayed to retries.
+type idempotencyRecord struct {
+	Fingerprint string `json:"fingerprint"`
+	Completed   bool   `json:"completed"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// Idempotency returns a middleware that makes mutating requests safe to
+// retry. A POST/PUT/PATCH/DELETE carrying an Idempotency-Key header is
+// executed once; retries with the same key and payload get the original
+// response back, and a key reused with a different payload, or while the
+// first request is still running, gets 409 Conflict. Keys are scoped to
+// the authenticated user, so the middleware must run after AuthMiddleware.
+//
+// Responses with a 5xx status are not stored, so the client may retry them.
+// Requests without the header are passed through unchanged.
+func Idempotency(store Store, ttl time.Duration) func(http.Handler) http.Handler {
+	if ttl == 0 {
+		ttl = 24 * time.Hour
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || !isMutatingMethod(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				http.Error(w, "Idempotency-Key too long", http.StatusBadRequest)
+				return
+			}
+
+			userID, ok := GetUserID(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			// Read the body for the fingerprint and hand the handler a fresh copy
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBodySize+1))
+			if err != nil {
+				http.Error(w, "Failed to read request body", http.StatusBadRequest)
+				return
+			}
+			if len(body) > maxIdempotentBodySize {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			ctx := r.Context()
+			storeKey := fmt.Sprintf("idempotency:%s:%s", userID, key)
+			fingerprint := requestFingerprint(r, body)
+
+			pending, _ := json.Marshal(idempotencyRecord{Fingerprint: fingerprint})
+			acquired, err := reserveKey(ctx, store, storeKey, string(pending), ttl)
+			if err != nil {
+				// Without the store we cannot tell a retry from a first attempt
+				http.Error(w, "Idempotency store unavailable", http.StatusServiceUnavailable)
+				return
+			}
+
+			if !acquired {
+				replayIdempotent(w, ctx, store, storeKey, fingerprint)
+				return
+			}
+
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			defer func() {
+				// A panic or server error releases the key so the request can be retried
+				if p := recover(); p != nil {
+					store.Delete(context.Background(), storeKey)
+					panic(p)
+				}
+				if rec.status >= http.StatusInternalServerError {
+					store.Delete(context.Background(), storeKey)
+					return
+				}
+				done, _ := json.Marshal(idempotencyRecord{
+					Fingerprint: fingerprint,
+					Completed:   true,
+					Status:      rec.status,
+					ContentType: rec.Header().Get("Content-Type"),
+					Body:        rec.body.Bytes(),
+				})
+				store.Set(context.Background(), storeKey, string(done), ttl)
+			}()
+
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// reserveKey claims storeKey for a new request. It reports false if the key
+// is already in use.
+func reserveKey(ctx context.Context, store Store, key, value string, ttl time.Duration) (bool, error) {
+	if atomic, ok := store.(AtomicStore); ok {
+		return atomic.SetNX(ctx, key, value, ttl)
+	}
+	existing, err := store.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if existing != "" {
+		return false, nil
+	}
+	return true, store.Set(ctx, key, value, ttl)
+}
+
+// replayIdempotent answers a request whose key is already in use.
+func replayIdempotent(w http.ResponseWriter, ctx context.Context, store Store, key, fingerprint string) {
+	raw, err := store.Get(ctx, key)
+	if err != nil {
+		http.Error(w, "Idempotency store unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var record idempotencyRecord
+	if raw == "" || json.Unmarshal([]byte(raw), &record) != nil {
+		// The key expired or was released between reserve and read
+		http.Error(w, "Request with this Idempotency-Key is being retried, try again", http.StatusConflict)
+		return
+	}
+
+	if record.Fingerprint != fingerprint {
+		http.Error(w, "Idempotency-Key was already used with a different request", http.StatusConflict)
+		return
+	}
+	if !record.Completed {
+		http.Error(w, "Request with this Idempotency-Key is still being processed", http.StatusConflict)
+		return
+	}
+
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(record.Status)
+	w.Write(record.Body)
+}
+
+// requestFingerprint identifies a request by method, path and body.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func isMutatingMethod(method string) bool {
+	switch strings.ToUpper(method) {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// responseRecorder passes the response through to the client while keeping
+// a copy of the status and body for the idempotency store.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,235 @@
+// internal/auth/idempotency_test.go
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// idempotentRequest builds a request authenticated as userID.
+func idempotentRequest(userID uuid.UUID, method, key, body string) *http.Request {
+	r := httptest.NewRequest(method, "/api/v1/swaps/execute", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set(IdempotencyKeyHeader, key)
+	}
+	return r.WithContext(context.WithValue(r.Context(), UserIDKey, userID))
+}
+
+// countingHandler answers 201 with a body numbering each execution.
+func countingHandler(calls *int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"execution":` + strconv.Itoa(int(n)) + `}`))
+	})
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	var calls int32
+	h := Idempotency(NewMemoryStore(), time.Hour)(countingHandler(&calls))
+	user := uuid.New()
+
+	first := serve(h, idempotentRequest(user, http.MethodPost, "key-1", `{"amount":"10"}`))
+	if first.Code != http.StatusCreated || first.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Fatalf("first request = %d replayed=%q, want 201 not replayed", first.Code, first.Header().Get(IdempotentReplayedHeader))
+	}
+
+	retry := serve(h, idempotentRequest(user, http.MethodPost, "key-1", `{"amount":"10"}`))
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want 1", calls)
+	}
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry = %d %q, want %d %q", retry.Code, retry.Body, first.Code, first.Body)
+	}
+	if retry.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Errorf("retry is missing the %s header", IdempotentReplayedHeader)
+	}
+	if got := retry.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("retry Content-Type = %q, want application/json", got)
+	}
+}
+
+func TestIdempotencyConflicts(t *testing.T) {
+	var calls int32
+	h := Idempotency(NewMemoryStore(), time.Hour)(countingHandler(&calls))
+	user := uuid.New()
+	serve(h, idempotentRequest(user, http.MethodPost, "key-1", `{"amount":"10"}`))
+
+	tests := []struct {
+		name string
+		req  *http.Request
+	}{
+		{"different body", idempotentRequest(user, http.MethodPost, "key-1", `{"amount":"11"}`)},
+		{"different method", idempotentRequest(user, http.MethodPut, "key-1", `{"amount":"10"}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(h, tt.req); w.Code != http.StatusConflict {
+				t.Errorf("status = %d, want 409", w.Code)
+			}
+		})
+	}
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want 1", calls)
+	}
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var calls int32
+	h := Idempotency(NewMemoryStore(), time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+	user := uuid.New()
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- serve(h, idempotentRequest(user, http.MethodPost, "key-1", `{}`)) }()
+	<-started
+
+	if w := serve(h, idempotentRequest(user, http.MethodPost, "key-1", `{}`)); w.Code != http.StatusConflict {
+		t.Errorf("concurrent retry status = %d, want 409", w.Code)
+	}
+	close(release)
+	if w := <-done; w.Code != http.StatusCreated {
+		t.Errorf("first request status = %d, want 201", w.Code)
+	}
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want 1", calls)
+	}
+}
+
+func TestIdempotencyReleasesFailedRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		fail http.HandlerFunc
+	}{
+		{"server error", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+		}},
+		{"panic", func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			failed := false
+			h := Idempotency(NewMemoryStore(), time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !failed {
+					failed = true
+					tt.fail(w, r)
+					return
+				}
+				countingHandler(&calls).ServeHTTP(w, r)
+			}))
+			user := uuid.New()
+
+			func() {
+				defer func() { recover() }()
+				serve(h, idempotentRequest(user, http.MethodPost, "key-1", `{}`))
+			}()
+
+			retry := serve(h, idempotentRequest(user, http.MethodPost, "key-1", `{}`))
+			if retry.Code != http.StatusCreated || calls != 1 {
+				t.Errorf("retry = %d after %d executions, want a fresh 201", retry.Code, calls)
+			}
+			if retry.Header().Get(IdempotentReplayedHeader) != "" {
+				t.Errorf("retry of a failed request was replayed")
+			}
+		})
+	}
+}
+
+func TestIdempotencyStoresClientErrors(t *testing.T) {
+	var calls int32
+	h := Idempotency(NewMemoryStore(), time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "insufficient balance", http.StatusBadRequest)
+	}))
+	user := uuid.New()
+
+	serve(h, idempotentRequest(user, http.MethodPost, "key-1", `{}`))
+	retry := serve(h, idempotentRequest(user, http.MethodPost, "key-1", `{}`))
+	if retry.Code != http.StatusBadRequest || retry.Header().Get(IdempotentReplayedHeader) != "true" || calls != 1 {
+		t.Errorf("retry = %d replayed=%q after %d executions, want replayed 400 after 1",
+			retry.Code, retry.Header().Get(IdempotentReplayedHeader), calls)
+	}
+}
+
+func TestIdempotencyScopedPerUser(t *testing.T) {
+	var calls int32
+	h := Idempotency(NewMemoryStore(), time.Hour)(countingHandler(&calls))
+
+	for _, user := range []uuid.UUID{uuid.New(), uuid.New()} {
+		w := serve(h, idempotentRequest(user, http.MethodPost, "shared-key", `{}`))
+		if w.Code != http.StatusCreated || w.Header().Get(IdempotentReplayedHeader) != "" {
+			t.Errorf("user %s: status = %d replayed=%q, want a fresh 201", user, w.Code, w.Header().Get(IdempotentReplayedHeader))
+		}
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want once per user", calls)
+	}
+}
+
+func TestIdempotencyKeyExpires(t *testing.T) {
+	var calls int32
+	h := Idempotency(NewMemoryStore(), 20*time.Millisecond)(countingHandler(&calls))
+	user := uuid.New()
+
+	serve(h, idempotentRequest(user, http.MethodPost, "key-1", `{}`))
+	time.Sleep(40 * time.Millisecond)
+	if w := serve(h, idempotentRequest(user, http.MethodPost, "key-1", `{}`)); w.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Errorf("request after the TTL was replayed")
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2", calls)
+	}
+}
+
+func TestIdempotencyPassThrough(t *testing.T) {
+	user := uuid.New()
+	anonymous := httptest.NewRequest(http.MethodPost, "/api/v1/swaps/execute", nil)
+	anonymous.Header.Set(IdempotencyKeyHeader, "key-1")
+	tests := []struct {
+		name      string
+		req       *http.Request
+		wantCode  int
+		wantCalls int32
+	}{
+		{"no key", idempotentRequest(user, http.MethodPost, "", `{}`), http.StatusCreated, 2},
+		{"read-only method", idempotentRequest(user, http.MethodGet, "key-1", ""), http.StatusCreated, 2},
+		{"key too long", idempotentRequest(user, http.MethodPost, strings.Repeat("k", maxIdempotencyKeyLength+1), `{}`), http.StatusBadRequest, 0},
+		{"unauthenticated", anonymous, http.StatusUnauthorized, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			h := Idempotency(NewMemoryStore(), time.Hour)(countingHandler(&calls))
+			var w *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				w = serve(h, tt.req.Clone(tt.req.Context()))
+			}
+			if w.Code != tt.wantCode || calls != tt.wantCalls {
+				t.Errorf("status = %d after %d executions, want %d after %d", w.Code, calls, tt.wantCode, tt.wantCalls)
+			}
+		})
+	}
+}
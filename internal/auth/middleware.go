@@ -57,7 +57,7 @@ func (a *WalletAuth) OptionalAuthMiddleware(next http.Handler) http.Handler {
 func (a *WalletAuth) RequireNodeLevel(minLevel int, getUserNodeLevel func(ctx context.Context, userID uuid.UUID) (int, error)) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			_, ok := GetUserID(r.Context())
+			userID, ok := GetUserID(r.Context())
 			if !ok {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
@@ -150,8 +150,7 @@ func RateLimiter(redisClient *redis.Client, limit int, window time.Duration) fun
 // 2FA code. This is a placeholder – actual implementation would verify TOTP.
 func (a *WalletAuth) Require2FA(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userID, ok := GetUserID(r.Context())
-		if !ok {
+		if _, ok := GetUserID(r.Context()); !ok {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
@@ -0,0 +1,46 @@
+// internal/auth/redis_store.go
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ---------------------------------------------------------------------
+// Redis Store (production)
+// ---------------------------------------------------------------------
+
+// RedisStore implements Store on top of a Redis client.
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	return s.client.Set(ctx, key, value, ttl).Err()
+}
+
+// Get returns an empty string (and no error) when the key does not exist,
+// matching MemoryStore.
+func (s *RedisStore) Get(ctx context.Context, key string) (string, error) {
+	val, err := s.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return val, err
+}
+
+func (s *RedisStore) Delete(ctx context.Context, key string) error {
+	return s.client.Del(ctx, key).Err()
+}
+
+// SetNX sets key only if it does not already exist and reports whether it did.
+func (s *RedisStore) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, key, value, ttl).Result()
+}
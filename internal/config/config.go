@@ -3,8 +3,10 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,6 +17,13 @@ type Config struct {
 	Redis    RedisConfig
 	JWT      JWTConfig
 	App      AppConfig
+	Swap     SwapConfig
+	Oracle   OracleConfig
+	Emission EmissionConfig
+	Rewards  RewardConfig
+	Vesting  VestingConfig
+	Badges   BadgeConfig
+	Referral ReferralConfig
 }
 
 // ServerConfig contains HTTP server settings
@@ -24,6 +33,10 @@ type ServerConfig struct {
 	WriteTimeout time.Duration
 	IdleTimeout  time.Duration
 	Environment  string
+
+	// Reverse proxies whose X-Forwarded-For entries are believed; requests
+	// from anywhere else are attributed to their peer address
+	TrustedProxies []netip.Prefix
 }
 
 // DatabaseConfig contains PostgreSQL connection settings
@@ -53,13 +66,109 @@ type JWTConfig struct {
 	RefreshDuration time.Duration
 }
 
+// SwapConfig contains swap quoting and slippage settings
+type SwapConfig struct {
+	QuoteSecret        string
+	QuoteTTL           time.Duration
+	DefaultSlippageBps int
+	MaxSlippageBps     int
+	MaxHops            int
+}
+
+// OracleConfig contains price oracle settings
+type OracleConfig struct {
+	Feeds           []string // enabled feeds: static, amm, http
+	StaticPrices    string   // e.g. "USDT=1,CAN=0.25"
+	HTTPURL         string
+	HTTPTimeout     time.Duration
+	TWAPWindow      time.Duration
+	AnchorSymbol    string
+	Interval        time.Duration
+	MaxPriceAge     time.Duration
+	MaxDeviationBps int
+	MinSources      int
+}
+
+// EmissionConfig contains the default mint block emission schedule
+type EmissionConfig struct {
+	InitialReward  string // reward per block before decay and halvings
+	HalvingBlocks  int64  // blocks between halvings, 0 disables
+	EpochBlocks    int64  // blocks per epoch, 0 disables epochs
+	EpochDecayBps  int64  // reward reduction per epoch
+	SupplyCap      string // total that may ever be minted, empty or 0 for uncapped
+	EpochOverrides string // e.g. "0=2000,1=1500"
+}
+
+// RewardConfig splits each block's reward into per-weight-type pools.
+// The shares are in basis points and must add up to 10000.
+type RewardConfig struct {
+	TransactionPoolBps int // CAN holdings
+	LPPoolBps          int // LP weight
+	BurnPoolBps        int // burn power
+
+	// Publish a Merkle root of cumulative rewards for on-chain claims
+	MerkleEnabled     bool
+	MerkleEveryBlocks int64 // publish on every Nth block
+}
+
+// VestingPolicy describes how one kind of earning is paid out: ImmediateBps
+// is released at once and the rest vests linearly over Duration.
+type VestingPolicy struct {
+	ImmediateBps int
+	Duration     time.Duration
+}
+
+// VestingConfig contains vesting policies for earned tokens
+type VestingConfig struct {
+	BlockRewards          VestingPolicy
+	Mining                VestingPolicy
+	ReferralCommissions   VestingPolicy
+	EarlyUnlockPenaltyBps int // share of the locked amount burned on early unlock, negative disables early unlock
+	ReleaseInterval       time.Duration
+}
+
+// BadgeConfig controls how badge combat power multipliers combine
+type BadgeConfig struct {
+	MultiplierStacking string  // "multiplicative" or "additive"
+	MultiplierCap      float64 // highest combined multiplier, 0 = uncapped
+}
+
+// ReferralConfig contains multi-level referral commission settings. Each
+// tier list is the commission in basis points by referrer depth, the
+// direct inviter first; an empty list pays no commission on that earning.
+type ReferralConfig struct {
+	PurchaseTiersBps    []int
+	MiningTiersBps      []int
+	BlockRewardTiersBps []int
+	MinNodeLevels       []int  // lowest node level a referrer needs at each depth, empty = no gate
+	BadgeMode           string // "off", "gate" (needs a referral badge) or "bonus" (badge share added to the rate)
+
+	MaxCodes          int           // referral codes a user may hold, including replaced ones
+	AttributionWindow time.Duration // how long after a link click a signup is credited, 0 = no limit
+	BlockedWords      []string      // extra words vanity codes may not contain
+
+	RiskFlagScore    int           // risk score at which a referred account's commissions are held, 0 = never
+	RiskBurstSignups int           // signups under one code within RiskBurstWindow that count as a burst, 0 = off
+	RiskBurstWindow  time.Duration // window RiskBurstSignups is counted over
+	RiskReviewers    []string      // wallets allowed to work the referral risk review queue
+}
+
 // AppConfig contains application-specific settings
 type AppConfig struct {
-	Name                string
-	Version             string
-	DefaultDailyLimit   float64
-	DefaultMonthlyLimit float64
-	MintBlockInterval   time.Duration
+	Name                   string
+	Version                string
+	DefaultDailyLimit      float64
+	DefaultMonthlyLimit    float64
+	MintBlockInterval      time.Duration
+	MintProducer           bool          // run the block producer in this process
+	MintProducerPoll       time.Duration // how often the producer checks for due slots
+	MintCatchUpPolicy      string        // "backfill" or "skip"
+	MintCatchUpMaxBlocks   int           // most blocks backfilled per poll, 0 = unlimited
+	IdempotencyTTL         time.Duration
+	SnapshotInterval       time.Duration
+	CombatSnapshotInterval time.Duration
+	TeamRepairInterval     time.Duration // full team stats recomputation; 0 disables
+	NetworkStatsTTL        time.Duration
 }
 
 // Load reads configuration from environment variables
@@ -94,14 +203,190 @@ func Load() (*Config, error) {
 			RefreshDuration: getDuration("JWT_REFRESH_DURATION", 7*24*time.Hour),
 		},
 		App: AppConfig{
-			Name:                getEnv("APP_NAME", "CangLanFu"),
-			Version:             getEnv("APP_VERSION", "1.0.0"),
-			DefaultDailyLimit:   getFloat("DEFAULT_DAILY_LIMIT", 1000.0),
-			DefaultMonthlyLimit: getFloat("DEFAULT_MONTHLY_LIMIT", 30000.0),
-			MintBlockInterval:   getDuration("MINT_BLOCK_INTERVAL", 30*time.Minute),
+			Name:                   getEnv("APP_NAME", "CangLanFu"),
+			Version:                getEnv("APP_VERSION", "1.0.0"),
+			DefaultDailyLimit:      getFloat("DEFAULT_DAILY_LIMIT", 1000.0),
+			DefaultMonthlyLimit:    getFloat("DEFAULT_MONTHLY_LIMIT", 30000.0),
+			MintBlockInterval:      getDuration("MINT_BLOCK_INTERVAL", 30*time.Minute),
+			MintProducer:           getBool("MINT_PRODUCER_ENABLED", true),
+			MintProducerPoll:       getDuration("MINT_PRODUCER_POLL", 15*time.Second),
+			MintCatchUpPolicy:      getEnv("MINT_CATCHUP_POLICY", "backfill"),
+			MintCatchUpMaxBlocks:   getInt("MINT_CATCHUP_MAX_BLOCKS", 48),
+			IdempotencyTTL:         getDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+			SnapshotInterval:       getDuration("PORTFOLIO_SNAPSHOT_INTERVAL", time.Hour),
+			CombatSnapshotInterval: getDuration("COMBAT_SNAPSHOT_INTERVAL", time.Hour),
+			TeamRepairInterval:     getDuration("TEAM_REPAIR_INTERVAL", 6*time.Hour),
+			NetworkStatsTTL:        getDuration("NETWORK_STATS_TTL", time.Minute),
+		},
+		Swap: SwapConfig{
+			QuoteSecret:        getEnv("SWAP_QUOTE_SECRET", ""),
+			QuoteTTL:           getDuration("SWAP_QUOTE_TTL", 30*time.Second),
+			DefaultSlippageBps: getInt("SWAP_DEFAULT_SLIPPAGE_BPS", 50),
+			MaxSlippageBps:     getInt("SWAP_MAX_SLIPPAGE_BPS", 1000),
+			MaxHops:            getInt("SWAP_MAX_HOPS", 3),
+		},
+		Oracle: OracleConfig{
+			Feeds:           getList("ORACLE_FEEDS", []string{"static", "amm"}),
+			StaticPrices:    getEnv("ORACLE_STATIC_PRICES", "USDT=1"),
+			HTTPURL:         getEnv("ORACLE_HTTP_URL", ""),
+			HTTPTimeout:     getDuration("ORACLE_HTTP_TIMEOUT", 5*time.Second),
+			TWAPWindow:      getDuration("ORACLE_TWAP_WINDOW", 30*time.Minute),
+			AnchorSymbol:    getEnv("ORACLE_ANCHOR_SYMBOL", "USDT"),
+			Interval:        getDuration("ORACLE_INTERVAL", time.Minute),
+			MaxPriceAge:     getDuration("ORACLE_MAX_PRICE_AGE", 10*time.Minute),
+			MaxDeviationBps: getInt("ORACLE_MAX_DEVIATION_BPS", 500),
+			MinSources:      getInt("ORACLE_MIN_SOURCES", 1),
+		},
+		Emission: EmissionConfig{
+			InitialReward:  getEnv("EMISSION_INITIAL_REWARD", "1000"),
+			HalvingBlocks:  int64(getInt("EMISSION_HALVING_BLOCKS", 0)),
+			EpochBlocks:    int64(getInt("EMISSION_EPOCH_BLOCKS", 0)),
+			EpochDecayBps:  int64(getInt("EMISSION_EPOCH_DECAY_BPS", 0)),
+			SupplyCap:      getEnv("EMISSION_SUPPLY_CAP", ""),
+			EpochOverrides: getEnv("EMISSION_EPOCH_OVERRIDES", ""),
+		},
+		Vesting: VestingConfig{
+			BlockRewards: VestingPolicy{
+				ImmediateBps: getInt("VESTING_BLOCK_REWARDS_IMMEDIATE_BPS", 10000),
+				Duration:     getDuration("VESTING_BLOCK_REWARDS_DURATION", 0),
+			},
+			Mining: VestingPolicy{
+				ImmediateBps: getInt("VESTING_MINING_IMMEDIATE_BPS", 10000),
+				Duration:     getDuration("VESTING_MINING_DURATION", 0),
+			},
+			ReferralCommissions: VestingPolicy{
+				ImmediateBps: getInt("VESTING_REFERRAL_IMMEDIATE_BPS", 10000),
+				Duration:     getDuration("VESTING_REFERRAL_DURATION", 0),
+			},
+			EarlyUnlockPenaltyBps: getInt("VESTING_EARLY_UNLOCK_PENALTY_BPS", -1),
+			ReleaseInterval:       getDuration("VESTING_RELEASE_INTERVAL", time.Hour),
+		},
+		Badges: BadgeConfig{
+			MultiplierStacking: getEnv("BADGE_MULTIPLIER_STACKING", "multiplicative"),
+			MultiplierCap:      getFloat("BADGE_MULTIPLIER_CAP", 0),
+		},
+		Referral: ReferralConfig{
+			PurchaseTiersBps:    getIntList("REFERRAL_PURCHASE_TIERS_BPS", []int{1000}),
+			MiningTiersBps:      getIntList("REFERRAL_MINING_TIERS_BPS", nil),
+			BlockRewardTiersBps: getIntList("REFERRAL_BLOCK_REWARD_TIERS_BPS", nil),
+			MinNodeLevels:       getIntList("REFERRAL_MIN_NODE_LEVELS", nil),
+			BadgeMode:           getEnv("REFERRAL_BADGE_MODE", "off"),
+			MaxCodes:            getInt("REFERRAL_MAX_CODES", 10),
+			AttributionWindow:   getDuration("REFERRAL_ATTRIBUTION_WINDOW", 30*24*time.Hour),
+			BlockedWords:        getList("REFERRAL_BLOCKED_WORDS", nil),
+			RiskFlagScore:       getInt("REFERRAL_RISK_FLAG_SCORE", 60),
+			RiskBurstSignups:    getInt("REFERRAL_RISK_BURST_SIGNUPS", 20),
+			RiskBurstWindow:     getDuration("REFERRAL_RISK_BURST_WINDOW", time.Hour),
+			RiskReviewers:       getList("REFERRAL_RISK_REVIEWERS", nil),
+		},
+		Rewards: RewardConfig{
+			TransactionPoolBps: getInt("REWARD_POOL_TRANSACTION_BPS", 3000),
+			LPPoolBps:          getInt("REWARD_POOL_LP_BPS", 4000),
+			BurnPoolBps:        getInt("REWARD_POOL_BURN_BPS", 3000),
+			MerkleEnabled:      getBool("REWARD_MERKLE_ENABLED", false),
+			MerkleEveryBlocks:  int64(getInt("REWARD_MERKLE_EVERY_BLOCKS", 1)),
 		},
 	}
 
+	for _, entry := range getList("TRUSTED_PROXIES", nil) {
+		prefix, err := parsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+		}
+		cfg.Server.TrustedProxies = append(cfg.Server.TrustedProxies, prefix)
+	}
+
+	// Quotes are signed with the JWT secret unless a dedicated one is set
+	if cfg.Swap.QuoteSecret == "" {
+		cfg.Swap.QuoteSecret = cfg.JWT.Secret
+	}
+
+	if p := cfg.App.MintCatchUpPolicy; p != "backfill" && p != "skip" {
+		return nil, fmt.Errorf("MINT_CATCHUP_POLICY must be backfill or skip, got %q", p)
+	}
+
+	r := cfg.Rewards
+	if r.TransactionPoolBps < 0 || r.LPPoolBps < 0 || r.BurnPoolBps < 0 ||
+		r.TransactionPoolBps+r.LPPoolBps+r.BurnPoolBps != 10000 {
+		return nil, fmt.Errorf("REWARD_POOL_*_BPS must be non-negative and add up to 10000")
+	}
+	if r.MerkleEveryBlocks < 1 {
+		return nil, fmt.Errorf("REWARD_MERKLE_EVERY_BLOCKS must be at least 1")
+	}
+
+	for name, p := range map[string]VestingPolicy{
+		"BLOCK_REWARDS": cfg.Vesting.BlockRewards,
+		"MINING":        cfg.Vesting.Mining,
+		"REFERRAL":      cfg.Vesting.ReferralCommissions,
+	} {
+		if p.ImmediateBps < 0 || p.ImmediateBps > 10000 {
+			return nil, fmt.Errorf("VESTING_%s_IMMEDIATE_BPS must be between 0 and 10000", name)
+		}
+		if p.ImmediateBps < 10000 && p.Duration <= 0 {
+			return nil, fmt.Errorf("VESTING_%s_DURATION must be positive when part of the amount vests", name)
+		}
+	}
+	if m := cfg.Badges.MultiplierStacking; m != "multiplicative" && m != "additive" {
+		return nil, fmt.Errorf("BADGE_MULTIPLIER_STACKING must be multiplicative or additive, got %q", m)
+	}
+	if cfg.Badges.MultiplierCap != 0 && cfg.Badges.MultiplierCap < 1 {
+		return nil, fmt.Errorf("BADGE_MULTIPLIER_CAP must be 0 (uncapped) or at least 1")
+	}
+	for name, tiers := range map[string][]int{
+		"PURCHASE":     cfg.Referral.PurchaseTiersBps,
+		"MINING":       cfg.Referral.MiningTiersBps,
+		"BLOCK_REWARD": cfg.Referral.BlockRewardTiersBps,
+	} {
+		total := 0
+		for _, bps := range tiers {
+			if bps < 0 {
+				return nil, fmt.Errorf("REFERRAL_%s_TIERS_BPS must not be negative", name)
+			}
+			total += bps
+		}
+		if total > 10000 {
+			return nil, fmt.Errorf("REFERRAL_%s_TIERS_BPS must not add up to more than 10000", name)
+		}
+	}
+	for _, level := range cfg.Referral.MinNodeLevels {
+		if level < 0 {
+			return nil, fmt.Errorf("REFERRAL_MIN_NODE_LEVELS must not be negative")
+		}
+	}
+	if m := cfg.Referral.BadgeMode; m != "off" && m != "gate" && m != "bonus" {
+		return nil, fmt.Errorf("REFERRAL_BADGE_MODE must be off, gate or bonus, got %q", m)
+	}
+	if cfg.Referral.MaxCodes < 1 {
+		return nil, fmt.Errorf("REFERRAL_MAX_CODES must be at least 1")
+	}
+	if cfg.Referral.AttributionWindow < 0 {
+		return nil, fmt.Errorf("REFERRAL_ATTRIBUTION_WINDOW must not be negative")
+	}
+	if cfg.Referral.RiskFlagScore < 0 || cfg.Referral.RiskFlagScore > 100 {
+		return nil, fmt.Errorf("REFERRAL_RISK_FLAG_SCORE must be between 0 and 100")
+	}
+	if cfg.Referral.RiskBurstSignups < 0 {
+		return nil, fmt.Errorf("REFERRAL_RISK_BURST_SIGNUPS must not be negative")
+	}
+	if cfg.Referral.RiskBurstSignups > 0 && cfg.Referral.RiskBurstWindow <= 0 {
+		return nil, fmt.Errorf("REFERRAL_RISK_BURST_WINDOW must be positive when burst detection is on")
+	}
+	// Rewards claimed on chain bypass vesting and commissions, which are
+	// applied when a reward is claimed into an internal balance
+	if cfg.Rewards.MerkleEnabled {
+		if cfg.Vesting.BlockRewards.ImmediateBps < 10000 {
+			return nil, fmt.Errorf("REWARD_MERKLE_ENABLED requires VESTING_BLOCK_REWARDS_IMMEDIATE_BPS=10000")
+		}
+		for _, bps := range cfg.Referral.BlockRewardTiersBps {
+			if bps > 0 {
+				return nil, fmt.Errorf("REWARD_MERKLE_ENABLED cannot be combined with REFERRAL_BLOCK_REWARD_TIERS_BPS")
+			}
+		}
+	}
+	if cfg.Vesting.EarlyUnlockPenaltyBps > 10000 {
+		return nil, fmt.Errorf("VESTING_EARLY_UNLOCK_PENALTY_BPS must not exceed 10000")
+	}
+
 	// Validate required config
 	if cfg.JWT.Secret == "change-me-in-production" && cfg.Server.Environment == "production" {
 		return nil, fmt.Errorf("JWT_SECRET must be set in production")
@@ -133,6 +418,18 @@ func getInt(key string, defaultValue int) int {
 	return value
 }
 
+func getBool(key string, defaultValue bool) bool {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
 func getFloat(key string, defaultValue float64) float64 {
 	valueStr := os.Getenv(key)
 	if valueStr == "" {
@@ -156,3 +453,50 @@ func getDuration(key string, defaultValue time.Duration) time.Duration {
 	}
 	return value
 }
+
+func getList(key string, defaultValue []string) []string {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+	var values []string
+	for _, v := range strings.Split(valueStr, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// getIntList reads a comma-separated list of integers. An unparsable list
+// falls back to the default as a whole.
+func getIntList(key string, defaultValue []int) []int {
+	var values []int
+	for _, v := range getList(key, nil) {
+		value, err := strconv.Atoi(v)
+		if err != nil {
+			return defaultValue
+		}
+		values = append(values, value)
+	}
+	if values == nil {
+		return defaultValue
+	}
+	return values
+}
+
+// parsePrefix parses a CIDR range or a single IP address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
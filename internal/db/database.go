@@ -0,0 +1,92 @@
+// internal/db/database.go
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"jd7008911/canlan.org/internal/config"
+)
+
+// Database owns the connection pool and the sqlc queries bound to it.
+type Database struct {
+	Pool    *pgxpool.Pool
+	Queries *Queries
+}
+
+// NewDatabase opens a pgx connection pool and verifies connectivity.
+func NewDatabase(cfg *config.DatabaseConfig) (*Database, error) {
+	dsn := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s",
+		cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Name, cfg.SSLMode)
+
+	poolCfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database config: %w", err)
+	}
+	poolCfg.MaxConns = cfg.MaxConns
+	poolCfg.MinConns = cfg.MinConns
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pool: %w", err)
+	}
+	if err := pool.Ping(context.Background()); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &Database{
+		Pool:    pool,
+		Queries: New(pool),
+	}, nil
+}
+
+// Close releases all pooled connections.
+func (d *Database) Close() {
+	d.Pool.Close()
+}
+
+// ---------------------------------------------------------------------
+// Unit of Work
+// ---------------------------------------------------------------------
+
+// TxRunner runs a function inside a database transaction.
+// Services depend on this rather than on *Database so the unit of work
+// can be shared or replaced.
+type TxRunner interface {
+	WithTx(ctx context.Context, fn func(q *Queries) error) error
+}
+
+// WithTx runs fn in a single transaction. The Queries passed to fn are
+// bound to that transaction; every statement issued through them commits
+// together or not at all. The transaction is rolled back if fn returns an
+// error or panics.
+func (d *Database) WithTx(ctx context.Context, fn func(q *Queries) error) (err error) {
+	tx, err := d.Pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback(ctx)
+			panic(p)
+		}
+		if err != nil {
+			if rbErr := tx.Rollback(ctx); rbErr != nil && !errors.Is(rbErr, pgx.ErrTxClosed) {
+				err = fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+			}
+		}
+	}()
+
+	if err = fn(d.Queries.WithTx(tx)); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
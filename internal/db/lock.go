@@ -0,0 +1,67 @@
+// internal/db/lock.go
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ---------------------------------------------------------------------
+// Advisory Locks
+// ---------------------------------------------------------------------
+
+// Advisory lock keys. Every process that competes for the same job must
+// use the same key.
+const (
+	LockKeyBlockProducer int64 = 0x63616e6c616e01 // "canlan" + 1
+)
+
+// AdvisoryLocker acquires session-level Postgres advisory locks.
+type AdvisoryLocker interface {
+	TryAdvisoryLock(ctx context.Context, key int64) (*AdvisoryLock, error)
+}
+
+// AdvisoryLock is a session-level advisory lock. It is held by a dedicated
+// pooled connection for as long as the lock is needed; if that connection
+// dies, Postgres releases the lock and Alive reports false.
+type AdvisoryLock struct {
+	conn *pgxpool.Conn
+	key  int64
+}
+
+// TryAdvisoryLock takes the lock for key without waiting. It returns nil
+// and no error when another session already holds it.
+func (d *Database) TryAdvisoryLock(ctx context.Context, key int64) (*AdvisoryLock, error) {
+	conn, err := d.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+
+	var locked bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked); err != nil {
+		conn.Release()
+		return nil, fmt.Errorf("failed to take advisory lock: %w", err)
+	}
+	if !locked {
+		conn.Release()
+		return nil, nil
+	}
+	return &AdvisoryLock{conn: conn, key: key}, nil
+}
+
+// Alive reports whether the session holding the lock is still connected.
+func (l *AdvisoryLock) Alive(ctx context.Context) bool {
+	return l.conn.Ping(ctx) == nil
+}
+
+// Release unlocks and returns the connection to the pool.
+func (l *AdvisoryLock) Release(ctx context.Context) {
+	if _, err := l.conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", l.key); err != nil {
+		// The session may be gone, which released the lock already; make
+		// sure the pool does not reuse a connection that might still hold it.
+		l.conn.Conn().Close(ctx)
+	}
+	l.conn.Release()
+}
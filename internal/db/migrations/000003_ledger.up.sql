@@ -0,0 +1,81 @@
+-- Double-entry ledger
+--
+-- Every balance mutation is recorded as a journal entry with two or more
+-- postings. Postings carry a signed amount (positive = debit / increase of
+-- holdings, negative = credit / decrease) and the postings of one entry must
+-- sum to zero per token. user_balances is kept as a projection of the
+-- postings made against 'user' accounts.
+
+-- Ledger accounts
+CREATE TABLE ledger_accounts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    account_type VARCHAR(20) NOT NULL, -- user, pool, fee, treasury, emission, burn, clearing, external
+    owner_id UUID, -- user id for 'user', pool id for 'pool', NULL for system accounts
+    token_id UUID NOT NULL REFERENCES tokens(id),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT ledger_accounts_unique UNIQUE NULLS NOT DISTINCT (account_type, owner_id, token_id)
+);
+
+-- Journal entries (one per business operation)
+CREATE TABLE journal_entries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    entry_type VARCHAR(30) NOT NULL, -- swap, purchase, withdrawal, burn, lp_add, lp_remove, mining, reward_claim, ...
+    reference_id UUID, -- id of the business record (swap, purchase, withdrawal, ...)
+    description TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Postings (individual debit/credit lines)
+CREATE TABLE ledger_postings (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    entry_id UUID NOT NULL REFERENCES journal_entries(id) ON DELETE CASCADE,
+    account_id UUID NOT NULL REFERENCES ledger_accounts(id),
+    token_id UUID NOT NULL REFERENCES tokens(id),
+    amount DECIMAL(36,18) NOT NULL CHECK (amount <> 0),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_ledger_accounts_owner ON ledger_accounts(owner_id);
+CREATE INDEX idx_journal_entries_reference ON journal_entries(reference_id);
+CREATE INDEX idx_ledger_postings_entry ON ledger_postings(entry_id);
+CREATE INDEX idx_ledger_postings_account ON ledger_postings(account_id);
+
+-- Opening balances: bring existing user_balances rows into the ledger so that
+-- reconciliation holds from the first run. The counterpart is the treasury.
+INSERT INTO ledger_accounts (account_type, owner_id, token_id)
+SELECT 'user', ub.user_id, ub.token_id
+FROM user_balances ub
+WHERE ub.balance <> 0
+ON CONFLICT DO NOTHING;
+
+INSERT INTO ledger_accounts (account_type, owner_id, token_id)
+SELECT DISTINCT 'treasury', NULL::uuid, ub.token_id
+FROM user_balances ub
+WHERE ub.balance <> 0
+ON CONFLICT DO NOTHING;
+
+WITH opening AS (
+    INSERT INTO journal_entries (entry_type, description)
+    SELECT 'opening_balance', 'Opening balances migrated from user_balances'
+    WHERE EXISTS (SELECT 1 FROM user_balances WHERE balance <> 0)
+    RETURNING id
+)
+INSERT INTO ledger_postings (entry_id, account_id, token_id, amount)
+SELECT o.id, la.id, ub.token_id, ub.balance
+FROM opening o
+CROSS JOIN user_balances ub
+JOIN ledger_accounts la
+  ON la.account_type = 'user' AND la.owner_id = ub.user_id AND la.token_id = ub.token_id
+WHERE ub.balance <> 0
+UNION ALL
+SELECT o.id, la.id, t.token_id, -t.total
+FROM opening o
+CROSS JOIN (
+    SELECT token_id, SUM(balance) AS total
+    FROM user_balances
+    WHERE balance <> 0
+    GROUP BY token_id
+    HAVING SUM(balance) <> 0
+) t
+JOIN ledger_accounts la
+  ON la.account_type = 'treasury' AND la.owner_id IS NULL AND la.token_id = t.token_id;
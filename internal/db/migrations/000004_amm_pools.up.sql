@@ -0,0 +1,88 @@
+-- Constant-product AMM pools
+--
+-- Liquidity pools hold real reserves of both tokens and price swaps with
+-- x * y = k. reserve0/reserve1 always equal the balances of the pool's
+-- ledger accounts; lp_total_supply equals the sum of lp_positions.lp_amount.
+-- total_liquidity_usd is kept as a derived figure (reserves at token prices).
+
+ALTER TABLE liquidity_pools
+    ADD COLUMN reserve0 DECIMAL(36,18) NOT NULL DEFAULT 0 CHECK (reserve0 >= 0),
+    ADD COLUMN reserve1 DECIMAL(36,18) NOT NULL DEFAULT 0 CHECK (reserve1 >= 0),
+    ADD COLUMN lp_total_supply DECIMAL(36,18) NOT NULL DEFAULT 0 CHECK (lp_total_supply >= 0),
+    ADD COLUMN fee_bps INTEGER NOT NULL DEFAULT 30 CHECK (fee_bps >= 0 AND fee_bps < 10000), -- 30 = 0.30%
+    ADD COLUMN updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+
+-- Swaps record the pool they were routed through (NULL = treasury rate)
+ALTER TABLE swaps
+    ADD COLUMN pool_id UUID REFERENCES liquidity_pools(id),
+    ADD COLUMN fee_amount DECIMAL(36,18) NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_liquidity_pools_tokens ON liquidity_pools(token0_id, token1_id);
+CREATE INDEX idx_swaps_pool ON swaps(pool_id);
+
+-- Backfill: LP supply from the existing positions, reserves from the old
+-- 50/50 value split of total_liquidity_usd.
+UPDATE liquidity_pools p
+SET
+    lp_total_supply = COALESCE((
+        SELECT SUM(lp_amount) FROM lp_positions WHERE pool_id = p.id
+    ), 0),
+    reserve0 = COALESCE(ROUND(p.total_liquidity_usd / 2 / NULLIF(t0.price_usd, 0), 18), 0),
+    reserve1 = COALESCE(ROUND(p.total_liquidity_usd / 2 / NULLIF(t1.price_usd, 0), 18), 0)
+FROM tokens t0, tokens t1
+WHERE t0.id = p.token0_id AND t1.id = p.token1_id;
+
+-- Bring the pool ledger accounts in line with the backfilled reserves.
+-- Anything already posted to a pool account counts toward its reserve; the
+-- difference comes from the treasury.
+INSERT INTO ledger_accounts (account_type, owner_id, token_id)
+SELECT 'pool', p.id, tok.token_id
+FROM liquidity_pools p
+CROSS JOIN LATERAL (VALUES (p.token0_id), (p.token1_id)) AS tok(token_id)
+ON CONFLICT DO NOTHING;
+
+INSERT INTO ledger_accounts (account_type, owner_id, token_id)
+SELECT DISTINCT 'treasury', NULL::uuid, tok.token_id
+FROM liquidity_pools p
+CROSS JOIN LATERAL (VALUES (p.token0_id), (p.token1_id)) AS tok(token_id)
+ON CONFLICT DO NOTHING;
+
+WITH targets AS (
+    SELECT p.id AS pool_id, p.token0_id AS token_id, p.reserve0 AS reserve FROM liquidity_pools p
+    UNION ALL
+    SELECT p.id, p.token1_id, p.reserve1 FROM liquidity_pools p
+),
+diffs AS (
+    SELECT
+        la.id AS account_id,
+        t.token_id,
+        t.reserve - COALESCE((
+            SELECT SUM(amount) FROM ledger_postings WHERE account_id = la.id
+        ), 0) AS diff
+    FROM targets t
+    JOIN ledger_accounts la
+      ON la.account_type = 'pool' AND la.owner_id = t.pool_id AND la.token_id = t.token_id
+),
+opening AS (
+    INSERT INTO journal_entries (entry_type, description)
+    SELECT 'pool_opening', 'Opening pool reserves for constant-product pricing'
+    WHERE EXISTS (SELECT 1 FROM diffs WHERE diff <> 0)
+    RETURNING id
+)
+INSERT INTO ledger_postings (entry_id, account_id, token_id, amount)
+SELECT o.id, d.account_id, d.token_id, d.diff
+FROM opening o
+CROSS JOIN diffs d
+WHERE d.diff <> 0
+UNION ALL
+SELECT o.id, la.id, s.token_id, -s.total
+FROM opening o
+CROSS JOIN (
+    SELECT token_id, SUM(diff) AS total
+    FROM diffs
+    WHERE diff <> 0
+    GROUP BY token_id
+    HAVING SUM(diff) <> 0
+) s
+JOIN ledger_accounts la
+  ON la.account_type = 'treasury' AND la.owner_id IS NULL AND la.token_id = s.token_id;
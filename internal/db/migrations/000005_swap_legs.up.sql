@@ -0,0 +1,28 @@
+-- Multi-hop swaps
+--
+-- A swap may be routed through several pools (e.g. LAN -> USDT -> CAN).
+-- Every swap records its hops here in order; a hop with a NULL pool_id was
+-- filled by the treasury at the static price ratio. swaps.pool_id is only
+-- set for single-pool swaps.
+
+CREATE TABLE swap_legs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    swap_id UUID NOT NULL REFERENCES swaps(id) ON DELETE CASCADE,
+    leg_index INT NOT NULL,
+    pool_id UUID REFERENCES liquidity_pools(id),
+    from_token_id UUID NOT NULL REFERENCES tokens(id),
+    to_token_id UUID NOT NULL REFERENCES tokens(id),
+    amount_in DECIMAL(36,18) NOT NULL,
+    amount_out DECIMAL(36,18) NOT NULL,
+    fee_amount DECIMAL(36,18) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (swap_id, leg_index)
+);
+
+CREATE INDEX idx_swap_legs_pool ON swap_legs(pool_id);
+
+-- Existing swaps become single-leg swaps
+INSERT INTO swap_legs (swap_id, leg_index, pool_id, from_token_id, to_token_id, amount_in, amount_out, fee_amount, created_at)
+SELECT id, 0, pool_id, from_token_id, to_token_id, from_amount, to_amount, fee_amount, COALESCE(created_at, CURRENT_TIMESTAMP)
+FROM swaps
+WHERE from_token_id IS NOT NULL AND to_token_id IS NOT NULL;
@@ -0,0 +1,20 @@
+-- Token price history
+--
+-- Every price the oracle writes to tokens.price_usd is also appended here,
+-- with the sources that agreed on it.
+
+CREATE TABLE token_price_history (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    token_id UUID NOT NULL REFERENCES tokens(id) ON DELETE CASCADE,
+    price_usd DECIMAL(36,18) NOT NULL,
+    source VARCHAR(100) NOT NULL, -- e.g. "static,amm_twap" or "manual"
+    recorded_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_token_price_history_token_time ON token_price_history(token_id, recorded_at DESC);
+
+-- Seed the history with the current prices
+INSERT INTO token_price_history (token_id, price_usd, source, recorded_at)
+SELECT id, price_usd, 'initial', COALESCE(price_updated_at, CURRENT_TIMESTAMP)
+FROM tokens
+WHERE price_usd IS NOT NULL AND price_usd > 0;
@@ -0,0 +1,18 @@
+-- Daily portfolio snapshots
+--
+-- One row per user, token and day with the balance and its USD value at
+-- the time of the snapshot. The snapshot job rewrites the current day's
+-- rows on every run, so the last run of a day leaves its closing values.
+
+CREATE TABLE portfolio_snapshots (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_id UUID NOT NULL REFERENCES tokens(id),
+    snapshot_date DATE NOT NULL,
+    balance DECIMAL(36,18) NOT NULL,
+    price_usd DECIMAL(36,18) NOT NULL,
+    value_usd DECIMAL(36,18) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, snapshot_date, token_id)
+);
+
+CREATE INDEX idx_portfolio_snapshots_date ON portfolio_snapshots(snapshot_date);
@@ -0,0 +1,20 @@
+-- Emission schedules
+--
+-- Mint block rewards follow the schedule configured with EMISSION_*
+-- settings until governance replaces it. Each executed emission proposal
+-- adds a row here that takes effect from effective_from_block; the latest
+-- row at or before a block number governs that block.
+
+CREATE TABLE emission_schedules (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    schedule JSONB NOT NULL,
+    effective_from_block BIGINT NOT NULL,
+    proposal_id UUID REFERENCES governance_proposals(id),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_emission_schedules_from_block ON emission_schedules(effective_from_block);
+
+-- Proposals carry a machine-readable payload for the change they make,
+-- e.g. {"schedule": {...}, "effective_from_block": 5000} for 'emission'.
+ALTER TABLE governance_proposals ADD COLUMN payload JSONB;
@@ -0,0 +1,14 @@
+-- Mint block scheduling
+--
+-- scheduled_at is the slot a block was minted for, as opposed to when the
+-- producer got round to it. Slots are spaced MINT_BLOCK_INTERVAL apart
+-- from the previous block's slot, so catching up after downtime produces
+-- the same slots whichever replica does it.
+
+ALTER TABLE mint_blocks ADD COLUMN scheduled_at TIMESTAMP;
+
+UPDATE mint_blocks SET scheduled_at = COALESCE(created_at, CURRENT_TIMESTAMP);
+
+ALTER TABLE mint_blocks
+    ALTER COLUMN scheduled_at SET NOT NULL,
+    ALTER COLUMN scheduled_at SET DEFAULT CURRENT_TIMESTAMP;
@@ -0,0 +1,27 @@
+-- Resumable reward distribution
+--
+-- A block is distributed in two checkpointed steps, each one transaction:
+--   pending     -> snapshotted  weight_snapshots written for every user
+--   snapshotted -> distributed  block_rewards written, dust sent to treasury
+-- A crashed run resumes from the last checkpoint. Rounding dust is recorded
+-- on the block, so SUM(block_rewards.amount) + dust_amount = total_reward.
+
+ALTER TABLE mint_blocks
+    ADD COLUMN distribution_status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    ADD COLUMN dust_amount DECIMAL(36,18) NOT NULL DEFAULT 0;
+
+UPDATE mint_blocks SET distribution_status = 'distributed' WHERE distributed = true;
+
+CREATE INDEX idx_mint_blocks_undistributed ON mint_blocks(block_number)
+    WHERE distribution_status <> 'distributed';
+
+-- One snapshot per user and block, so re-running a step cannot double count
+DELETE FROM weight_snapshots a
+USING weight_snapshots b
+WHERE a.user_id = b.user_id
+  AND a.block_id = b.block_id
+  AND a.created_at > b.created_at;
+
+CREATE UNIQUE INDEX idx_weight_snapshots_user_block ON weight_snapshots(user_id, block_id);
+
+CREATE UNIQUE INDEX idx_block_rewards_user_block_type ON block_rewards(user_id, block_id, weight_type);
@@ -0,0 +1,17 @@
+-- Per-weight-type reward pools
+--
+-- Each block's reward is split into one pool per weight type
+-- ('transaction', 'lp', 'burn'), and each pool is shared in proportion to
+-- that weight alone. The split is recorded when the block is distributed so
+-- later changes to the configured shares do not rewrite history. A pool
+-- whose total weight is zero is not paid out and ends up in the block's dust.
+
+CREATE TABLE block_reward_pools (
+    block_id UUID NOT NULL REFERENCES mint_blocks(id),
+    weight_type VARCHAR(20) NOT NULL,
+    share_bps INT NOT NULL,
+    amount DECIMAL(36,18) NOT NULL,
+    total_weight DECIMAL(36,18) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (block_id, weight_type)
+);
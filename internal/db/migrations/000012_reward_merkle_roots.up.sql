@@ -0,0 +1,13 @@
+-- Merkle roots for on-chain reward claims
+--
+-- When publishing is enabled, a distributed block gets the root of a
+-- Merkle tree over every wallet's cumulative block rewards up to and
+-- including that block. A MerkleDistributor contract pays out against the
+-- latest published root; proofs are rebuilt from block_rewards on demand.
+
+ALTER TABLE mint_blocks
+    ADD COLUMN merkle_root VARCHAR(66),
+    ADD COLUMN merkle_leaf_count INT;
+
+CREATE INDEX idx_mint_blocks_merkle ON mint_blocks(block_number)
+    WHERE merkle_root IS NOT NULL;
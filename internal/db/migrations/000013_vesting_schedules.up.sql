@@ -0,0 +1,29 @@
+-- Vesting schedules for earned tokens
+--
+-- Block rewards, mining earnings and referral commissions can be paid
+-- partly at once and partly into a per-user 'vesting' ledger account. Each
+-- schedule tracks the locked part of one payment, which is released to the
+-- user's balance linearly between start_at and end_at. An early unlock
+-- releases the rest at once, minus a penalty that is burned.
+
+CREATE TABLE vesting_schedules (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id),
+    token_id UUID NOT NULL REFERENCES tokens(id),
+    source VARCHAR(30) NOT NULL, -- 'block_reward', 'mining', 'referral_commission'
+    reference_id UUID,
+    journal_entry_id UUID NOT NULL REFERENCES journal_entries(id),
+    amount DECIMAL(36,18) NOT NULL CHECK (amount > 0),
+    released_amount DECIMAL(36,18) NOT NULL DEFAULT 0,
+    penalty_amount DECIMAL(36,18) NOT NULL DEFAULT 0,
+    start_at TIMESTAMP NOT NULL,
+    end_at TIMESTAMP NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active', -- 'active', 'completed', 'unlocked'
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (end_at > start_at),
+    CHECK (released_amount + penalty_amount <= amount)
+);
+
+CREATE INDEX idx_vesting_schedules_user ON vesting_schedules(user_id, status);
+CREATE INDEX idx_vesting_schedules_active ON vesting_schedules(start_at) WHERE status = 'active';
@@ -0,0 +1,27 @@
+-- Daily combat power snapshots
+--
+-- combat_power_history gets one row per user and day with every component
+-- of the user's combat power and their rank in the network by personal
+-- power. The snapshot job rewrites the current day's rows on every run, so
+-- the last run of a day leaves its closing values.
+
+ALTER TABLE combat_power
+    ADD COLUMN badge_multiplier DECIMAL(10,4) NOT NULL DEFAULT 1;
+
+DELETE FROM combat_power_history a
+USING combat_power_history b
+WHERE a.user_id = b.user_id
+  AND a.date = b.date
+  AND a.created_at < b.created_at;
+
+ALTER TABLE combat_power_history
+    ALTER COLUMN user_id SET NOT NULL,
+    ALTER COLUMN personal_power SET DEFAULT 0,
+    ADD COLUMN network_power DECIMAL(36,18) NOT NULL DEFAULT 0,
+    ADD COLUMN lp_weight DECIMAL(36,18) NOT NULL DEFAULT 0,
+    ADD COLUMN burn_power DECIMAL(36,18) NOT NULL DEFAULT 0,
+    ADD COLUMN badge_multiplier DECIMAL(10,4) NOT NULL DEFAULT 1,
+    ADD COLUMN network_rank INT;
+
+CREATE UNIQUE INDEX idx_combat_power_history_user_date ON combat_power_history(user_id, date);
+CREATE INDEX idx_combat_power_history_date ON combat_power_history(date);
@@ -0,0 +1,23 @@
+-- Versioned combat power formulas
+--
+-- Each row is an immutable formula definition (token weights, LP pool
+-- weights, burn weights and burn decay). The highest version is the one in
+-- force; governance 'combat_formula' proposals add new versions. Version 1
+-- reproduces the original hard-coded rules: LAN and CAN holdings, LP
+-- amounts and burned amounts all count 1:1.
+
+CREATE TABLE combat_power_formulas (
+    version INT PRIMARY KEY,
+    definition JSONB NOT NULL,
+    proposal_id UUID REFERENCES governance_proposals(id),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO combat_power_formulas (version, definition) VALUES (1, '{
+    "token_weights": {"LAN": "1", "CAN": "1"},
+    "lp_default_weight": "1",
+    "burn_default_weight": "1"
+}');
+
+ALTER TABLE combat_power
+    ADD COLUMN formula_version INT NOT NULL DEFAULT 1;
@@ -0,0 +1,15 @@
+-- Multi-level referral commissions
+--
+-- Every commission paid on a purchase, mining earning or block reward claim
+-- is recorded in referral_earnings with the referrer's depth below which
+-- the earning happened (1 = direct inviter), the rate applied and the
+-- amount it was applied to.
+
+ALTER TABLE referral_earnings
+    ADD COLUMN depth INT NOT NULL DEFAULT 1,
+    ADD COLUMN rate_bps INT NOT NULL DEFAULT 0,
+    ADD COLUMN source_amount DECIMAL(36,18) NOT NULL DEFAULT 0,
+    ADD COLUMN reference_id UUID,
+    ADD COLUMN journal_entry_id UUID REFERENCES journal_entries(id);
+
+CREATE INDEX idx_referral_earnings_user ON referral_earnings(user_id, created_at DESC);
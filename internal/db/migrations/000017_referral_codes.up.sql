@@ -0,0 +1,37 @@
+-- Referral codes
+--
+-- A user can have several referral codes, random or vanity, each with an
+-- optional campaign label and click/signup counters. users.referral_code
+-- holds the primary code; a code replaced as primary keeps resolving to its
+-- owner so links already shared still work. Codes are unique regardless of
+-- case.
+
+CREATE TABLE referral_codes (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id),
+    code VARCHAR(20) NOT NULL,
+    campaign VARCHAR(50),
+    vanity BOOLEAN NOT NULL DEFAULT FALSE,
+    clicks BIGINT NOT NULL DEFAULT 0,
+    signups BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    replaced_at TIMESTAMP -- when it stopped being the primary code
+);
+
+CREATE UNIQUE INDEX idx_referral_codes_code ON referral_codes(LOWER(code));
+CREATE INDEX idx_referral_codes_user ON referral_codes(user_id, created_at);
+
+INSERT INTO referral_codes (user_id, code, created_at)
+SELECT id, referral_code, COALESCE(created_at, CURRENT_TIMESTAMP) FROM users;
+
+-- One row per referral link click; signup_user_id is set when the click
+-- leads to a registration inside the attribution window.
+CREATE TABLE referral_clicks (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    code_id UUID NOT NULL REFERENCES referral_codes(id),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    signup_user_id UUID REFERENCES users(id),
+    signed_up_at TIMESTAMP
+);
+
+CREATE INDEX idx_referral_clicks_code ON referral_clicks(code_id, created_at);
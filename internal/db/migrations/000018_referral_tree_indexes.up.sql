@@ -0,0 +1,10 @@
+-- Referral tree explorer
+--
+-- The explorer treats parent_id, falling back to invited_by, as a user's
+-- parent so every user has exactly one. These indexes serve paging a
+-- node's children by id and walking the tree level by level.
+
+CREATE INDEX idx_users_parent ON users(parent_id, id);
+CREATE INDEX idx_users_invited_by_orphans ON users(invited_by, id) WHERE parent_id IS NULL;
+CREATE INDEX idx_users_wallet_lower ON users(LOWER(wallet_address) text_pattern_ops);
+CREATE INDEX idx_purchases_user_completed ON purchases(user_id, completed_at) WHERE status = 'completed';
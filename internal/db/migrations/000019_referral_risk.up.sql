@@ -0,0 +1,81 @@
+-- Referral risk
+--
+-- A user can never be their own referrer, directly or through a cycle of
+-- parent_id/invited_by links. Signups and logins record signals (IP
+-- address, device fingerprint) that are compared across accounts to score
+-- how likely a referred account is to belong to the referrer. Commissions involving a flagged account are held in
+-- referral_earnings until a reviewer approves (paid out) or rejects
+-- (forfeited) the account.
+
+ALTER TABLE users
+    ADD CONSTRAINT users_not_own_parent CHECK (parent_id <> id),
+    ADD CONSTRAINT users_not_own_inviter CHECK (invited_by <> id);
+
+CREATE OR REPLACE FUNCTION users_reject_referral_cycle() RETURNS TRIGGER AS $$
+BEGIN
+    IF EXISTS (
+        WITH RECURSIVE ancestors AS (
+            SELECT a.id, a.parent_id, a.invited_by, 1 AS hops
+            FROM users a
+            WHERE a.id IN (NEW.parent_id, NEW.invited_by)
+            UNION
+            SELECT a.id, a.parent_id, a.invited_by, an.hops + 1
+            FROM ancestors an
+            JOIN users a ON a.id IN (an.parent_id, an.invited_by)
+            WHERE an.hops < 1000
+        )
+        SELECT 1 FROM ancestors WHERE id = NEW.id
+    ) THEN
+        RAISE EXCEPTION 'referral cycle: user % would be their own ancestor', NEW.id
+            USING ERRCODE = 'check_violation';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_referral_cycle
+    BEFORE INSERT OR UPDATE OF parent_id, invited_by ON users
+    FOR EACH ROW
+    WHEN (NEW.parent_id IS NOT NULL OR NEW.invited_by IS NOT NULL)
+    EXECUTE FUNCTION users_reject_referral_cycle();
+
+-- One row per distinct signal value seen for a user. Device fingerprints
+-- are stored hashed.
+CREATE TABLE user_risk_signals (
+    user_id UUID NOT NULL REFERENCES users(id),
+    kind VARCHAR(20) NOT NULL, -- 'ip', 'device'
+    value VARCHAR(128) NOT NULL,
+    first_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, kind, value)
+);
+
+CREATE INDEX idx_user_risk_signals_value ON user_risk_signals(kind, value);
+
+-- Latest risk score of a referred account and the review decision.
+CREATE TABLE referral_risk (
+    user_id UUID PRIMARY KEY REFERENCES users(id),
+    referral_code_id UUID REFERENCES referral_codes(id), -- code the signup was credited to
+    score INT NOT NULL DEFAULT 0,
+    reasons JSONB NOT NULL DEFAULT '[]',
+    status VARCHAR(20) NOT NULL DEFAULT 'clear', -- clear, flagged, approved, rejected
+    flagged_at TIMESTAMP,
+    reviewed_by UUID REFERENCES users(id),
+    reviewed_at TIMESTAMP,
+    review_note TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_referral_risk_queue ON referral_risk(status, flagged_at);
+CREATE INDEX idx_referral_risk_code ON referral_risk(referral_code_id, created_at);
+
+-- Held commissions are recorded without a journal entry; payout_account is
+-- the system account they are paid from when released.
+ALTER TABLE referral_earnings
+    ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'paid', -- paid, held, forfeited
+    ADD COLUMN payout_account VARCHAR(20),
+    ADD COLUMN resolved_at TIMESTAMP;
+
+CREATE INDEX idx_referral_earnings_held ON referral_earnings(user_id) WHERE status = 'held';
+CREATE INDEX idx_referral_earnings_held_from ON referral_earnings(from_user_id) WHERE status = 'held';
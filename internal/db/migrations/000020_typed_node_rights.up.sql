@@ -0,0 +1,31 @@
+-- Typed node rights
+--
+-- node_levels.rights now follows a fixed schema that the services enforce:
+--   swap_fee_discount_bps     share of swap LP fees rebated by the treasury
+--   commission_depth          deepest referral level that earns commissions
+--                             (omitted = every configured tier)
+--   create_proposals          may create governance proposals
+--   withdrawal_daily_limit    withdrawal limits raised to on reaching the
+--   withdrawal_monthly_limit  level
+--   mining_boost_bps          added to daily mining output
+--
+-- Existing levels keep the daily withdrawal limit gift_limit used to grant,
+-- and proposal creation, open to everyone until now, becomes a right of
+-- every level above 0. Keys already present are kept; unknown keys are
+-- ignored.
+--
+-- A level without a definition grants nothing, not even commissions, so
+-- level 0 is defined (with no rights beyond commissions at every tier) for
+-- users who have not reached a level yet.
+
+UPDATE node_levels
+SET rights = jsonb_build_object(
+        'withdrawal_daily_limit', COALESCE(gift_limit, 0)::text,
+        'create_proposals', level > 0
+    ) || COALESCE(rights, '{}'::jsonb);
+
+ALTER TABLE node_levels ALTER COLUMN rights SET DEFAULT '{}'::jsonb;
+
+INSERT INTO node_levels (level, required_team_power, required_direct_members, rights, gift_limit)
+VALUES (0, 0, 0, '{}', 0)
+ON CONFLICT (level) DO NOTHING;
@@ -1,3 +1,17 @@
+-- name: GetLastMintBlock :one
+SELECT * FROM mint_blocks
+ORDER BY block_number DESC
+LIMIT 1;
+
+-- name: CreateMintBlock :one
+INSERT INTO mint_blocks (block_number, total_reward, scheduled_at)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: GetTotalMintedReward :one
+SELECT COALESCE(SUM(total_reward), 0)::decimal AS total
+FROM mint_blocks;
+
 -- name: GetCurrentMintBlock :one
 SELECT * FROM mint_blocks 
 WHERE distributed = false 
@@ -16,7 +30,187 @@ VALUES ($1, $2, $3, $4, $5);
 SELECT * FROM block_rewards 
 WHERE user_id = $1 AND claimed = false;
 
+-- name: GetUserBlockRewards :many
+SELECT * FROM block_rewards
+WHERE user_id = $1
+ORDER BY created_at DESC;
+
+-- name: GetUserBlockRewardHistory :many
+SELECT * FROM block_rewards
+WHERE user_id = sqlc.arg(user_id)
+ORDER BY created_at DESC
+LIMIT sqlc.arg(row_limit) OFFSET sqlc.arg(row_offset);
+
+-- name: CountUserBlockRewards :one
+SELECT COUNT(*) FROM block_rewards
+WHERE user_id = $1;
+
 -- name: ClaimReward :exec
 UPDATE block_rewards 
 SET claimed = true, claimed_at = NOW() 
-WHERE id = $1 AND user_id = $2;
\ No newline at end of file
+WHERE id = $1 AND user_id = $2;
+
+-- name: ClaimUnclaimedReward :one
+-- Marks a reward claimed only if it is still unclaimed, so two concurrent
+-- claims of the same reward cannot both succeed.
+UPDATE block_rewards
+SET claimed = true, claimed_at = NOW()
+WHERE id = $1 AND user_id = $2 AND claimed = false
+RETURNING *;
+
+-- name: ListEmissionSchedules :many
+-- Governance schedule changes in the order they take effect.
+SELECT * FROM emission_schedules
+ORDER BY effective_from_block ASC, created_at ASC;
+
+-- name: CreateEmissionSchedule :one
+INSERT INTO emission_schedules (schedule, effective_from_block, proposal_id)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- -----------------------------------------------------------------
+-- Distribution
+-- -----------------------------------------------------------------
+
+-- name: LockMintBlock :one
+SELECT * FROM mint_blocks
+WHERE id = $1
+FOR UPDATE;
+
+-- name: GetUndistributedMintBlocks :many
+SELECT * FROM mint_blocks
+WHERE distribution_status <> 'distributed'
+ORDER BY block_number ASC;
+
+-- name: SnapshotBlockWeights :execrows
+-- Snapshots the weight of every user with any weight: CAN balance
+-- (transaction), LP weight and burn power.
+INSERT INTO weight_snapshots (user_id, block_id, transaction_weight, lp_weight, burn_weight)
+SELECT
+    u.id,
+    sqlc.arg(block_id),
+    COALESCE(ub.balance, 0),
+    COALESCE(cp.lp_weight, 0),
+    COALESCE(cp.burn_power, 0)
+FROM users u
+LEFT JOIN tokens can ON can.symbol = 'CAN'
+LEFT JOIN user_balances ub ON ub.user_id = u.id AND ub.token_id = can.id
+LEFT JOIN combat_power cp ON cp.user_id = u.id
+WHERE COALESCE(ub.balance, 0) > 0
+   OR COALESCE(cp.lp_weight, 0) > 0
+   OR COALESCE(cp.burn_power, 0) > 0
+ON CONFLICT (user_id, block_id) DO NOTHING;
+
+-- name: SetBlockDistributionStatus :exec
+UPDATE mint_blocks
+SET distribution_status = $2
+WHERE id = $1;
+
+-- name: CreateBlockRewardPools :exec
+-- Splits the block's total_reward into one pool per weight type, truncated
+-- to 18 decimals, and records each type's total snapshotted weight.
+INSERT INTO block_reward_pools (block_id, weight_type, share_bps, amount, total_weight)
+SELECT
+    mb.id,
+    p.weight_type,
+    p.share_bps,
+    trunc(mb.total_reward * p.share_bps / 10000, 18),
+    CASE p.weight_type
+        WHEN 'transaction' THEN t.transaction_weight
+        WHEN 'lp' THEN t.lp_weight
+        WHEN 'burn' THEN t.burn_weight
+    END
+FROM mint_blocks mb
+CROSS JOIN unnest(sqlc.arg(weight_types)::text[], sqlc.arg(share_bps)::int[]) AS p(weight_type, share_bps)
+CROSS JOIN (
+    SELECT
+        COALESCE(SUM(transaction_weight), 0) AS transaction_weight,
+        COALESCE(SUM(lp_weight), 0) AS lp_weight,
+        COALESCE(SUM(burn_weight), 0) AS burn_weight
+    FROM weight_snapshots
+    WHERE block_id = sqlc.arg(block_id)
+) t
+WHERE mb.id = sqlc.arg(block_id)
+ON CONFLICT (block_id, weight_type) DO NOTHING;
+
+-- name: GetBlockRewardPools :many
+SELECT * FROM block_reward_pools
+WHERE block_id = $1
+ORDER BY weight_type;
+
+-- name: DistributeBlockRewards :execrows
+-- Gives every snapshotted user pool_amount * weight / pool_total_weight for
+-- each pool they have weight in, truncated to 18 decimals, as one reward row
+-- per weight type. The truncated remainder and any pool nobody has weight
+-- in are the block's dust.
+INSERT INTO block_rewards (user_id, block_id, amount, weight_type, weight_value, claimed)
+SELECT
+    ws.user_id,
+    ws.block_id,
+    trunc(p.amount * w.value / p.total_weight, 18),
+    p.weight_type,
+    w.value,
+    false
+FROM block_reward_pools p
+JOIN weight_snapshots ws ON ws.block_id = p.block_id
+CROSS JOIN LATERAL (
+    SELECT CASE p.weight_type
+        WHEN 'transaction' THEN ws.transaction_weight
+        WHEN 'lp' THEN ws.lp_weight
+        WHEN 'burn' THEN ws.burn_weight
+    END AS value
+) w
+WHERE p.block_id = sqlc.arg(block_id)
+  AND p.total_weight > 0
+  AND w.value > 0
+ON CONFLICT (user_id, block_id, weight_type) DO NOTHING;
+
+-- name: GetBlockRewardTotal :one
+SELECT COALESCE(SUM(amount), 0)::decimal AS total
+FROM block_rewards
+WHERE block_id = $1;
+
+-- name: CompleteBlockDistribution :exec
+UPDATE mint_blocks
+SET
+    distributed = true,
+    distributed_at = NOW(),
+    dust_amount = $2,
+    distribution_status = 'distributed'
+WHERE id = $1;
+
+-- -----------------------------------------------------------------
+-- Merkle roots
+-- -----------------------------------------------------------------
+
+-- name: GetCumulativeRewardsAtBlock :many
+-- Every wallet's total block rewards up to and including block_number,
+-- ordered by wallet so the tree built from it is deterministic. Rewards
+-- already claimed into internal balances are left out so they are not paid
+-- a second time on chain.
+SELECT
+    LOWER(u.wallet_address)::text AS wallet_address,
+    SUM(br.amount)::decimal AS cumulative_amount
+FROM block_rewards br
+JOIN mint_blocks mb ON mb.id = br.block_id
+JOIN users u ON u.id = br.user_id
+WHERE mb.block_number <= $1
+  AND br.claimed IS NOT TRUE
+GROUP BY LOWER(u.wallet_address)
+HAVING SUM(br.amount) > 0
+ORDER BY LOWER(u.wallet_address);
+
+-- name: SetMintBlockMerkleRoot :exec
+UPDATE mint_blocks
+SET merkle_root = $2, merkle_leaf_count = $3
+WHERE id = $1;
+
+-- name: GetLatestMerkleBlock :one
+SELECT * FROM mint_blocks
+WHERE merkle_root IS NOT NULL
+ORDER BY block_number DESC
+LIMIT 1;
+
+-- name: GetMintBlockByNumber :one
+SELECT * FROM mint_blocks
+WHERE block_number = $1;
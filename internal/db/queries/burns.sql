@@ -4,4 +4,15 @@ VALUES ($1, $2, $3, $4, $5)
 RETURNING *;
 
 -- name: GetUserBurns :many
-SELECT * FROM burns WHERE user_id = $1 ORDER BY created_at DESC;
\ No newline at end of file
+SELECT * FROM burns WHERE user_id = $1 ORDER BY created_at DESC;
+
+-- name: GetUserBurnsForPower :many
+-- Every burn of the user with the burned token's symbol, for evaluating
+-- the combat power formula.
+SELECT
+    b.amount,
+    t.symbol,
+    b.created_at
+FROM burns b
+JOIN tokens t ON t.id = b.token_id
+WHERE b.user_id = $1;
@@ -1,15 +1,24 @@
 -- name: GetCombatPower :one
 SELECT * FROM combat_power WHERE user_id = $1;
 
+-- name: GetPersonalPowerForUpdate :one
+-- Locks the user's combat power row until the transaction ends.
+SELECT COALESCE(personal_power, 0)::decimal
+FROM combat_power
+WHERE user_id = $1
+FOR UPDATE;
+
 -- name: UpsertCombatPower :exec
-INSERT INTO combat_power (user_id, personal_power, network_power, lp_weight, burn_power, updated_at)
-VALUES ($1, $2, $3, $4, $5, NOW())
+INSERT INTO combat_power (user_id, personal_power, network_power, lp_weight, burn_power, badge_multiplier, formula_version, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
 ON CONFLICT (user_id) 
 DO UPDATE SET 
     personal_power = EXCLUDED.personal_power,
     network_power = EXCLUDED.network_power,
     lp_weight = EXCLUDED.lp_weight,
     burn_power = EXCLUDED.burn_power,
+    badge_multiplier = EXCLUDED.badge_multiplier,
+    formula_version = EXCLUDED.formula_version,
     updated_at = NOW();
 
 -- name: AddBurnPower :exec
@@ -18,4 +27,94 @@ SET burn_power = burn_power + $2, personal_power = personal_power + $2, updated_
 WHERE user_id = $1;
 
 -- name: GetNetworkCombatPower :one
-SELECT COALESCE(SUM(personal_power), 0)::decimal FROM combat_power;
\ No newline at end of file
+SELECT COALESCE(SUM(personal_power), 0)::decimal FROM combat_power;
+
+-- -----------------------------------------------------------------
+-- History
+-- -----------------------------------------------------------------
+
+-- name: UpsertCombatPowerSnapshots :execrows
+-- Snapshots every user's combat power components for snapshot_date, with
+-- their rank by personal power (1 = strongest, ties share a rank).
+INSERT INTO combat_power_history (
+    user_id, date, personal_power, network_power, lp_weight, burn_power, badge_multiplier, network_rank
+)
+SELECT
+    cp.user_id,
+    sqlc.arg(snapshot_date)::date,
+    COALESCE(cp.personal_power, 0),
+    COALESCE(cp.network_power, 0),
+    COALESCE(cp.lp_weight, 0),
+    COALESCE(cp.burn_power, 0),
+    cp.badge_multiplier,
+    RANK() OVER (ORDER BY COALESCE(cp.personal_power, 0) DESC)
+FROM combat_power cp
+ON CONFLICT (user_id, date) DO UPDATE
+SET
+    personal_power = EXCLUDED.personal_power,
+    network_power = EXCLUDED.network_power,
+    lp_weight = EXCLUDED.lp_weight,
+    burn_power = EXCLUDED.burn_power,
+    badge_multiplier = EXCLUDED.badge_multiplier,
+    network_rank = EXCLUDED.network_rank,
+    created_at = NOW();
+
+-- name: GetCombatPowerHistory :many
+-- One point per bucket ('day', 'week' or 'month') from the bucket's last
+-- snapshot, with the change since the previous bucket (zero for the
+-- user's first bucket). rank_change is positive when the user moved up.
+WITH buckets AS (
+    SELECT DISTINCT ON (date_trunc(sqlc.arg(bucket)::text, h.date))
+        date_trunc(sqlc.arg(bucket)::text, h.date)::date AS bucket_start,
+        h.personal_power,
+        h.network_power,
+        h.lp_weight,
+        h.burn_power,
+        h.badge_multiplier,
+        h.network_rank
+    FROM combat_power_history h
+    WHERE h.user_id = sqlc.arg(user_id)
+    ORDER BY date_trunc(sqlc.arg(bucket)::text, h.date), h.date DESC
+),
+changes AS (
+    SELECT
+        b.*,
+        COALESCE(b.personal_power - LAG(b.personal_power) OVER w, 0)::decimal AS personal_delta,
+        COALESCE(b.network_power - LAG(b.network_power) OVER w, 0)::decimal AS network_delta,
+        COALESCE(LAG(b.network_rank) OVER w - b.network_rank, 0)::int AS rank_change
+    FROM buckets b
+    WINDOW w AS (ORDER BY b.bucket_start)
+)
+SELECT * FROM changes
+WHERE bucket_start >= date_trunc(sqlc.arg(bucket)::text, sqlc.arg(since)::date)::date
+ORDER BY bucket_start ASC;
+
+
+-- -----------------------------------------------------------------
+-- Formulas
+-- -----------------------------------------------------------------
+
+-- name: GetActiveCombatFormula :one
+SELECT * FROM combat_power_formulas
+ORDER BY version DESC
+LIMIT 1;
+
+-- name: GetCombatFormula :one
+SELECT * FROM combat_power_formulas
+WHERE version = $1;
+
+-- name: CreateCombatFormula :one
+-- Adds the next formula version, which takes effect immediately.
+INSERT INTO combat_power_formulas (version, definition, proposal_id)
+SELECT COALESCE(MAX(version), 0) + 1, sqlc.arg(definition), sqlc.narg(proposal_id)
+FROM combat_power_formulas
+RETURNING *;
+
+-- name: ListCombatPowerPage :many
+-- Stored combat power in user_id order after sqlc.arg(after_id), for
+-- batch recomputation.
+SELECT user_id, COALESCE(personal_power, 0)::decimal AS personal_power, formula_version
+FROM combat_power
+WHERE user_id > sqlc.arg(after_id)
+ORDER BY user_id ASC
+LIMIT sqlc.arg(row_limit);
@@ -15,9 +15,10 @@ INSERT INTO governance_proposals (
     proposal_type,
     voting_end,
     quorum,
-    threshold
+    threshold,
+    payload
 ) VALUES (
-    $1, $2, $3, $4, $5, $6, $7
+    $1, $2, $3, $4, $5, $6, $7, $8
 )
 RETURNING *;
 
@@ -52,6 +53,19 @@ FROM governance_proposals
 ORDER BY created_at DESC
 LIMIT $1 OFFSET $2;
 
+-- name: ListProposalsByStatus :many
+SELECT *
+FROM governance_proposals
+WHERE status = sqlc.arg(status)::text
+ORDER BY created_at DESC
+LIMIT sqlc.arg(row_limit) OFFSET sqlc.arg(row_offset);
+
+-- name: CountProposals :one
+-- Counts proposals with the given status, or all of them when status is empty.
+SELECT COUNT(*)
+FROM governance_proposals
+WHERE sqlc.arg(status)::text = '' OR status = sqlc.arg(status)::text;
+
 -- name: UpdateProposalStatus :exec
 UPDATE governance_proposals
 SET status = $2, updated_at = NOW()
@@ -74,9 +88,10 @@ WHERE status = 'active'
   AND voting_end <= NOW();
 
 -- name: ExecuteProposal :one
+-- Only a passed proposal can be executed, and only once.
 UPDATE governance_proposals
 SET status = 'executed', updated_at = NOW()
-WHERE id = $1
+WHERE id = $1 AND status = 'passed'
 RETURNING *;
 
 -- -----------------------------------------------------------------
@@ -124,6 +139,9 @@ WHERE v.user_id = $1
 ORDER BY v.created_at DESC
 LIMIT $2 OFFSET $3;
 
+-- name: CountUserVotes :one
+SELECT COUNT(*) FROM votes WHERE user_id = $1;
+
 -- name: CountProposalVotes :one
 SELECT COUNT(*) FROM votes WHERE proposal_id = $1;
 
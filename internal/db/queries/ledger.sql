@@ -0,0 +1,170 @@
+-- internal/db/queries/ledger.sql
+-- ============================================
+-- Double-Entry Ledger Queries for Cang Lan Fu Platform
+-- ============================================
+
+-- -----------------------------------------------------------------
+-- Accounts
+-- -----------------------------------------------------------------
+
+-- name: GetOrCreateLedgerAccount :one
+INSERT INTO ledger_accounts (
+    account_type,
+    owner_id,
+    token_id
+) VALUES (
+    $1, $2, $3
+)
+ON CONFLICT ON CONSTRAINT ledger_accounts_unique
+DO UPDATE SET account_type = EXCLUDED.account_type
+RETURNING *;
+
+-- name: GetLedgerAccountBalance :one
+SELECT COALESCE(SUM(amount), 0)::decimal AS balance
+FROM ledger_postings
+WHERE account_id = $1;
+
+-- name: GetSystemAccountBalances :many
+SELECT
+    la.account_type,
+    la.owner_id,
+    t.symbol,
+    COALESCE(SUM(p.amount), 0)::decimal AS balance
+FROM ledger_accounts la
+JOIN tokens t ON la.token_id = t.id
+LEFT JOIN ledger_postings p ON p.account_id = la.id
+WHERE la.account_type <> 'user'
+GROUP BY la.id, la.account_type, la.owner_id, t.symbol
+ORDER BY la.account_type, t.symbol;
+
+-- -----------------------------------------------------------------
+-- Journal Entries & Postings
+-- -----------------------------------------------------------------
+
+-- name: CreateJournalEntry :one
+INSERT INTO journal_entries (
+    entry_type,
+    reference_id,
+    description
+) VALUES (
+    $1, $2, $3
+)
+RETURNING *;
+
+-- name: CreateLedgerPosting :one
+INSERT INTO ledger_postings (
+    entry_id,
+    account_id,
+    token_id,
+    amount
+) VALUES (
+    $1, $2, $3, $4
+)
+RETURNING *;
+
+-- name: GetJournalEntriesByReference :many
+SELECT *
+FROM journal_entries
+WHERE reference_id = $1
+ORDER BY created_at ASC;
+
+-- name: GetJournalEntryPostings :many
+SELECT
+    p.*,
+    la.account_type,
+    la.owner_id,
+    t.symbol
+FROM ledger_postings p
+JOIN ledger_accounts la ON p.account_id = la.id
+JOIN tokens t ON p.token_id = t.id
+WHERE p.entry_id = $1
+ORDER BY p.amount ASC;
+
+-- name: GetUserLedgerHistory :many
+SELECT
+    p.id,
+    p.entry_id,
+    p.amount,
+    p.created_at,
+    je.entry_type,
+    je.reference_id,
+    je.description,
+    t.symbol
+FROM ledger_postings p
+JOIN ledger_accounts la ON p.account_id = la.id
+JOIN journal_entries je ON p.entry_id = je.id
+JOIN tokens t ON p.token_id = t.id
+WHERE la.account_type = 'user'
+  AND la.owner_id = sqlc.arg(user_id)
+  AND (sqlc.narg(symbol)::text IS NULL OR t.symbol = sqlc.narg(symbol))
+ORDER BY p.created_at DESC
+LIMIT sqlc.arg(row_limit) OFFSET sqlc.arg(row_offset);
+
+-- name: CountUserLedgerHistory :one
+SELECT COUNT(*)
+FROM ledger_postings p
+JOIN ledger_accounts la ON p.account_id = la.id
+JOIN tokens t ON p.token_id = t.id
+WHERE la.account_type = 'user'
+  AND la.owner_id = sqlc.arg(user_id)
+  AND (sqlc.narg(symbol)::text IS NULL OR t.symbol = sqlc.narg(symbol));
+
+-- -----------------------------------------------------------------
+-- Balance Projection (user_balances)
+-- -----------------------------------------------------------------
+
+-- name: CreditUserBalanceProjection :exec
+INSERT INTO user_balances (user_id, token_id, balance)
+VALUES (sqlc.arg(user_id), sqlc.arg(token_id), sqlc.arg(amount))
+ON CONFLICT (user_id, token_id)
+DO UPDATE SET
+    balance = user_balances.balance + EXCLUDED.balance,
+    updated_at = NOW();
+
+-- name: DebitUserBalanceProjection :execrows
+UPDATE user_balances
+SET
+    balance = balance - sqlc.arg(amount),
+    updated_at = NOW()
+WHERE user_id = sqlc.arg(user_id)
+  AND token_id = sqlc.arg(token_id)
+  AND balance >= sqlc.arg(amount);
+
+-- -----------------------------------------------------------------
+-- Reconciliation
+-- -----------------------------------------------------------------
+
+-- name: ReconcileUserBalances :many
+-- Returns every (user, token) where the user_balances projection
+-- disagrees with the sum of the postings on the user's ledger account.
+WITH ledger AS (
+    SELECT
+        la.owner_id AS user_id,
+        la.token_id,
+        SUM(p.amount) AS ledger_balance
+    FROM ledger_postings p
+    JOIN ledger_accounts la ON p.account_id = la.id
+    WHERE la.account_type = 'user'
+    GROUP BY la.owner_id, la.token_id
+)
+SELECT
+    COALESCE(ub.user_id, l.user_id)::uuid AS user_id,
+    COALESCE(ub.token_id, l.token_id)::uuid AS token_id,
+    COALESCE(ub.balance, 0)::decimal AS projected_balance,
+    COALESCE(l.ledger_balance, 0)::decimal AS ledger_balance
+FROM user_balances ub
+FULL OUTER JOIN ledger l
+  ON ub.user_id = l.user_id AND ub.token_id = l.token_id
+WHERE COALESCE(ub.balance, 0) <> COALESCE(l.ledger_balance, 0)
+ORDER BY 1, 2;
+
+-- name: GetUnbalancedJournalEntries :many
+-- Returns entries whose postings do not sum to zero for some token.
+SELECT
+    p.entry_id,
+    p.token_id,
+    SUM(p.amount)::decimal AS imbalance
+FROM ledger_postings p
+GROUP BY p.entry_id, p.token_id
+HAVING SUM(p.amount) <> 0
+ORDER BY p.entry_id;
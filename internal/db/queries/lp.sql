@@ -18,11 +18,27 @@ SELECT *
 FROM liquidity_pools
 WHERE id = $1;
 
+-- name: LockLiquidityPool :one
+SELECT *
+FROM liquidity_pools
+WHERE id = $1
+FOR UPDATE;
+
 -- name: GetLiquidityPoolByTokens :one
 SELECT *
 FROM liquidity_pools
 WHERE token0_id = $1 AND token1_id = $2;
 
+-- name: GetActivePoolForPair :one
+-- Finds the pool trading a pair in either orientation.
+SELECT *
+FROM liquidity_pools
+WHERE is_active = true
+  AND ((token0_id = sqlc.arg(token_a) AND token1_id = sqlc.arg(token_b))
+    OR (token0_id = sqlc.arg(token_b) AND token1_id = sqlc.arg(token_a)))
+ORDER BY (reserve0 * reserve1) DESC
+LIMIT 1;
+
 -- name: CreateLiquidityPool :one
 INSERT INTO liquidity_pools (
     name,
@@ -30,9 +46,10 @@ INSERT INTO liquidity_pools (
     token1_id,
     total_liquidity_usd,
     apr,
-    is_active
+    is_active,
+    fee_bps
 ) VALUES (
-    $1, $2, $3, $4, $5, $6
+    $1, $2, $3, $4, $5, $6, $7
 )
 RETURNING *;
 
@@ -44,6 +61,21 @@ SET
     updated_at = NOW()
 WHERE id = $1;
 
+-- name: UpdatePoolReserves :exec
+UPDATE liquidity_pools
+SET
+    reserve0 = $2,
+    reserve1 = $3,
+    lp_total_supply = $4,
+    total_liquidity_usd = $5,
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: UpdatePoolFee :exec
+UPDATE liquidity_pools
+SET fee_bps = $2, updated_at = NOW()
+WHERE id = $1;
+
 -- name: UpdatePoolAPR :exec
 UPDATE liquidity_pools
 SET apr = $2, updated_at = NOW()
@@ -145,6 +177,11 @@ WHERE t.user_id = $1
 ORDER BY t.created_at DESC
 LIMIT $2 OFFSET $3;
 
+-- name: CountUserLPTransactions :one
+SELECT COUNT(*)
+FROM lp_transactions
+WHERE user_id = $1;
+
 -- name: GetLPTransactionsByPosition :many
 SELECT *
 FROM lp_transactions
@@ -165,6 +202,14 @@ SELECT COALESCE(lp_amount, 0)::decimal AS lp_weight
 FROM lp_positions
 WHERE user_id = $1 AND pool_id = $2;
 
+-- name: GetUserLPAmountsByPool :many
+SELECT
+    pool_id,
+    COALESCE(SUM(lp_amount), 0)::decimal AS lp_amount
+FROM lp_positions
+WHERE user_id = $1
+GROUP BY pool_id;
+
 -- name: GetAllUserLPWeights :many
 SELECT
     user_id,
@@ -186,6 +231,11 @@ SELECT COALESCE(SUM(lp_amount), 0)::decimal
 FROM lp_positions
 WHERE pool_id = $1;
 
+-- name: GetPoolUserCount :one
+SELECT COUNT(*)
+FROM lp_positions
+WHERE pool_id = $1 AND lp_amount > 0;
+
 -- name: GetUserSharePercentage :one
 SELECT
     COALESCE(
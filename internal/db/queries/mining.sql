@@ -20,4 +20,33 @@ VALUES ($1, $2, $3, $4, CURRENT_DATE);
 
 -- name: GetTodayMiningEarnings :many
 SELECT * FROM mining_earnings 
-WHERE user_id = $1 AND date = CURRENT_DATE AND earning_type = $2;
\ No newline at end of file
+WHERE user_id = $1 AND date = CURRENT_DATE;
+
+-- name: AddTotalMined :exec
+UPDATE mining_machines
+SET total_mined = total_mined + sqlc.arg(amount),
+    updated_at = NOW()
+WHERE user_id = sqlc.arg(user_id);
+
+-- name: GetMiningEarningsByDate :one
+SELECT COALESCE(SUM(amount), 0)::decimal
+FROM mining_earnings
+WHERE user_id = $1 AND date = $2;
+
+-- name: GetMiningEarningsSumByDateRange :one
+SELECT COALESCE(SUM(amount), 0)::decimal
+FROM mining_earnings
+WHERE user_id = sqlc.arg(user_id)
+  AND date BETWEEN sqlc.arg(start_date) AND sqlc.arg(end_date);
+
+-- name: GetMiningEarningsHistory :many
+SELECT * FROM mining_earnings
+WHERE user_id = sqlc.arg(user_id)
+  AND date > CURRENT_DATE - sqlc.arg(days)::int
+ORDER BY date DESC, created_at DESC
+LIMIT sqlc.arg(row_limit) OFFSET sqlc.arg(row_offset);
+
+-- name: CountMiningEarningsHistory :one
+SELECT COUNT(*) FROM mining_earnings
+WHERE user_id = sqlc.arg(user_id)
+  AND date > CURRENT_DATE - sqlc.arg(days)::int;
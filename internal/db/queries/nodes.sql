@@ -94,6 +94,30 @@ SET
     updated_at = NOW()
 WHERE user_id = $1;
 
+-- name: PropagateTeamDelta :execrows
+-- Adds power_delta and member_delta to the team stats of every ancestor of
+-- user_id, i.e. every user whose team (downline through invited_by or
+-- parent_id) contains user_id. Each ancestor is counted once.
+WITH RECURSIVE ancestors AS (
+    SELECT a.id
+    FROM users u
+    JOIN users a ON a.id IN (u.invited_by, u.parent_id)
+    WHERE u.id = sqlc.arg(user_id)
+    UNION
+    SELECT a.id
+    FROM ancestors c
+    JOIN users u ON u.id = c.id
+    JOIN users a ON a.id IN (u.invited_by, u.parent_id)
+)
+INSERT INTO user_nodes (user_id, team_power, team_members, updated_at)
+SELECT id, sqlc.arg(power_delta)::decimal, sqlc.arg(member_delta)::int, NOW()
+FROM ancestors
+ON CONFLICT (user_id)
+DO UPDATE SET
+    team_power = COALESCE(user_nodes.team_power, 0) + EXCLUDED.team_power,
+    team_members = COALESCE(user_nodes.team_members, 0) + EXCLUDED.team_members,
+    updated_at = NOW();
+
 -- -----------------------------------------------------------------
 -- Team Calculation Queries
 -- -----------------------------------------------------------------
@@ -134,13 +158,20 @@ WITH RECURSIVE team_tree AS (
     FROM users u
     INNER JOIN team_tree t ON u.invited_by = t.id OR u.parent_id = t.id
 )
-SELECT COUNT(*) FROM team_tree;
+SELECT COUNT(DISTINCT id) FROM team_tree;
 
 -- name: GetDirectReferralCount :one
 SELECT COUNT(*)
 FROM users
 WHERE invited_by = $1;
 
+-- name: GetDirectReferralsPage :many
+SELECT *
+FROM users
+WHERE invited_by = sqlc.arg(invited_by)
+ORDER BY created_at DESC, id
+LIMIT sqlc.arg(row_limit) OFFSET sqlc.arg(row_offset);
+
 -- name: GetUserNodeWithDetails :one
 SELECT
     un.*,
@@ -184,7 +215,7 @@ UPDATE users
 SET node_level = $2, updated_at = NOW()
 WHERE id = $1;
 
--- name: BatchUpgradeNodes :exec
+-- name: BatchUpgradeNodes :execrows
 WITH eligible_users AS (
     SELECT
         un.user_id,
@@ -268,6 +299,37 @@ FROM subtree
 WHERE id != $1
 ORDER BY depth, created_at;
 
+-- name: GetReferralSubtreePage :many
+-- One page of the user's downline down to max_depth levels below them,
+-- nearest levels first. Follows the tree explorer's parents.
+WITH RECURSIVE subtree AS (
+    SELECT id, wallet_address, invited_by, parent_id, node_level, created_at, 1 AS depth
+    FROM users
+    WHERE COALESCE(parent_id, invited_by) = sqlc.arg(user_id)
+    UNION ALL
+    SELECT u.id, u.wallet_address, u.invited_by, u.parent_id, u.node_level, u.created_at, s.depth + 1
+    FROM users u
+    INNER JOIN subtree s ON COALESCE(u.parent_id, u.invited_by) = s.id
+    WHERE s.depth < sqlc.arg(max_depth)::int
+)
+SELECT id, wallet_address, invited_by, parent_id, node_level, created_at, depth::int AS depth
+FROM subtree
+ORDER BY depth, created_at, id
+LIMIT sqlc.arg(row_limit) OFFSET sqlc.arg(row_offset);
+
+-- name: CountReferralSubtree :one
+WITH RECURSIVE subtree AS (
+    SELECT id, 1 AS depth
+    FROM users
+    WHERE COALESCE(parent_id, invited_by) = sqlc.arg(user_id)
+    UNION ALL
+    SELECT u.id, s.depth + 1
+    FROM users u
+    INNER JOIN subtree s ON COALESCE(u.parent_id, u.invited_by) = s.id
+    WHERE s.depth < sqlc.arg(max_depth)::int
+)
+SELECT COUNT(*) FROM subtree;
+
 -- name: GetReferralTreeDepth :one
 WITH RECURSIVE subtree AS (
     SELECT id, invited_by, parent_id, 0 AS depth
@@ -280,11 +342,140 @@ WITH RECURSIVE subtree AS (
 )
 SELECT COALESCE(MAX(depth), 0) FROM subtree;
 
+-- -----------------------------------------------------------------
+-- Tree Explorer
+-- -----------------------------------------------------------------
+-- A user's tree parent is parent_id, falling back to invited_by.
+
+-- name: GetTreeDepthBelow :one
+-- How many levels node_id is below root_id; no row when it is not in
+-- root_id's downline (0 when they are the same user).
+WITH RECURSIVE up AS (
+    SELECT id, COALESCE(parent_id, invited_by) AS up_id, 0 AS hops
+    FROM users
+    WHERE id = sqlc.arg(node_id)
+    UNION ALL
+    SELECT u.id, COALESCE(u.parent_id, u.invited_by), up.hops + 1
+    FROM up
+    JOIN users u ON u.id = up.up_id
+    WHERE up.id <> sqlc.arg(root_id) AND up.hops < 1000
+)
+SELECT hops::int FROM up WHERE id = sqlc.arg(root_id);
+
+-- name: GetTreeNode :one
+SELECT
+    u.id AS user_id,
+    u.wallet_address,
+    COALESCE(u.node_level, 0)::int AS node_level,
+    u.created_at,
+    COALESCE(cp.personal_power, 0)::decimal AS personal_power,
+    COALESCE(un.team_power, 0)::decimal AS team_power,
+    COALESCE(un.team_members, 0)::int AS team_members,
+    (
+        SELECT COUNT(*) FROM users c
+        WHERE c.parent_id = u.id OR (c.parent_id IS NULL AND c.invited_by = u.id)
+    ) AS direct_children
+FROM users u
+LEFT JOIN combat_power cp ON cp.user_id = u.id
+LEFT JOIN user_nodes un ON un.user_id = u.id
+WHERE u.id = $1;
+
+-- name: GetTreeChildren :many
+-- Pages a node's children by id.
+SELECT
+    u.id AS user_id,
+    u.wallet_address,
+    COALESCE(u.node_level, 0)::int AS node_level,
+    u.created_at,
+    COALESCE(cp.personal_power, 0)::decimal AS personal_power,
+    COALESCE(un.team_power, 0)::decimal AS team_power,
+    COALESCE(un.team_members, 0)::int AS team_members,
+    (
+        SELECT COUNT(*) FROM users c
+        WHERE c.parent_id = u.id OR (c.parent_id IS NULL AND c.invited_by = u.id)
+    ) AS direct_children
+FROM users u
+LEFT JOIN combat_power cp ON cp.user_id = u.id
+LEFT JOIN user_nodes un ON un.user_id = u.id
+WHERE (u.parent_id = sqlc.arg(parent_id) OR (u.parent_id IS NULL AND u.invited_by = sqlc.arg(parent_id)))
+  AND u.id > sqlc.arg(after_id)
+ORDER BY u.id
+LIMIT sqlc.arg(row_limit);
+
+-- name: GetSubtreeVolumes :many
+-- Completed purchase value since since of each root's subtree, the root
+-- included.
+WITH RECURSIVE sub AS (
+    SELECT id AS root_id, id
+    FROM users
+    WHERE id = ANY(sqlc.arg(root_ids)::uuid[])
+    UNION ALL
+    SELECT s.root_id, u.id
+    FROM sub s
+    JOIN users u ON u.parent_id = s.id OR (u.parent_id IS NULL AND u.invited_by = s.id)
+)
+SELECT s.root_id, COALESCE(SUM(p.total_value), 0)::decimal AS volume
+FROM sub s
+LEFT JOIN purchases p
+    ON p.user_id = s.id AND p.status = 'completed' AND p.completed_at >= sqlc.arg(since)
+GROUP BY s.root_id;
+
+-- name: SearchDownlineByWallet :many
+-- Finds users in root_id's downline whose wallet starts with the prefix,
+-- with the path of user IDs from root_id down to each.
+WITH RECURSIVE matches AS (
+    SELECT id, wallet_address
+    FROM users
+    WHERE LOWER(wallet_address) LIKE LOWER(sqlc.arg(wallet_prefix)::text) || '%'
+      AND id <> sqlc.arg(root_id)
+    LIMIT 500
+),
+chain AS (
+    SELECT m.id AS match_id, u.id AS node_id, COALESCE(u.parent_id, u.invited_by) AS up_id, ARRAY[u.id] AS path, 0 AS hops
+    FROM matches m
+    JOIN users u ON u.id = m.id
+    UNION ALL
+    SELECT c.match_id, p.id, COALESCE(p.parent_id, p.invited_by), p.id || c.path, c.hops + 1
+    FROM chain c
+    JOIN users p ON p.id = c.up_id
+    WHERE c.node_id <> sqlc.arg(root_id) AND c.hops < 1000
+)
+SELECT c.match_id AS user_id, m.wallet_address, c.hops::int AS depth, c.path::uuid[] AS path
+FROM chain c
+JOIN matches m ON m.id = c.match_id
+WHERE c.node_id = sqlc.arg(root_id)
+ORDER BY depth, m.wallet_address
+LIMIT sqlc.arg(row_limit);
+
+-- name: GetTreeChildrenOf :many
+-- Pages the children of a set of nodes by id, for exporting a subtree
+-- level by level.
+SELECT
+    u.id AS user_id,
+    u.parent_id,
+    u.invited_by,
+    u.wallet_address,
+    COALESCE(u.node_level, 0)::int AS node_level,
+    u.created_at,
+    COALESCE(cp.personal_power, 0)::decimal AS personal_power,
+    COALESCE(un.team_power, 0)::decimal AS team_power,
+    COALESCE(un.team_members, 0)::int AS team_members
+FROM users u
+LEFT JOIN combat_power cp ON cp.user_id = u.id
+LEFT JOIN user_nodes un ON un.user_id = u.id
+WHERE (u.parent_id = ANY(sqlc.arg(parent_ids)::uuid[])
+       OR (u.parent_id IS NULL AND u.invited_by = ANY(sqlc.arg(parent_ids)::uuid[])))
+  AND u.id > sqlc.arg(after_id)
+ORDER BY u.id
+LIMIT sqlc.arg(row_limit);
+
 -- -----------------------------------------------------------------
 -- Team Power Aggregation (for all users – maintenance)
 -- -----------------------------------------------------------------
 
--- name: RecalculateAllTeamStats :exec
+-- name: RecalculateAllTeamStats :many
+-- Recomputes every user's team power and member count from scratch, fixes
+-- the rows that drifted from it and returns them with their previous values.
 WITH team_stats AS (
     SELECT
         u.id AS user_id,
@@ -310,17 +501,36 @@ WITH team_stats AS (
                 FROM users m
                 INNER JOIN team t ON m.invited_by = t.id OR m.parent_id = t.id
             )
-            SELECT COUNT(*) FROM team
-        ) AS team_members
+            SELECT COUNT(DISTINCT id) FROM team
+        )::int AS team_members
     FROM users u
+),
+drift AS (
+    SELECT
+        ts.user_id,
+        COALESCE(un.team_power, 0)::decimal AS previous_team_power,
+        ts.team_power,
+        COALESCE(un.team_members, 0)::int AS previous_team_members,
+        ts.team_members
+    FROM team_stats ts
+    LEFT JOIN user_nodes un ON un.user_id = ts.user_id
+    WHERE COALESCE(un.team_power, 0) <> ts.team_power
+       OR COALESCE(un.team_members, 0) <> ts.team_members
+),
+repaired AS (
+    INSERT INTO user_nodes (user_id, team_power, team_members, updated_at)
+    SELECT user_id, team_power, team_members, NOW()
+    FROM drift
+    ON CONFLICT (user_id)
+    DO UPDATE SET
+        team_power = EXCLUDED.team_power,
+        team_members = EXCLUDED.team_members,
+        updated_at = NOW()
+    RETURNING user_id
 )
-UPDATE user_nodes un
-SET
-    team_power = ts.team_power,
-    team_members = ts.team_members,
-    updated_at = NOW()
-FROM team_stats ts
-WHERE un.user_id = ts.user_id;
+SELECT d.user_id, d.previous_team_power, d.team_power, d.previous_team_members, d.team_members
+FROM drift d
+JOIN repaired r ON r.user_id = d.user_id;
 
 -- -----------------------------------------------------------------
 -- Rights & Gift Limits
@@ -0,0 +1,101 @@
+-- internal/db/queries/portfolio.sql
+-- ============================================
+-- Portfolio Snapshot & PnL Queries for Cang Lan Fu
+-- ============================================
+
+-- -----------------------------------------------------------------
+-- Snapshots
+-- -----------------------------------------------------------------
+
+-- name: UpsertPortfolioSnapshots :execrows
+-- Snapshots every positive balance of every user for snapshot_date.
+INSERT INTO portfolio_snapshots (user_id, token_id, snapshot_date, balance, price_usd, value_usd)
+SELECT
+    ub.user_id,
+    ub.token_id,
+    sqlc.arg(snapshot_date)::date,
+    ub.balance,
+    COALESCE(t.price_usd, 0),
+    ub.balance * COALESCE(t.price_usd, 0)
+FROM user_balances ub
+JOIN tokens t ON t.id = ub.token_id
+WHERE ub.balance > 0
+ON CONFLICT (user_id, snapshot_date, token_id) DO UPDATE
+SET
+    balance = EXCLUDED.balance,
+    price_usd = EXCLUDED.price_usd,
+    value_usd = EXCLUDED.value_usd,
+    created_at = NOW();
+
+-- name: DeleteStalePortfolioSnapshots :exec
+-- Removes rows for balances that dropped to zero since the day's last run.
+DELETE FROM portfolio_snapshots ps
+WHERE ps.snapshot_date = sqlc.arg(snapshot_date)::date
+  AND NOT EXISTS (
+      SELECT 1 FROM user_balances ub
+      WHERE ub.user_id = ps.user_id
+        AND ub.token_id = ps.token_id
+        AND ub.balance > 0
+  );
+
+-- name: GetUserPortfolioHistory :many
+SELECT
+    snapshot_date,
+    SUM(value_usd)::decimal AS total_value_usd
+FROM portfolio_snapshots
+WHERE user_id = sqlc.arg(user_id)
+  AND snapshot_date >= sqlc.arg(since)::date
+GROUP BY snapshot_date
+ORDER BY snapshot_date ASC;
+
+-- -----------------------------------------------------------------
+-- Profit & Loss
+-- -----------------------------------------------------------------
+
+-- name: GetUserCostBasis :many
+-- Per token: what the user bought (completed purchases), what they sold
+-- (swaps out of the token, valued at the received token's price at the
+-- time of the swap) and what they hold now.
+WITH bought AS (
+    SELECT
+        token_id,
+        SUM(amount) AS amount,
+        SUM(total_value) AS cost
+    FROM purchases
+    WHERE user_id = sqlc.arg(user_id) AND status = 'completed'
+    GROUP BY token_id
+),
+sold AS (
+    SELECT
+        s.from_token_id AS token_id,
+        SUM(s.from_amount) AS amount,
+        SUM(s.to_amount * COALESCE(ph.price_usd, t.price_usd, 0)) AS proceeds
+    FROM swaps s
+    JOIN tokens t ON t.id = s.to_token_id
+    LEFT JOIN LATERAL (
+        SELECT h.price_usd
+        FROM token_price_history h
+        WHERE h.token_id = s.to_token_id AND h.recorded_at <= s.created_at
+        ORDER BY h.recorded_at DESC
+        LIMIT 1
+    ) ph ON true
+    WHERE s.user_id = sqlc.arg(user_id)
+    GROUP BY s.from_token_id
+)
+SELECT
+    t.id AS token_id,
+    t.symbol,
+    COALESCE(t.price_usd, 0)::decimal AS price_usd,
+    COALESCE(ub.balance, 0)::decimal AS balance,
+    COALESCE(b.amount, 0)::decimal AS bought_amount,
+    COALESCE(b.cost, 0)::decimal AS bought_cost,
+    COALESCE(so.amount, 0)::decimal AS sold_amount,
+    COALESCE(so.proceeds, 0)::decimal AS sold_proceeds
+FROM tokens t
+LEFT JOIN user_balances ub ON ub.token_id = t.id AND ub.user_id = sqlc.arg(user_id)
+LEFT JOIN bought b ON b.token_id = t.id
+LEFT JOIN sold so ON so.token_id = t.id
+WHERE b.token_id IS NOT NULL
+   OR so.token_id IS NOT NULL
+   OR ub.balance > 0
+ORDER BY t.symbol ASC;
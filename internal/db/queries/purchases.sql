@@ -6,6 +6,9 @@ RETURNING *;
 -- name: GetPurchaseByID :one
 SELECT * FROM purchases WHERE id = $1 AND user_id = $2;
 
+-- name: GetPurchaseForUpdate :one
+SELECT * FROM purchases WHERE id = $1 FOR UPDATE;
+
 -- name: UpdatePurchaseStatus :exec
 UPDATE purchases 
 SET status = $2, completed_at = CASE WHEN $2 = 'completed' THEN NOW() ELSE NULL END, tx_hash = $3
@@ -17,4 +20,7 @@ FROM purchases p
 JOIN tokens t ON p.token_id = t.id
 WHERE p.user_id = $1
 ORDER BY p.created_at DESC
-LIMIT $2 OFFSET $3;
\ No newline at end of file
+LIMIT $2 OFFSET $3;
+
+-- name: CountUserPurchases :one
+SELECT COUNT(*) FROM purchases WHERE user_id = $1;
@@ -1,6 +1,79 @@
 -- name: GetReferralByCode :one
 SELECT * FROM users WHERE referral_code = $1;
 
+-- -----------------------------------------------------------------
+-- Codes
+-- -----------------------------------------------------------------
+
+-- name: CreateReferralCode :one
+INSERT INTO referral_codes (user_id, code, campaign, vanity)
+VALUES ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: ResolveReferralCode :one
+-- Finds a code, current or replaced, regardless of case.
+SELECT * FROM referral_codes WHERE LOWER(code) = LOWER($1);
+
+-- name: GetReferralCode :one
+SELECT * FROM referral_codes WHERE id = $1;
+
+-- name: ReferralCodeExists :one
+SELECT EXISTS (SELECT 1 FROM referral_codes WHERE LOWER(code) = LOWER($1));
+
+-- name: GetReferralCodeForUser :one
+SELECT * FROM referral_codes WHERE id = $1 AND user_id = $2;
+
+-- name: ListUserReferralCodes :many
+SELECT * FROM referral_codes WHERE user_id = $1 ORDER BY created_at DESC, id;
+
+-- name: CountUserReferralCodes :one
+SELECT COUNT(*) FROM referral_codes WHERE user_id = $1;
+
+-- name: SetPrimaryReferralCode :exec
+-- Makes code the user's primary code and marks the previous primary code
+-- replaced.
+WITH replaced AS (
+    UPDATE referral_codes rc
+    SET replaced_at = NOW()
+    FROM users u
+    WHERE u.id = sqlc.arg(user_id)
+      AND rc.user_id = u.id
+      AND LOWER(rc.code) = LOWER(u.referral_code)
+      AND LOWER(rc.code) <> LOWER(sqlc.arg(code))
+)
+UPDATE users
+SET referral_code = sqlc.arg(code), updated_at = NOW()
+WHERE id = sqlc.arg(user_id);
+
+-- name: ClearReferralCodeReplaced :exec
+UPDATE referral_codes SET replaced_at = NULL WHERE id = $1;
+
+-- -----------------------------------------------------------------
+-- Attribution
+-- -----------------------------------------------------------------
+
+-- name: CreateReferralClick :one
+WITH counted AS (
+    UPDATE referral_codes SET clicks = clicks + 1 WHERE id = $1
+)
+INSERT INTO referral_clicks (code_id) VALUES ($1)
+RETURNING *;
+
+-- name: GetReferralClick :one
+SELECT * FROM referral_clicks WHERE id = $1;
+
+-- name: RecordReferralSignup :exec
+UPDATE referral_codes SET signups = signups + 1 WHERE id = $1;
+
+-- name: MarkReferralClickSignup :exec
+UPDATE referral_clicks
+SET signup_user_id = $2, signed_up_at = NOW()
+WHERE id = $1 AND signup_user_id IS NULL;
+
+-- -----------------------------------------------------------------
+-- Network
+-- -----------------------------------------------------------------
+
 -- name: GetDirectReferrals :many
 SELECT * FROM users WHERE invited_by = $1 ORDER BY created_at DESC;
 
@@ -11,4 +84,177 @@ SELECT COUNT(*) FROM users WHERE invited_by = $1;
 SELECT COALESCE(SUM(cp.personal_power), 0)::decimal
 FROM combat_power cp
 JOIN users u ON cp.user_id = u.id
-WHERE u.invited_by = $1 OR u.parent_id = $1;
\ No newline at end of file
+WHERE u.invited_by = $1 OR u.parent_id = $1;
+
+-- -----------------------------------------------------------------
+-- Commissions
+-- -----------------------------------------------------------------
+
+-- name: GetReferralUpline :many
+-- Returns the user's referrers through invited_by up to max_depth levels,
+-- the direct inviter first.
+WITH RECURSIVE upline AS (
+    SELECT u.invited_by AS id, 1 AS depth
+    FROM users u
+    WHERE u.id = sqlc.arg(user_id) AND u.invited_by IS NOT NULL
+    UNION ALL
+    SELECT u.invited_by, up.depth + 1
+    FROM upline up
+    JOIN users u ON u.id = up.id
+    WHERE u.invited_by IS NOT NULL AND up.depth < sqlc.arg(max_depth)::int
+)
+SELECT u.id AS user_id, up.depth::int AS depth, COALESCE(u.node_level, 0)::int AS node_level
+FROM upline up
+JOIN users u ON u.id = up.id
+ORDER BY up.depth;
+
+-- name: CreateReferralEarning :one
+INSERT INTO referral_earnings (
+    user_id, from_user_id, amount, token_id, earning_type,
+    depth, rate_bps, source_amount, reference_id, journal_entry_id,
+    status, payout_account, resolved_at
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12,
+    CASE WHEN $11 = 'held' THEN NULL ELSE NOW() END
+)
+RETURNING *;
+
+-- name: GetReferralEarningTotals :many
+SELECT t.symbol, re.earning_type, re.status, COALESCE(SUM(re.amount), 0)::decimal AS total
+FROM referral_earnings re
+JOIN tokens t ON t.id = re.token_id
+WHERE re.user_id = $1
+GROUP BY t.symbol, re.earning_type, re.status
+ORDER BY t.symbol, re.earning_type, re.status;
+
+-- name: GetReferralEarningsHistory :many
+SELECT
+    re.id,
+    re.from_user_id,
+    fu.wallet_address AS from_wallet,
+    re.earning_type,
+    re.depth,
+    re.rate_bps,
+    re.source_amount,
+    re.amount,
+    t.symbol,
+    re.status,
+    re.created_at
+FROM referral_earnings re
+JOIN tokens t ON t.id = re.token_id
+LEFT JOIN users fu ON fu.id = re.from_user_id
+WHERE re.user_id = $1
+ORDER BY re.created_at DESC, re.id
+LIMIT $2 OFFSET $3;
+
+-- name: CountReferralEarnings :one
+SELECT COUNT(*) FROM referral_earnings WHERE user_id = $1;
+
+-- -----------------------------------------------------------------
+-- Risk
+-- -----------------------------------------------------------------
+
+-- name: UpsertRiskSignal :exec
+INSERT INTO user_risk_signals (user_id, kind, value)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id, kind, value) DO UPDATE SET last_seen_at = NOW();
+
+-- name: GetRiskSignalOverlaps :many
+-- For each kind of the user's signals, whether their referrer shares a
+-- value and how many other accounts, the referrer excluded, do.
+SELECT
+    s.kind,
+    COALESCE(BOOL_OR(o.user_id = u.invited_by), FALSE)::bool AS shared_with_referrer,
+    COUNT(DISTINCT o.user_id) FILTER (WHERE o.user_id IS DISTINCT FROM u.invited_by)::int AS shared_accounts
+FROM user_risk_signals s
+JOIN users u ON u.id = s.user_id
+JOIN user_risk_signals o ON o.kind = s.kind AND o.value = s.value AND o.user_id <> s.user_id
+WHERE s.user_id = $1
+GROUP BY s.kind;
+
+-- name: HasRiskSignal :one
+SELECT EXISTS (
+    SELECT 1 FROM user_risk_signals WHERE user_id = $1 AND kind = $2 AND value = $3
+);
+
+-- name: CountCodeSignupsBetween :one
+SELECT COUNT(*) FROM referral_risk
+WHERE referral_code_id = sqlc.arg(referral_code_id)
+  AND created_at > sqlc.arg(since) AND created_at <= sqlc.arg(until);
+
+-- name: GetReferralRisk :one
+SELECT * FROM referral_risk WHERE user_id = $1;
+
+-- name: UpsertReferralRisk :one
+-- Stores a new score. A clear account becomes flagged when flag is set; a
+-- flagged or reviewed account keeps its status.
+INSERT INTO referral_risk (user_id, referral_code_id, score, reasons, status, flagged_at)
+VALUES (
+    sqlc.arg(user_id), sqlc.narg(referral_code_id), sqlc.arg(score), sqlc.arg(reasons),
+    CASE WHEN sqlc.arg(flag)::bool THEN 'flagged' ELSE 'clear' END,
+    CASE WHEN sqlc.arg(flag)::bool THEN NOW() END
+)
+ON CONFLICT (user_id) DO UPDATE SET
+    referral_code_id = COALESCE(referral_risk.referral_code_id, EXCLUDED.referral_code_id),
+    score = EXCLUDED.score,
+    reasons = EXCLUDED.reasons,
+    status = CASE WHEN referral_risk.status = 'clear' THEN EXCLUDED.status ELSE referral_risk.status END,
+    flagged_at = CASE WHEN referral_risk.status = 'clear' THEN EXCLUDED.flagged_at ELSE referral_risk.flagged_at END,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: GetReferralRiskStatuses :many
+SELECT user_id, status FROM referral_risk
+WHERE user_id = ANY(sqlc.arg(user_ids)::uuid[]) AND status IN ('flagged', 'rejected');
+
+-- name: ListReferralRiskQueue :many
+SELECT
+    rr.user_id,
+    u.wallet_address,
+    u.invited_by,
+    rr.score,
+    rr.reasons,
+    rr.status,
+    rr.flagged_at,
+    rr.reviewed_by,
+    rr.reviewed_at,
+    rr.review_note,
+    (SELECT COUNT(*) FROM referral_earnings re
+     WHERE re.status = 'held' AND (re.user_id = rr.user_id OR re.from_user_id = rr.user_id)) AS held_commissions
+FROM referral_risk rr
+JOIN users u ON u.id = rr.user_id
+WHERE rr.status = $1
+ORDER BY rr.score DESC, rr.flagged_at, rr.user_id
+LIMIT $2 OFFSET $3;
+
+-- name: CountReferralRiskQueue :one
+SELECT COUNT(*) FROM referral_risk WHERE status = $1;
+
+-- name: ReviewReferralRisk :one
+UPDATE referral_risk
+SET status = $2, reviewed_by = $3, review_note = $4, reviewed_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND status = 'flagged'
+RETURNING *;
+
+-- name: GetReleasableReferralEarnings :many
+-- Held commissions earned by or on the user that no flagged or rejected
+-- account is party to any more.
+SELECT re.* FROM referral_earnings re
+WHERE re.status = 'held'
+  AND (re.user_id = $1 OR re.from_user_id = $1)
+  AND NOT EXISTS (
+      SELECT 1 FROM referral_risk rr
+      WHERE rr.user_id IN (re.user_id, re.from_user_id) AND rr.status IN ('flagged', 'rejected')
+  )
+ORDER BY re.created_at, re.id
+FOR UPDATE OF re;
+
+-- name: ReleaseReferralEarning :exec
+UPDATE referral_earnings
+SET status = 'paid', journal_entry_id = $2, resolved_at = NOW()
+WHERE id = $1 AND status = 'held';
+
+-- name: ForfeitHeldReferralEarnings :execrows
+UPDATE referral_earnings
+SET status = 'forfeited', resolved_at = NOW()
+WHERE status = 'held' AND (user_id = $1 OR from_user_id = $1);
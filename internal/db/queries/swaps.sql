@@ -16,9 +16,11 @@ INSERT INTO swaps (
     to_amount,
     rate,
     tx_hash,
-    status
+    status,
+    pool_id,
+    fee_amount
 ) VALUES (
-    $1, $2, $3, $4, $5, $6, $7, 'completed'
+    $1, $2, $3, $4, $5, $6, $7, 'completed', $8, $9
 )
 RETURNING *;
 
@@ -44,6 +46,11 @@ WHERE s.user_id = $1
 ORDER BY s.created_at DESC
 LIMIT $2 OFFSET $3;
 
+-- name: CountUserSwaps :one
+SELECT COUNT(*)
+FROM swaps
+WHERE user_id = $1;
+
 -- name: GetUserSwapsByTimeRange :many
 SELECT
     s.*,
@@ -69,6 +76,38 @@ JOIN tokens tt ON s.to_token_id = tt.id
 ORDER BY s.created_at DESC
 LIMIT $1 OFFSET $2;
 
+-- -----------------------------------------------------------------
+-- Swap Legs (multi-hop routes)
+-- -----------------------------------------------------------------
+
+-- name: CreateSwapLeg :one
+INSERT INTO swap_legs (
+    swap_id,
+    leg_index,
+    pool_id,
+    from_token_id,
+    to_token_id,
+    amount_in,
+    amount_out,
+    fee_amount
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8
+)
+RETURNING *;
+
+-- name: GetSwapLegs :many
+SELECT
+    l.*,
+    ft.symbol AS from_token_symbol,
+    tt.symbol AS to_token_symbol,
+    p.name AS pool_name
+FROM swap_legs l
+JOIN tokens ft ON l.from_token_id = ft.id
+JOIN tokens tt ON l.to_token_id = tt.id
+LEFT JOIN liquidity_pools p ON l.pool_id = p.id
+WHERE l.swap_id = $1
+ORDER BY l.leg_index ASC;
+
 -- -----------------------------------------------------------------
 -- Swap Rate (from token prices)
 -- -----------------------------------------------------------------
@@ -112,6 +151,16 @@ WHERE user_id = $1
   AND created_at >= COALESCE($2::timestamp, '1970-01-01')
   AND created_at <= COALESCE($3::timestamp, NOW());
 
+-- name: GetUserSwapSummary :one
+SELECT
+    COUNT(*) AS swap_count,
+    COALESCE(SUM(fee_amount), 0)::decimal AS total_fees,
+    MIN(created_at)::timestamp AS first_swap_at,
+    MAX(created_at)::timestamp AS last_swap_at
+FROM swaps
+WHERE user_id = $1
+  AND status = 'completed';
+
 -- name: GetSwapVolume24h :one
 SELECT
     COALESCE(SUM(from_amount), 0)::decimal AS volume_24h,
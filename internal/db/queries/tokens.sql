@@ -58,18 +58,31 @@ WHERE id = $1
 RETURNING *;
 
 -- name: UpdateTokenPrice :exec
-UPDATE tokens
-SET
-    price_usd = $2,
-    price_updated_at = NOW()
-WHERE id = $1;
+-- Manual price changes are recorded in the price history like oracle updates.
+WITH updated AS (
+    UPDATE tokens
+    SET
+        price_usd = $2,
+        price_updated_at = NOW()
+    WHERE id = $1
+    RETURNING id, price_usd
+)
+INSERT INTO token_price_history (token_id, price_usd, source)
+SELECT id, price_usd, 'manual'
+FROM updated;
 
 -- name: UpdateTokenPriceBySymbol :exec
-UPDATE tokens
-SET
-    price_usd = $2,
-    price_updated_at = NOW()
-WHERE symbol = $1;
+WITH updated AS (
+    UPDATE tokens
+    SET
+        price_usd = $2,
+        price_updated_at = NOW()
+    WHERE symbol = $1
+    RETURNING id, price_usd
+)
+INSERT INTO token_price_history (token_id, price_usd, source)
+SELECT id, price_usd, 'manual'
+FROM updated;
 
 -- name: DeleteToken :exec
 DELETE FROM tokens WHERE id = $1;
@@ -101,6 +114,7 @@ SELECT
     t.name,
     t.decimals,
     t.price_usd,
+    t.price_updated_at,
     (ub.balance * t.price_usd)::decimal AS value_usd
 FROM user_balances ub
 JOIN tokens t ON ub.token_id = t.id
@@ -120,6 +134,17 @@ WHERE ub.user_id = $1
   AND t.symbol IN (sqlc.slice('symbols'))
 ORDER BY t.symbol;
 
+-- name: LockUserBalances :many
+-- Locks the user's balance rows for the given tokens until the end of the
+-- transaction. Rows are locked in token_id order so that concurrent
+-- operations on the same user cannot deadlock.
+SELECT *
+FROM user_balances
+WHERE user_id = sqlc.arg(user_id)
+  AND token_id = ANY(sqlc.arg(token_ids)::uuid[])
+ORDER BY token_id
+FOR UPDATE;
+
 -- name: CreateUserBalance :one
 INSERT INTO user_balances (
     user_id,
@@ -195,6 +220,43 @@ JOIN user_balances ub ON t.id = ub.token_id
 WHERE ub.user_id = $1 AND ub.balance > 0
 ORDER BY t.symbol;
 
+-- -----------------------------------------------------------------
+-- Network Statistics
+-- -----------------------------------------------------------------
+
+-- name: GetNetworkTokenStats :many
+-- Holdings of every active token across all users in one pass.
+SELECT
+    t.id AS token_id,
+    t.symbol,
+    COALESCE(t.price_usd, 0)::decimal AS price_usd,
+    COALESCE(SUM(ub.balance), 0)::decimal AS total_balance,
+    COUNT(ub.user_id) AS holders,
+    (COALESCE(SUM(ub.balance), 0) * COALESCE(t.price_usd, 0))::decimal AS tvl_usd
+FROM tokens t
+LEFT JOIN user_balances ub ON ub.token_id = t.id AND ub.balance > 0
+WHERE t.is_active = true
+GROUP BY t.id, t.symbol, t.price_usd
+ORDER BY tvl_usd DESC;
+
+-- name: GetNetworkHolderCount :one
+SELECT COUNT(DISTINCT user_id)
+FROM user_balances
+WHERE balance > 0;
+
+-- name: GetTopHoldersByValue :many
+SELECT
+    u.id,
+    u.wallet_address,
+    SUM(ub.balance * COALESCE(t.price_usd, 0))::decimal AS value_usd
+FROM user_balances ub
+JOIN users u ON u.id = ub.user_id
+JOIN tokens t ON t.id = ub.token_id
+WHERE ub.balance > 0 AND t.is_active = true
+GROUP BY u.id, u.wallet_address
+ORDER BY value_usd DESC
+LIMIT $1;
+
 -- -----------------------------------------------------------------
 -- Price Oracle Support
 -- -----------------------------------------------------------------
@@ -213,7 +275,65 @@ SET
     price_updated_at = NOW()
 FROM (
     SELECT
-        unnest($1::uuid[]) AS id,
-        unnest($2::decimal[]) AS price_usd
+        unnest(sqlc.arg(ids)::uuid[]) AS id,
+        unnest(sqlc.arg(prices)::decimal[]) AS price_usd
 ) AS data_table
-WHERE tokens.id = data_table.id;
\ No newline at end of file
+WHERE tokens.id = data_table.id;
+
+-- name: BulkInsertTokenPriceHistory :exec
+INSERT INTO token_price_history (token_id, price_usd, source)
+SELECT
+    unnest(sqlc.arg(ids)::uuid[]),
+    unnest(sqlc.arg(prices)::decimal[]),
+    unnest(sqlc.arg(sources)::text[]);
+
+-- -----------------------------------------------------------------
+-- Price History
+-- -----------------------------------------------------------------
+
+-- name: GetTokenPriceCandles :many
+-- OHLC candles of bucket_seconds width, aligned to the epoch.
+SELECT
+    date_bin(make_interval(secs => sqlc.arg(bucket_seconds)::int), recorded_at, TIMESTAMP '1970-01-01')::timestamp AS bucket_start,
+    ((array_agg(price_usd ORDER BY recorded_at ASC))[1])::decimal AS open,
+    MAX(price_usd)::decimal AS high,
+    MIN(price_usd)::decimal AS low,
+    ((array_agg(price_usd ORDER BY recorded_at DESC))[1])::decimal AS close,
+    COUNT(*) AS samples
+FROM token_price_history
+WHERE token_id = sqlc.arg(token_id)
+  AND recorded_at >= sqlc.arg(since)::timestamp
+GROUP BY bucket_start
+ORDER BY bucket_start ASC;
+
+-- name: GetTokenPricesAt24hAgo :many
+-- The reference price for 24h change figures: the last price recorded at
+-- least 24 hours ago or, for tokens with a shorter history, the oldest one.
+-- Each branch is a single probe of idx_token_price_history_token_time.
+SELECT
+    t.id AS token_id,
+    ref.price_usd
+FROM tokens t
+CROSS JOIN LATERAL (
+    SELECT c.price_usd
+    FROM (
+        (
+            SELECT h.price_usd, 0 AS preference
+            FROM token_price_history h
+            WHERE h.token_id = t.id
+              AND h.recorded_at <= NOW() - INTERVAL '24 hours'
+            ORDER BY h.recorded_at DESC
+            LIMIT 1
+        )
+        UNION ALL
+        (
+            SELECT h.price_usd, 1 AS preference
+            FROM token_price_history h
+            WHERE h.token_id = t.id
+            ORDER BY h.recorded_at ASC
+            LIMIT 1
+        )
+    ) c
+    ORDER BY c.preference
+    LIMIT 1
+) ref;
@@ -0,0 +1,61 @@
+-- internal/db/queries/vesting.sql
+-- ============================================
+-- Vesting Schedule Queries for Cang Lan Fu
+-- ============================================
+
+-- name: CreateVestingSchedule :one
+INSERT INTO vesting_schedules (
+    user_id, token_id, source, reference_id, journal_entry_id, amount, start_at, end_at
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8
+)
+RETURNING *;
+
+-- name: GetActiveVestingScheduleIDs :many
+-- Active schedules that have started vesting, in id order after
+-- sqlc.arg(after_id) so the release job can page through them.
+SELECT id FROM vesting_schedules
+WHERE status = 'active'
+  AND start_at < sqlc.arg(as_of)
+  AND id > sqlc.arg(after_id)
+ORDER BY id ASC
+LIMIT sqlc.arg(row_limit);
+
+-- name: LockVestingSchedule :one
+SELECT * FROM vesting_schedules
+WHERE id = $1
+FOR UPDATE;
+
+-- name: UpdateVestingRelease :exec
+UPDATE vesting_schedules
+SET
+    released_amount = $2,
+    penalty_amount = $3,
+    status = $4,
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: GetUserVestingSchedules :many
+SELECT
+    vs.*,
+    t.symbol
+FROM vesting_schedules vs
+JOIN tokens t ON t.id = vs.token_id
+WHERE vs.user_id = $1
+  AND (sqlc.narg(status)::varchar IS NULL OR vs.status = sqlc.narg(status))
+ORDER BY vs.created_at DESC;
+
+-- name: GetUserLockedBalances :many
+-- Per token, the part of the user's vesting schedules not yet released.
+SELECT
+    t.id AS token_id,
+    t.symbol,
+    t.name,
+    t.price_usd,
+    t.price_updated_at,
+    SUM(vs.amount - vs.released_amount - vs.penalty_amount)::decimal AS locked
+FROM vesting_schedules vs
+JOIN tokens t ON t.id = vs.token_id
+WHERE vs.user_id = $1 AND vs.status = 'active'
+GROUP BY t.id, t.symbol, t.name, t.price_usd, t.price_updated_at
+ORDER BY t.symbol;
@@ -25,6 +25,12 @@ SELECT *
 FROM withdrawals
 WHERE id = $1;
 
+-- name: GetWithdrawalForUpdate :one
+SELECT *
+FROM withdrawals
+WHERE id = $1
+FOR UPDATE;
+
 -- name: GetWithdrawalByTxHash :one
 SELECT *
 FROM withdrawals
@@ -41,6 +47,11 @@ WHERE w.user_id = $1
 ORDER BY w.created_at DESC
 LIMIT $2 OFFSET $3;
 
+-- name: CountUserWithdrawals :one
+SELECT COUNT(*)
+FROM withdrawals
+WHERE user_id = $1;
+
 -- name: GetUserWithdrawalsByStatus :many
 SELECT
     w.*,
@@ -2,6 +2,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -13,13 +14,15 @@ import (
 
 // AssetHandler handles asset-related HTTP requests.
 type AssetHandler struct {
-	assetSvc *services.AssetService
+	assetSvc  *services.AssetService
+	ledgerSvc *services.LedgerService
 }
 
 // NewAssetHandler creates a new asset handler.
-func NewAssetHandler(assetSvc *services.AssetService) *AssetHandler {
+func NewAssetHandler(assetSvc *services.AssetService, ledgerSvc *services.LedgerService) *AssetHandler {
 	return &AssetHandler{
-		assetSvc: assetSvc,
+		assetSvc:  assetSvc,
+		ledgerSvc: ledgerSvc,
 	}
 }
 
@@ -27,8 +30,11 @@ func NewAssetHandler(assetSvc *services.AssetService) *AssetHandler {
 func (h *AssetHandler) RegisterRoutes(r chi.Router) {
 	r.Get("/assets/portfolio", h.GetPortfolio)
 	r.Get("/assets/balance/{symbol}", h.GetTokenBalance)
+	r.Get("/assets/ledger", h.GetLedgerHistory)
+	r.Get("/assets/history", h.GetAssetHistory)
 	r.Get("/assets/tokens", h.ListTokens)
 	r.Get("/assets/tokens/{symbol}/price", h.GetTokenPrice)
+	r.Get("/assets/tokens/{symbol}/candles", h.GetTokenCandles)
 	r.Get("/assets/tokens/{symbol}/holders", h.GetTopHolders)
 	r.Get("/assets/stats/network", h.GetNetworkStats)
 }
@@ -76,14 +82,64 @@ func (h *AssetHandler) GetTokenBalance(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// ListTokens returns all active tokens available on the platform.
+// GetLedgerHistory returns the ledger postings behind the user's balances,
+// optionally filtered by token symbol (?symbol=CAN&page=1&limit=20).
+func (h *AssetHandler) GetLedgerHistory(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		web.Unauthorized(w, "user not authenticated")
+		return
+	}
+
+	page := web.ParsePagination(r)
+	offset := (page.Page - 1) * page.Limit
+	symbol := r.URL.Query().Get("symbol")
+
+	postings, total, err := h.ledgerSvc.GetUserLedgerHistory(r.Context(), userID, symbol, int32(page.Limit), int32(offset))
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
+
+	web.SuccessWithMeta(w, http.StatusOK, postings, web.NewMeta(page, total))
+}
+
+// GetAssetHistory returns the user's daily portfolio value over the last
+// N days together with realised and unrealised PnL.
+// GET /assets/history?days=30
+func (h *AssetHandler) GetAssetHistory(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		web.Unauthorized(w, "user not authenticated")
+		return
+	}
+
+	days := 30
+	if d, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && d > 0 {
+		days = d
+	}
+
+	history, err := h.assetSvc.GetUserAssetHistory(r.Context(), userID, days)
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
+	pnl, err := h.assetSvc.GetUserPnL(r.Context(), userID)
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
+
+	web.Success(w, http.StatusOK, map[string]interface{}{
+		"days":    days,
+		"history": history,
+		"pnl":     pnl,
+	})
+}
+
+// ListTokens returns all active tokens with their 24h price change.
 func (h *AssetHandler) ListTokens(w http.ResponseWriter, r *http.Request) {
-	// This could be public or authenticated – we'll keep it public for now.
-	// If you want authentication, add the middleware.
-	tokens, err := h.assetSvc.queries.ListTokens(r.Context()) // need to expose? We can add a method.
-	// Better to add a method to assetSvc: ListAllTokens().
-	// For simplicity, we'll assume assetSvc has a ListAllTokens method.
-	// We'll add that now:
+	tokens, err := h.assetSvc.ListTokens(r.Context())
 	if err != nil {
 		web.InternalError(w, err)
 		return
@@ -112,6 +168,38 @@ func (h *AssetHandler) GetTokenPrice(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// GetTokenCandles returns OHLC price candles for a token.
+// GET /assets/tokens/{symbol}/candles?interval=1h&limit=100
+func (h *AssetHandler) GetTokenCandles(w http.ResponseWriter, r *http.Request) {
+	symbol := chi.URLParam(r, "symbol")
+	if symbol == "" {
+		web.Error(w, http.StatusBadRequest, "token symbol required")
+		return
+	}
+
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = "1h"
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	candles, err := h.assetSvc.GetTokenCandles(r.Context(), symbol, interval, limit)
+	if err != nil {
+		if errors.Is(err, services.ErrUnsupportedInterval) {
+			web.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		web.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	web.Success(w, http.StatusOK, map[string]interface{}{
+		"symbol":   symbol,
+		"interval": interval,
+		"candles":  candles,
+	})
+}
+
 // GetTopHolders returns the top N holders of a specific token.
 func (h *AssetHandler) GetTopHolders(w http.ResponseWriter, r *http.Request) {
 	symbol := chi.URLParam(r, "symbol")
@@ -2,7 +2,13 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
+	"net"
 	"net/http"
+	"net/netip"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -16,13 +22,19 @@ type AuthHandler struct {
 	walletAuth  *auth.WalletAuth
 	queries     *db.Queries
 	referralSvc *services.ReferralService
+	riskSvc     *services.ReferralRiskService
+	combatSvc   *services.CombatPowerService
+	proxies     []netip.Prefix
 }
 
-func NewAuthHandler(wa *auth.WalletAuth, q *db.Queries, rs *services.ReferralService) *AuthHandler {
+func NewAuthHandler(wa *auth.WalletAuth, q *db.Queries, rs *services.ReferralService, risk *services.ReferralRiskService, combat *services.CombatPowerService, trustedProxies []netip.Prefix) *AuthHandler {
 	return &AuthHandler{
 		walletAuth:  wa,
 		queries:     q,
 		referralSvc: rs,
+		riskSvc:     risk,
+		combatSvc:   combat,
+		proxies:     trustedProxies,
 	}
 }
 
@@ -47,24 +59,34 @@ func (h *AuthHandler) GetNonce(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	nonce, err := h.walletAuth.GenerateNonce(req.Wallet)
+	nonce, err := h.walletAuth.GenerateNonce(r.Context(), req.Wallet)
 	if err != nil {
 		web.Error(w, http.StatusInternalServerError, "failed to generate nonce")
 		return
 	}
 
-	web.Respond(w, http.StatusOK, NonceResponse{Nonce: nonce})
+	web.Success(w, http.StatusOK, NonceResponse{Nonce: nonce})
 }
 
 type LoginRequest struct {
 	Wallet    string `json:"wallet"`
 	Signature string `json:"signature"`
 	Referral  string `json:"referral,omitempty"` // optional referral code
+
+	// Optional click ID from POST /referral/click; credits the signup to the
+	// clicked link while within the attribution window
+	ReferralClick *uuid.UUID `json:"referral_click,omitempty"`
+
+	// Optional client device fingerprint, used to detect self-referral and
+	// accounts operated together
+	DeviceFingerprint string `json:"device_fingerprint,omitempty"`
 }
 
 type LoginResponse struct {
-	Token string   `json:"token"`
-	User  *db.User `json:"user"`
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	User         *db.User  `json:"user"`
 }
 
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -74,39 +96,56 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get nonce from store
-	nonceKey := "nonce:" + req.Wallet
-	nonce, err := h.walletAuth.nonceStore.Get(r.Context(), nonceKey)
-	if err != nil || nonce == "" {
+	// Each nonce is good for one login attempt
+	nonce, err := h.walletAuth.ConsumeNonce(r.Context(), req.Wallet)
+	if err != nil {
 		web.Error(w, http.StatusUnauthorized, "nonce expired or not found")
 		return
 	}
-	defer h.walletAuth.nonceStore.Delete(r.Context(), nonceKey)
 
 	// Verify signature
-	ok, err := h.walletAuth.VerifySignature(req.Wallet, req.Signature, nonce)
-	if err != nil || !ok {
+	if err := h.walletAuth.VerifySignature(req.Wallet, req.Signature, nonce); err != nil {
 		web.Error(w, http.StatusUnauthorized, "invalid signature")
 		return
 	}
 
+	signals := services.RiskSignals{IP: clientIP(r, h.proxies), DeviceFingerprint: req.DeviceFingerprint}
+	var signupCode *uuid.UUID
+
 	// Find or create user
 	user, err := h.queries.GetUserByWallet(r.Context(), req.Wallet)
 	if err != nil {
 		// New user - create account
-		code, _ := h.referralSvc.GenerateReferralCode()
+		code, err := h.referralSvc.NewRandomCode(r.Context())
+		if err != nil {
+			web.Error(w, http.StatusInternalServerError, "failed to create user")
+			return
+		}
 		var parentID, invitedBy *uuid.UUID
 
-		// Handle referral
-		if req.Referral != "" {
-			referrer, err := h.queries.GetReferralByCode(r.Context(), req.Referral)
+		// Handle referral; a code or click that cannot be credited is ignored
+		var attribution *services.ReferralAttribution
+		if req.Referral != "" || req.ReferralClick != nil {
+			attribution, err = h.referralSvc.ResolveReferral(r.Context(), req.Referral, req.ReferralClick)
 			if err == nil {
-				invitedBy = &referrer.ID
-				parentID = &referrer.ID
+				invitedBy = &attribution.UserID
+				parentID = &attribution.UserID
 			}
 		}
 
-		user, err = h.queries.CreateUser(r.Context(), db.CreateUserParams{
+		// Self-referral is refused outright rather than ignored
+		if invitedBy != nil {
+			if err := h.riskSvc.CheckReferral(r.Context(), req.Wallet, *invitedBy, signals); err != nil {
+				if errors.Is(err, services.ErrSelfReferral) {
+					web.Error(w, http.StatusForbidden, err.Error())
+				} else {
+					web.Error(w, http.StatusInternalServerError, "failed to check referral")
+				}
+				return
+			}
+		}
+
+		created, err := h.referralSvc.CreateUser(r.Context(), db.CreateUserParams{
 			WalletAddress: req.Wallet,
 			ReferralCode:  code,
 			ParentID:      parentID,
@@ -117,36 +156,45 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 			web.Error(w, http.StatusInternalServerError, "failed to create user")
 			return
 		}
+		user = *created
 
 		// Create default mining machine
 		h.queries.CreateMiningMachine(r.Context(), user.ID)
 		// Create withdrawal limits
 		h.queries.CreateWithdrawalLimits(r.Context(), user.ID)
-		// Create combat power entry
-		h.queries.UpsertCombatPower(r.Context(), db.UpsertCombatPowerParams{
-			UserID:        user.ID,
-			PersonalPower: decimal.Zero,
-			NetworkPower:  decimal.Zero,
-			LpWeight:      decimal.Zero,
-			BurnPower:     decimal.Zero,
-		})
+		// Create combat power entry, stamped with the formula in force
+		if err := h.combatSvc.UpdateCombatPower(r.Context(), user.ID); err != nil {
+			log.Printf("signup %s: failed to create combat power: %v", user.ID, err)
+		}
 
 		// Process referral if exists
 		if invitedBy != nil {
-			h.referralSvc.ProcessReferral(r.Context(), user.ID, *invitedBy, parentID)
+			if err := h.referralSvc.ProcessReferral(r.Context(), user.ID, *invitedBy, parentID); err != nil {
+				log.Printf("signup %s: failed to process referral from %s: %v", user.ID, *invitedBy, err)
+			}
+			h.referralSvc.RecordSignup(r.Context(), attribution, user.ID)
+			signupCode = &attribution.CodeID
 		}
 	}
 
+	// Score the account on what this login reveals; a signup is also
+	// scored against its referral code
+	if _, err := h.riskSvc.RecordSignals(r.Context(), user.ID, signals, signupCode); err != nil {
+		log.Printf("login %s: failed to record risk signals: %v", user.ID, err)
+	}
+
 	// Generate JWT
-	token, err := h.walletAuth.GenerateJWT(user.ID, user.WalletAddress)
+	tokens, err := h.walletAuth.GenerateTokenPair(user.ID, user.WalletAddress)
 	if err != nil {
 		web.Error(w, http.StatusInternalServerError, "failed to generate token")
 		return
 	}
 
-	web.Respond(w, http.StatusOK, LoginResponse{
-		Token: token,
-		User:  &user,
+	web.Success(w, http.StatusOK, LoginResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt,
+		User:         &user,
 	})
 }
 
@@ -163,5 +211,46 @@ func (h *AuthHandler) GetMe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	web.Respond(w, http.StatusOK, user)
+	web.Success(w, http.StatusOK, user)
+}
+
+// clientIP returns the address the request came from. X-Forwarded-For is
+// only believed when the peer is a trusted proxy, and is read from the
+// right: the first hop not added by a trusted proxy is the client, as
+// anything before it may have been written by the client itself.
+func clientIP(r *http.Request, trusted []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host, trusted) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !isTrustedProxy(hop, trusted) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+func isTrustedProxy(ip string, trusted []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,39 @@
+// internal/handlers/auth_test.go
+package handlers
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{"direct client", "203.0.113.7:4000", "", "203.0.113.7"},
+		{"untrusted peer cannot forward", "203.0.113.7:4000", "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy without header", "10.0.0.1:4000", "", "10.0.0.1"},
+		{"client-written hops are skipped", "10.0.0.1:4000", "198.51.100.1, 203.0.113.7", "203.0.113.7"},
+		{"proxy chain", "10.0.0.1:4000", "198.51.100.1, 203.0.113.7, 10.0.0.2", "203.0.113.7"},
+		{"only proxies", "10.0.0.1:4000", "10.0.0.3, 10.0.0.2", "10.0.0.3"},
+		{"mapped IPv4 peer", "[::ffff:10.0.0.1]:4000", "203.0.113.7", "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/auth/login", nil)
+			r.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if got := clientIP(r, trusted); got != tt.want {
+				t.Errorf("clientIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
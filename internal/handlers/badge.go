@@ -24,21 +24,20 @@ func NewBadgeHandler(badgeSvc *services.BadgeService) *BadgeHandler {
 	}
 }
 
-// RegisterRoutes registers badge routes under the authenticated group.
-func (h *BadgeHandler) RegisterRoutes(r chi.Router) {
-	// Public routes (or optional auth)
+// RegisterPublicRoutes registers the badge catalog, which visitors can
+// browse without an account.
+func (h *BadgeHandler) RegisterPublicRoutes(r chi.Router) {
 	r.Get("/badges", h.ListAvailableBadges)
 	r.Get("/badges/{id}", h.GetBadgeDetails)
 	r.Get("/badges/stats/network", h.GetNetworkBadgeStats)
+}
 
-	// Authenticated routes
-	r.Group(func(r chi.Router) {
-		r.Use(auth.Middleware) // Assuming auth middleware is available via context or passed
-		r.Get("/badges/user", h.GetUserBadges)
-		r.Get("/badges/user/active", h.GetUserActiveBadges)
-		r.Post("/badges/purchase", h.PurchaseBadge)
-		r.Get("/badges/records", h.GetBadgeRecords) // could be network badge records
-	})
+// RegisterRoutes registers badge routes under the authenticated group.
+func (h *BadgeHandler) RegisterRoutes(r chi.Router) {
+	r.Get("/badges/user", h.GetUserBadges)
+	r.Get("/badges/user/active", h.GetUserActiveBadges)
+	r.Post("/badges/purchase", h.PurchaseBadge)
+	r.Get("/badges/records", h.GetBadgeRecords) // could be network badge records
 }
 
 // ListAvailableBadges returns all badges available for purchase.
@@ -139,12 +138,12 @@ func (h *BadgeHandler) PurchaseBadge(w http.ResponseWriter, r *http.Request) {
 
 // GetBadgeRecords returns network-wide badge records (badge direct list).
 // This is a network statistics endpoint.
-// GET /badges/records?limit=10&offset=0
+// GET /badges/records?page=1&limit=10
 func (h *BadgeHandler) GetBadgeRecords(w http.ResponseWriter, r *http.Request) {
-	// Parse pagination
-	limit, offset := web.ParsePagination(r)
+	page := web.ParsePagination(r)
+	offset := (page.Page - 1) * page.Limit
 
-	records, err := h.badgeSvc.GetBadgeDirectList(r.Context(), int32(limit), int32(offset))
+	records, err := h.badgeSvc.GetBadgeDirectList(r.Context(), int32(page.Limit), int32(offset))
 	if err != nil {
 		web.InternalError(w, err)
 		return
@@ -156,8 +155,7 @@ func (h *BadgeHandler) GetBadgeRecords(w http.ResponseWriter, r *http.Request) {
 		total = 0
 	}
 
-	meta := web.NewMeta(limit, offset, total)
-	web.SuccessWithMeta(w, http.StatusOK, records, meta)
+	web.SuccessWithMeta(w, http.StatusOK, records, web.NewMeta(page, total))
 }
 
 // GetNetworkBadgeStats returns global badge statistics.
@@ -3,7 +3,9 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -24,20 +26,23 @@ func NewBlockRewardHandler(blockRewardSvc *services.BlockRewardService) *BlockRe
 	}
 }
 
-// RegisterRoutes registers block reward routes.
-func (h *BlockRewardHandler) RegisterRoutes(r chi.Router) {
-	// Public endpoints
+// RegisterPublicRoutes registers the block countdown, projection and
+// distribution endpoints, which need no account.
+func (h *BlockRewardHandler) RegisterPublicRoutes(r chi.Router) {
 	r.Get("/block/countdown", h.GetCountdown)
 	r.Get("/block/current", h.GetCurrentBlock)
+	r.Get("/block/emission/projection", h.GetEmissionProjection)
+	r.Get("/block/{id}/pools", h.GetBlockRewardPools)
+	r.Get("/block/merkle-root", h.GetMerkleRoot)
+}
 
-	// Authenticated endpoints
-	r.Group(func(r chi.Router) {
-		r.Use(auth.Middleware) // Use your actual auth middleware name
-		r.Get("/block/rewards", h.GetUserRewards)
-		r.Get("/block/rewards/history", h.GetRewardHistory)
-		r.Post("/block/rewards/claim", h.ClaimRewards)
-		r.Post("/block/rewards/claim-all", h.ClaimAllRewards)
-	})
+// RegisterRoutes registers block reward routes under the authenticated group.
+func (h *BlockRewardHandler) RegisterRoutes(r chi.Router) {
+	r.Get("/block/rewards", h.GetUserRewards)
+	r.Get("/block/rewards/history", h.GetRewardHistory)
+	r.Post("/block/rewards/claim", h.ClaimRewards)
+	r.Post("/block/rewards/claim-all", h.ClaimAllRewards)
+	r.Get("/block/rewards/proof", h.GetRewardProof)
 
 	// Admin endpoints (optional, can be protected by role middleware)
 	// r.With(auth.RequireRole("admin")).Get("/block/stats", h.GetNetworkStats)
@@ -79,6 +84,56 @@ func (h *BlockRewardHandler) GetCurrentBlock(w http.ResponseWriter, r *http.Requ
 	web.Success(w, http.StatusOK, block)
 }
 
+// GetEmissionProjection projects block rewards and remaining supply over
+// the next N blocks under the current emission schedule.
+// GET /block/emission/projection?blocks=1000
+func (h *BlockRewardHandler) GetEmissionProjection(w http.ResponseWriter, r *http.Request) {
+	blocks, _ := strconv.ParseInt(r.URL.Query().Get("blocks"), 10, 64)
+
+	projection, err := h.blockRewardSvc.ProjectEmissions(r.Context(), blocks)
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
+	web.Success(w, http.StatusOK, projection)
+}
+
+// GetBlockRewardPools returns how a distributed block's reward was split
+// across the transaction, LP and burn pools.
+// GET /block/{id}/pools
+func (h *BlockRewardHandler) GetBlockRewardPools(w http.ResponseWriter, r *http.Request) {
+	blockID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		web.Error(w, http.StatusBadRequest, "invalid block ID")
+		return
+	}
+
+	pools, err := h.blockRewardSvc.GetBlockRewardPools(r.Context(), blockID)
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
+	web.Success(w, http.StatusOK, pools)
+}
+
+// GetMerkleRoot returns a published reward Merkle root, so anyone can check
+// the root the distributor contract pays out against.
+// GET /block/merkle-root?block=123 (latest published root if omitted)
+func (h *BlockRewardHandler) GetMerkleRoot(w http.ResponseWriter, r *http.Request) {
+	blockNumber, _ := strconv.ParseInt(r.URL.Query().Get("block"), 10, 64)
+
+	root, err := h.blockRewardSvc.GetMerkleRoot(r.Context(), blockNumber)
+	if errors.Is(err, services.ErrNoMerkleRoot) {
+		web.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
+	web.Success(w, http.StatusOK, root)
+}
+
 // ---------------------------------------------------------------------
 // Authenticated Handlers
 // ---------------------------------------------------------------------
@@ -99,7 +154,11 @@ func (h *BlockRewardHandler) GetUserRewards(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Also get summary for convenience
-	summary, _ := h.blockRewardSvc.GetUserRewardsSummary(r.Context(), userID)
+	summary, err := h.blockRewardSvc.GetUserRewardsSummary(r.Context(), userID)
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
 
 	response := map[string]interface{}{
 		"rewards": rewards,
@@ -109,7 +168,7 @@ func (h *BlockRewardHandler) GetUserRewards(w http.ResponseWriter, r *http.Reque
 }
 
 // GetRewardHistory returns paginated historical rewards (claimed/unclaimed) for the user.
-// GET /block/rewards/history?limit=20&offset=0
+// GET /block/rewards/history?page=1&limit=20
 func (h *BlockRewardHandler) GetRewardHistory(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
 	if !ok {
@@ -117,22 +176,45 @@ func (h *BlockRewardHandler) GetRewardHistory(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// Parse pagination
-	limit, offset := web.ParsePagination(r)
-	limitInt := int32(limit)
-	offsetInt := int32(offset)
+	page := web.ParsePagination(r)
+	offset := (page.Page - 1) * page.Limit
+
+	rewards, err := h.blockRewardSvc.GetUserBlockRewards(r.Context(), userID, int32(page.Limit), int32(offset))
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
 
-	rewards, err := h.blockRewardSvc.GetUserBlockRewards(r.Context(), userID, limitInt, offsetInt)
+	total, err := h.blockRewardSvc.GetUserRewardsCount(r.Context(), userID)
 	if err != nil {
 		web.InternalError(w, err)
 		return
 	}
 
-	// Get total count for pagination
-	total, _ := h.blockRewardSvc.GetUserRewardsCount(r.Context(), userID) // you may need to add this method
+	web.SuccessWithMeta(w, http.StatusOK, rewards, web.NewMeta(page, total))
+}
+
+// GetRewardProof returns the user's Merkle proof for claiming cumulative
+// rewards from the distributor contract.
+// GET /block/rewards/proof?block=123 (latest published root if omitted)
+func (h *BlockRewardHandler) GetRewardProof(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		web.Unauthorized(w)
+		return
+	}
+	blockNumber, _ := strconv.ParseInt(r.URL.Query().Get("block"), 10, 64)
 
-	meta := web.NewMeta(limit, offset, total)
-	web.SuccessWithMeta(w, http.StatusOK, rewards, meta)
+	proof, err := h.blockRewardSvc.GetRewardProof(r.Context(), userID, blockNumber)
+	if errors.Is(err, services.ErrNoMerkleRoot) || errors.Is(err, services.ErrNotInMerkleTree) {
+		web.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
+	web.Success(w, http.StatusOK, proof)
 }
 
 // ClaimRewards claims a specific set of unclaimed rewards.
@@ -159,6 +241,10 @@ func (h *BlockRewardHandler) ClaimRewards(w http.ResponseWriter, r *http.Request
 	}
 
 	claimedAmount, err := h.blockRewardSvc.ClaimRewards(r.Context(), userID, req.RewardIDs)
+	if errors.Is(err, services.ErrRewardsClaimedOnChain) {
+		web.Error(w, http.StatusConflict, err.Error())
+		return
+	}
 	if err != nil {
 		web.InternalError(w, err)
 		return
@@ -180,6 +266,10 @@ func (h *BlockRewardHandler) ClaimAllRewards(w http.ResponseWriter, r *http.Requ
 	}
 
 	claimedAmount, err := h.blockRewardSvc.ClaimAllRewards(r.Context(), userID)
+	if errors.Is(err, services.ErrRewardsClaimedOnChain) {
+		web.Error(w, http.StatusConflict, err.Error())
+		return
+	}
 	if err != nil {
 		web.InternalError(w, err)
 		return
@@ -1,9 +1,12 @@
+// internal/handlers/burn.go
 package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/shopspring/decimal"
 	"jd7008911/canlan.org/internal/auth"
 	"jd7008911/canlan.org/internal/db"
@@ -11,11 +14,28 @@ import (
 	"jd7008911/canlan.org/pkg/web"
 )
 
+// BurnHandler handles token burn requests.
 type BurnHandler struct {
 	burnSvc *services.BurnService
 	queries *db.Queries
 }
 
+// NewBurnHandler creates a new burn handler.
+func NewBurnHandler(burnSvc *services.BurnService, queries *db.Queries) *BurnHandler {
+	return &BurnHandler{
+		burnSvc: burnSvc,
+		queries: queries,
+	}
+}
+
+// RegisterRoutes registers the burn route under the authenticated group.
+func (h *BurnHandler) RegisterRoutes(r chi.Router) {
+	r.Post("/burns", h.Burn)
+}
+
+// Burn destroys tokens from the user's balance.
+// POST /burns
+// Request body: { "token_symbol": "CAN", "amount": "10.0", "tx_hash": "0x..." }
 func (h *BurnHandler) Burn(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
 	if !ok {
@@ -33,6 +53,10 @@ func (h *BurnHandler) Burn(w http.ResponseWriter, r *http.Request) {
 		web.Error(w, http.StatusBadRequest, "invalid request")
 		return
 	}
+	if req.Amount.LessThanOrEqual(decimal.Zero) {
+		web.Error(w, http.StatusBadRequest, "amount must be positive")
+		return
+	}
 
 	token, err := h.queries.GetTokenBySymbol(r.Context(), req.TokenSymbol)
 	if err != nil {
@@ -41,10 +65,14 @@ func (h *BurnHandler) Burn(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = h.burnSvc.BurnTokens(r.Context(), userID, token.ID, req.Amount, req.TxHash)
+	if errors.Is(err, services.ErrInsufficientBalance) {
+		web.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
 		web.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	web.Respond(w, http.StatusOK, map[string]string{"status": "burned"})
+	web.Success(w, http.StatusOK, map[string]string{"status": "burned"})
 }
@@ -2,6 +2,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
@@ -26,16 +27,11 @@ func NewCombatHandler(combatSvc *services.CombatPowerService) *CombatHandler {
 
 // RegisterRoutes registers combat routes under the authenticated group.
 func (h *CombatHandler) RegisterRoutes(r chi.Router) {
-	// All combat endpoints require authentication
-	r.Group(func(r chi.Router) {
-		// Use your actual auth middleware name (e.g., auth.AuthMiddleware)
-		// This middleware should be applied by the main router, or we can require it here.
-		// For consistency, we assume the caller will apply auth middleware to the group.
-		r.Get("/combat/personal", h.GetPersonalCombatPower)
-		r.Get("/combat/network", h.GetNetworkCombatPower)
-		r.Get("/combat/history", h.GetCombatPowerHistory)
-		r.Post("/combat/refresh", h.RefreshCombatPower)
-	})
+	r.Get("/combat/personal", h.GetPersonalCombatPower)
+	r.Get("/combat/network", h.GetNetworkCombatPower)
+	r.Get("/combat/history", h.GetCombatPowerHistory)
+	r.Get("/combat/explain", h.ExplainCombatPower)
+	r.Post("/combat/refresh", h.RefreshCombatPower)
 }
 
 // ---------------------------------------------------------------------
@@ -51,10 +47,13 @@ func (h *CombatHandler) GetPersonalCombatPower(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	// Get full combat power record
-	cp, err := h.combatSvc.queries.GetCombatPower(r.Context(), userID)
+	cp, err := h.combatSvc.GetCombatPower(r.Context(), userID)
 	if err != nil {
-		// If no record exists, return zeros
+		web.InternalError(w, err)
+		return
+	}
+	if cp == nil {
+		// Not calculated yet
 		web.Success(w, http.StatusOK, map[string]interface{}{
 			"user_id":        userID,
 			"personal_power": "0",
@@ -66,7 +65,6 @@ func (h *CombatHandler) GetPersonalCombatPower(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	// Optionally calculate additional derived metrics
 	response := map[string]interface{}{
 		"user_id":        cp.UserID,
 		"personal_power": cp.PersonalPower,
@@ -82,9 +80,10 @@ func (h *CombatHandler) GetPersonalCombatPower(w http.ResponseWriter, r *http.Re
 // GetNetworkCombatPower returns the total combat power of the entire network.
 // GET /combat/network
 func (h *CombatHandler) GetNetworkCombatPower(w http.ResponseWriter, r *http.Request) {
-	total, err := h.combatSvc.queries.GetNetworkCombatPower(r.Context())
+	total, err := h.combatSvc.GetNetworkCombatPower(r.Context())
 	if err != nil {
-		total = 0
+		web.InternalError(w, err)
+		return
 	}
 
 	web.Success(w, http.StatusOK, map[string]interface{}{
@@ -92,8 +91,10 @@ func (h *CombatHandler) GetNetworkCombatPower(w http.ResponseWriter, r *http.Req
 	})
 }
 
-// GetCombatPowerHistory returns historical daily snapshots of the user's combat power.
-// GET /combat/history?days=7&limit=30
+// GetCombatPowerHistory returns the user's combat power over time, one point
+// per day, week or month, with the change since the previous point and the
+// user's network rank.
+// GET /combat/history?interval=day&points=30
 func (h *CombatHandler) GetCombatPowerHistory(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
 	if !ok {
@@ -101,28 +102,46 @@ func (h *CombatHandler) GetCombatPowerHistory(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// Parse query parameters
-	days := 7
-	daysStr := r.URL.Query().Get("days")
-	if daysStr != "" {
-		if d, err := strconv.Atoi(daysStr); err == nil && d > 0 && d <= 90 {
-			days = d
-		}
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = "day"
+	}
+	points := 30
+	if p, err := strconv.Atoi(r.URL.Query().Get("points")); err == nil && p > 0 && p <= 366 {
+		points = p
 	}
 
-	// This assumes you have a query method to get combat power history for the last N days.
-	// If not implemented yet, you can return a placeholder or skip this endpoint.
-	// We'll assume a method: GetCombatPowerHistory(ctx, userID, days) exists.
-	history, err := h.combatSvc.GetCombatPowerHistory(r.Context(), userID, days)
+	history, err := h.combatSvc.GetCombatPowerHistory(r.Context(), userID, interval, points)
+	if errors.Is(err, services.ErrUnsupportedBucket) {
+		web.Error(w, http.StatusBadRequest, "interval must be day, week or month")
+		return
+	}
 	if err != nil {
-		// If not implemented, return empty array with message
-		web.Success(w, http.StatusOK, []interface{}{})
+		web.InternalError(w, err)
 		return
 	}
 
 	web.Success(w, http.StatusOK, history)
 }
 
+// ExplainCombatPower breaks the user's personal combat power down into its
+// holdings, LP weight, burn power and badge multipliers.
+// GET /combat/explain
+func (h *CombatHandler) ExplainCombatPower(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		web.Unauthorized(w)
+		return
+	}
+
+	breakdown, err := h.combatSvc.ExplainPersonalPower(r.Context(), userID)
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
+	web.Success(w, http.StatusOK, breakdown)
+}
+
 // RefreshCombatPower manually triggers a recalculation of the user's combat power.
 // This can be used after significant changes (e.g., purchase, LP, burn) if not auto‑updated.
 // POST /combat/refresh
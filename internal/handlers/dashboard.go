@@ -1,14 +1,17 @@
+// internal/handlers/dashboard.go
 package handlers
 
 import (
 	"net/http"
 
+	"github.com/go-chi/chi/v5"
 	"jd7008911/canlan.org/internal/auth"
 	"jd7008911/canlan.org/internal/db"
 	"jd7008911/canlan.org/internal/services"
 	"jd7008911/canlan.org/pkg/web"
 )
 
+// DashboardHandler serves the home screen summary.
 type DashboardHandler struct {
 	queries        *db.Queries
 	combatSvc      *services.CombatPowerService
@@ -16,6 +19,24 @@ type DashboardHandler struct {
 	assetSvc       *services.AssetService
 }
 
+// NewDashboardHandler creates a new dashboard handler.
+func NewDashboardHandler(queries *db.Queries, combatSvc *services.CombatPowerService, blockRewardSvc *services.BlockRewardService, assetSvc *services.AssetService) *DashboardHandler {
+	return &DashboardHandler{
+		queries:        queries,
+		combatSvc:      combatSvc,
+		blockRewardSvc: blockRewardSvc,
+		assetSvc:       assetSvc,
+	}
+}
+
+// RegisterRoutes registers the dashboard route under the authenticated group.
+func (h *DashboardHandler) RegisterRoutes(r chi.Router) {
+	r.Get("/dashboard", h.GetDashboard)
+}
+
+// GetDashboard returns the user's combat power, balances, today's mining,
+// badges and node alongside the next block countdown.
+// GET /dashboard
 func (h *DashboardHandler) GetDashboard(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
 	if !ok {
@@ -28,7 +49,7 @@ func (h *DashboardHandler) GetDashboard(w http.ResponseWriter, r *http.Request)
 	// Network combat power
 	networkCP, _ := h.queries.GetNetworkCombatPower(r.Context())
 	// Token balances
-	balances, _ := h.queries.GetUserBalancesWithTokens(r.Context(), userID)
+	balances, _ := h.queries.GetUserBalances(r.Context(), userID)
 	// Mining earnings today
 	miningToday, _ := h.queries.GetTodayMiningEarnings(r.Context(), userID)
 	// Block countdown
@@ -48,5 +69,5 @@ func (h *DashboardHandler) GetDashboard(w http.ResponseWriter, r *http.Request)
 		"node_level":            node,
 	}
 
-	web.Respond(w, http.StatusOK, response)
+	web.Success(w, http.StatusOK, response)
 }
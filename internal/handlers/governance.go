@@ -3,12 +3,15 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
 	"jd7008911/canlan.org/internal/auth"
+	"jd7008911/canlan.org/internal/db"
 	"jd7008911/canlan.org/internal/services"
 	"jd7008911/canlan.org/pkg/web"
 )
@@ -16,31 +19,36 @@ import (
 // GovernanceHandler handles governance-related HTTP requests.
 type GovernanceHandler struct {
 	governanceSvc *services.GovernanceService
+	walletAuth    *auth.WalletAuth
+	nodeSvc       *services.NodeService
 }
 
 // NewGovernanceHandler creates a new governance handler.
-func NewGovernanceHandler(governanceSvc *services.GovernanceService) *GovernanceHandler {
+func NewGovernanceHandler(governanceSvc *services.GovernanceService, walletAuth *auth.WalletAuth, nodeSvc *services.NodeService) *GovernanceHandler {
 	return &GovernanceHandler{
 		governanceSvc: governanceSvc,
+		walletAuth:    walletAuth,
+		nodeSvc:       nodeSvc,
 	}
 }
 
-// RegisterRoutes registers governance routes.
-func (h *GovernanceHandler) RegisterRoutes(r chi.Router) {
-	// Public routes
+// RegisterPublicRoutes registers the proposal listings and results, which
+// need no account.
+func (h *GovernanceHandler) RegisterPublicRoutes(r chi.Router) {
 	r.Get("/governance/proposals", h.ListProposals)
 	r.Get("/governance/proposals/{id}", h.GetProposal)
 	r.Get("/governance/proposals/{id}/results", h.GetProposalResults)
 	r.Get("/governance/proposals/{id}/votes", h.GetProposalVotes)
 	r.Get("/governance/stats", h.GetGovernanceStats)
+}
 
-	// Protected routes (require authentication)
-	r.Group(func(r chi.Router) {
-		r.Use(auth.AuthMiddleware) // Assumes your middleware is named AuthMiddleware
-		r.Post("/governance/proposals", h.CreateProposal)
-		r.Post("/governance/proposals/{id}/vote", h.CastVote)
-		r.Get("/governance/user/votes", h.GetUserVotingHistory)
-	})
+// RegisterRoutes registers governance routes under the authenticated group.
+// Creating a proposal also requires a node level granting it.
+func (h *GovernanceHandler) RegisterRoutes(r chi.Router) {
+	r.With(h.walletAuth.RequirePermission(services.PermissionCreateProposal, h.nodeSvc.HasPermission)).
+		Post("/governance/proposals", h.CreateProposal)
+	r.Post("/governance/proposals/{id}/vote", h.CastVote)
+	r.Get("/governance/user/votes", h.GetUserVotingHistory)
 }
 
 // ---------------------------------------------------------------------
@@ -48,45 +56,44 @@ func (h *GovernanceHandler) RegisterRoutes(r chi.Router) {
 // ---------------------------------------------------------------------
 
 // ListProposals returns a paginated list of proposals, optionally filtered by status.
-// GET /governance/proposals?status=active&limit=10&offset=0
+// Active proposals are few and all returned, soonest to close first.
+// GET /governance/proposals?status=active&page=1&limit=10
 func (h *GovernanceHandler) ListProposals(w http.ResponseWriter, r *http.Request) {
-	// Parse query parameters
 	status := r.URL.Query().Get("status")
-	limit, offset := web.ParsePagination(r)
-
-	var proposals interface{}
-	var err error
-	var total int64
-
-	switch status {
-	case "active":
-		proposals, err = h.governanceSvc.ListActiveProposals(r.Context())
-		// For active, we don't paginate? Or we can paginate by applying limit/offset after fetch.
-		// For simplicity, we'll just return all active proposals without pagination metadata.
+	if status == "active" {
+		proposals, err := h.governanceSvc.ListActiveProposals(r.Context())
 		if err != nil {
 			web.InternalError(w, err)
 			return
 		}
 		web.Success(w, http.StatusOK, proposals)
 		return
+	}
+
+	page := web.ParsePagination(r)
+	offset := (page.Page - 1) * page.Limit
+
+	var proposals []db.GovernanceProposal
+	var err error
+	switch status {
 	case "pending", "passed", "rejected", "executed":
-		// For completed proposals we have a dedicated query; but we can also use ListProposals with filter.
-		// We'll use ListProposals which is paginated.
-		proposals, err = h.governanceSvc.ListProposals(r.Context(), int32(limit), int32(offset))
-		total, _ = h.governanceSvc.CountProposals(r.Context(), status) // you may need to implement this
+		proposals, err = h.governanceSvc.ListProposalsByStatus(r.Context(), status, int32(page.Limit), int32(offset))
 	default:
-		// All proposals, paginated
-		proposals, err = h.governanceSvc.ListProposals(r.Context(), int32(limit), int32(offset))
-		total, _ = h.governanceSvc.CountProposals(r.Context(), "") // implement this
+		status = ""
+		proposals, err = h.governanceSvc.ListProposals(r.Context(), int32(page.Limit), int32(offset))
+	}
+	if err != nil {
+		web.InternalError(w, err)
+		return
 	}
 
+	total, err := h.governanceSvc.CountProposals(r.Context(), status)
 	if err != nil {
 		web.InternalError(w, err)
 		return
 	}
 
-	meta := web.NewMeta(limit, offset, total)
-	web.SuccessWithMeta(w, http.StatusOK, proposals, meta)
+	web.SuccessWithMeta(w, http.StatusOK, proposals, web.NewMeta(page, total))
 }
 
 // GetProposal returns details of a specific proposal.
@@ -172,12 +179,13 @@ func (h *GovernanceHandler) CreateProposal(w http.ResponseWriter, r *http.Reques
 	}
 
 	var req struct {
-		Title        string    `json:"title" validate:"required"`
-		Description  string    `json:"description" validate:"required"`
-		ProposalType string    `json:"proposal_type" validate:"required"`
-		VotingEnd    time.Time `json:"voting_end" validate:"required"`
-		Quorum       float64   `json:"quorum"`    // percentage, default 50
-		Threshold    float64   `json:"threshold"` // percentage, default 50
+		Title        string          `json:"title" validate:"required"`
+		Description  string          `json:"description" validate:"required"`
+		ProposalType string          `json:"proposal_type" validate:"required"`
+		VotingEnd    time.Time       `json:"voting_end" validate:"required"`
+		Quorum       float64         `json:"quorum"`    // percentage, default 50
+		Threshold    float64         `json:"threshold"` // percentage, default 50
+		Payload      json.RawMessage `json:"payload"`   // required for executable types, e.g. "emission"
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -207,10 +215,19 @@ func (h *GovernanceHandler) CreateProposal(w http.ResponseWriter, r *http.Reques
 		VotingEnd:    req.VotingEnd,
 		Quorum:       decimal.NewFromFloat(req.Quorum),
 		Threshold:    decimal.NewFromFloat(req.Threshold),
+		Payload:      req.Payload,
 	}
 
 	proposal, err := h.governanceSvc.CreateProposal(r.Context(), params)
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidEmissionSchedule) || errors.Is(err, services.ErrInvalidCombatFormula) {
+			web.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, services.ErrProposalNotPermitted) {
+			web.Forbidden(w, err.Error())
+			return
+		}
 		web.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -253,7 +270,7 @@ func (h *GovernanceHandler) CastVote(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetUserVotingHistory returns the authenticated user's voting history.
-// GET /governance/user/votes?limit=10&offset=0
+// GET /governance/user/votes?page=1&limit=10
 func (h *GovernanceHandler) GetUserVotingHistory(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
 	if !ok {
@@ -261,14 +278,20 @@ func (h *GovernanceHandler) GetUserVotingHistory(w http.ResponseWriter, r *http.
 		return
 	}
 
-	limit, offset := web.ParsePagination(r)
-	votes, err := h.governanceSvc.GetUserVotingHistory(r.Context(), userID, int32(limit), int32(offset))
+	page := web.ParsePagination(r)
+	offset := (page.Page - 1) * page.Limit
+
+	votes, err := h.governanceSvc.GetUserVotingHistory(r.Context(), userID, int32(page.Limit), int32(offset))
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
+
+	total, err := h.governanceSvc.CountUserVotes(r.Context(), userID)
 	if err != nil {
 		web.InternalError(w, err)
 		return
 	}
 
-	total, _ := h.governanceSvc.CountUserVotes(r.Context(), userID) // implement this if needed
-	meta := web.NewMeta(limit, offset, total)
-	web.SuccessWithMeta(w, http.StatusOK, votes, meta)
+	web.SuccessWithMeta(w, http.StatusOK, votes, web.NewMeta(page, total))
 }
@@ -4,7 +4,6 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
-	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -26,23 +25,21 @@ func NewLPHandler(lpSvc *services.LPService) *LPHandler {
 	}
 }
 
-// RegisterRoutes registers LP routes.
-func (h *LPHandler) RegisterRoutes(r chi.Router) {
-	// Public routes
+// RegisterPublicRoutes registers the pool listings, which need no account.
+func (h *LPHandler) RegisterPublicRoutes(r chi.Router) {
 	r.Get("/lp/pools", h.ListPools)
 	r.Get("/lp/pools/{id}", h.GetPool)
 	r.Get("/lp/pools/{id}/stats", h.GetPoolStats)
+}
 
-	// Authenticated routes
-	r.Group(func(r chi.Router) {
-		r.Use(auth.AuthMiddleware) // Assumes your middleware is named AuthMiddleware
-		r.Get("/lp/positions", h.GetUserLPPositions)
-		r.Get("/lp/positions/{id}", h.GetLPPosition)
-		r.Get("/lp/weight", h.GetUserLPWeight)
-		r.Post("/lp/add", h.AddLiquidity)
-		r.Post("/lp/remove", h.RemoveLiquidity)
-		r.Get("/lp/transactions", h.GetLPTransactions)
-	})
+// RegisterRoutes registers LP routes under the authenticated group.
+func (h *LPHandler) RegisterRoutes(r chi.Router) {
+	r.Get("/lp/positions", h.GetUserLPPositions)
+	r.Get("/lp/positions/{id}", h.GetLPPosition)
+	r.Get("/lp/weight", h.GetUserLPWeight)
+	r.Post("/lp/add", h.AddLiquidity)
+	r.Post("/lp/remove", h.RemoveLiquidity)
+	r.Get("/lp/transactions", h.GetLPTransactions)
 }
 
 // ---------------------------------------------------------------------
@@ -89,16 +86,26 @@ func (h *LPHandler) GetPoolStats(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Gather various stats
-	pool, _ := h.lpSvc.GetPoolByID(r.Context(), poolID)
-	totalLP, _ := h.lpSvc.queries.GetPoolTotalLPAmount(r.Context(), poolID)
-	userCount, _ := h.lpSvc.queries.GetPoolUserCount(r.Context(), poolID) // You may need to add this query
+	pool, err := h.lpSvc.GetPoolByID(r.Context(), poolID)
+	if err != nil {
+		web.Error(w, http.StatusNotFound, "pool not found")
+		return
+	}
+	userCount, err := h.lpSvc.GetPoolUserCount(r.Context(), poolID)
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
 
 	stats := map[string]interface{}{
 		"pool_id":             poolID,
 		"name":                pool.Name,
 		"total_liquidity_usd": pool.TotalLiquidityUsd,
 		"apr":                 pool.Apr,
-		"total_lp_amount":     totalLP,
+		"reserve0":            pool.Reserve0,
+		"reserve1":            pool.Reserve1,
+		"fee_bps":             pool.FeeBps,
+		"total_lp_amount":     pool.LpTotalSupply,
 		"liquidity_providers": userCount,
 	}
 	web.Success(w, http.StatusOK, stats)
@@ -141,16 +148,11 @@ func (h *LPHandler) GetLPPosition(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify ownership
-	position, err := h.lpSvc.queries.GetLPPosition(r.Context(), positionID)
+	position, err := h.lpSvc.GetLPPosition(r.Context(), positionID, userID)
 	if err != nil {
 		web.Error(w, http.StatusNotFound, "position not found")
 		return
 	}
-	if position.UserID != userID {
-		web.Forbidden(w)
-		return
-	}
 
 	web.Success(w, http.StatusOK, position)
 }
@@ -278,7 +280,7 @@ func (h *LPHandler) RemoveLiquidity(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetLPTransactions returns the user's LP transaction history (add/remove).
-// GET /lp/transactions?limit=20&offset=0
+// GET /lp/transactions?page=1&limit=20
 func (h *LPHandler) GetLPTransactions(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
 	if !ok {
@@ -286,20 +288,20 @@ func (h *LPHandler) GetLPTransactions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	limit, offset := web.ParsePagination(r)
-	transactions, err := h.lpSvc.queries.GetUserLPTransactions(r.Context(), db.GetUserLPTransactionsParams{
-		UserID: userID,
-		Limit:  int32(limit),
-		Offset: int32(offset),
-	})
+	page := web.ParsePagination(r)
+	offset := (page.Page - 1) * page.Limit
+
+	transactions, err := h.lpSvc.GetUserLPTransactions(r.Context(), userID, int32(page.Limit), int32(offset))
 	if err != nil {
 		web.InternalError(w, err)
 		return
 	}
 
-	// Get total count for pagination
-	total, _ := h.lpSvc.queries.CountUserLPTransactions(r.Context(), userID) // You may need to add this query
+	total, err := h.lpSvc.CountUserLPTransactions(r.Context(), userID)
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
 
-	meta := web.NewMeta(limit, offset, total)
-	web.SuccessWithMeta(w, http.StatusOK, transactions, meta)
+	web.SuccessWithMeta(w, http.StatusOK, transactions, web.NewMeta(page, total))
 }
@@ -25,22 +25,17 @@ func NewMiningHandler(miningSvc *services.MiningService) *MiningHandler {
 
 // RegisterRoutes registers mining routes under the authenticated group.
 func (h *MiningHandler) RegisterRoutes(r chi.Router) {
-	// All mining endpoints require authentication
-	r.Group(func(r chi.Router) {
-		r.Use(auth.AuthMiddleware) // Adjust middleware name as needed
+	// Mining machine endpoints
+	r.Get("/mining/machine", h.GetMiningMachine)
+	r.Post("/mining/upgrade", h.UpgradeMachine)
 
-		// Mining machine endpoints
-		r.Get("/mining/machine", h.GetMiningMachine)
-		r.Post("/mining/upgrade", h.UpgradeMachine)
+	// Earnings endpoints
+	r.Get("/mining/earnings/today", h.GetTodayEarnings)
+	r.Get("/mining/earnings/history", h.GetEarningsHistory)
+	r.Post("/mining/earnings/accrue", h.AccrueDailyEarnings) // Manual trigger (maybe admin only)
 
-		// Earnings endpoints
-		r.Get("/mining/earnings/today", h.GetTodayEarnings)
-		r.Get("/mining/earnings/history", h.GetEarningsHistory)
-		r.Post("/mining/earnings/accrue", h.AccrueDailyEarnings) // Manual trigger (maybe admin only)
-
-		// Statistics
-		r.Get("/mining/stats", h.GetMiningStats)
-	})
+	// Statistics
+	r.Get("/mining/stats", h.GetMiningStats)
 }
 
 // ---------------------------------------------------------------------
@@ -119,7 +114,7 @@ func (h *MiningHandler) GetTodayEarnings(w http.ResponseWriter, r *http.Request)
 }
 
 // GetEarningsHistory returns paginated historical mining earnings.
-// GET /mining/earnings/history?limit=20&offset=0&days=30
+// GET /mining/earnings/history?page=1&limit=20&days=30
 func (h *MiningHandler) GetEarningsHistory(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
 	if !ok {
@@ -127,8 +122,8 @@ func (h *MiningHandler) GetEarningsHistory(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Parse pagination
-	limit, offset := web.ParsePagination(r)
+	page := web.ParsePagination(r)
+	offset := (page.Page - 1) * page.Limit
 
 	// Optional days filter
 	days := 30
@@ -138,22 +133,19 @@ func (h *MiningHandler) GetEarningsHistory(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
-	// This assumes the mining service has a method to get earnings history.
-	// We'll call it with userID, limit, offset, and days.
-	history, err := h.miningSvc.GetEarningsHistory(r.Context(), userID, int32(limit), int32(offset), days)
+	history, err := h.miningSvc.GetEarningsHistory(r.Context(), userID, int32(page.Limit), int32(offset), days)
 	if err != nil {
 		web.InternalError(w, err)
 		return
 	}
 
-	// Get total count for pagination
 	total, err := h.miningSvc.CountEarningsHistory(r.Context(), userID, days)
 	if err != nil {
-		total = 0
+		web.InternalError(w, err)
+		return
 	}
 
-	meta := web.NewMeta(limit, offset, total)
-	web.SuccessWithMeta(w, http.StatusOK, history, meta)
+	web.SuccessWithMeta(w, http.StatusOK, history, web.NewMeta(page, total))
 }
 
 // AccrueDailyEarnings manually triggers daily earnings accrual for the user.
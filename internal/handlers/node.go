@@ -2,9 +2,13 @@
 package handlers
 
 import (
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -26,28 +30,30 @@ func NewNodeHandler(nodeSvc *services.NodeService) *NodeHandler {
 	}
 }
 
-// RegisterRoutes registers node routes.
-func (h *NodeHandler) RegisterRoutes(r chi.Router) {
-	// Public routes
+// RegisterPublicRoutes registers the node level definitions and network
+// statistics, which need no account.
+func (h *NodeHandler) RegisterPublicRoutes(r chi.Router) {
 	r.Get("/node/levels", h.ListNodeLevels)
 	r.Get("/node/levels/{level}", h.GetNodeLevel)
 	r.Get("/node/stats/network", h.GetNetworkNodeStats)
+}
 
-	// Authenticated routes
-	r.Group(func(r chi.Router) {
-		r.Use(auth.AuthMiddleware) // Adjust middleware name as needed
-		r.Get("/node/my", h.GetMyNode)
-		r.Get("/node/team", h.GetTeamInfo)
-		r.Get("/node/team/power", h.GetTeamPower)
-		r.Get("/node/team/members", h.GetTeamMembers)
-		r.Get("/node/team/subtree", h.GetReferralSubtree)
-		r.Get("/node/ancestors", h.GetAncestors)
-		r.Get("/node/direct", h.GetDirectReferrals)
-		r.Post("/node/upgrade", h.UpgradeNode)
-		r.Get("/node/eligibility", h.GetUpgradeEligibility)
-		r.Get("/node/rights", h.GetUserRights)
-		r.Get("/node/gift-limit", h.GetGiftLimit)
-	})
+// RegisterRoutes registers node routes under the authenticated group.
+func (h *NodeHandler) RegisterRoutes(r chi.Router) {
+	r.Get("/node/my", h.GetMyNode)
+	r.Get("/node/team", h.GetTeamInfo)
+	r.Get("/node/team/power", h.GetTeamPower)
+	r.Get("/node/team/members", h.GetTeamMembers)
+	r.Get("/node/team/subtree", h.GetReferralSubtree)
+	r.Get("/node/tree", h.GetTree)
+	r.Get("/node/tree/search", h.SearchTree)
+	r.Get("/node/tree/export", h.ExportTree)
+	r.Get("/node/ancestors", h.GetAncestors)
+	r.Get("/node/direct", h.GetDirectReferrals)
+	r.Post("/node/upgrade", h.UpgradeNode)
+	r.Get("/node/eligibility", h.GetUpgradeEligibility)
+	r.Get("/node/rights", h.GetUserRights)
+	r.Get("/node/gift-limit", h.GetGiftLimit)
 }
 
 // ---------------------------------------------------------------------
@@ -146,7 +152,7 @@ func (h *NodeHandler) GetTeamPower(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	power, err := h.nodeSvc.queries.GetTeamPower(r.Context(), userID)
+	power, err := h.nodeSvc.GetTeamPower(r.Context(), userID)
 	if err != nil {
 		web.InternalError(w, err)
 		return
@@ -166,7 +172,7 @@ func (h *NodeHandler) GetTeamMembers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	count, err := h.nodeSvc.queries.GetTeamMemberCount(r.Context(), userID)
+	count, err := h.nodeSvc.GetTeamMemberCount(r.Context(), userID)
 	if err != nil {
 		web.InternalError(w, err)
 		return
@@ -178,7 +184,7 @@ func (h *NodeHandler) GetTeamMembers(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetDirectReferrals returns the list of users directly referred by the authenticated user.
-// GET /node/direct?limit=20&offset=0
+// GET /node/direct?page=1&limit=20
 func (h *NodeHandler) GetDirectReferrals(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
 	if !ok {
@@ -186,24 +192,27 @@ func (h *NodeHandler) GetDirectReferrals(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	limit, offset := web.ParsePagination(r)
-	referrals, err := h.nodeSvc.queries.GetDirectReferrals(r.Context(), db.GetDirectReferralsParams{
-		InvitedBy: userID,
-		Limit:     int32(limit),
-		Offset:    int32(offset),
-	})
+	page := web.ParsePagination(r)
+	offset := (page.Page - 1) * page.Limit
+
+	referrals, err := h.nodeSvc.GetDirectReferrals(r.Context(), userID, int32(page.Limit), int32(offset))
 	if err != nil {
 		web.InternalError(w, err)
 		return
 	}
 
-	total, _ := h.nodeSvc.queries.GetDirectReferralCount(r.Context(), userID)
-	meta := web.NewMeta(limit, offset, total)
-	web.SuccessWithMeta(w, http.StatusOK, referrals, meta)
+	total, err := h.nodeSvc.CountDirectReferrals(r.Context(), userID)
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
+
+	web.SuccessWithMeta(w, http.StatusOK, referrals, web.NewMeta(page, total))
 }
 
 // GetReferralSubtree returns the paginated downline tree of the user.
-// GET /node/team/subtree?limit=50&offset=0&depth=3
+// Members are listed level by level down to depth levels below the user.
+// GET /node/team/subtree?page=1&limit=50&depth=3
 func (h *NodeHandler) GetReferralSubtree(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
 	if !ok {
@@ -211,8 +220,8 @@ func (h *NodeHandler) GetReferralSubtree(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	limit, offset := web.ParsePagination(r)
-	// Optional max depth
+	page := web.ParsePagination(r)
+	offset := (page.Page - 1) * page.Limit
 	depth := 10
 	if depthStr := r.URL.Query().Get("depth"); depthStr != "" {
 		if d, err := strconv.Atoi(depthStr); err == nil && d > 0 {
@@ -220,21 +229,179 @@ func (h *NodeHandler) GetReferralSubtree(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	// You may need a more efficient paginated tree query.
-	// For simplicity, we assume the query supports limit/offset.
-	subtree, err := h.nodeSvc.queries.GetReferralSubtree(r.Context(), db.GetReferralSubtreeParams{
-		UserID: userID,
-		Limit:  int32(limit),
-		Offset: int32(offset),
-	})
+	subtree, err := h.nodeSvc.GetReferralSubtreePage(r.Context(), userID, int32(depth), int32(page.Limit), int32(offset))
 	if err != nil {
 		web.InternalError(w, err)
 		return
 	}
 
-	total, _ := h.nodeSvc.queries.GetTeamMemberCount(r.Context(), userID)
-	meta := web.NewMeta(limit, offset, total)
-	web.SuccessWithMeta(w, http.StatusOK, subtree, meta)
+	total, err := h.nodeSvc.CountReferralSubtree(r.Context(), userID, int32(depth))
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
+
+	web.SuccessWithMeta(w, http.StatusOK, subtree, web.NewMeta(page, total))
+}
+
+// GetTree returns a node of the user's referral tree with one page of its
+// children, each with subtree member count, team power and 30-day volume.
+// node defaults to the user; pass next_after as after for the next page.
+// GET /node/tree?node={id}&after={id}&limit=50
+func (h *NodeHandler) GetTree(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		web.Unauthorized(w)
+		return
+	}
+
+	nodeID, ok := parseTreeNode(w, r, userID)
+	if !ok {
+		return
+	}
+	after := uuid.Nil
+	if s := r.URL.Query().Get("after"); s != "" {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			web.Error(w, http.StatusBadRequest, "invalid after")
+			return
+		}
+		after = id
+	}
+	limit := 50
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			limit = n
+		}
+	}
+
+	page, err := h.nodeSvc.GetTreePage(r.Context(), userID, nodeID, after, limit)
+	if err != nil {
+		if errors.Is(err, services.ErrNotInDownline) {
+			web.Error(w, http.StatusNotFound, err.Error())
+			return
+		}
+		web.InternalError(w, err)
+		return
+	}
+	web.Success(w, http.StatusOK, page)
+}
+
+// SearchTree finds users in the caller's downline by wallet prefix and
+// returns the path to each so the tree can be expanded to them.
+// GET /node/tree/search?wallet=0x12ab
+func (h *NodeHandler) SearchTree(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		web.Unauthorized(w)
+		return
+	}
+
+	results, err := h.nodeSvc.SearchDownline(r.Context(), userID, r.URL.Query().Get("wallet"))
+	if err != nil {
+		if errors.Is(err, services.ErrSearchPrefixTooShort) {
+			web.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		web.InternalError(w, err)
+		return
+	}
+	web.Success(w, http.StatusOK, results)
+}
+
+// ExportTree streams the subtree below a node of the user's tree as CSV or
+// JSON Lines, level by level.
+// GET /node/tree/export?node={id}&format=csv|jsonl
+func (h *NodeHandler) ExportTree(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		web.Unauthorized(w)
+		return
+	}
+
+	nodeID, ok := parseTreeNode(w, r, userID)
+	if !ok {
+		return
+	}
+	// Check access before anything is streamed
+	if err := h.nodeSvc.CheckInDownline(r.Context(), userID, nodeID); err != nil {
+		if errors.Is(err, services.ErrNotInDownline) {
+			web.Error(w, http.StatusNotFound, err.Error())
+			return
+		}
+		web.InternalError(w, err)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+
+	var emit func(services.TreeExportRow) error
+	var flush func() error
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		emit = func(row services.TreeExportRow) error {
+			joined := ""
+			if row.JoinedAt != nil {
+				joined = row.JoinedAt.UTC().Format(time.RFC3339)
+			}
+			return cw.Write([]string{
+				row.UserID.String(),
+				row.ParentID.String(),
+				row.WalletAddress,
+				strconv.Itoa(int(row.Depth)),
+				strconv.Itoa(int(row.NodeLevel)),
+				row.PersonalPower.String(),
+				row.TeamPower.String(),
+				strconv.Itoa(int(row.TeamMembers)),
+				joined,
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="team.csv"`)
+		if err := cw.Write([]string{"user_id", "parent_id", "wallet_address", "depth", "node_level", "personal_power", "team_power", "team_members", "joined_at"}); err != nil {
+			return
+		}
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		emit = func(row services.TreeExportRow) error { return enc.Encode(row) }
+		flush = func() error { return nil }
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="team.jsonl"`)
+	default:
+		web.Error(w, http.StatusBadRequest, "format must be csv or jsonl")
+		return
+	}
+
+	// Headers are sent with the first row; a failure after that can only
+	// cut the stream short
+	if err := h.nodeSvc.ExportSubtree(r.Context(), userID, nodeID, emit); err != nil {
+		log.Printf("tree export for %s failed: %v", userID, err)
+	}
+	if err := flush(); err != nil {
+		log.Printf("tree export for %s failed: %v", userID, err)
+	}
+}
+
+// parseTreeNode reads the optional node query parameter, defaulting to the
+// user.
+func parseTreeNode(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (uuid.UUID, bool) {
+	s := r.URL.Query().Get("node")
+	if s == "" {
+		return userID, true
+	}
+	id, err := uuid.Parse(s)
+	if err != nil {
+		web.Error(w, http.StatusBadRequest, "invalid node")
+		return uuid.Nil, false
+	}
+	return id, true
 }
 
 // GetAncestors returns the referral chain above the user.
@@ -292,7 +459,7 @@ func (h *NodeHandler) UpgradeNode(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// GetUserRights returns the rights JSON for the user's current node level.
+// GetUserRights returns the rights of the user's current node level.
 // GET /node/rights
 func (h *NodeHandler) GetUserRights(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
@@ -303,21 +470,11 @@ func (h *NodeHandler) GetUserRights(w http.ResponseWriter, r *http.Request) {
 
 	rights, err := h.nodeSvc.GetUserRights(r.Context(), userID)
 	if err != nil {
-		web.Error(w, http.StatusNotFound, "rights not found")
+		web.InternalError(w, err)
 		return
 	}
 
-	// rights is []byte (JSON) – we can return it directly or parse.
-	// If it's valid JSON, we can send it as raw JSON.
-	var parsed interface{}
-	if err := json.Unmarshal(rights, &parsed); err == nil {
-		web.Success(w, http.StatusOK, parsed)
-	} else {
-		// Fallback: send as string
-		web.Success(w, http.StatusOK, map[string]string{
-			"rights": string(rights),
-		})
-	}
+	web.Success(w, http.StatusOK, rights)
 }
 
 // GetGiftLimit returns the user's gift limit and remaining amount.
@@ -334,13 +491,20 @@ func (h *NodeHandler) GetGiftLimit(w http.ResponseWriter, r *http.Request) {
 		web.InternalError(w, err)
 		return
 	}
-
-	// Check remaining limit (you may add a method to NodeService)
-	remaining, _ := h.nodeSvc.CheckGiftLimitAvailable(r.Context(), userID, decimal.Zero)
+	_, remaining, err := h.nodeSvc.CheckGiftLimitAvailable(r.Context(), userID, decimal.Zero)
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
+	node, err := h.nodeSvc.GetUserNode(r.Context(), userID)
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
 
 	web.Success(w, http.StatusOK, map[string]interface{}{
 		"gift_limit":    limit,
 		"remaining":     remaining,
-		"current_level": nil, // You might want to include the current node level
+		"current_level": node.CurrentLevel,
 	})
 }
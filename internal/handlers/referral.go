@@ -2,9 +2,12 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	"jd7008911/canlan.org/internal/auth"
 	"jd7008911/canlan.org/internal/services"
 	"jd7008911/canlan.org/pkg/web"
@@ -24,17 +27,22 @@ func NewReferralHandler(referralSvc *services.ReferralService, nodeSvc *services
 	}
 }
 
-// RegisterRoutes registers referral routes.
+// RegisterPublicRoutes registers the click tracker; referral links are
+// clicked before the visitor has an account.
+func (h *ReferralHandler) RegisterPublicRoutes(r chi.Router) {
+	r.Post("/referral/click", h.RecordClick)
+}
+
+// RegisterRoutes registers referral routes under the authenticated group.
 func (h *ReferralHandler) RegisterRoutes(r chi.Router) {
-	r.Group(func(r chi.Router) {
-		r.Use(auth.AuthMiddleware) // All referral endpoints require authentication
-
-		r.Get("/referral/code", h.GetReferralCode)
-		r.Post("/referral/generate", h.GenerateReferralCode)
-		r.Get("/referral/earnings", h.GetReferralEarnings)
-		r.Get("/referral/stats", h.GetReferralStats)
-		r.Get("/referral/history", h.GetReferralHistory)
-	})
+	r.Get("/referral/code", h.GetReferralCode)
+	r.Post("/referral/generate", h.GenerateReferralCode)
+	r.Get("/referral/codes", h.ListReferralCodes)
+	r.Post("/referral/codes", h.CreateReferralCode)
+	r.Post("/referral/codes/{id}/primary", h.SetPrimaryReferralCode)
+	r.Get("/referral/earnings", h.GetReferralEarnings)
+	r.Get("/referral/stats", h.GetReferralStats)
+	r.Get("/referral/history", h.GetReferralHistory)
 }
 
 // ---------------------------------------------------------------------
@@ -50,7 +58,6 @@ func (h *ReferralHandler) GetReferralCode(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Assuming ReferralService has GetReferralCode method
 	code, err := h.referralSvc.GetReferralCode(r.Context(), userID)
 	if err != nil {
 		web.InternalError(w, err)
@@ -63,7 +70,8 @@ func (h *ReferralHandler) GetReferralCode(w http.ResponseWriter, r *http.Request
 	})
 }
 
-// GenerateReferralCode generates a new referral code for the user.
+// GenerateReferralCode gives the user a new random primary referral code.
+// The previous code keeps resolving to the user, so shared links still work.
 // POST /referral/generate
 func (h *ReferralHandler) GenerateReferralCode(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
@@ -72,27 +80,121 @@ func (h *ReferralHandler) GenerateReferralCode(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	// Generate new code
-	newCode, err := h.referralSvc.GenerateReferralCode()
+	code, err := h.referralSvc.RotateReferralCode(r.Context(), userID)
 	if err != nil {
-		web.InternalError(w, err)
+		writeReferralCodeError(w, err)
 		return
 	}
 
-	// Update user's referral code in database
-	err = h.referralSvc.UpdateReferralCode(r.Context(), userID, newCode)
+	web.Success(w, http.StatusOK, map[string]string{
+		"referral_code": code.Code,
+		"referral_link": generateReferralLink(code.Code),
+	})
+}
+
+// ListReferralCodes returns all of the user's referral codes, newest first,
+// with their campaign, click and signup counts, and when each stopped being
+// the primary code.
+// GET /referral/codes
+func (h *ReferralHandler) ListReferralCodes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		web.Unauthorized(w)
+		return
+	}
+
+	codes, err := h.referralSvc.ListReferralCodes(r.Context(), userID)
 	if err != nil {
 		web.InternalError(w, err)
 		return
 	}
 
+	web.Success(w, http.StatusOK, codes)
+}
+
+// CreateReferralCode adds a referral code, a vanity one when code is given
+// and a random one otherwise. The primary code does not change.
+// POST /referral/codes
+// Request body: { "code": "alice", "campaign": "twitter" }
+func (h *ReferralHandler) CreateReferralCode(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		web.Unauthorized(w)
+		return
+	}
+
+	var req struct {
+		Code     string `json:"code"`
+		Campaign string `json:"campaign"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		web.Error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	code, err := h.referralSvc.CreateCode(r.Context(), userID, req.Code, req.Campaign)
+	if err != nil {
+		writeReferralCodeError(w, err)
+		return
+	}
+
+	web.Success(w, http.StatusCreated, map[string]interface{}{
+		"code":          code,
+		"referral_link": generateReferralLink(code.Code),
+	})
+}
+
+// SetPrimaryReferralCode makes one of the user's codes their primary code.
+// POST /referral/codes/{id}/primary
+func (h *ReferralHandler) SetPrimaryReferralCode(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		web.Unauthorized(w)
+		return
+	}
+
+	codeID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		web.Error(w, http.StatusBadRequest, "invalid code ID")
+		return
+	}
+
+	code, err := h.referralSvc.SetPrimaryCode(r.Context(), userID, codeID)
+	if err != nil {
+		writeReferralCodeError(w, err)
+		return
+	}
+
 	web.Success(w, http.StatusOK, map[string]string{
-		"referral_code": newCode,
-		"referral_link": generateReferralLink(newCode),
+		"referral_code": code.Code,
+		"referral_link": generateReferralLink(code.Code),
 	})
 }
 
-// GetReferralEarnings returns the user's total referral earnings.
+// RecordClick counts a referral link click and returns a click ID for the
+// client to send as referral_click when the visitor signs up.
+// POST /referral/click
+// Request body: { "code": "alice" }
+func (h *ReferralHandler) RecordClick(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		web.Error(w, http.StatusBadRequest, "code is required")
+		return
+	}
+
+	click, err := h.referralSvc.RecordClick(r.Context(), req.Code)
+	if err != nil {
+		writeReferralCodeError(w, err)
+		return
+	}
+
+	web.Success(w, http.StatusOK, click)
+}
+
+// GetReferralEarnings returns the user's lifetime referral commissions per
+// token, earning type and state.
 // GET /referral/earnings
 func (h *ReferralHandler) GetReferralEarnings(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
@@ -101,7 +203,6 @@ func (h *ReferralHandler) GetReferralEarnings(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// Assuming ReferralService has GetTotalEarnings method
 	earnings, err := h.referralSvc.GetTotalEarnings(r.Context(), userID)
 	if err != nil {
 		web.InternalError(w, err)
@@ -110,7 +211,6 @@ func (h *ReferralHandler) GetReferralEarnings(w http.ResponseWriter, r *http.Req
 
 	web.Success(w, http.StatusOK, map[string]interface{}{
 		"total_earnings": earnings,
-		"currency":       "USDT", // or whatever token is used for rewards
 	})
 }
 
@@ -143,8 +243,9 @@ func (h *ReferralHandler) GetReferralStats(w http.ResponseWriter, r *http.Reques
 	web.Success(w, http.StatusOK, stats)
 }
 
-// GetReferralHistory returns a list of referral events (e.g., when someone joined using the user's code).
-// GET /referral/history?limit=20&offset=0
+// GetReferralHistory returns the referral commissions the user received,
+// newest first, with the downline user, depth and rate of each.
+// GET /referral/history?page=1&limit=20
 func (h *ReferralHandler) GetReferralHistory(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
 	if !ok {
@@ -152,25 +253,42 @@ func (h *ReferralHandler) GetReferralHistory(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	limit, offset := web.ParsePagination(r)
+	page := web.ParsePagination(r)
+	offset := (page.Page - 1) * page.Limit
 
-	// Assuming ReferralService has GetReferralHistory method
-	history, err := h.referralSvc.GetReferralHistory(r.Context(), userID, int32(limit), int32(offset))
+	history, err := h.referralSvc.GetReferralHistory(r.Context(), userID, int32(page.Limit), int32(offset))
 	if err != nil {
 		web.InternalError(w, err)
 		return
 	}
 
-	total, _ := h.referralSvc.CountReferralHistory(r.Context(), userID)
+	total, err := h.referralSvc.CountReferralHistory(r.Context(), userID)
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
 
-	meta := web.NewMeta(limit, offset, total)
-	web.SuccessWithMeta(w, http.StatusOK, history, meta)
+	web.SuccessWithMeta(w, http.StatusOK, history, web.NewMeta(page, total))
 }
 
 // ---------------------------------------------------------------------
 // Helper Functions
 // ---------------------------------------------------------------------
 
+// writeReferralCodeError maps referral code errors to HTTP statuses.
+func writeReferralCodeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidReferralCode):
+		web.Error(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrReferralCodeNotFound):
+		web.Error(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrReferralCodeTaken), errors.Is(err, services.ErrTooManyReferralCodes):
+		web.Error(w, http.StatusConflict, err.Error())
+	default:
+		web.InternalError(w, err)
+	}
+}
+
 // generateReferralLink builds the full referral URL from the code.
 func generateReferralLink(code string) string {
 	// In production, read base URL from config
@@ -0,0 +1,141 @@
+// internal/handlers/referral_risk.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"jd7008911/canlan.org/internal/auth"
+	"jd7008911/canlan.org/internal/services"
+	"jd7008911/canlan.org/pkg/web"
+)
+
+// ReferralRiskHandler serves the review queue of referred accounts flagged
+// for possible self-referral or sybil farming.
+type ReferralRiskHandler struct {
+	riskSvc *services.ReferralRiskService
+}
+
+// NewReferralRiskHandler creates a new referral risk handler.
+func NewReferralRiskHandler(riskSvc *services.ReferralRiskService) *ReferralRiskHandler {
+	return &ReferralRiskHandler{riskSvc: riskSvc}
+}
+
+// RegisterRoutes registers the review routes under the authenticated
+// group. Only wallets listed in REFERRAL_RISK_REVIEWERS may use them.
+func (h *ReferralRiskHandler) RegisterRoutes(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		r.Use(h.requireReviewer)
+
+		r.Get("/admin/referral-risk", h.ListReviewQueue)
+		r.Post("/admin/referral-risk/{userID}/approve", h.Approve)
+		r.Post("/admin/referral-risk/{userID}/reject", h.Reject)
+	})
+}
+
+// requireReviewer rejects requests from users who are not reviewers.
+func (h *ReferralRiskHandler) requireReviewer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.GetUserID(r.Context())
+		if !ok {
+			web.Unauthorized(w)
+			return
+		}
+		reviewer, err := h.riskSvc.IsReviewer(r.Context(), userID)
+		if err != nil || !reviewer {
+			web.Forbidden(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ---------------------------------------------------------------------
+// Handlers
+// ---------------------------------------------------------------------
+
+// ListReviewQueue returns accounts in a review state, highest risk first,
+// with the reasons for their score and how many commissions they hold.
+// GET /admin/referral-risk?status=flagged&page=1&limit=20
+func (h *ReferralRiskHandler) ListReviewQueue(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = services.RiskStatusFlagged
+	}
+	page := web.ParsePagination(r)
+
+	items, err := h.riskSvc.ListReviewQueue(r.Context(), status, int32(page.Limit), int32((page.Page-1)*page.Limit))
+	if err != nil {
+		writeReferralRiskError(w, err)
+		return
+	}
+	total, err := h.riskSvc.CountReviewQueue(r.Context(), status)
+	if err != nil {
+		writeReferralRiskError(w, err)
+		return
+	}
+
+	web.SuccessWithMeta(w, http.StatusOK, items, web.NewMeta(page, total))
+}
+
+// Approve clears a flagged account and pays out its held commissions.
+// POST /admin/referral-risk/{userID}/approve
+// Request body: { "note": "..." } (optional)
+func (h *ReferralRiskHandler) Approve(w http.ResponseWriter, r *http.Request) {
+	h.review(w, r, h.riskSvc.Approve)
+}
+
+// Reject confirms a flagged account as abusive and forfeits its held
+// commissions.
+// POST /admin/referral-risk/{userID}/reject
+// Request body: { "note": "..." } (optional)
+func (h *ReferralRiskHandler) Reject(w http.ResponseWriter, r *http.Request) {
+	h.review(w, r, h.riskSvc.Reject)
+}
+
+type riskDecision func(ctx context.Context, reviewerID, userID uuid.UUID, note string) (*services.RiskReviewResult, error)
+
+func (h *ReferralRiskHandler) review(w http.ResponseWriter, r *http.Request, decide riskDecision) {
+	reviewerID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		web.Unauthorized(w)
+		return
+	}
+
+	userID, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		web.Error(w, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+
+	var req struct {
+		Note string `json:"note"`
+	}
+	json.NewDecoder(r.Body).Decode(&req) // ignore error, note is optional
+
+	result, err := decide(r.Context(), reviewerID, userID, req.Note)
+	if err != nil {
+		writeReferralRiskError(w, err)
+		return
+	}
+
+	web.Success(w, http.StatusOK, result)
+}
+
+// writeReferralRiskError maps referral risk errors to HTTP statuses.
+func writeReferralRiskError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidRiskStatus):
+		web.Error(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrOwnRiskReview):
+		web.Error(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, services.ErrRiskReviewNotPending):
+		web.Error(w, http.StatusConflict, err.Error())
+	default:
+		web.InternalError(w, err)
+	}
+}
@@ -3,7 +3,10 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -25,19 +28,18 @@ func NewSwapHandler(swapSvc *services.SwapService) *SwapHandler {
 	}
 }
 
-// RegisterRoutes registers swap routes.
-func (h *SwapHandler) RegisterRoutes(r chi.Router) {
-	// Public routes
+// RegisterPublicRoutes registers the swap rate, which needs no account.
+func (h *SwapHandler) RegisterPublicRoutes(r chi.Router) {
 	r.Get("/swaps/rate", h.GetSwapRate)
+}
 
-	// Authenticated routes
-	r.Group(func(r chi.Router) {
-		r.Use(auth.AuthMiddleware)
-		r.Post("/swaps/execute", h.ExecuteSwap)
-		r.Get("/swaps", h.GetUserSwaps)
-		r.Get("/swaps/{id}", h.GetSwap)
-		r.Get("/swaps/stats", h.GetSwapStats)
-	})
+// RegisterRoutes registers swap routes under the authenticated group.
+func (h *SwapHandler) RegisterRoutes(r chi.Router) {
+	r.Get("/swaps/quote", h.GetSwapQuote)
+	r.Post("/swaps/execute", h.ExecuteSwap)
+	r.Get("/swaps", h.GetUserSwaps)
+	r.Get("/swaps/stats", h.GetSwapStats)
+	r.Get("/swaps/{id}", h.GetSwap)
 }
 
 // ---------------------------------------------------------------------
@@ -71,9 +73,53 @@ func (h *SwapHandler) GetSwapRate(w http.ResponseWriter, r *http.Request) {
 // Authenticated Handlers
 // ---------------------------------------------------------------------
 
+// GetSwapQuote returns the expected output, fee, price impact and route of
+// a swap, with a signed quote ID that /swaps/execute accepts until it expires.
+// GET /swaps/quote?from=USDT&to=CAN&amount=100&slippage_bps=50
+func (h *SwapHandler) GetSwapQuote(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		web.Unauthorized(w)
+		return
+	}
+
+	q := r.URL.Query()
+	from, to := q.Get("from"), q.Get("to")
+	if from == "" || to == "" {
+		web.Error(w, http.StatusBadRequest, "from and to token symbols are required")
+		return
+	}
+	amount, err := decimal.NewFromString(q.Get("amount"))
+	if err != nil || amount.LessThanOrEqual(decimal.Zero) {
+		web.Error(w, http.StatusBadRequest, "amount must be positive")
+		return
+	}
+	var slippageBps *int // omitted: the configured default
+	if v := q.Get("slippage_bps"); v != "" {
+		bps, err := strconv.Atoi(v)
+		if err != nil {
+			web.Error(w, http.StatusBadRequest, "invalid slippage_bps")
+			return
+		}
+		slippageBps = &bps
+	}
+
+	quote, err := h.swapSvc.QuoteSwap(r.Context(), userID, from, to, amount, slippageBps)
+	if err != nil {
+		if errors.Is(err, services.ErrStalePrice) {
+			web.Error(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		web.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	web.Success(w, http.StatusOK, quote)
+}
+
 // ExecuteSwap performs a token swap.
 // POST /swaps/execute
-// Request body: { "from_token": "USDT", "to_token": "CAN", "amount": "100.0", "tx_hash": "0x..." }
+// Request body: { "from_token": "USDT", "to_token": "CAN", "amount": "100.0", "tx_hash": "0x...", "quote_id": "..." }
+// or, without a quote: { ..., "min_amount_out": "99.5", "deadline": 1700000000 }
 func (h *SwapHandler) ExecuteSwap(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
 	if !ok {
@@ -86,6 +132,10 @@ func (h *SwapHandler) ExecuteSwap(w http.ResponseWriter, r *http.Request) {
 		ToToken   string          `json:"to_token" validate:"required"`
 		Amount    decimal.Decimal `json:"amount" validate:"required,gt=0"`
 		TxHash    string          `json:"tx_hash" validate:"required"`
+
+		QuoteID      string           `json:"quote_id"`
+		MinAmountOut *decimal.Decimal `json:"min_amount_out"`
+		Deadline     int64            `json:"deadline"` // unix seconds
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -102,17 +152,40 @@ func (h *SwapHandler) ExecuteSwap(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.QuoteID == "" && (req.MinAmountOut == nil || req.Deadline == 0) {
+		web.Error(w, http.StatusBadRequest, "quote_id or min_amount_out and deadline are required")
+		return
+	}
+
 	params := services.SwapParams{
 		UserID:    userID,
 		FromToken: req.FromToken,
 		ToToken:   req.ToToken,
 		Amount:    req.Amount,
 		TxHash:    req.TxHash,
+		QuoteID:   req.QuoteID,
+	}
+	if req.QuoteID == "" {
+		params.MinAmountOut = *req.MinAmountOut
+		params.Deadline = time.Unix(req.Deadline, 0)
 	}
 
 	result, err := h.swapSvc.ExecuteSwap(r.Context(), params)
 	if err != nil {
-		web.Error(w, http.StatusInternalServerError, err.Error())
+		switch {
+		case errors.Is(err, services.ErrSlippageExceeded),
+			errors.Is(err, services.ErrQuoteExpired),
+			errors.Is(err, services.ErrDeadlinePassed):
+			web.Error(w, http.StatusConflict, err.Error())
+		case errors.Is(err, services.ErrInvalidQuote),
+			errors.Is(err, services.ErrSlippageProtected),
+			errors.Is(err, services.ErrInsufficientBalance):
+			web.Error(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrStalePrice):
+			web.Error(w, http.StatusServiceUnavailable, err.Error())
+		default:
+			web.Error(w, http.StatusInternalServerError, err.Error())
+		}
 		return
 	}
 
@@ -120,7 +193,7 @@ func (h *SwapHandler) ExecuteSwap(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetUserSwaps returns paginated swap history for the authenticated user.
-// GET /swaps?limit=20&offset=0
+// GET /swaps?page=1&limit=20
 func (h *SwapHandler) GetUserSwaps(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
 	if !ok {
@@ -128,19 +201,22 @@ func (h *SwapHandler) GetUserSwaps(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	limit, offset := web.ParsePagination(r)
+	page := web.ParsePagination(r)
+	offset := (page.Page - 1) * page.Limit
 
-	swaps, err := h.swapSvc.GetUserSwaps(r.Context(), userID, int32(limit), int32(offset))
+	swaps, err := h.swapSvc.GetUserSwaps(r.Context(), userID, int32(page.Limit), int32(offset))
 	if err != nil {
 		web.InternalError(w, err)
 		return
 	}
 
-	// Get total count for pagination (you may need to add this method)
-	total, _ := h.swapSvc.CountUserSwaps(r.Context(), userID)
+	total, err := h.swapSvc.CountUserSwaps(r.Context(), userID)
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
 
-	meta := web.NewMeta(limit, offset, total)
-	web.SuccessWithMeta(w, http.StatusOK, swaps, meta)
+	web.SuccessWithMeta(w, http.StatusOK, swaps, web.NewMeta(page, total))
 }
 
 // GetSwap returns a specific swap by ID.
@@ -159,7 +235,6 @@ func (h *SwapHandler) GetSwap(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// You need to implement GetSwapByID in the service with ownership check
 	swap, err := h.swapSvc.GetSwapByID(r.Context(), swapID, userID)
 	if err != nil {
 		web.Error(w, http.StatusNotFound, "swap not found")
@@ -178,9 +253,6 @@ func (h *SwapHandler) GetSwapStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// You may want to get user-specific stats (total swapped, volume, etc.)
-	// For now, we can return global stats or a placeholder.
-	// We'll assume the service has a method for user swap summary.
 	stats, err := h.swapSvc.GetUserSwapSummary(r.Context(), userID)
 	if err != nil {
 		web.InternalError(w, err)
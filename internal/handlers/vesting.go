@@ -0,0 +1,78 @@
+// internal/handlers/vesting.go
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"jd7008911/canlan.org/internal/auth"
+	"jd7008911/canlan.org/internal/services"
+	"jd7008911/canlan.org/pkg/web"
+)
+
+// VestingHandler handles vesting schedule HTTP requests.
+type VestingHandler struct {
+	vestingSvc *services.VestingService
+}
+
+// NewVestingHandler creates a new vesting handler.
+func NewVestingHandler(vestingSvc *services.VestingService) *VestingHandler {
+	return &VestingHandler{
+		vestingSvc: vestingSvc,
+	}
+}
+
+// RegisterRoutes registers the vesting routes under the authenticated group.
+func (h *VestingHandler) RegisterRoutes(r chi.Router) {
+	r.Get("/vesting", h.GetSchedules)
+	r.Post("/vesting/{id}/unlock", h.EarlyUnlock)
+}
+
+// GetSchedules returns the user's vesting schedules with their progress.
+// GET /vesting?status=active
+func (h *VestingHandler) GetSchedules(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		web.Unauthorized(w)
+		return
+	}
+
+	schedules, err := h.vestingSvc.GetUserVestingSchedules(r.Context(), userID, r.URL.Query().Get("status"))
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
+	web.Success(w, http.StatusOK, schedules)
+}
+
+// EarlyUnlock releases a schedule's locked amount at once, burning the
+// early-unlock penalty.
+// POST /vesting/{id}/unlock
+func (h *VestingHandler) EarlyUnlock(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r.Context())
+	if !ok {
+		web.Unauthorized(w)
+		return
+	}
+	scheduleID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		web.Error(w, http.StatusBadRequest, "invalid schedule ID")
+		return
+	}
+
+	unlock, err := h.vestingSvc.EarlyUnlock(r.Context(), userID, scheduleID)
+	switch {
+	case errors.Is(err, services.ErrEarlyUnlockDisabled):
+		web.Error(w, http.StatusForbidden, err.Error())
+		return
+	case errors.Is(err, services.ErrVestingNotFound):
+		web.Error(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		web.InternalError(w, err)
+		return
+	}
+	web.Success(w, http.StatusOK, unlock)
+}
@@ -27,16 +27,11 @@ func NewWithdrawalHandler(withdrawalSvc *services.WithdrawalService) *Withdrawal
 
 // RegisterRoutes registers withdrawal routes.
 func (h *WithdrawalHandler) RegisterRoutes(r chi.Router) {
-	// User routes - require authentication
-	r.Group(func(r chi.Router) {
-		r.Use(auth.AuthMiddleware)
-
-		r.Post("/withdrawals", h.CreateWithdrawal)
-		r.Get("/withdrawals", h.GetUserWithdrawals)
-		r.Get("/withdrawals/{id}", h.GetWithdrawal)
-		r.Post("/withdrawals/{id}/cancel", h.CancelWithdrawal)
-		r.Get("/withdrawals/limits", h.GetWithdrawalLimits)
-	})
+	r.Post("/withdrawals", h.CreateWithdrawal)
+	r.Get("/withdrawals", h.GetUserWithdrawals)
+	r.Get("/withdrawals/limits", h.GetWithdrawalLimits)
+	r.Get("/withdrawals/{id}", h.GetWithdrawal)
+	r.Post("/withdrawals/{id}/cancel", h.CancelWithdrawal)
 
 	// Admin routes - require admin role (can be in a separate admin router)
 	// Uncomment and adjust as needed
@@ -96,7 +91,7 @@ func (h *WithdrawalHandler) CreateWithdrawal(w http.ResponseWriter, r *http.Requ
 }
 
 // GetUserWithdrawals returns paginated withdrawal history for the authenticated user.
-// GET /withdrawals?limit=20&offset=0
+// GET /withdrawals?page=1&limit=20
 func (h *WithdrawalHandler) GetUserWithdrawals(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
 	if !ok {
@@ -104,22 +99,22 @@ func (h *WithdrawalHandler) GetUserWithdrawals(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	limit, offset := web.ParsePagination(r)
+	page := web.ParsePagination(r)
+	offset := (page.Page - 1) * page.Limit
 
-	withdrawals, err := h.withdrawalSvc.GetUserWithdrawals(r.Context(), userID, int32(limit), int32(offset))
+	withdrawals, err := h.withdrawalSvc.GetUserWithdrawals(r.Context(), userID, int32(page.Limit), int32(offset))
 	if err != nil {
 		web.InternalError(w, err)
 		return
 	}
 
-	// Get total count for pagination
 	total, err := h.withdrawalSvc.CountUserWithdrawals(r.Context(), userID)
 	if err != nil {
-		total = 0
+		web.InternalError(w, err)
+		return
 	}
 
-	meta := web.NewMeta(limit, offset, total)
-	web.SuccessWithMeta(w, http.StatusOK, withdrawals, meta)
+	web.SuccessWithMeta(w, http.StatusOK, withdrawals, web.NewMeta(page, total))
 }
 
 // GetWithdrawal returns a specific withdrawal by ID.
@@ -198,11 +193,12 @@ func (h *WithdrawalHandler) GetWithdrawalLimits(w http.ResponseWriter, r *http.R
 // ---------------------------------------------------------------------
 
 // ListPendingWithdrawals returns all pending withdrawals (admin only).
-// GET /admin/withdrawals/pending?limit=20&offset=0
+// GET /admin/withdrawals/pending?page=1&limit=20
 func (h *WithdrawalHandler) ListPendingWithdrawals(w http.ResponseWriter, r *http.Request) {
-	limit, offset := web.ParsePagination(r)
+	page := web.ParsePagination(r)
+	offset := (page.Page - 1) * page.Limit
 
-	withdrawals, err := h.withdrawalSvc.ListPendingWithdrawals(r.Context(), int32(limit), int32(offset))
+	withdrawals, err := h.withdrawalSvc.ListPendingWithdrawals(r.Context(), int32(page.Limit), int32(offset))
 	if err != nil {
 		web.InternalError(w, err)
 		return
@@ -210,11 +206,11 @@ func (h *WithdrawalHandler) ListPendingWithdrawals(w http.ResponseWriter, r *htt
 
 	total, err := h.withdrawalSvc.CountPendingWithdrawals(r.Context())
 	if err != nil {
-		total = 0
+		web.InternalError(w, err)
+		return
 	}
 
-	meta := web.NewMeta(limit, offset, total)
-	web.SuccessWithMeta(w, http.StatusOK, withdrawals, meta)
+	web.SuccessWithMeta(w, http.StatusOK, withdrawals, web.NewMeta(page, total))
 }
 
 // ApproveWithdrawal marks a withdrawal as completed.
@@ -3,6 +3,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -27,16 +28,11 @@ func NewPurchaseHandler(purchaseSvc *services.PurchaseService) *PurchaseHandler
 
 // RegisterRoutes registers purchase routes under the authenticated group.
 func (h *PurchaseHandler) RegisterRoutes(r chi.Router) {
-	// All purchase endpoints require authentication
-	r.Group(func(r chi.Router) {
-		r.Use(auth.AuthMiddleware) // Adjust middleware name as needed
-
-		r.Post("/purchases/subscribe", h.Subscribe)
-		r.Get("/purchases", h.GetUserPurchases)
-		r.Get("/purchases/{id}", h.GetPurchase)
-		r.Post("/purchases/{id}/complete", h.CompletePurchase)
-		r.Post("/purchases/{id}/cancel", h.CancelPurchase) // Optional
-	})
+	r.Post("/purchases/subscribe", h.Subscribe)
+	r.Get("/purchases", h.GetUserPurchases)
+	r.Get("/purchases/{id}", h.GetPurchase)
+	r.Post("/purchases/{id}/complete", h.CompletePurchase)
+	r.Post("/purchases/{id}/cancel", h.CancelPurchase)
 }
 
 // ---------------------------------------------------------------------
@@ -79,6 +75,10 @@ func (h *PurchaseHandler) Subscribe(w http.ResponseWriter, r *http.Request) {
 
 	purchase, totalValue, err := h.purchaseSvc.Subscribe(r.Context(), params)
 	if err != nil {
+		if errors.Is(err, services.ErrStalePrice) {
+			web.Error(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
 		web.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -92,7 +92,7 @@ func (h *PurchaseHandler) Subscribe(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetUserPurchases returns paginated purchase history for the authenticated user.
-// GET /purchases?limit=20&offset=0
+// GET /purchases?page=1&limit=20
 func (h *PurchaseHandler) GetUserPurchases(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
 	if !ok {
@@ -100,19 +100,22 @@ func (h *PurchaseHandler) GetUserPurchases(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	limit, offset := web.ParsePagination(r)
+	page := web.ParsePagination(r)
+	offset := (page.Page - 1) * page.Limit
 
-	purchases, err := h.purchaseSvc.GetUserPurchases(r.Context(), userID, int32(limit), int32(offset))
+	purchases, err := h.purchaseSvc.GetUserPurchases(r.Context(), userID, int32(page.Limit), int32(offset))
 	if err != nil {
 		web.InternalError(w, err)
 		return
 	}
 
-	// Get total count for pagination (you may need to add this method to service)
-	total, _ := h.purchaseSvc.CountUserPurchases(r.Context(), userID)
+	total, err := h.purchaseSvc.CountUserPurchases(r.Context(), userID)
+	if err != nil {
+		web.InternalError(w, err)
+		return
+	}
 
-	meta := web.NewMeta(limit, offset, total)
-	web.SuccessWithMeta(w, http.StatusOK, purchases, meta)
+	web.SuccessWithMeta(w, http.StatusOK, purchases, web.NewMeta(page, total))
 }
 
 // GetPurchase returns a specific purchase by ID.
@@ -188,7 +191,7 @@ func (h *PurchaseHandler) CompletePurchase(w http.ResponseWriter, r *http.Reques
 	})
 }
 
-// CancelPurchase cancels a pending purchase (optional).
+// CancelPurchase cancels a pending purchase and refunds its payment.
 // POST /purchases/{id}/cancel
 func (h *PurchaseHandler) CancelPurchase(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserID(r.Context())
@@ -211,8 +214,6 @@ func (h *PurchaseHandler) CancelPurchase(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// This method should refund the payment and set status to 'cancelled'
-	// You need to implement CancelPurchase in the service.
 	err = h.purchaseSvc.CancelPurchase(r.Context(), purchaseID, userID)
 	if err != nil {
 		web.Error(w, http.StatusInternalServerError, err.Error())
@@ -0,0 +1,134 @@
+// internal/merkle/merkle.go
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/shopspring/decimal"
+)
+
+// ---------------------------------------------------------------------
+// Errors
+// ---------------------------------------------------------------------
+
+var (
+	ErrNegativeAmount = errors.New("amount must not be negative")
+	ErrAmountTooLarge = errors.New("amount does not fit in uint256")
+	ErrLeafIndex      = errors.New("leaf index out of range")
+)
+
+// TokenDecimals is the number of decimals amounts are scaled by on chain.
+const TokenDecimals = 18
+
+var maxUint256 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// ---------------------------------------------------------------------
+// Leaf encoding
+// ---------------------------------------------------------------------
+
+// Leaf hashes one (account, cumulative amount) entry the way a cumulative
+// MerkleDistributor contract does:
+//
+//	keccak256(abi.encodePacked(account, cumulativeAmount))
+//
+// i.e. the 20-byte address followed by the amount as a 32-byte big-endian
+// uint256.
+func Leaf(account common.Address, amount *big.Int) common.Hash {
+	return crypto.Keccak256Hash(account.Bytes(), common.LeftPadBytes(amount.Bytes(), 32))
+}
+
+// ToWei converts a token amount to its integer on-chain representation.
+// Digits beyond TokenDecimals are truncated.
+func ToWei(amount decimal.Decimal) (*big.Int, error) {
+	if amount.IsNegative() {
+		return nil, ErrNegativeAmount
+	}
+	wei := amount.Shift(TokenDecimals).BigInt()
+	if wei.Cmp(maxUint256) > 0 {
+		return nil, ErrAmountTooLarge
+	}
+	return wei, nil
+}
+
+// ---------------------------------------------------------------------
+// Tree
+// ---------------------------------------------------------------------
+
+// Tree is a binary Merkle tree whose inner nodes hash their two children in
+// sorted order, matching OpenZeppelin's MerkleProof.verify. A node without
+// a sibling is carried up to the next level unchanged.
+type Tree struct {
+	levels [][]common.Hash // levels[0] are the leaves, the last level is the root
+}
+
+// New builds a tree over leaves in the given order.
+func New(leaves []common.Hash) *Tree {
+	level := make([]common.Hash, len(leaves))
+	copy(level, leaves)
+	levels := [][]common.Hash{level}
+
+	for len(level) > 1 {
+		next := make([]common.Hash, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, hashPair(level[i], level[i+1]))
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return &Tree{levels: levels}
+}
+
+// Root returns the tree's root, or the zero hash for an empty tree.
+func (t *Tree) Root() common.Hash {
+	top := t.levels[len(t.levels)-1]
+	if len(top) == 0 {
+		return common.Hash{}
+	}
+	return top[0]
+}
+
+// Len returns the number of leaves.
+func (t *Tree) Len() int {
+	return len(t.levels[0])
+}
+
+// Proof returns the sibling hashes from leaf i up to the root.
+func (t *Tree) Proof(i int) ([]common.Hash, error) {
+	if i < 0 || i >= t.Len() {
+		return nil, ErrLeafIndex
+	}
+	proof := []common.Hash{}
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := i ^ 1
+		if sibling < len(level) {
+			proof = append(proof, level[sibling])
+		}
+		i /= 2
+	}
+	return proof, nil
+}
+
+// Verify reports whether proof links leaf to root. It is the Go
+// equivalent of MerkleProof.verify(proof, root, leaf).
+func Verify(proof []common.Hash, root, leaf common.Hash) bool {
+	computed := leaf
+	for _, sibling := range proof {
+		computed = hashPair(computed, sibling)
+	}
+	return computed == root
+}
+
+func hashPair(a, b common.Hash) common.Hash {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+	return crypto.Keccak256Hash(a[:], b[:])
+}
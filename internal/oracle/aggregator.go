@@ -0,0 +1,127 @@
+// internal/oracle/aggregator.go
+package oracle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// AggregatedPrice is the price agreed by the configured feeds for a token.
+type AggregatedPrice struct {
+	Price   decimal.Decimal `json:"price"`
+	Sources []string        `json:"sources"`
+}
+
+// Source joins the agreeing feed names for the price history.
+func (p AggregatedPrice) Source() string {
+	return strings.Join(p.Sources, ",")
+}
+
+// AggregatorOptions configures how feed quotes are combined.
+type AggregatorOptions struct {
+	MaxAge          time.Duration // quotes older than this are ignored
+	MaxDeviationBps int           // quotes further than this from the median are dropped
+	MinSources      int           // agreeing quotes required to publish a price
+}
+
+// Aggregator combines several feeds into one price per token: the median of
+// the fresh quotes, after dropping outliers. A token without enough
+// agreeing quotes gets no price, so the stored price ages and the staleness
+// guard takes over instead of trading on a single bad source.
+type Aggregator struct {
+	feeds []PriceFeed
+	opts  AggregatorOptions
+}
+
+// NewAggregator creates an aggregator over feeds.
+func NewAggregator(feeds []PriceFeed, opts AggregatorOptions) *Aggregator {
+	if opts.MinSources < 1 {
+		opts.MinSources = 1
+	}
+	return &Aggregator{feeds: feeds, opts: opts}
+}
+
+// Prices queries every feed and returns the aggregated price of each symbol
+// that has enough agreeing sources. A failing feed is skipped; an error is
+// returned only when every feed fails.
+func (a *Aggregator) Prices(ctx context.Context, symbols []string) (map[string]AggregatedPrice, error) {
+	if len(a.feeds) == 0 {
+		return nil, errors.New("no price feeds configured")
+	}
+
+	now := time.Now()
+	quotes := make(map[string][]Quote, len(symbols))
+	var failed int
+	for _, feed := range a.feeds {
+		feedQuotes, err := feed.Prices(ctx, symbols)
+		if err != nil {
+			failed++
+			log.Printf("oracle: %s feed failed: %v", feed.Name(), err)
+			continue
+		}
+		for symbol, q := range feedQuotes {
+			if !q.Price.IsPositive() {
+				continue
+			}
+			if a.opts.MaxAge > 0 && now.Sub(q.Timestamp) > a.opts.MaxAge {
+				continue
+			}
+			quotes[symbol] = append(quotes[symbol], q)
+		}
+	}
+	if failed == len(a.feeds) {
+		return nil, fmt.Errorf("all %d price feeds failed", failed)
+	}
+
+	result := make(map[string]AggregatedPrice, len(quotes))
+	for symbol, qs := range quotes {
+		price, err := a.aggregate(qs)
+		if err != nil {
+			log.Printf("oracle: no price for %s: %v", symbol, err)
+			continue
+		}
+		result[symbol] = price
+	}
+	return result, nil
+}
+
+// aggregate takes the median of qs, drops quotes deviating from it by more
+// than MaxDeviationBps and returns the median of the rest.
+func (a *Aggregator) aggregate(qs []Quote) (AggregatedPrice, error) {
+	if len(qs) < a.opts.MinSources {
+		return AggregatedPrice{}, fmt.Errorf("%w: have %d, need %d", ErrNotEnoughSources, len(qs), a.opts.MinSources)
+	}
+
+	prices := make([]decimal.Decimal, len(qs))
+	for i, q := range qs {
+		prices[i] = q.Price
+	}
+	mid := median(prices)
+
+	var kept []decimal.Decimal
+	var sources []string
+	for _, q := range qs {
+		if a.opts.MaxDeviationBps > 0 {
+			deviationBps := q.Price.Sub(mid).Abs().Div(mid).Mul(decimal.NewFromInt(10000))
+			if deviationBps.GreaterThan(decimal.NewFromInt(int64(a.opts.MaxDeviationBps))) {
+				continue
+			}
+		}
+		kept = append(kept, q.Price)
+		sources = append(sources, q.Source)
+	}
+	if len(kept) < a.opts.MinSources {
+		return AggregatedPrice{}, fmt.Errorf("%w: %d of %d quotes within %d bps of the median",
+			ErrNotEnoughSources, len(kept), len(qs), a.opts.MaxDeviationBps)
+	}
+
+	sort.Strings(sources)
+	return AggregatedPrice{Price: median(kept), Sources: sources}, nil
+}
@@ -0,0 +1,138 @@
+// internal/oracle/aggregator_test.go
+package oracle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// fakeFeed quotes fixed prices, or fails with err.
+type fakeFeed struct {
+	name   string
+	prices map[string]string
+	age    time.Duration // how old every quote is
+	err    error
+}
+
+func (f fakeFeed) Name() string { return f.name }
+
+func (f fakeFeed) Prices(ctx context.Context, symbols []string) (map[string]Quote, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	quotes := make(map[string]Quote, len(f.prices))
+	for symbol, price := range f.prices {
+		quotes[symbol] = Quote{
+			Price:     decimal.RequireFromString(price),
+			Timestamp: time.Now().Add(-f.age),
+			Source:    f.name,
+		}
+	}
+	return quotes, nil
+}
+
+// canFeeds returns one feed per price, named a, b, c, ..., quoting CAN.
+func canFeeds(prices ...string) []PriceFeed {
+	feeds := make([]PriceFeed, len(prices))
+	for i, p := range prices {
+		feeds[i] = fakeFeed{name: string(rune('a' + i)), prices: map[string]string{"CAN": p}}
+	}
+	return feeds
+}
+
+func TestAggregatorPrices(t *testing.T) {
+	errDown := errors.New("feed down")
+	tests := []struct {
+		name        string
+		opts        AggregatorOptions
+		feeds       []PriceFeed
+		wantPrice   string // empty when CAN gets no price
+		wantSources []string
+	}{
+		{"single source", AggregatorOptions{}, canFeeds("0.25"), "0.25", []string{"a"}},
+		{"median of an odd count", AggregatorOptions{}, canFeeds("1.02", "1.00", "1.01"), "1.01", []string{"a", "b", "c"}},
+		{"median of an even count", AggregatorOptions{}, canFeeds("1.03", "1.00", "1.01", "1.02"), "1.015", []string{"a", "b", "c", "d"}},
+		{"stale quote rejected", AggregatorOptions{MaxAge: time.Minute}, []PriceFeed{
+			fakeFeed{name: "fresh", prices: map[string]string{"CAN": "0.25"}},
+			fakeFeed{name: "stale", prices: map[string]string{"CAN": "0.40"}, age: 2 * time.Minute},
+		}, "0.25", []string{"fresh"}},
+		{"old quotes kept without MaxAge", AggregatorOptions{}, []PriceFeed{
+			fakeFeed{name: "old", prices: map[string]string{"CAN": "0.25"}, age: 24 * time.Hour},
+		}, "0.25", []string{"old"}},
+		{"outlier dropped", AggregatorOptions{MaxDeviationBps: 500}, canFeeds("1.00", "1.01", "1.50"), "1.005", []string{"a", "b"}},
+		{"quote at the deviation limit kept", AggregatorOptions{MaxDeviationBps: 500}, canFeeds("1.00", "1.05", "0.95"), "1.00", []string{"a", "b", "c"}},
+		{"non-positive quotes ignored", AggregatorOptions{}, canFeeds("0", "-1", "0.30"), "0.30", []string{"c"}},
+		{"failing feed skipped", AggregatorOptions{}, []PriceFeed{
+			fakeFeed{name: "down", err: errDown},
+			fakeFeed{name: "up", prices: map[string]string{"CAN": "0.25"}},
+		}, "0.25", []string{"up"}},
+		{"too few sources", AggregatorOptions{MinSources: 2}, canFeeds("0.25"), "", nil},
+		{"too few sources once stale ones are dropped", AggregatorOptions{MinSources: 2, MaxAge: time.Minute}, []PriceFeed{
+			fakeFeed{name: "fresh", prices: map[string]string{"CAN": "0.25"}},
+			fakeFeed{name: "stale", prices: map[string]string{"CAN": "0.25"}, age: time.Hour},
+		}, "", nil},
+		{"too few sources within the deviation", AggregatorOptions{MinSources: 2, MaxDeviationBps: 100}, canFeeds("1", "2", "3"), "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prices, err := NewAggregator(tt.feeds, tt.opts).Prices(context.Background(), []string{"CAN"})
+			if err != nil {
+				t.Fatalf("Prices() error = %v", err)
+			}
+			got, ok := prices["CAN"]
+			if tt.wantPrice == "" {
+				if ok {
+					t.Errorf("Prices() = %s from %v, want no price", got.Price, got.Sources)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("Prices() has no CAN price, want %s", tt.wantPrice)
+			}
+			if !got.Price.Equal(decimal.RequireFromString(tt.wantPrice)) {
+				t.Errorf("Prices() = %s, want %s", got.Price, tt.wantPrice)
+			}
+			if len(got.Sources) != len(tt.wantSources) {
+				t.Fatalf("sources = %v, want %v", got.Sources, tt.wantSources)
+			}
+			for i := range got.Sources {
+				if got.Sources[i] != tt.wantSources[i] {
+					t.Fatalf("sources = %v, want %v", got.Sources, tt.wantSources)
+				}
+			}
+		})
+	}
+}
+
+func TestAggregatorPricesFailures(t *testing.T) {
+	errDown := errors.New("feed down")
+	tests := []struct {
+		name  string
+		feeds []PriceFeed
+	}{
+		{"no feeds", nil},
+		{"every feed fails", []PriceFeed{fakeFeed{name: "a", err: errDown}, fakeFeed{name: "b", err: errDown}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewAggregator(tt.feeds, AggregatorOptions{}).Prices(context.Background(), []string{"CAN"}); err == nil {
+				t.Error("Prices() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestAggregateNotEnoughSources(t *testing.T) {
+	a := NewAggregator(nil, AggregatorOptions{MinSources: 3})
+	quotes := []Quote{
+		{Price: decimal.RequireFromString("1"), Source: "a"},
+		{Price: decimal.RequireFromString("1"), Source: "b"},
+	}
+	if _, err := a.aggregate(quotes); !errors.Is(err, ErrNotEnoughSources) {
+		t.Errorf("aggregate() error = %v, want ErrNotEnoughSources", err)
+	}
+}
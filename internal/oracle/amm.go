@@ -0,0 +1,174 @@
+// internal/oracle/amm.go
+package oracle
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/db"
+)
+
+// PoolReader is the subset of *db.Queries the AMM feed needs.
+type PoolReader interface {
+	ListLiquidityPools(ctx context.Context) ([]db.LiquidityPool, error)
+	ListAllTokens(ctx context.Context) ([]db.Token, error)
+}
+
+// AMMFeed prices tokens from the platform's own pools against an anchor
+// token (USDT by default, assumed to be worth $1). Each token is priced from
+// its deepest anchor pool, and spot prices are averaged over a time window
+// so a single large swap cannot move the oracle price on its own. The
+// anchor itself is not quoted; other feeds are expected to cover it.
+type AMMFeed struct {
+	reader       PoolReader
+	anchorSymbol string
+	window       time.Duration
+
+	mu      sync.Mutex
+	samples map[string][]priceSample
+}
+
+// priceSample is one observed spot price.
+type priceSample struct {
+	at    time.Time
+	price decimal.Decimal
+}
+
+// NewAMMFeed creates a pool-backed TWAP feed.
+func NewAMMFeed(reader PoolReader, anchorSymbol string, window time.Duration) *AMMFeed {
+	return &AMMFeed{
+		reader:       reader,
+		anchorSymbol: strings.ToUpper(anchorSymbol),
+		window:       window,
+		samples:      make(map[string][]priceSample),
+	}
+}
+
+func (f *AMMFeed) Name() string { return "amm_twap" }
+
+func (f *AMMFeed) Prices(ctx context.Context, symbols []string) (map[string]Quote, error) {
+	spot, err := f.spotPrices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	quotes := make(map[string]Quote, len(symbols))
+	for _, symbol := range symbols {
+		key := strings.ToUpper(symbol)
+		if price, ok := spot[key]; ok {
+			f.samples[key] = append(f.samples[key], priceSample{at: now, price: price})
+		}
+		cutoff := now.Add(-f.window)
+		f.samples[key] = trimSamples(f.samples[key], cutoff)
+		if len(f.samples[key]) == 0 {
+			continue
+		}
+		quotes[symbol] = Quote{
+			Price:     timeWeightedAverage(f.samples[key], cutoff, now),
+			Timestamp: f.samples[key][len(f.samples[key])-1].at,
+			Source:    f.Name(),
+		}
+	}
+	return quotes, nil
+}
+
+// spotPrices returns the anchor-denominated spot price of every token that
+// has an active pool against the anchor, taken from the deepest such pool.
+func (f *AMMFeed) spotPrices(ctx context.Context) (map[string]decimal.Decimal, error) {
+	tokens, err := f.reader.ListAllTokens(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tokens: %w", err)
+	}
+	symbols := make(map[uuid.UUID]string, len(tokens))
+	var anchorID uuid.UUID
+	for _, t := range tokens {
+		symbols[t.ID] = strings.ToUpper(t.Symbol)
+		if strings.ToUpper(t.Symbol) == f.anchorSymbol {
+			anchorID = t.ID
+		}
+	}
+	if anchorID == uuid.Nil {
+		return nil, fmt.Errorf("anchor token %s not found", f.anchorSymbol)
+	}
+
+	pools, err := f.reader.ListLiquidityPools(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pools: %w", err)
+	}
+
+	prices := make(map[string]decimal.Decimal)
+	depth := make(map[string]decimal.Decimal)
+	for _, pool := range pools {
+		if !pool.Reserve0.IsPositive() || !pool.Reserve1.IsPositive() {
+			continue
+		}
+		var tokenID uuid.UUID
+		var reserveToken, reserveAnchor decimal.Decimal
+		switch anchorID {
+		case pool.Token0ID:
+			tokenID, reserveToken, reserveAnchor = pool.Token1ID, pool.Reserve1, pool.Reserve0
+		case pool.Token1ID:
+			tokenID, reserveToken, reserveAnchor = pool.Token0ID, pool.Reserve0, pool.Reserve1
+		default:
+			continue
+		}
+		symbol, ok := symbols[tokenID]
+		if !ok || reserveAnchor.LessThanOrEqual(depth[symbol]) {
+			continue
+		}
+		depth[symbol] = reserveAnchor
+		prices[symbol] = reserveAnchor.DivRound(reserveToken, 18)
+	}
+	return prices, nil
+}
+
+// trimSamples drops samples older than cutoff, keeping the most recent
+// older sample so the window always starts with a known price.
+func trimSamples(samples []priceSample, cutoff time.Time) []priceSample {
+	start := 0
+	for start+1 < len(samples) && !samples[start+1].at.After(cutoff) {
+		start++
+	}
+	if start < len(samples) && samples[start].at.Before(cutoff) && start == len(samples)-1 {
+		// Only a stale sample is left: the pool has not been observed in
+		// the whole window.
+		return samples[:0]
+	}
+	return samples[start:]
+}
+
+// timeWeightedAverage weights every sample by how long it was current
+// within [from, now]. The newest sample counts until now; a single sample
+// is returned as is.
+func timeWeightedAverage(samples []priceSample, from, now time.Time) decimal.Decimal {
+	if len(samples) == 1 {
+		return samples[0].price
+	}
+	sum := decimal.Zero
+	total := decimal.Zero
+	for i, s := range samples {
+		start, end := s.at, now
+		if start.Before(from) {
+			start = from
+		}
+		if i+1 < len(samples) {
+			end = samples[i+1].at
+		}
+		weight := decimal.NewFromInt(end.Sub(start).Milliseconds())
+		sum = sum.Add(s.price.Mul(weight))
+		total = total.Add(weight)
+	}
+	if total.IsZero() {
+		return samples[len(samples)-1].price
+	}
+	return sum.DivRound(total, 18)
+}
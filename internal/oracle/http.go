@@ -0,0 +1,94 @@
+// internal/oracle/http.go
+package oracle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// HTTPFeed reads prices from a JSON endpoint:
+//
+//	GET {url}?symbols=CAN,USDT
+//	{"timestamp": 1700000000, "prices": {"CAN": "0.25", "USDT": "1.0"}}
+//
+// The timestamp (unix seconds) applies to every price; when it is missing
+// the response time is used. cmd/pricefeed-stub serves this format locally.
+type HTTPFeed struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+// NewHTTPFeed creates a feed for endpoint with the given request timeout.
+func NewHTTPFeed(name, endpoint string, timeout time.Duration) *HTTPFeed {
+	if name == "" {
+		name = "http"
+	}
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	return &HTTPFeed{
+		name:   name,
+		url:    endpoint,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// httpFeedResponse is the JSON body returned by the price endpoint.
+type httpFeedResponse struct {
+	Timestamp int64                      `json:"timestamp"`
+	Prices    map[string]decimal.Decimal `json:"prices"`
+}
+
+func (f *HTTPFeed) Name() string { return f.name }
+
+func (f *HTTPFeed) Prices(ctx context.Context, symbols []string) (map[string]Quote, error) {
+	u, err := url.Parse(f.url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid feed url: %w", err)
+	}
+	query := u.Query()
+	query.Set("symbols", strings.Join(symbols, ","))
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s feed request failed: %w", f.name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s feed returned status %d", f.name, resp.StatusCode)
+	}
+
+	var body httpFeedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("%s feed returned invalid JSON: %w", f.name, err)
+	}
+
+	ts := time.Now()
+	if body.Timestamp > 0 {
+		ts = time.Unix(body.Timestamp, 0)
+	}
+	quotes := make(map[string]Quote, len(symbols))
+	for _, symbol := range symbols {
+		price, ok := body.Prices[symbol]
+		if !ok {
+			price, ok = body.Prices[strings.ToUpper(symbol)]
+		}
+		if ok && price.IsPositive() {
+			quotes[symbol] = Quote{Price: price, Timestamp: ts, Source: f.name}
+		}
+	}
+	return quotes, nil
+}
@@ -0,0 +1,107 @@
+// internal/oracle/http_test.go
+package oracle
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestHTTPFeedPrices(t *testing.T) {
+	var gotSymbols string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSymbols = r.URL.Query().Get("symbols")
+		w.Write([]byte(`{"timestamp": 1700000000, "prices": {"CAN": "0.25", "USDT": "1.0", "ETH": "0"}}`))
+	}))
+	defer srv.Close()
+
+	feed := NewHTTPFeed("remote", srv.URL+"/prices?key=abc", time.Second)
+	quotes, err := feed.Prices(context.Background(), []string{"CAN", "usdt", "ETH", "BTC"})
+	if err != nil {
+		t.Fatalf("Prices() error = %v", err)
+	}
+	if gotSymbols != "CAN,usdt,ETH,BTC" {
+		t.Errorf("requested symbols = %q, want CAN,usdt,ETH,BTC", gotSymbols)
+	}
+
+	want := map[string]string{"CAN": "0.25", "usdt": "1"}
+	if len(quotes) != len(want) {
+		t.Fatalf("Prices() = %v, want quotes for %v", quotes, want)
+	}
+	for symbol, price := range want {
+		q, ok := quotes[symbol]
+		if !ok {
+			t.Fatalf("Prices() has no quote for %s", symbol)
+		}
+		if !q.Price.Equal(decimal.RequireFromString(price)) || q.Source != "remote" || !q.Timestamp.Equal(time.Unix(1700000000, 0)) {
+			t.Errorf("%s = %+v, want %s from remote at 1700000000", symbol, q, price)
+		}
+	}
+}
+
+func TestHTTPFeedMissingTimestamp(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"prices": {"CAN": "0.25"}}`))
+	}))
+	defer srv.Close()
+
+	before := time.Now()
+	quotes, err := NewHTTPFeed("", srv.URL, time.Second).Prices(context.Background(), []string{"CAN"})
+	if err != nil {
+		t.Fatalf("Prices() error = %v", err)
+	}
+	if q := quotes["CAN"]; q.Timestamp.Before(before) || q.Source != "http" {
+		t.Errorf("quote = %+v, want a current quote from http", q)
+	}
+}
+
+func TestHTTPFeedErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"error status", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "maintenance", http.StatusServiceUnavailable)
+		}},
+		{"invalid JSON", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"prices": {"CAN": 0.25`))
+		}},
+		{"invalid price", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"prices": {"CAN": "cheap"}}`))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			if _, err := NewHTTPFeed("remote", srv.URL, time.Second).Prices(context.Background(), []string{"CAN"}); err == nil {
+				t.Error("Prices() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestHTTPFeedTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	start := time.Now()
+	_, err := NewHTTPFeed("slow", srv.URL, 50*time.Millisecond).Prices(context.Background(), []string{"CAN"})
+	if err == nil {
+		t.Fatal("Prices() error = nil, want a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Prices() returned after %s, want the 50ms timeout to apply", elapsed)
+	}
+}
@@ -0,0 +1,56 @@
+// internal/oracle/oracle.go
+package oracle
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ---------------------------------------------------------------------
+// Errors
+// ---------------------------------------------------------------------
+
+var (
+	ErrNoPrice          = errors.New("no price available")
+	ErrNotEnoughSources = errors.New("not enough agreeing price sources")
+)
+
+// ---------------------------------------------------------------------
+// PriceFeed interface
+// ---------------------------------------------------------------------
+
+// Quote is one source's USD price for a token.
+type Quote struct {
+	Price     decimal.Decimal `json:"price"`
+	Timestamp time.Time       `json:"timestamp"`
+	Source    string          `json:"source"`
+}
+
+// PriceFeed is a source of USD prices. Prices returns quotes for as many of
+// the requested symbols as the feed knows; unknown symbols are omitted
+// rather than reported as errors.
+type PriceFeed interface {
+	Name() string
+	Prices(ctx context.Context, symbols []string) (map[string]Quote, error)
+}
+
+// ---------------------------------------------------------------------
+// Helpers
+// ---------------------------------------------------------------------
+
+// median returns the median of a non-empty list of prices.
+func median(prices []decimal.Decimal) decimal.Decimal {
+	sorted := make([]decimal.Decimal, len(prices))
+	copy(sorted, prices)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].LessThan(sorted[j]) })
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	return sorted[mid-1].Add(sorted[mid]).Div(decimal.NewFromInt(2))
+}
@@ -0,0 +1,60 @@
+// internal/oracle/static.go
+package oracle
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// StaticFeed serves fixed prices from configuration. Its quotes are always
+// current: a configured price is valid until the configuration changes.
+type StaticFeed struct {
+	prices map[string]decimal.Decimal
+}
+
+// NewStaticFeed creates a feed from a symbol → price map.
+func NewStaticFeed(prices map[string]decimal.Decimal) *StaticFeed {
+	normalized := make(map[string]decimal.Decimal, len(prices))
+	for symbol, price := range prices {
+		normalized[strings.ToUpper(symbol)] = price
+	}
+	return &StaticFeed{prices: normalized}
+}
+
+// ParseStaticPrices parses "USDT=1,CAN=0.25" into a price map.
+func ParseStaticPrices(spec string) (map[string]decimal.Decimal, error) {
+	prices := make(map[string]decimal.Decimal)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		symbol, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid static price %q, want SYMBOL=PRICE", pair)
+		}
+		price, err := decimal.NewFromString(strings.TrimSpace(value))
+		if err != nil || !price.IsPositive() {
+			return nil, fmt.Errorf("invalid static price for %s: %q", symbol, value)
+		}
+		prices[strings.ToUpper(strings.TrimSpace(symbol))] = price
+	}
+	return prices, nil
+}
+
+func (f *StaticFeed) Name() string { return "static" }
+
+func (f *StaticFeed) Prices(ctx context.Context, symbols []string) (map[string]Quote, error) {
+	now := time.Now()
+	quotes := make(map[string]Quote, len(symbols))
+	for _, symbol := range symbols {
+		if price, ok := f.prices[strings.ToUpper(symbol)]; ok {
+			quotes[symbol] = Quote{Price: price, Timestamp: now, Source: f.Name()}
+		}
+	}
+	return quotes, nil
+}
@@ -0,0 +1,66 @@
+// internal/oracle/static_test.go
+package oracle
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestParseStaticPrices(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"pairs", "USDT=1,CAN=0.25", map[string]string{"USDT": "1", "CAN": "0.25"}, false},
+		{"spaces, case and empty pairs", " usdt = 1 , ,can=0.25,", map[string]string{"USDT": "1", "CAN": "0.25"}, false},
+		{"empty", "", map[string]string{}, false},
+		{"later pair wins", "CAN=0.25,can=0.30", map[string]string{"CAN": "0.30"}, false},
+		{"missing price", "CAN", nil, true},
+		{"not a number", "CAN=cheap", nil, true},
+		{"zero price", "CAN=0", nil, true},
+		{"negative price", "USDT=1,CAN=-0.25", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseStaticPrices(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseStaticPrices() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseStaticPrices() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseStaticPrices() = %v, want %v", got, tt.want)
+			}
+			for symbol, price := range tt.want {
+				if p, ok := got[symbol]; !ok || !p.Equal(decimal.RequireFromString(price)) {
+					t.Errorf("ParseStaticPrices()[%s] = %s, want %s", symbol, p, price)
+				}
+			}
+		})
+	}
+}
+
+func TestStaticFeedPrices(t *testing.T) {
+	prices, err := ParseStaticPrices("usdt=1,CAN=0.25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	quotes, err := NewStaticFeed(prices).Prices(context.Background(), []string{"USDT", "can", "BTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quotes) != 2 || !quotes["USDT"].Price.Equal(decimal.NewFromInt(1)) || !quotes["can"].Price.Equal(decimal.RequireFromString("0.25")) {
+		t.Errorf("Prices() = %v, want USDT 1 and can 0.25", quotes)
+	}
+	if quotes["can"].Source != "static" {
+		t.Errorf("source = %q, want static", quotes["can"].Source)
+	}
+}
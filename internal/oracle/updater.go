@@ -0,0 +1,109 @@
+// internal/oracle/updater.go
+package oracle
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/db"
+)
+
+// Updater periodically writes aggregated prices to tokens.price_usd and
+// appends them to token_price_history.
+type Updater struct {
+	queries  *db.Queries
+	tx       db.TxRunner
+	agg      *Aggregator
+	interval time.Duration
+}
+
+// NewUpdater creates an updater that refreshes prices every interval.
+func NewUpdater(queries *db.Queries, tx db.TxRunner, agg *Aggregator, interval time.Duration) *Updater {
+	return &Updater{
+		queries:  queries,
+		tx:       tx,
+		agg:      agg,
+		interval: interval,
+	}
+}
+
+// Refresh fetches prices for every active token and stores the ones the
+// aggregator agreed on. Tokens without a price keep their previous value
+// and price_updated_at, so they go stale rather than being overwritten.
+func (u *Updater) Refresh(ctx context.Context) (map[string]AggregatedPrice, error) {
+	tokens, err := u.queries.ListTokens(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tokens: %w", err)
+	}
+	symbols := make([]string, len(tokens))
+	for i, t := range tokens {
+		symbols[i] = t.Symbol
+	}
+
+	prices, err := u.agg.Prices(ctx, symbols)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uuid.UUID
+	var values []decimal.Decimal
+	var sources []string
+	for _, t := range tokens {
+		p, ok := prices[t.Symbol]
+		if !ok {
+			continue
+		}
+		ids = append(ids, t.ID)
+		values = append(values, p.Price)
+		sources = append(sources, p.Source())
+	}
+	if len(ids) == 0 {
+		return prices, nil
+	}
+
+	err = u.tx.WithTx(ctx, func(q *db.Queries) error {
+		if err := q.BulkUpdateTokenPrices(ctx, db.BulkUpdateTokenPricesParams{
+			Ids:    ids,
+			Prices: values,
+		}); err != nil {
+			return fmt.Errorf("failed to update token prices: %w", err)
+		}
+		if err := q.BulkInsertTokenPriceHistory(ctx, db.BulkInsertTokenPriceHistoryParams{
+			Ids:     ids,
+			Prices:  values,
+			Sources: sources,
+		}); err != nil {
+			return fmt.Errorf("failed to record price history: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prices, nil
+}
+
+// Run refreshes prices immediately and then every interval until ctx is
+// cancelled. Failures are logged and retried on the next tick.
+func (u *Updater) Run(ctx context.Context) {
+	if u.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(u.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := u.Refresh(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("oracle: price refresh failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// internal/services/amm.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/db"
+)
+
+// Constant-product (x * y = k) pool math shared by swaps and liquidity
+// changes, so LP value and swap output always come from the same reserves.
+// Amounts paid out of a pool are rounded down to 18 decimals (the column
+// precision), so rounding never drains a pool.
+
+// ErrInsufficientLiquidity is returned when a pool cannot fill a trade.
+var ErrInsufficientLiquidity = errors.New("insufficient pool liquidity")
+
+const ammPrecision = 18
+
+var bpsDenominator = decimal.NewFromInt(10000)
+
+// ---------------------------------------------------------------------
+// Swap Math
+// ---------------------------------------------------------------------
+
+// orientReserves returns the pool's reserves ordered for a trade that
+// sells fromTokenID, and whether the trade sells token0.
+func orientReserves(pool *db.LiquidityPool, fromTokenID uuid.UUID) (reserveIn, reserveOut decimal.Decimal, zeroForOne bool) {
+	if pool.Token0ID == fromTokenID {
+		return pool.Reserve0, pool.Reserve1, true
+	}
+	return pool.Reserve1, pool.Reserve0, false
+}
+
+// getAmountOut returns the output of selling amountIn into a pool, and
+// the part of amountIn taken as the LP fee. The fee stays in the pool.
+func getAmountOut(amountIn, reserveIn, reserveOut decimal.Decimal, feeBps int32) (amountOut, fee decimal.Decimal, err error) {
+	if !amountIn.IsPositive() {
+		return decimal.Zero, decimal.Zero, fmt.Errorf("amount in must be positive")
+	}
+	if !reserveIn.IsPositive() || !reserveOut.IsPositive() {
+		return decimal.Zero, decimal.Zero, ErrInsufficientLiquidity
+	}
+
+	amountInWithFee := divDown(amountIn.Mul(bpsDenominator.Sub(decimal.NewFromInt32(feeBps))), bpsDenominator)
+	fee = amountIn.Sub(amountInWithFee)
+
+	// out = in' * y / (x + in')
+	amountOut = divDown(amountInWithFee.Mul(reserveOut), reserveIn.Add(amountInWithFee))
+	if !amountOut.IsPositive() || amountOut.GreaterThanOrEqual(reserveOut) {
+		return decimal.Zero, decimal.Zero, ErrInsufficientLiquidity
+	}
+	return amountOut, fee, nil
+}
+
+// spotPrice is the marginal price of the input token in output tokens.
+func spotPrice(reserveIn, reserveOut decimal.Decimal) decimal.Decimal {
+	if reserveIn.IsZero() {
+		return decimal.Zero
+	}
+	return reserveOut.DivRound(reserveIn, ammPrecision)
+}
+
+// ---------------------------------------------------------------------
+// Liquidity Math
+// ---------------------------------------------------------------------
+
+// optimalDeposit scales the desired amounts down to the pool's current
+// ratio so a deposit never shifts the price. An empty pool takes both
+// amounts as given; they set the initial price.
+func optimalDeposit(amount0, amount1, reserve0, reserve1 decimal.Decimal) (decimal.Decimal, decimal.Decimal) {
+	if reserve0.IsZero() || reserve1.IsZero() {
+		return amount0, amount1
+	}
+	amount1Optimal := divDown(amount0.Mul(reserve1), reserve0)
+	if amount1Optimal.LessThanOrEqual(amount1) {
+		return amount0, amount1Optimal
+	}
+	amount0Optimal := divDown(amount1.Mul(reserve0), reserve1)
+	return amount0Optimal, amount1
+}
+
+// sharesToMint returns the LP shares for depositing amount0/amount1. The
+// first deposit mints sqrt(amount0 * amount1); later deposits mint in
+// proportion to the smaller of the two reserve ratios.
+func sharesToMint(amount0, amount1, reserve0, reserve1, totalSupply decimal.Decimal) (decimal.Decimal, error) {
+	var shares decimal.Decimal
+	if totalSupply.IsZero() || reserve0.IsZero() || reserve1.IsZero() {
+		shares = sqrtDecimal(amount0.Mul(amount1))
+	} else {
+		shares0 := divDown(amount0.Mul(totalSupply), reserve0)
+		shares1 := divDown(amount1.Mul(totalSupply), reserve1)
+		shares = decimal.Min(shares0, shares1)
+	}
+	if !shares.IsPositive() {
+		return decimal.Zero, fmt.Errorf("deposit too small to mint LP shares")
+	}
+	return shares, nil
+}
+
+// amountsForShares returns the reserves owed for burning shares.
+func amountsForShares(shares, reserve0, reserve1, totalSupply decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
+	if !totalSupply.IsPositive() || shares.GreaterThan(totalSupply) {
+		return decimal.Zero, decimal.Zero, ErrInsufficientLiquidity
+	}
+	amount0 := divDown(shares.Mul(reserve0), totalSupply)
+	amount1 := divDown(shares.Mul(reserve1), totalSupply)
+	return amount0, amount1, nil
+}
+
+// divDown returns a / b truncated to 18 decimals. Rounding to nearest
+// could pay out one unit more than the pool holds against the curve.
+func divDown(a, b decimal.Decimal) decimal.Decimal {
+	q, _ := a.QuoRem(b, ammPrecision)
+	return q
+}
+
+// sqrtDecimal returns the square root of x to 18 decimals using Newton's
+// method, seeded from float64.
+func sqrtDecimal(x decimal.Decimal) decimal.Decimal {
+	if !x.IsPositive() {
+		return decimal.Zero
+	}
+	f, _ := x.Float64()
+	z := decimal.NewFromFloat(math.Sqrt(f))
+	if !z.IsPositive() {
+		z = decimal.NewFromInt(1)
+	}
+	two := decimal.NewFromInt(2)
+	epsilon := decimal.New(1, -ammPrecision)
+	for i := 0; i < 100; i++ {
+		next := z.Add(x.DivRound(z, ammPrecision+4)).DivRound(two, ammPrecision+4)
+		if next.Sub(z).Abs().LessThan(epsilon) {
+			z = next
+			break
+		}
+		z = next
+	}
+	return z.Truncate(ammPrecision)
+}
+
+// ---------------------------------------------------------------------
+// Pool State
+// ---------------------------------------------------------------------
+
+// poolValueUSD values reserves at the current token prices.
+func poolValueUSD(reserve0, reserve1, price0, price1 decimal.Decimal) decimal.Decimal {
+	return reserve0.Mul(price0).Add(reserve1.Mul(price1))
+}
+
+// setPoolReserves stores new reserves and LP supply for a pool locked by
+// the caller's transaction, refreshing its derived USD liquidity.
+func setPoolReserves(ctx context.Context, q *db.Queries, pool *db.LiquidityPool, reserve0, reserve1, totalSupply decimal.Decimal) error {
+	token0, err := q.GetTokenByID(ctx, pool.Token0ID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch token0: %w", err)
+	}
+	token1, err := q.GetTokenByID(ctx, pool.Token1ID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch token1: %w", err)
+	}
+
+	if err := q.UpdatePoolReserves(ctx, db.UpdatePoolReservesParams{
+		ID:                pool.ID,
+		Reserve0:          reserve0,
+		Reserve1:          reserve1,
+		LpTotalSupply:     totalSupply,
+		TotalLiquidityUsd: poolValueUSD(reserve0, reserve1, token0.PriceUsd, token1.PriceUsd),
+	}); err != nil {
+		return fmt.Errorf("failed to update pool reserves: %w", err)
+	}
+	pool.Reserve0, pool.Reserve1, pool.LpTotalSupply = reserve0, reserve1, totalSupply
+	return nil
+}
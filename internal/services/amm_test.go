@@ -0,0 +1,252 @@
+// internal/services/amm_test.go
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/db"
+)
+
+func dec(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+func TestGetAmountOut(t *testing.T) {
+	tests := []struct {
+		name       string
+		amountIn   string
+		reserveIn  string
+		reserveOut string
+		feeBps     int32
+		wantOut    string
+		wantFee    string
+		wantErr    error
+	}{
+		{"0.30% fee", "100", "1000", "1000", 30, "90.661089388014913158", "0.3", nil},
+		{"no fee, output truncated", "1", "1000", "2000", 0, "1.998001998001998001", "0", nil},
+		{"fee rounded in the pool's favour", "333.333333333333333333", "1000", "1000", 30, "249.4370778083562672", "1", nil},
+		{"trade larger than the pool", "1000000000000", "1", "1", 30, "0.99999999999899699", "3000000000", nil},
+		{"zero input", "0", "1000", "1000", 30, "", "", errAny},
+		{"negative input", "-1", "1000", "1000", 30, "", "", errAny},
+		{"empty input reserve", "1", "0", "1000", 30, "", "", ErrInsufficientLiquidity},
+		{"empty output reserve", "1", "1000", "0", 30, "", "", ErrInsufficientLiquidity},
+		{"dust rounds to zero", "0.000000000000000001", "1000", "1", 30, "", "", ErrInsufficientLiquidity},
+		{"huge trade cannot empty the pool", "1000000000000000000000000000000", "1", "1", 0, "0.999999999999999999", "0", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, fee, err := getAmountOut(dec(tt.amountIn), dec(tt.reserveIn), dec(tt.reserveOut), tt.feeBps)
+			if tt.wantErr != nil {
+				if err == nil || (tt.wantErr != errAny && !errors.Is(err, tt.wantErr)) {
+					t.Errorf("getAmountOut() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getAmountOut() error = %v", err)
+			}
+			if !out.Equal(dec(tt.wantOut)) {
+				t.Errorf("getAmountOut() out = %s, want %s", out, tt.wantOut)
+			}
+			if !fee.Equal(dec(tt.wantFee)) {
+				t.Errorf("getAmountOut() fee = %s, want %s", fee, tt.wantFee)
+			}
+		})
+	}
+}
+
+// errAny matches any non-nil error in table tests.
+var errAny = errors.New("any error")
+
+// TestGetAmountOutKeepsInvariant checks that no trade can lower x * y: the
+// pool must never pay out more than the curve allows.
+func TestGetAmountOutKeepsInvariant(t *testing.T) {
+	reserves := [][2]string{
+		{"1000", "1000"},
+		{"1", "1000000"},
+		{"123456.789", "0.000001"},
+		{"3", "7"},
+	}
+	amounts := []string{"0.000000000000000001", "0.1", "1", "333.333333333333333333", "1000000"}
+	for _, feeBps := range []int32{0, 30, 9999} {
+		for _, r := range reserves {
+			x, y := dec(r[0]), dec(r[1])
+			k := x.Mul(y)
+			for _, a := range amounts {
+				in := dec(a)
+				out, fee, err := getAmountOut(in, x, y, feeBps)
+				if err != nil {
+					continue
+				}
+				if !out.LessThan(y) {
+					t.Errorf("fee %d, reserves %s/%s, in %s: out %s drains the pool", feeBps, x, y, in, out)
+				}
+				if after := x.Add(in).Mul(y.Sub(out)); after.LessThan(k) {
+					t.Errorf("fee %d, reserves %s/%s, in %s: k fell from %s to %s", feeBps, x, y, in, k, after)
+				}
+				// The fee may only round up, and by less than one unit
+				exactFee := in.Mul(decimal.NewFromInt32(feeBps)).DivRound(bpsDenominator, ammPrecision+4)
+				if fee.LessThan(exactFee) || fee.Sub(exactFee).GreaterThanOrEqual(decimal.New(1, -ammPrecision)) {
+					t.Errorf("fee %d, in %s: fee = %s, want %s rounded up", feeBps, in, fee, exactFee)
+				}
+			}
+		}
+	}
+}
+
+func TestOrientReserves(t *testing.T) {
+	token0, token1 := uuid.New(), uuid.New()
+	pool := &db.LiquidityPool{Token0ID: token0, Token1ID: token1, Reserve0: dec("10"), Reserve1: dec("20")}
+
+	in, out, zeroForOne := orientReserves(pool, token0)
+	if !in.Equal(dec("10")) || !out.Equal(dec("20")) || !zeroForOne {
+		t.Errorf("orientReserves(token0) = %s, %s, %v; want 10, 20, true", in, out, zeroForOne)
+	}
+	in, out, zeroForOne = orientReserves(pool, token1)
+	if !in.Equal(dec("20")) || !out.Equal(dec("10")) || zeroForOne {
+		t.Errorf("orientReserves(token1) = %s, %s, %v; want 20, 10, false", in, out, zeroForOne)
+	}
+}
+
+func TestSpotPrice(t *testing.T) {
+	tests := []struct {
+		reserveIn, reserveOut, want string
+	}{
+		{"1000", "2000", "2"},
+		{"3", "1", "0.333333333333333333"},
+		{"0", "1000", "0"},
+	}
+	for _, tt := range tests {
+		if got := spotPrice(dec(tt.reserveIn), dec(tt.reserveOut)); !got.Equal(dec(tt.want)) {
+			t.Errorf("spotPrice(%s, %s) = %s, want %s", tt.reserveIn, tt.reserveOut, got, tt.want)
+		}
+	}
+}
+
+func TestOptimalDeposit(t *testing.T) {
+	tests := []struct {
+		name                     string
+		amount0, amount1         string
+		reserve0, reserve1       string
+		wantAmount0, wantAmount1 string
+	}{
+		{"empty pool sets the price", "10", "50", "0", "0", "10", "50"},
+		{"token1 scaled down", "10", "50", "1000", "2000", "10", "20"},
+		{"token0 scaled down", "10", "10", "1000", "2000", "5", "10"},
+		{"already at ratio", "10", "20", "1000", "2000", "10", "20"},
+		{"scaled amount truncated", "1", "1", "3", "1", "1", "0.333333333333333333"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got0, got1 := optimalDeposit(dec(tt.amount0), dec(tt.amount1), dec(tt.reserve0), dec(tt.reserve1))
+			if !got0.Equal(dec(tt.wantAmount0)) || !got1.Equal(dec(tt.wantAmount1)) {
+				t.Errorf("optimalDeposit() = %s, %s; want %s, %s", got0, got1, tt.wantAmount0, tt.wantAmount1)
+			}
+		})
+	}
+}
+
+func TestSharesToMint(t *testing.T) {
+	tests := []struct {
+		name               string
+		amount0, amount1   string
+		reserve0, reserve1 string
+		totalSupply        string
+		want               string
+		wantErr            bool
+	}{
+		{"first deposit mints the geometric mean", "4", "9", "0", "0", "0", "6", false},
+		{"first deposit with irrational root", "2", "1", "0", "0", "0", "1.414213562373095048", false},
+		{"proportional deposit", "10", "20", "1000", "2000", "100", "1", false},
+		{"excess token is not rewarded", "10", "30", "1000", "2000", "100", "1", false},
+		{"dust deposit", "0.000000000000000001", "0.000000000000000001", "1000000", "1000000", "1", "", true},
+		{"empty first deposit", "0", "5", "0", "0", "0", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sharesToMint(dec(tt.amount0), dec(tt.amount1), dec(tt.reserve0), dec(tt.reserve1), dec(tt.totalSupply))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sharesToMint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !got.Equal(dec(tt.want)) {
+				t.Errorf("sharesToMint() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAmountsForShares(t *testing.T) {
+	tests := []struct {
+		name                     string
+		shares                   string
+		reserve0, reserve1       string
+		totalSupply              string
+		wantAmount0, wantAmount1 string
+		wantErr                  bool
+	}{
+		{"half the supply", "50", "1000", "2000", "100", "500", "1000", false},
+		{"whole supply", "100", "1000", "2000", "100", "1000", "2000", false},
+		{"payout truncated", "1", "1", "2", "3", "0.333333333333333333", "0.666666666666666666", false},
+		{"more than the supply", "101", "1000", "2000", "100", "", "", true},
+		{"no supply", "1", "1000", "2000", "0", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got0, got1, err := amountsForShares(dec(tt.shares), dec(tt.reserve0), dec(tt.reserve1), dec(tt.totalSupply))
+			if tt.wantErr {
+				if !errors.Is(err, ErrInsufficientLiquidity) {
+					t.Errorf("amountsForShares() error = %v, want ErrInsufficientLiquidity", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("amountsForShares() error = %v", err)
+			}
+			if !got0.Equal(dec(tt.wantAmount0)) || !got1.Equal(dec(tt.wantAmount1)) {
+				t.Errorf("amountsForShares() = %s, %s; want %s, %s", got0, got1, tt.wantAmount0, tt.wantAmount1)
+			}
+		})
+	}
+}
+
+// TestLiquidityRoundTrip checks that depositing and immediately burning the
+// minted shares never returns more than was deposited.
+func TestLiquidityRoundTrip(t *testing.T) {
+	reserve0, reserve1, supply := dec("1000"), dec("3000"), dec("1732.050807568877293527")
+	for _, a := range []string{"0.000001", "1", "7.777777777777777777", "250"} {
+		amount0, amount1 := optimalDeposit(dec(a), dec(a).Mul(dec("10")), reserve0, reserve1)
+		shares, err := sharesToMint(amount0, amount1, reserve0, reserve1, supply)
+		if err != nil {
+			t.Fatalf("deposit %s: %v", a, err)
+		}
+		out0, out1, err := amountsForShares(shares, reserve0.Add(amount0), reserve1.Add(amount1), supply.Add(shares))
+		if err != nil {
+			t.Fatalf("withdraw %s: %v", a, err)
+		}
+		if out0.GreaterThan(amount0) || out1.GreaterThan(amount1) {
+			t.Errorf("deposit %s/%s returned %s/%s", amount0, amount1, out0, out1)
+		}
+	}
+}
+
+func TestSqrtDecimal(t *testing.T) {
+	tests := []struct {
+		x, want string
+	}{
+		{"0", "0"},
+		{"-4", "0"},
+		{"4", "2"},
+		{"2", "1.414213562373095048"},
+		{"0.000000000000000000000000000000000001", "0.000000000000000001"},
+		{"1000000000000000000000000000000", "1000000000000000"},
+		{"123456789.123456789", "11111.11106611111096943"},
+	}
+	for _, tt := range tests {
+		if got := sqrtDecimal(dec(tt.x)); !got.Equal(dec(tt.want)) {
+			t.Errorf("sqrtDecimal(%s) = %s, want %s", tt.x, got, tt.want)
+		}
+	}
+}
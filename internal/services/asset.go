@@ -3,23 +3,44 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/config"
 	"jd7008911/canlan.org/internal/db"
+	"jd7008911/canlan.org/internal/oracle"
+)
+
+var (
+	// ErrStalePrice is returned when a token's price is too old to trade on.
+	ErrStalePrice = errors.New("token price is stale")
+	// ErrUnsupportedInterval is returned for an unknown candle interval.
+	ErrUnsupportedInterval = errors.New("unsupported candle interval")
 )
 
 // AssetService handles all asset-related business logic.
 type AssetService struct {
 	queries *db.Queries
+	tx      db.TxRunner
+	cfg     *config.Config
+	prices  *oracle.Updater
+
+	statsMu sync.Mutex
+	stats   *NetworkAssetSummary // cached network summary
 }
 
 // NewAssetService creates a new asset service.
-func NewAssetService(queries *db.Queries) *AssetService {
+func NewAssetService(queries *db.Queries, tx db.TxRunner, cfg *config.Config, prices *oracle.Updater) *AssetService {
 	return &AssetService{
 		queries: queries,
+		tx:      tx,
+		cfg:     cfg,
+		prices:  prices,
 	}
 }
 
@@ -28,22 +49,27 @@ func NewAssetService(queries *db.Queries) *AssetService {
 // ---------------------------------------------------------------------
 
 // UserBalanceDetail represents a user's balance for a single token
-// with enriched pricing and valuation.
+// with enriched pricing and valuation. Balance is spendable; Locked is
+// still vesting and not included in ValueUSD.
 type UserBalanceDetail struct {
 	TokenID        uuid.UUID       `json:"token_id"`
 	Symbol         string          `json:"symbol"`
 	Name           string          `json:"name"`
 	Balance        decimal.Decimal `json:"balance"`
+	Locked         decimal.Decimal `json:"locked"`
 	PriceUSD       decimal.Decimal `json:"price_usd"`
 	ValueUSD       decimal.Decimal `json:"value_usd"`
-	PriceChange24h decimal.Decimal `json:"price_change_24h,omitempty"`
+	LockedValueUSD decimal.Decimal `json:"locked_value_usd"`
+	PriceChange24h decimal.Decimal `json:"price_change_24h"` // percent
+	PriceStale     bool            `json:"price_stale"`
 }
 
 // PortfolioSummary represents a user's complete asset portfolio.
 type PortfolioSummary struct {
-	TotalValueUSD decimal.Decimal     `json:"total_value_usd"`
-	Balances      []UserBalanceDetail `json:"balances"`
-	LastUpdated   time.Time           `json:"last_updated"`
+	TotalValueUSD  decimal.Decimal     `json:"total_value_usd"`  // unlocked balances
+	LockedValueUSD decimal.Decimal     `json:"locked_value_usd"` // balances still vesting
+	Balances       []UserBalanceDetail `json:"balances"`
+	LastUpdated    time.Time           `json:"last_updated"`
 }
 
 // GetUserPortfolio retrieves all token balances for a user,
@@ -55,8 +81,20 @@ func (s *AssetService) GetUserPortfolio(ctx context.Context, userID uuid.UUID) (
 		return nil, fmt.Errorf("failed to fetch user balances: %w", err)
 	}
 
+	refPrices, err := s.referencePrices24h(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lockedRows, err := s.queries.GetUserLockedBalances(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch locked balances: %w", err)
+	}
+
 	details := make([]UserBalanceDetail, 0, len(balances))
+	byToken := make(map[uuid.UUID]int, len(balances))
 	total := decimal.Zero
+	lockedTotal := decimal.Zero
 
 	for _, b := range balances {
 		balance := b.Balance
@@ -64,21 +102,43 @@ func (s *AssetService) GetUserPortfolio(ctx context.Context, userID uuid.UUID) (
 		value := balance.Mul(price)
 		total = total.Add(value)
 
+		byToken[b.TokenID] = len(details)
 		details = append(details, UserBalanceDetail{
-			TokenID:  b.TokenID,
-			Symbol:   b.Symbol,
-			Name:     b.Name,
-			Balance:  balance,
-			PriceUSD: price,
-			ValueUSD: value,
-			// PriceChange24h can be added later from token_price_history
+			TokenID:        b.TokenID,
+			Symbol:         b.Symbol,
+			Name:           b.Name,
+			Balance:        balance,
+			PriceUSD:       price,
+			ValueUSD:       value,
+			PriceStale:     s.isStale(b.PriceUpdatedAt),
+			PriceChange24h: priceChangePercent(price, refPrices[b.TokenID]),
 		})
 	}
 
+	// Tokens that are only vesting have no user_balances row yet
+	for _, l := range lockedRows {
+		i, ok := byToken[l.TokenID]
+		if !ok {
+			i = len(details)
+			details = append(details, UserBalanceDetail{
+				TokenID:        l.TokenID,
+				Symbol:         l.Symbol,
+				Name:           l.Name,
+				PriceUSD:       l.PriceUSD,
+				PriceStale:     s.isStale(l.PriceUpdatedAt),
+				PriceChange24h: priceChangePercent(l.PriceUSD, refPrices[l.TokenID]),
+			})
+		}
+		details[i].Locked = l.Locked
+		details[i].LockedValueUSD = l.Locked.Mul(l.PriceUSD)
+		lockedTotal = lockedTotal.Add(details[i].LockedValueUSD)
+	}
+
 	return &PortfolioSummary{
-		TotalValueUSD: total,
-		Balances:      details,
-		LastUpdated:   time.Now(),
+		TotalValueUSD:  total,
+		LockedValueUSD: lockedTotal,
+		Balances:       details,
+		LastUpdated:    time.Now(),
 	}, nil
 }
 
@@ -108,35 +168,188 @@ func (s *AssetService) GetTokenPrice(ctx context.Context, symbol string) (decima
 	return token.PriceUsd, nil
 }
 
-// RefreshTokenPrices updates token prices from an external oracle.
-// This is a placeholder – actual implementation would call a price feed API
-// and bulk update the database.
+// RefreshTokenPrices pulls fresh prices from the oracle feeds and stores
+// them with their history.
 func (s *AssetService) RefreshTokenPrices(ctx context.Context) error {
-	// In production, you would:
-	// 1. Fetch prices from CoinGecko, Binance, or Chainlink oracle
-	// 2. Map symbols to token IDs
-	// 3. Call BulkUpdateTokenPrices with slices of IDs and prices
-
-	// Example placeholder:
-	// ids := []uuid.UUID{...}
-	// prices := []decimal.Decimal{...}
-	// return s.queries.BulkUpdateTokenPrices(ctx, db.BulkUpdateTokenPricesParams{
-	//    IDs: ids,
-	//    Prices: prices,
-	// })
+	if s.prices == nil {
+		return fmt.Errorf("price oracle is not configured")
+	}
+	_, err := s.prices.Refresh(ctx)
+	return err
+}
+
+// RequireFreshPrice returns ErrStalePrice when token's price has never been
+// set or was last updated longer ago than the configured maximum age.
+// Anything that converts value at the stored price must check it first.
+func (s *AssetService) RequireFreshPrice(token db.Token) error {
+	if !token.PriceUsd.IsPositive() {
+		return fmt.Errorf("%w: no price for %s", ErrStalePrice, token.Symbol)
+	}
+	if s.isStale(token.PriceUpdatedAt) {
+		return fmt.Errorf("%w: %s price last updated %s", ErrStalePrice, token.Symbol, formatPriceAge(token.PriceUpdatedAt))
+	}
 	return nil
 }
 
+// isStale reports whether a price updated at updatedAt is older than the
+// configured maximum age. A zero maximum disables the check.
+func (s *AssetService) isStale(updatedAt *time.Time) bool {
+	maxAge := s.cfg.Oracle.MaxPriceAge
+	if maxAge <= 0 {
+		return false
+	}
+	return updatedAt == nil || time.Since(*updatedAt) > maxAge
+}
+
+func formatPriceAge(updatedAt *time.Time) string {
+	if updatedAt == nil {
+		return "never"
+	}
+	return time.Since(*updatedAt).Truncate(time.Second).String() + " ago"
+}
+
+// ---------------------------------------------------------------------
+// Price History
+// ---------------------------------------------------------------------
+
+// TokenDetail is a token with its 24h price change.
+type TokenDetail struct {
+	db.Token
+	PriceChange24h decimal.Decimal `json:"price_change_24h"`
+	PriceStale     bool            `json:"price_stale"`
+}
+
+// ListTokens returns all active tokens with their 24h price change.
+func (s *AssetService) ListTokens(ctx context.Context) ([]TokenDetail, error) {
+	tokens, err := s.queries.ListTokens(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tokens: %w", err)
+	}
+	refPrices, err := s.referencePrices24h(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]TokenDetail, len(tokens))
+	for i, t := range tokens {
+		result[i] = TokenDetail{
+			Token:          t,
+			PriceChange24h: priceChangePercent(t.PriceUsd, refPrices[t.ID]),
+			PriceStale:     s.isStale(t.PriceUpdatedAt),
+		}
+	}
+	return result, nil
+}
+
+// PriceCandle is one OHLC bar built from token_price_history.
+type PriceCandle struct {
+	Time    time.Time       `json:"time"`
+	Open    decimal.Decimal `json:"open"`
+	High    decimal.Decimal `json:"high"`
+	Low     decimal.Decimal `json:"low"`
+	Close   decimal.Decimal `json:"close"`
+	Samples int64           `json:"samples"`
+}
+
+// candleIntervals are the supported candle widths.
+var candleIntervals = map[string]time.Duration{
+	"5m":  5 * time.Minute,
+	"15m": 15 * time.Minute,
+	"1h":  time.Hour,
+	"4h":  4 * time.Hour,
+	"1d":  24 * time.Hour,
+}
+
+// GetTokenCandles returns the last limit candles of the given interval for
+// a token. Buckets without any recorded price are omitted.
+func (s *AssetService) GetTokenCandles(ctx context.Context, symbol, interval string, limit int) ([]PriceCandle, error) {
+	width, ok := candleIntervals[interval]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedInterval, interval)
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	token, err := s.queries.GetTokenBySymbol(ctx, symbol)
+	if err != nil {
+		return nil, fmt.Errorf("token not found: %s", symbol)
+	}
+
+	since := time.Now().UTC().Truncate(width).Add(-width * time.Duration(limit-1))
+	rows, err := s.queries.GetTokenPriceCandles(ctx, db.GetTokenPriceCandlesParams{
+		BucketSeconds: int32(width / time.Second),
+		TokenID:       token.ID,
+		Since:         since,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch price candles: %w", err)
+	}
+
+	candles := make([]PriceCandle, len(rows))
+	for i, row := range rows {
+		candles[i] = PriceCandle{
+			Time:    row.BucketStart,
+			Open:    row.Open,
+			High:    row.High,
+			Low:     row.Low,
+			Close:   row.Close,
+			Samples: row.Samples,
+		}
+	}
+	return candles, nil
+}
+
+// referencePrices24h maps token IDs to the price the 24h change is measured from.
+func (s *AssetService) referencePrices24h(ctx context.Context) (map[uuid.UUID]decimal.Decimal, error) {
+	rows, err := s.queries.GetTokenPricesAt24hAgo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch 24h reference prices: %w", err)
+	}
+	prices := make(map[uuid.UUID]decimal.Decimal, len(rows))
+	for _, row := range rows {
+		prices[row.TokenID] = row.PriceUsd
+	}
+	return prices, nil
+}
+
+// priceChangePercent returns the change from ref to current in percent,
+// or zero when there is no reference price.
+func priceChangePercent(current, ref decimal.Decimal) decimal.Decimal {
+	if !ref.IsPositive() {
+		return decimal.Zero
+	}
+	return current.Sub(ref).Div(ref).Mul(decimal.NewFromInt(100)).Round(2)
+}
+
 // ---------------------------------------------------------------------
 // Network-Wide Asset Statistics
 // ---------------------------------------------------------------------
 
 // NetworkAssetSummary returns global statistics about all assets on the platform.
 type NetworkAssetSummary struct {
-	TotalValueLockedUSD decimal.Decimal `json:"total_value_locked_usd"`
-	TotalTokenHolders   int64           `json:"total_token_holders"`
-	ActiveTokens        int64           `json:"active_tokens"`
-	TopHolders          []TopHolder     `json:"top_holders,omitempty"`
+	TotalValueLockedUSD decimal.Decimal     `json:"total_value_locked_usd"`
+	TotalTokenHolders   int64               `json:"total_token_holders"`
+	ActiveTokens        int64               `json:"active_tokens"`
+	Tokens              []TokenNetworkStats `json:"tokens"`
+	TopHolders          []NetworkHolder     `json:"top_holders,omitempty"`
+	AsOf                time.Time           `json:"as_of"`
+}
+
+// TokenNetworkStats is the platform-wide holding of one token.
+type TokenNetworkStats struct {
+	Symbol       string          `json:"symbol"`
+	PriceUSD     decimal.Decimal `json:"price_usd"`
+	TotalBalance decimal.Decimal `json:"total_balance"`
+	Holders      int64           `json:"holders"`
+	TVLUSD       decimal.Decimal `json:"tvl_usd"`
+}
+
+// NetworkHolder is a user ranked by the USD value of all their tokens.
+type NetworkHolder struct {
+	UserID   uuid.UUID       `json:"user_id"`
+	Wallet   string          `json:"wallet"`
+	ValueUSD decimal.Decimal `json:"value_usd"`
 }
 
 // TopHolder represents a user with large token holdings.
@@ -147,44 +360,69 @@ type TopHolder struct {
 	ValueUSD decimal.Decimal `json:"value_usd"`
 }
 
-// GetNetworkAssetStats calculates global TVL and related metrics.
+// networkTopHolders is how many holders the network summary lists.
+const networkTopHolders = 10
+
+// GetNetworkAssetStats returns global TVL and holder statistics. The
+// summary is computed with set-based queries and cached for
+// NetworkStatsTTL; AsOf tells callers when it was computed.
 func (s *AssetService) GetNetworkAssetStats(ctx context.Context) (*NetworkAssetSummary, error) {
-	// Sum all user balances * current price = TVL
-	// This is an expensive query – in production, consider caching.
-	users, err := s.queries.GetAllUsers(ctx)
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	if s.stats != nil && time.Since(s.stats.AsOf) < s.cfg.App.NetworkStatsTTL {
+		return s.stats, nil
+	}
+	stats, err := s.computeNetworkAssetStats(ctx)
 	if err != nil {
 		return nil, err
 	}
+	s.stats = stats
+	return stats, nil
+}
 
-	var tvl decimal.Decimal
-	var holderCount int64
-	for _, user := range users {
-		balances, err := s.queries.GetUserBalances(ctx, user.ID)
-		if err != nil {
-			continue
-		}
-		hasPositive := false
-		for _, b := range balances {
-			if b.Balance.GreaterThan(decimal.Zero) {
-				hasPositive = true
-				tvl = tvl.Add(b.Balance.Mul(b.PriceUSD))
-			}
-		}
-		if hasPositive {
-			holderCount++
-		}
-	}
+func (s *AssetService) computeNetworkAssetStats(ctx context.Context) (*NetworkAssetSummary, error) {
+	asOf := time.Now().UTC()
 
-	activeTokens, err := s.queries.GetActiveTokensCount(ctx)
+	tokenRows, err := s.queries.GetNetworkTokenStats(ctx)
 	if err != nil {
-		activeTokens = 0
+		return nil, fmt.Errorf("failed to aggregate token holdings: %w", err)
+	}
+	holderCount, err := s.queries.GetNetworkHolderCount(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count holders: %w", err)
+	}
+	holderRows, err := s.queries.GetTopHoldersByValue(ctx, networkTopHolders)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch top holders: %w", err)
 	}
 
-	return &NetworkAssetSummary{
-		TotalValueLockedUSD: tvl,
+	summary := &NetworkAssetSummary{
+		TotalValueLockedUSD: decimal.Zero,
 		TotalTokenHolders:   holderCount,
-		ActiveTokens:        activeTokens,
-	}, nil
+		ActiveTokens:        int64(len(tokenRows)),
+		Tokens:              make([]TokenNetworkStats, len(tokenRows)),
+		TopHolders:          make([]NetworkHolder, len(holderRows)),
+		AsOf:                asOf,
+	}
+	for i, row := range tokenRows {
+		summary.Tokens[i] = TokenNetworkStats{
+			Symbol:       row.Symbol,
+			PriceUSD:     row.PriceUsd,
+			TotalBalance: row.TotalBalance,
+			Holders:      row.Holders,
+			TVLUSD:       row.TvlUsd,
+		}
+		summary.TotalValueLockedUSD = summary.TotalValueLockedUSD.Add(row.TvlUsd)
+	}
+	for i, row := range holderRows {
+		summary.TopHolders[i] = NetworkHolder{
+			UserID:   row.ID,
+			Wallet:   row.WalletAddress,
+			ValueUSD: row.ValueUsd,
+		}
+	}
+	return summary, nil
 }
 
 // GetTopTokenHolders retrieves the top N holders for a specific token.
@@ -215,7 +453,7 @@ func (s *AssetService) GetTopTokenHolders(ctx context.Context, tokenSymbol strin
 }
 
 // ---------------------------------------------------------------------
-// Asset History (Placeholder)
+// Asset History
 // ---------------------------------------------------------------------
 
 // AssetHistoryPoint represents a single point in a user's asset history.
@@ -224,9 +462,129 @@ type AssetHistoryPoint struct {
 	TotalUSD  decimal.Decimal `json:"total_usd"`
 }
 
-// GetUserAssetHistory retrieves historical total asset values for a user.
-// This would require a separate table to store snapshots.
+// GetUserAssetHistory returns the user's total portfolio value for each of
+// the last days days, from the daily snapshots. Days before the user held
+// anything have no point.
 func (s *AssetService) GetUserAssetHistory(ctx context.Context, userID uuid.UUID, days int) ([]AssetHistoryPoint, error) {
-	// Placeholder – implement if daily snapshots are stored.
-	return []AssetHistoryPoint{}, nil
+	if days <= 0 || days > 365 {
+		days = 30
+	}
+	since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -(days - 1))
+
+	rows, err := s.queries.GetUserPortfolioHistory(ctx, db.GetUserPortfolioHistoryParams{
+		UserID: userID,
+		Since:  since,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch portfolio history: %w", err)
+	}
+
+	points := make([]AssetHistoryPoint, len(rows))
+	for i, row := range rows {
+		points[i] = AssetHistoryPoint{
+			Timestamp: row.SnapshotDate,
+			TotalUSD:  row.TotalValueUsd,
+		}
+	}
+	return points, nil
+}
+
+// TakePortfolioSnapshot records every user's balances and their USD value
+// for day. Running it again for the same day replaces that day's rows.
+func (s *AssetService) TakePortfolioSnapshot(ctx context.Context, day time.Time) (int64, error) {
+	var rows int64
+	err := s.tx.WithTx(ctx, func(q *db.Queries) error {
+		var err error
+		rows, err = q.UpsertPortfolioSnapshots(ctx, day)
+		if err != nil {
+			return fmt.Errorf("failed to snapshot portfolios: %w", err)
+		}
+		if err := q.DeleteStalePortfolioSnapshots(ctx, day); err != nil {
+			return fmt.Errorf("failed to prune portfolio snapshots: %w", err)
+		}
+		return nil
+	})
+	return rows, err
+}
+
+// RunPortfolioSnapshots snapshots the current day immediately and then
+// every SnapshotInterval until ctx is cancelled, so each day ends up with
+// the values from its last run.
+func (s *AssetService) RunPortfolioSnapshots(ctx context.Context) {
+	interval := s.cfg.App.SnapshotInterval
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.TakePortfolioSnapshot(ctx, time.Now().UTC()); err != nil && ctx.Err() == nil {
+			log.Printf("portfolio snapshot failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ---------------------------------------------------------------------
+// Profit & Loss
+// ---------------------------------------------------------------------
+
+// TokenPnL is the profit and loss on one token using the average cost of
+// the user's completed purchases. Tokens that were never purchased (rewards,
+// mining) have a zero cost basis.
+type TokenPnL struct {
+	Symbol        string          `json:"symbol"`
+	Balance       decimal.Decimal `json:"balance"`
+	PriceUSD      decimal.Decimal `json:"price_usd"`
+	AvgCostUSD    decimal.Decimal `json:"avg_cost_usd"`
+	ValueUSD      decimal.Decimal `json:"value_usd"`
+	RealisedPnL   decimal.Decimal `json:"realised_pnl"`   // swap proceeds less the cost of the tokens sold
+	UnrealisedPnL decimal.Decimal `json:"unrealised_pnl"` // current value less the cost of the tokens held
+}
+
+// PnLSummary totals TokenPnL across a user's tokens.
+type PnLSummary struct {
+	Tokens        []TokenPnL      `json:"tokens"`
+	RealisedPnL   decimal.Decimal `json:"realised_pnl"`
+	UnrealisedPnL decimal.Decimal `json:"unrealised_pnl"`
+}
+
+// GetUserPnL derives realised and unrealised profit and loss from the
+// purchase cost basis.
+func (s *AssetService) GetUserPnL(ctx context.Context, userID uuid.UUID) (*PnLSummary, error) {
+	rows, err := s.queries.GetUserCostBasis(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch cost basis: %w", err)
+	}
+
+	summary := &PnLSummary{
+		Tokens:        make([]TokenPnL, 0, len(rows)),
+		RealisedPnL:   decimal.Zero,
+		UnrealisedPnL: decimal.Zero,
+	}
+	for _, row := range rows {
+		avgCost := decimal.Zero
+		if row.BoughtAmount.IsPositive() {
+			avgCost = row.BoughtCost.DivRound(row.BoughtAmount, 18)
+		}
+		value := row.Balance.Mul(row.PriceUsd)
+		pnl := TokenPnL{
+			Symbol:        row.Symbol,
+			Balance:       row.Balance,
+			PriceUSD:      row.PriceUsd,
+			AvgCostUSD:    avgCost,
+			ValueUSD:      value,
+			RealisedPnL:   row.SoldProceeds.Sub(row.SoldAmount.Mul(avgCost)).Round(8),
+			UnrealisedPnL: value.Sub(row.Balance.Mul(avgCost)).Round(8),
+		}
+		summary.Tokens = append(summary.Tokens, pnl)
+		summary.RealisedPnL = summary.RealisedPnL.Add(pnl.RealisedPnL)
+		summary.UnrealisedPnL = summary.UnrealisedPnL.Add(pnl.UnrealisedPnL)
+	}
+	return summary, nil
 }
@@ -9,22 +9,27 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/config"
 	"jd7008911/canlan.org/internal/db"
 )
 
 // BadgeService handles all badge-related business logic.
 type BadgeService struct {
 	queries   *db.Queries
+	tx        db.TxRunner
 	assetSvc  *AssetService
 	combatSvc *CombatPowerService
+	ledger    *LedgerService
 }
 
 // NewBadgeService creates a new badge service.
-func NewBadgeService(queries *db.Queries, assetSvc *AssetService, combatSvc *CombatPowerService) *BadgeService {
+func NewBadgeService(queries *db.Queries, tx db.TxRunner, assetSvc *AssetService, combatSvc *CombatPowerService, ledger *LedgerService) *BadgeService {
 	return &BadgeService{
 		queries:   queries,
+		tx:        tx,
 		assetSvc:  assetSvc,
 		combatSvc: combatSvc,
+		ledger:    ledger,
 	}
 }
 
@@ -33,7 +38,8 @@ func NewBadgeService(queries *db.Queries, assetSvc *AssetService, combatSvc *Com
 // ---------------------------------------------------------------------
 
 // PurchaseBadge allows a user to buy a badge.
-// It deducts the price from the user's USDT balance and creates a user_badge record.
+// It deducts the price from the user's USDT balance and creates a user_badge
+// record; both happen in one transaction.
 func (s *BadgeService) PurchaseBadge(ctx context.Context, userID uuid.UUID, badgeID uuid.UUID) (*db.UserBadge, error) {
 	// Get badge details
 	badge, err := s.queries.GetBadgeByID(ctx, badgeID)
@@ -53,42 +59,46 @@ func (s *BadgeService) PurchaseBadge(ctx context.Context, userID uuid.UUID, badg
 		}
 	}
 
-	// Check user's USDT balance
-	usdtBalance, err := s.assetSvc.GetUserTokenBalance(ctx, userID, "USDT")
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch USDT balance: %w", err)
-	}
-
-	if usdtBalance.LessThan(badge.PriceUsd) {
-		return nil, fmt.Errorf("insufficient USDT balance: have %s, need %s",
-			usdtBalance.String(), badge.PriceUsd.String())
-	}
-
-	// Deduct USDT balance
-	err = s.queries.SubtractUserBalance(ctx, db.SubtractUserBalanceParams{
-		UserID:  userID,
-		TokenID: mustGetUSDTTokenID(ctx, s.queries), // Helper to get USDT token ID
-		Balance: badge.PriceUsd,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to deduct USDT: %w", err)
-	}
-
 	// Calculate expiry date (if not permanent)
 	var expiryDate *time.Time
 	// In the future, badges could have a duration field; for now, assume permanent (nil)
 	// If we add a duration column to badges table, we can set it here.
 
-	// Create user badge
-	userBadge, err := s.queries.PurchaseBadge(ctx, db.PurchaseBadgeParams{
-		UserID:     userID,
-		BadgeID:    badgeID,
-		ExpiryDate: expiryDate,
+	// Lock the USDT balance, pay the badge price into the treasury and
+	// create the user badge in one transaction.
+	usdtID := mustGetUSDTTokenID(ctx, s.queries) // Helper to get USDT token ID
+	var userBadge db.UserBadge
+	err = s.tx.WithTx(ctx, func(q *db.Queries) error {
+		balances, err := lockBalances(ctx, q, userID, usdtID)
+		if err != nil {
+			return err
+		}
+		if err := requireBalance(balances, usdtID, "USDT", badge.PriceUsd); err != nil {
+			return err
+		}
+
+		entry := JournalEntry{
+			Type:        "badge_purchase",
+			ReferenceID: &badge.ID,
+			Description: "badge purchase: " + badge.Name,
+		}
+		entry.Add(Transfer(UserAccount(userID, usdtID), SystemAccount(AccountTreasury, usdtID), badge.PriceUsd)...)
+		if _, err := s.ledger.PostTx(ctx, q, entry); err != nil {
+			return fmt.Errorf("failed to deduct USDT: %w", err)
+		}
+
+		userBadge, err = q.PurchaseBadge(ctx, db.PurchaseBadgeParams{
+			UserID:     userID,
+			BadgeID:    badgeID,
+			ExpiryDate: expiryDate,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create user badge: %w", err)
+		}
+		return nil
 	})
 	if err != nil {
-		// Rollback balance deduction? For simplicity, we log the error but don't auto-rollback.
-		// In production, consider using a transaction.
-		return nil, fmt.Errorf("failed to create user badge: %w", err)
+		return nil, err
 	}
 
 	// Apply badge benefits immediately (e.g., combat power multiplier)
@@ -139,6 +149,20 @@ func (s *BadgeService) ListAvailableBadges(ctx context.Context) ([]db.Badge, err
 	return s.queries.ListBadges(ctx)
 }
 
+// GetBadgeDirectList returns a page of active badge holders across the
+// network, newest first, with the wallet that invited each holder.
+func (s *BadgeService) GetBadgeDirectList(ctx context.Context, limit, offset int32) ([]db.GetBadgeDirectListRow, error) {
+	return s.queries.GetBadgeDirectList(ctx, db.GetBadgeDirectListParams{
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// GetTotalBadgesInNetwork counts the active badges held across the network.
+func (s *BadgeService) GetTotalBadgesInNetwork(ctx context.Context) (int64, error) {
+	return s.queries.GetTotalBadgesInNetwork(ctx)
+}
+
 // ---------------------------------------------------------------------
 // Badge Benefits & Multipliers
 // ---------------------------------------------------------------------
@@ -156,16 +180,65 @@ type BadgeBenefits struct {
 
 // ParseBenefits unmarshals the JSON benefits from a badge.
 func (s *BadgeService) ParseBenefits(badge *db.Badge) (*BadgeBenefits, error) {
-	if badge.Benefits == nil {
+	return parseBadgeBenefits(badge.Benefits)
+}
+
+func parseBadgeBenefits(raw []byte) (*BadgeBenefits, error) {
+	if raw == nil {
 		return &BadgeBenefits{}, nil
 	}
 	var benefits BadgeBenefits
-	if err := json.Unmarshal(badge.Benefits, &benefits); err != nil {
+	if err := json.Unmarshal(raw, &benefits); err != nil {
 		return nil, fmt.Errorf("failed to parse badge benefits: %w", err)
 	}
 	return &benefits, nil
 }
 
+// Badge multiplier stacking modes.
+const (
+	StackMultiplicative = "multiplicative"
+	StackAdditive       = "additive"
+)
+
+// BadgeStacking is the policy for combining the combat power multipliers of
+// several active badges. Multiplicative stacking takes the product of the
+// multipliers; additive stacking adds up their bonuses over 1 (1.5 and 1.2
+// give 1.7). A positive Cap bounds the combined multiplier. Badges without
+// a combat power multiplier do not take part.
+type BadgeStacking struct {
+	Mode string
+	Cap  decimal.Decimal // zero = uncapped
+}
+
+// badgeStackingFromConfig returns the configured stacking policy.
+func badgeStackingFromConfig(cfg config.BadgeConfig) BadgeStacking {
+	return BadgeStacking{
+		Mode: cfg.MultiplierStacking,
+		Cap:  decimal.NewFromFloat(cfg.MultiplierCap),
+	}
+}
+
+// Combine returns the combined multiplier before and after the cap.
+func (p BadgeStacking) Combine(multipliers []decimal.Decimal) (uncapped, capped decimal.Decimal) {
+	one := decimal.NewFromInt(1)
+	uncapped = one
+	for _, m := range multipliers {
+		if m.IsZero() {
+			continue
+		}
+		if p.Mode == StackAdditive {
+			uncapped = uncapped.Add(m.Sub(one))
+		} else {
+			uncapped = uncapped.Mul(m)
+		}
+	}
+	capped = uncapped
+	if p.Cap.IsPositive() && capped.GreaterThan(p.Cap) {
+		capped = p.Cap
+	}
+	return uncapped, capped
+}
+
 // ApplyBadgeBenefits applies the benefits of a newly purchased badge to the user.
 func (s *BadgeService) ApplyBadgeBenefits(ctx context.Context, userID uuid.UUID, badge *db.Badge) error {
 	benefits, err := s.ParseBenefits(badge)
@@ -188,40 +261,42 @@ func (s *BadgeService) ApplyBadgeBenefits(ctx context.Context, userID uuid.UUID,
 	return nil
 }
 
-// GetUserActiveBadgeMultipliers aggregates all multipliers from the user's active badges.
+// GetUserActiveBadgeMultipliers aggregates all multipliers from the user's
+// active badges. The combat power multiplier follows the configured
+// BadgeStacking policy, the same one CombatPowerService applies.
 func (s *BadgeService) GetUserActiveBadgeMultipliers(ctx context.Context, userID uuid.UUID) (*BadgeBenefits, error) {
 	activeBadges, err := s.GetUserActiveBadges(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
 
+	stacking := BadgeStacking{Mode: StackMultiplicative}
+	if s.combatSvc != nil {
+		stacking = s.combatSvc.stacking
+	}
+	return aggregateBadgeBenefits(activeBadges, stacking), nil
+}
+
+// aggregateBadgeBenefits sums boosts, multiplies governance multipliers and
+// combines combat power multipliers under stacking. Badges with malformed
+// benefits are skipped.
+func aggregateBadgeBenefits(activeBadges []db.GetUserActiveBadgesRow, stacking BadgeStacking) *BadgeBenefits {
 	total := &BadgeBenefits{
-		CombatPowerMultiplier: decimal.NewFromInt(1),
-		GovernanceMultiplier:  decimal.NewFromInt(1),
-		MiningBoost:           decimal.Zero,
-		LPYieldBoost:          decimal.Zero,
-		DailyRewardBonus:      decimal.Zero,
-		ReferralRewardShare:   decimal.Zero,
+		GovernanceMultiplier: decimal.NewFromInt(1),
+		MiningBoost:          decimal.Zero,
+		LPYieldBoost:         decimal.Zero,
+		DailyRewardBonus:     decimal.Zero,
+		ReferralRewardShare:  decimal.Zero,
 	}
 
+	var combat []decimal.Decimal
 	for _, ub := range activeBadges {
-		// ub.Benefits is a json.RawMessage; we need to convert to *db.Badge first.
-		badge := &db.Badge{
-			ID:       ub.BadgeID,
-			Name:     ub.BadgeName,
-			Symbol:   ub.BadgeSymbol,
-			Benefits: ub.Benefits,
-		}
-		benefits, err := s.ParseBenefits(badge)
+		benefits, err := parseBadgeBenefits(ub.Benefits)
 		if err != nil {
 			continue // skip malformed benefits
 		}
 
-		// Sum multipliers (additive for boosts, multiplicative for multipliers)
-		if !benefits.CombatPowerMultiplier.IsZero() {
-			// Multipliers are multiplicative; we multiply them.
-			total.CombatPowerMultiplier = total.CombatPowerMultiplier.Mul(benefits.CombatPowerMultiplier)
-		}
+		combat = append(combat, benefits.CombatPowerMultiplier)
 		if !benefits.GovernanceMultiplier.IsZero() {
 			total.GovernanceMultiplier = total.GovernanceMultiplier.Mul(benefits.GovernanceMultiplier)
 		}
@@ -233,8 +308,9 @@ func (s *BadgeService) GetUserActiveBadgeMultipliers(ctx context.Context, userID
 		// Merge permissions
 		total.CustomPermissions = append(total.CustomPermissions, benefits.CustomPermissions...)
 	}
+	_, total.CombatPowerMultiplier = stacking.Combine(combat)
 
-	return total, nil
+	return total
 }
 
 // ---------------------------------------------------------------------
@@ -0,0 +1,137 @@
+// internal/services/block_producer.go
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"jd7008911/canlan.org/internal/config"
+	"jd7008911/canlan.org/internal/db"
+)
+
+// Catch-up policies for slots missed while no producer was running.
+const (
+	CatchUpBackfill = "backfill" // mint every missed slot, oldest first
+	CatchUpSkip     = "skip"     // mint only the latest due slot
+)
+
+// BlockProducer mints a block every MintBlockInterval. Every API replica
+// runs one, but only the replica holding the producer advisory lock mints;
+// the others keep trying to take the lock over in case the leader dies.
+//
+// Block slots are deterministic: the first block takes the current
+// interval-aligned slot, and each following slot is the previous block's
+// scheduled_at plus the interval.
+type BlockProducer struct {
+	queries *db.Queries
+	locker  db.AdvisoryLocker
+	blocks  *BlockRewardService
+	cfg     *config.Config
+}
+
+// NewBlockProducer creates a block producer.
+func NewBlockProducer(queries *db.Queries, locker db.AdvisoryLocker, blocks *BlockRewardService, cfg *config.Config) *BlockProducer {
+	return &BlockProducer{
+		queries: queries,
+		locker:  locker,
+		blocks:  blocks,
+		cfg:     cfg,
+	}
+}
+
+// Run checks for due slots every MintProducerPoll until ctx is cancelled,
+// then releases the lock. A block being minted when ctx is cancelled is
+// finished first.
+func (p *BlockProducer) Run(ctx context.Context) {
+	if p.cfg.App.MintBlockInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(p.cfg.App.MintProducerPoll)
+	defer ticker.Stop()
+
+	var lock *db.AdvisoryLock
+	defer func() {
+		if lock != nil {
+			releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			lock.Release(releaseCtx)
+		}
+	}()
+
+	for {
+		if lock != nil && !lock.Alive(ctx) && ctx.Err() == nil {
+			log.Printf("block producer: lost leadership")
+			lock.Release(ctx)
+			lock = nil
+		}
+		if lock == nil && ctx.Err() == nil {
+			var err error
+			if lock, err = p.locker.TryAdvisoryLock(ctx, db.LockKeyBlockProducer); err != nil {
+				log.Printf("block producer: failed to take lock: %v", err)
+			} else if lock != nil {
+				log.Printf("block producer: acquired leadership")
+			}
+		}
+		if lock != nil {
+			if _, err := p.ProduceDue(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("block producer: %v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProduceDue finishes interrupted distributions, then mints the blocks
+// whose slots have passed, following the catch-up policy, and returns how
+// many it minted. The caller must hold the producer lock.
+func (p *BlockProducer) ProduceDue(ctx context.Context) (int, error) {
+	if err := p.blocks.ResumeDistributions(ctx); err != nil {
+		return 0, err
+	}
+
+	interval := p.cfg.App.MintBlockInterval
+	now := time.Now()
+
+	var next time.Time
+	last, err := p.queries.GetLastMintBlock(ctx)
+	if err != nil {
+		// No blocks yet – start at the current slot
+		next = now.Truncate(interval)
+	} else {
+		next = last.ScheduledAt.Add(interval)
+	}
+	if next.After(now) {
+		return 0, nil
+	}
+
+	due := int(now.Sub(next)/interval) + 1
+	if due > 1 && p.cfg.App.MintCatchUpPolicy == CatchUpSkip {
+		log.Printf("block producer: skipping %d missed slots", due-1)
+		next = next.Add(time.Duration(due-1) * interval)
+		due = 1
+	}
+	// Bound one pass so a long outage is backfilled over several ticks
+	if limit := p.cfg.App.MintCatchUpMaxBlocks; limit > 0 && due > limit {
+		due = limit
+	}
+
+	// A block that has started is finished even if shutdown begins
+	mintCtx := context.WithoutCancel(ctx)
+	minted := 0
+	for ; minted < due; minted++ {
+		if ctx.Err() != nil {
+			break
+		}
+		if _, err := p.blocks.CreateNewBlock(mintCtx, next); err != nil {
+			return minted, err
+		}
+		next = next.Add(interval)
+	}
+	return minted, nil
+}
@@ -4,6 +4,7 @@ package services
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,15 +15,29 @@ import (
 
 // BlockRewardService manages mint blocks, weight snapshots, and reward distribution.
 type BlockRewardService struct {
-	queries *db.Queries
-	cfg     *config.Config
+	queries     *db.Queries
+	tx          db.TxRunner
+	cfg         *config.Config
+	emission    *EmissionSchedule // configured schedule, until governance replaces it
+	ledger      *LedgerService
+	vesting     *VestingService
+	trees       rewardTreeCache
+	commissions *CommissionService
+
+	canIDMu sync.Mutex
+	canID   uuid.UUID // CAN token ID, loaded on first use
 }
 
 // NewBlockRewardService creates a new block reward service.
-func NewBlockRewardService(queries *db.Queries, cfg *config.Config) *BlockRewardService {
+func NewBlockRewardService(queries *db.Queries, tx db.TxRunner, cfg *config.Config, emission *EmissionSchedule, ledger *LedgerService, vesting *VestingService, commissions *CommissionService) *BlockRewardService {
 	return &BlockRewardService{
-		queries: queries,
-		cfg:     cfg,
+		queries:     queries,
+		tx:          tx,
+		cfg:         cfg,
+		emission:    emission,
+		ledger:      ledger,
+		vesting:     vesting,
+		commissions: commissions,
 	}
 }
 
@@ -31,53 +46,56 @@ func NewBlockRewardService(queries *db.Queries, cfg *config.Config) *BlockReward
 // ---------------------------------------------------------------------
 
 // GetCurrentBlockAndCountdown returns the active (undistributed) mint block
-// and the time remaining until the next block is generated.
-// If no active block exists, it returns nil and the time until the next scheduled block.
+// and the time remaining until the next block's slot. If no active block
+// exists, it returns nil and the time until the next scheduled block.
 func (s *BlockRewardService) GetCurrentBlockAndCountdown(ctx context.Context) (*db.MintBlock, time.Duration, error) {
+	lastBlock, err := s.queries.GetLastMintBlock(ctx)
+	if err != nil {
+		// No blocks at all – the producer mints the first one on start
+		return nil, s.cfg.App.MintBlockInterval, nil
+	}
+	remaining := time.Until(lastBlock.ScheduledAt.Add(s.cfg.App.MintBlockInterval))
+	if remaining < 0 {
+		remaining = 0
+	}
+
 	block, err := s.queries.GetCurrentMintBlock(ctx)
 	if err != nil {
-		// No active block – compute time until next block from last block or genesis
-		lastBlock, err := s.queries.GetLastMintBlock(ctx)
-		if err != nil {
-			// No blocks at all – assume genesis at service start
-			return nil, s.cfg.App.MintBlockInterval, nil
-		}
-		nextTime := lastBlock.CreatedAt.Add(s.cfg.App.MintBlockInterval)
-		remaining := time.Until(nextTime)
 		return nil, remaining, nil
 	}
-	// Block exists – time until next block is the interval from its creation
-	nextTime := block.CreatedAt.Add(s.cfg.App.MintBlockInterval)
-	remaining := time.Until(nextTime)
-	return block, remaining, nil
+	return &block, remaining, nil
 }
 
-// CreateNewBlock generates a new mint block with a fixed reward.
-// The block number should be incremented from the last block.
-func (s *BlockRewardService) CreateNewBlock(ctx context.Context) (*db.MintBlock, error) {
+// CreateNewBlock generates the next mint block for the slot scheduledAt,
+// with the reward given by the emission schedule in force for its block
+// number. The BlockProducer is the only caller in normal operation.
+func (s *BlockRewardService) CreateNewBlock(ctx context.Context, scheduledAt time.Time) (*db.MintBlock, error) {
 	// Determine next block number
 	var nextBlockNumber int64 = 1
 	lastBlock, err := s.queries.GetLastMintBlock(ctx)
-	if err == nil && lastBlock != nil {
+	if err == nil {
 		nextBlockNumber = lastBlock.BlockNumber + 1
 	}
 
-	// Determine total reward for this block (could be a fixed emission schedule)
-	// For simplicity, we use a constant reward per block; this can be made configurable.
-	blockReward := decimal.NewFromInt(1000) // 1000 CAN per block – adjust as needed
+	blockReward, err := s.blockReward(ctx, s.queries, nextBlockNumber)
+	if err != nil {
+		return nil, err
+	}
 
-	block, err := s.queries.CreateMintBlock(ctx, db.CreateMintBlockParams{
+	created, err := s.queries.CreateMintBlock(ctx, db.CreateMintBlockParams{
 		BlockNumber: nextBlockNumber,
 		TotalReward: blockReward,
+		ScheduledAt: scheduledAt,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create mint block: %w", err)
 	}
+	block := &created
 
-	// After creating block, calculate weights for all users
-	if err := s.CalculateWeights(ctx, block.ID); err != nil {
-		// Log error but don't fail block creation
-		// logger.Error("failed to calculate weights", "block_id", block.ID, "error", err)
+	// Snapshot weights and distribute the reward. A failure leaves the block
+	// at its last checkpoint; the producer resumes it on its next pass.
+	if err := s.ProcessBlock(ctx, block.ID); err != nil {
+		// logger.Error("failed to distribute block", "block_id", block.ID, "error", err)
 	}
 
 	return block, nil
@@ -87,162 +105,217 @@ func (s *BlockRewardService) CreateNewBlock(ctx context.Context) (*db.MintBlock,
 // Weight Calculation & Reward Distribution
 // ---------------------------------------------------------------------
 
-// CalculateWeights takes a snapshot of all users' transaction weight, LP weight,
-// and burn weight at the time of the block and stores them in weight_snapshots.
-func (s *BlockRewardService) CalculateWeights(ctx context.Context, blockID uuid.UUID) error {
-	// Get all active users (those with any positive combat power)
-	users, err := s.queries.GetAllUsers(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to fetch users: %w", err)
-	}
-
-	for _, user := range users {
-		// Get user's balances for CAN (transaction weight)
-		canBalance, _ := s.queries.GetUserBalanceBySymbol(ctx, db.GetUserBalanceBySymbolParams{
-			UserID: user.ID,
-			Symbol: "CAN",
-		})
-		transactionWeight := canBalance.Balance // decimal.Zero if no balance
+// Distribution checkpoints stored in mint_blocks.distribution_status.
+const (
+	distributionPending     = "pending"
+	distributionSnapshotted = "snapshotted"
+	distributionDistributed = "distributed"
+)
 
-		// Get LP weight from combat_power table
-		cp, err := s.queries.GetCombatPower(ctx, user.ID)
-		lpWeight := decimal.Zero
-		if err == nil {
-			lpWeight = cp.LpWeight
-		}
+// Reward pool weight types, stored in block_rewards.weight_type.
+const (
+	WeightTypeTransaction = "transaction" // CAN holdings
+	WeightTypeLP          = "lp"
+	WeightTypeBurn        = "burn"
+)
 
-		// Get burn weight from combat_power table
-		burnWeight := decimal.Zero
-		if err == nil {
-			burnWeight = cp.BurnPower
-		}
+// rewardPoolShares returns the configured pool split as parallel slices.
+func (s *BlockRewardService) rewardPoolShares() ([]string, []int32) {
+	r := s.cfg.Rewards
+	return []string{WeightTypeTransaction, WeightTypeLP, WeightTypeBurn},
+		[]int32{int32(r.TransactionPoolBps), int32(r.LPPoolBps), int32(r.BurnPoolBps)}
+}
 
-		// Store snapshot
-		err = s.queries.CreateWeightSnapshot(ctx, db.CreateWeightSnapshotParams{
-			UserID:            user.ID,
-			BlockID:           blockID,
-			TransactionWeight: transactionWeight,
-			LpWeight:          lpWeight,
-			BurnWeight:        burnWeight,
-		})
-		if err != nil {
-			// Log but continue processing other users
-			// logger.Error("failed to create weight snapshot", "user_id", user.ID, "error", err)
-			continue
-		}
+// ProcessBlock runs the distribution steps a block has not completed yet.
+// Each step commits with its checkpoint, so calling it again after a crash
+// continues where the previous run stopped.
+func (s *BlockRewardService) ProcessBlock(ctx context.Context, blockID uuid.UUID) error {
+	if err := s.CalculateWeights(ctx, blockID); err != nil {
+		return err
 	}
-
-	// After weights are snapshotted, distribute rewards
 	return s.DistributeRewards(ctx, blockID)
 }
 
-// DistributeRewards allocates the block's total reward to users proportionally
-// based on their total weight (transaction + LP + burn).
-func (s *BlockRewardService) DistributeRewards(ctx context.Context, blockID uuid.UUID) error {
-	// Get block details
-	block, err := s.queries.GetBlockByID(ctx, blockID)
-	if err != nil {
-		return fmt.Errorf("block not found: %w", err)
-	}
-
-	// Get all weight snapshots for this block
-	snapshots, err := s.queries.GetWeightSnapshotsByBlock(ctx, blockID)
+// ResumeDistributions finishes every block whose distribution was
+// interrupted, oldest first.
+func (s *BlockRewardService) ResumeDistributions(ctx context.Context) error {
+	blocks, err := s.queries.GetUndistributedMintBlocks(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to fetch weight snapshots: %w", err)
+		return fmt.Errorf("failed to list undistributed blocks: %w", err)
 	}
-
-	// Calculate total weight across all users
-	totalWeight := decimal.Zero
-	for _, snap := range snapshots {
-		userWeight := snap.TransactionWeight.Add(snap.LpWeight).Add(snap.BurnWeight)
-		totalWeight = totalWeight.Add(userWeight)
+	for _, block := range blocks {
+		if err := s.ProcessBlock(ctx, block.ID); err != nil {
+			return fmt.Errorf("block %d: %w", block.BlockNumber, err)
+		}
 	}
+	return nil
+}
 
-	if totalWeight.IsZero() {
-		// No weight – no rewards to distribute, but mark block as distributed anyway
-		return s.queries.UpdateBlockDistributed(ctx, db.UpdateBlockDistributedParams{
-			ID:            blockID,
-			Distributed:   true,
-			DistributedAt: time.Now(),
-		})
-	}
+// CalculateWeights snapshots every user's transaction weight, LP weight and
+// burn weight for the block in one statement. It does nothing once the
+// block is past the snapshot step.
+func (s *BlockRewardService) CalculateWeights(ctx context.Context, blockID uuid.UUID) error {
+	return s.tx.WithTx(ctx, func(q *db.Queries) error {
+		block, err := q.LockMintBlock(ctx, blockID)
+		if err != nil {
+			return fmt.Errorf("block not found: %w", err)
+		}
+		if block.DistributionStatus != distributionPending {
+			return nil
+		}
 
-	// Distribute rewards to each user
-	for _, snap := range snapshots {
-		userWeight := snap.TransactionWeight.Add(snap.LpWeight).Add(snap.BurnWeight)
-		if userWeight.IsZero() {
-			continue
+		if _, err := q.SnapshotBlockWeights(ctx, blockID); err != nil {
+			return fmt.Errorf("failed to snapshot weights: %w", err)
+		}
+		if err := q.SetBlockDistributionStatus(ctx, db.SetBlockDistributionStatusParams{
+			ID:                 blockID,
+			DistributionStatus: distributionSnapshotted,
+		}); err != nil {
+			return fmt.Errorf("failed to checkpoint block: %w", err)
 		}
+		return nil
+	})
+}
 
-		// User's share = (userWeight / totalWeight) * totalReward
-		share := block.TotalReward.Mul(userWeight).Div(totalWeight)
+// DistributeRewards splits the block's total reward into the configured
+// weight-type pools and allocates each pool to users in proportion to their
+// snapshotted weight of that type. Shares are truncated, and the remainder
+// (including any pool nobody has weight in) goes to the treasury, so the
+// rewards plus dust always equal total_reward.
+func (s *BlockRewardService) DistributeRewards(ctx context.Context, blockID uuid.UUID) error {
+	return s.tx.WithTx(ctx, func(q *db.Queries) error {
+		block, err := q.LockMintBlock(ctx, blockID)
+		if err != nil {
+			return fmt.Errorf("block not found: %w", err)
+		}
+		if block.DistributionStatus != distributionSnapshotted {
+			return nil
+		}
 
-		// Create block_reward record
-		_, err := s.queries.CreateBlockReward(ctx, db.CreateBlockRewardParams{
-			UserID:      snap.UserID,
+		// Pools are written once; a resumed run reuses the recorded split
+		weightTypes, shareBps := s.rewardPoolShares()
+		if err := q.CreateBlockRewardPools(ctx, db.CreateBlockRewardPoolsParams{
 			BlockID:     blockID,
-			Amount:      share,
-			WeightType:  "total", // could also store individual weights in separate records
-			WeightValue: userWeight,
-			Claimed:     false,
-		})
+			WeightTypes: weightTypes,
+			ShareBps:    shareBps,
+		}); err != nil {
+			return fmt.Errorf("failed to create reward pools: %w", err)
+		}
+		if _, err := q.DistributeBlockRewards(ctx, blockID); err != nil {
+			return fmt.Errorf("failed to create block rewards: %w", err)
+		}
+		distributed, err := q.GetBlockRewardTotal(ctx, blockID)
 		if err != nil {
-			// Log error but continue distributing to others
-			// logger.Error("failed to create block reward", "user_id", snap.UserID, "error", err)
-			continue
+			return fmt.Errorf("failed to sum block rewards: %w", err)
+		}
+
+		dust := block.TotalReward.Sub(distributed)
+		if dust.IsNegative() {
+			return fmt.Errorf("block %d over-distributed by %s", block.BlockNumber, dust.Neg())
+		}
+		if dust.IsPositive() {
+			canID, err := s.canTokenID(ctx, q)
+			if err != nil {
+				return err
+			}
+			entry := JournalEntry{
+				Type:        "reward_dust",
+				ReferenceID: &block.ID,
+				Description: fmt.Sprintf("block %d rounding dust", block.BlockNumber),
+			}
+			entry.Add(Transfer(SystemAccount(AccountEmission, canID), SystemAccount(AccountTreasury, canID), dust)...)
+			if _, err := s.ledger.PostTx(ctx, q, entry); err != nil {
+				return err
+			}
 		}
-	}
 
-	// Mark block as distributed
-	return s.queries.UpdateBlockDistributed(ctx, db.UpdateBlockDistributedParams{
-		ID:            blockID,
-		Distributed:   true,
-		DistributedAt: time.Now(),
+		if err := q.CompleteBlockDistribution(ctx, db.CompleteBlockDistributionParams{
+			ID:         blockID,
+			DustAmount: dust,
+		}); err != nil {
+			return fmt.Errorf("failed to checkpoint block: %w", err)
+		}
+
+		if s.publishesMerkleRoot(block.BlockNumber) {
+			return s.publishMerkleRoot(ctx, q, block)
+		}
+		return nil
 	})
 }
 
+// GetBlockRewardPools returns how a block's reward was split across weight
+// types. It is empty until the block has been distributed.
+func (s *BlockRewardService) GetBlockRewardPools(ctx context.Context, blockID uuid.UUID) ([]db.BlockRewardPool, error) {
+	return s.queries.GetBlockRewardPools(ctx, blockID)
+}
+
 // ---------------------------------------------------------------------
 // Reward Claiming
 // ---------------------------------------------------------------------
 
 // ClaimRewards allows a user to claim specific unclaimed rewards.
-// Returns the total amount claimed (in CAN).
+// Returns the total amount claimed (in CAN). Referral commissions on each
+// reward are paid with its claim. While Merkle roots are published,
+// rewards are paid on chain and cannot be claimed here; config.Load then
+// requires block rewards to be paid out immediately and without
+// commissions, as neither applies on chain.
 func (s *BlockRewardService) ClaimRewards(ctx context.Context, userID uuid.UUID, rewardIDs []uuid.UUID) (decimal.Decimal, error) {
-	totalClaimed := decimal.Zero
+	if s.cfg.Rewards.MerkleEnabled {
+		return decimal.Zero, ErrRewardsClaimedOnChain
+	}
 
+	canID, err := s.canTokenID(ctx, s.queries)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	totalClaimed := decimal.Zero
 	for _, rewardID := range rewardIDs {
-		// Verify reward belongs to user and is unclaimed
-		reward, err := s.queries.GetBlockReward(ctx, rewardID)
-		if err != nil {
-			continue // skip if not found
-		}
-		if reward.UserID != userID || reward.Claimed {
-			continue
-		}
+		// Marking the reward claimed and minting it commit together; a reward
+		// that is not the user's or was already claimed is skipped.
+		err := s.tx.WithTx(ctx, func(q *db.Queries) error {
+			reward, err := q.ClaimUnclaimedReward(ctx, db.ClaimUnclaimedRewardParams{
+				ID:     rewardID,
+				UserID: userID,
+			})
+			if err != nil {
+				return err
+			}
 
-		// Update reward as claimed
-		err = s.queries.ClaimReward(ctx, db.ClaimRewardParams{
-			ID:     rewardID,
-			UserID: userID,
-		})
-		if err != nil {
-			continue
-		}
+			// Mint the reward from the emission account; the locked share
+			// vests before it reaches the user's CAN balance
+			if _, err := s.vesting.PayTx(ctx, q, Payout{
+				UserID:      userID,
+				TokenID:     canID,
+				From:        SystemAccount(AccountEmission, canID),
+				Amount:      reward.Amount,
+				Source:      VestingSourceBlockReward,
+				EntryType:   "reward_claim",
+				ReferenceID: &reward.ID,
+				Description: "block reward claim",
+			}); err != nil {
+				return err
+			}
+
+			// The claimant's upline earns commissions on the reward
+			if _, err := s.commissions.PayTx(ctx, q, CommissionEvent{
+				UserID:      userID,
+				Source:      CommissionSourceBlockReward,
+				TokenID:     canID,
+				From:        SystemAccount(AccountEmission, canID),
+				Amount:      reward.Amount,
+				ReferenceID: &reward.ID,
+			}); err != nil {
+				return err
+			}
 
-		// Add to user's CAN balance (or mint new tokens)
-		// This should be done in a transaction with the claim update.
-		err = s.queries.AddUserBalance(ctx, db.AddUserBalanceParams{
-			UserID:  userID,
-			TokenID: mustGetCANTokenID(ctx, s.queries), // Helper to get CAN token ID
-			Balance: reward.Amount,
+			totalClaimed = totalClaimed.Add(reward.Amount)
+			return nil
 		})
 		if err != nil {
-			// Rollback claim? For simplicity, we log and continue.
-			// In production, use a transaction.
+			// logger.Error("failed to claim reward", "reward_id", rewardID, "error", err)
 			continue
 		}
-
-		totalClaimed = totalClaimed.Add(reward.Amount)
 	}
 
 	return totalClaimed, nil
@@ -263,6 +336,26 @@ func (s *BlockRewardService) ClaimAllRewards(ctx context.Context, userID uuid.UU
 	return s.ClaimRewards(ctx, userID, ids)
 }
 
+// GetUserUnclaimedRewards returns the user's rewards that have not been claimed yet.
+func (s *BlockRewardService) GetUserUnclaimedRewards(ctx context.Context, userID uuid.UUID) ([]db.BlockReward, error) {
+	return s.queries.GetUserUnclaimedRewards(ctx, userID)
+}
+
+// GetUserBlockRewards returns a page of the user's rewards, claimed or not,
+// newest first.
+func (s *BlockRewardService) GetUserBlockRewards(ctx context.Context, userID uuid.UUID, limit, offset int32) ([]db.BlockReward, error) {
+	return s.queries.GetUserBlockRewardHistory(ctx, db.GetUserBlockRewardHistoryParams{
+		UserID:    userID,
+		RowLimit:  limit,
+		RowOffset: offset,
+	})
+}
+
+// GetUserRewardsCount returns the number of rewards the user has earned.
+func (s *BlockRewardService) GetUserRewardsCount(ctx context.Context, userID uuid.UUID) (int64, error) {
+	return s.queries.CountUserBlockRewards(ctx, userID)
+}
+
 // ---------------------------------------------------------------------
 // User Reward Summary
 // ---------------------------------------------------------------------
@@ -274,6 +367,9 @@ type UserRewardsSummary struct {
 	PendingRewards decimal.Decimal `json:"pending_rewards"`
 	UnclaimedCount int64           `json:"unclaimed_count"`
 	LastClaimTime  *time.Time      `json:"last_claim_time,omitempty"`
+
+	// Lifetime earnings per weight type ("transaction", "lp", "burn")
+	EarnedByType map[string]decimal.Decimal `json:"earned_by_type"`
 }
 
 // GetUserRewardsSummary aggregates a user's reward information.
@@ -289,9 +385,13 @@ func (s *BlockRewardService) GetUserRewardsSummary(ctx context.Context, userID u
 	pendingRewards := decimal.Zero
 	unclaimedCount := int64(0)
 	var lastClaimTime *time.Time
+	earnedByType := make(map[string]decimal.Decimal)
 
 	for _, reward := range allRewards {
 		totalEarned = totalEarned.Add(reward.Amount)
+		if reward.WeightType != nil {
+			earnedByType[*reward.WeightType] = earnedByType[*reward.WeightType].Add(reward.Amount)
+		}
 		if reward.Claimed {
 			totalClaimed = totalClaimed.Add(reward.Amount)
 			if lastClaimTime == nil || reward.ClaimedAt.After(*lastClaimTime) {
@@ -309,6 +409,7 @@ func (s *BlockRewardService) GetUserRewardsSummary(ctx context.Context, userID u
 		PendingRewards: pendingRewards,
 		UnclaimedCount: unclaimedCount,
 		LastClaimTime:  lastClaimTime,
+		EarnedByType:   earnedByType,
 	}, nil
 }
 
@@ -316,16 +417,19 @@ func (s *BlockRewardService) GetUserRewardsSummary(ctx context.Context, userID u
 // Helpers
 // ---------------------------------------------------------------------
 
-var canTokenID uuid.UUID
+// canTokenID returns the CAN token's ID, looking it up once. A failed
+// lookup is not cached, so the next call retries it.
+func (s *BlockRewardService) canTokenID(ctx context.Context, q *db.Queries) (uuid.UUID, error) {
+	s.canIDMu.Lock()
+	defer s.canIDMu.Unlock()
 
-func mustGetCANTokenID(ctx context.Context, queries *db.Queries) uuid.UUID {
-	if canTokenID != uuid.Nil {
-		return canTokenID
+	if s.canID != uuid.Nil {
+		return s.canID, nil
 	}
-	token, err := queries.GetTokenBySymbol(ctx, "CAN")
+	token, err := q.GetTokenBySymbol(ctx, "CAN")
 	if err != nil {
-		panic("CAN token not found in database")
+		return uuid.Nil, fmt.Errorf("CAN token not found: %w", err)
 	}
-	canTokenID = token.ID
-	return canTokenID
+	s.canID = token.ID
+	return s.canID, nil
 }
@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"fmt"
+	"log"
 
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
@@ -11,62 +12,60 @@ import (
 
 type BurnService struct {
 	queries       *db.Queries
+	tx            db.TxRunner
 	combatService *CombatPowerService
+	ledger        *LedgerService
 }
 
-func NewBurnService(queries *db.Queries, combat *CombatPowerService) *BurnService {
-	return &BurnService{queries: queries, combatService: combat}
+func NewBurnService(queries *db.Queries, tx db.TxRunner, combat *CombatPowerService, ledger *LedgerService) *BurnService {
+	return &BurnService{queries: queries, tx: tx, combatService: combat, ledger: ledger}
 }
 
 // BurnTokens processes a burn transaction
 func (s *BurnService) BurnTokens(ctx context.Context, userID uuid.UUID, tokenID uuid.UUID, amount decimal.Decimal, txHash string) error {
-	// Check user balance
-	balance, err := s.queries.GetUserBalance(ctx, db.GetUserBalanceParams{
-		UserID:  userID,
-		TokenID: tokenID,
-	})
+	// Combat power gained is weighted by the active combat power formula
+	token, err := s.queries.GetTokenByID(ctx, tokenID)
 	if err != nil {
-		return fmt.Errorf("insufficient balance")
-	}
-	if balance.Balance.LessThan(amount) {
-		return fmt.Errorf("insufficient balance")
+		return fmt.Errorf("token not found: %w", err)
 	}
-
-	// Deduct balance
-	err = s.queries.UpdateUserBalance(ctx, db.UpdateUserBalanceParams{
-		UserID:  userID,
-		TokenID: tokenID,
-		Balance: balance.Balance.Sub(amount),
-	})
+	combatGained, err := s.combatService.BurnPowerFor(ctx, token.Symbol, amount)
 	if err != nil {
 		return err
 	}
 
-	// Calculate combat power gained (e.g., 1:1 ratio for LAN)
-	// In production, this may be dynamic based on token
-	combatGained := amount
+	// Move the tokens into the burn account and record the burn in one
+	// transaction; the ledger refuses a debit the locked balance cannot
+	// cover, so concurrent burns cannot overspend
+	err = s.tx.WithTx(ctx, func(q *db.Queries) error {
+		entry := JournalEntry{
+			Type:        "burn",
+			Description: fmt.Sprintf("burn (tx %s)", txHash),
+		}
+		entry.Add(Transfer(UserAccount(userID, tokenID), SystemAccount(AccountBurn, tokenID), amount)...)
+		if _, err := s.ledger.PostTx(ctx, q, entry); err != nil {
+			return err
+		}
 
-	// Record burn
-	_, err = s.queries.CreateBurn(ctx, db.CreateBurnParams{
-		UserID:            userID,
-		TokenID:           tokenID,
-		Amount:            amount,
-		CombatPowerGained: combatGained,
-		TxHash:            &txHash,
+		if _, err := q.CreateBurn(ctx, db.CreateBurnParams{
+			UserID:            userID,
+			TokenID:           tokenID,
+			Amount:            amount,
+			CombatPowerGained: combatGained,
+			TxHash:            &txHash,
+		}); err != nil {
+			return fmt.Errorf("failed to record burn: %w", err)
+		}
+		return nil
 	})
 	if err != nil {
 		return err
 	}
 
-	// Update combat power
-	err = s.queries.AddBurnPower(ctx, db.AddBurnPowerParams{
-		UserID:    userID,
-		BurnPower: combatGained,
-	})
-	if err != nil {
-		return err
+	// Recalculate full combat power; this also carries the gain up to the
+	// user's ancestors' team power. The burn has committed, so a failure
+	// is left for the next recompute rather than failing the request.
+	if err := s.combatService.UpdateCombatPower(ctx, userID); err != nil {
+		log.Printf("burn by %s: failed to update combat power: %v", userID, err)
 	}
-
-	// Recalculate full combat power
-	return s.combatService.UpdateCombatPower(ctx, userID)
+	return nil
 }
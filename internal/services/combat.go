@@ -2,78 +2,256 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/config"
 	"jd7008911/canlan.org/internal/db"
 )
 
 type CombatPowerService struct {
-	queries *db.Queries
+	queries  *db.Queries
+	tx       db.TxRunner
+	cfg      *config.Config
+	stacking BadgeStacking
+
+	formulaMu       sync.Mutex
+	formula         *VersionedFormula
+	formulaLoadedAt time.Time
 }
 
-func NewCombatPowerService(queries *db.Queries) *CombatPowerService {
-	return &CombatPowerService{queries: queries}
+func NewCombatPowerService(queries *db.Queries, tx db.TxRunner, cfg *config.Config) *CombatPowerService {
+	return &CombatPowerService{
+		queries:  queries,
+		tx:       tx,
+		cfg:      cfg,
+		stacking: badgeStackingFromConfig(cfg.Badges),
+	}
 }
 
-// CalculatePersonalPower computes a user's combat power based on:
-// - Token holdings (LAN/CAN)
-// - LP positions
-// - Burns
-// - Badge multipliers
-func (s *CombatPowerService) CalculatePersonalPower(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
-	// Get user's token balances
-	balances, err := s.queries.GetUserBalances(ctx, userID)
+// HoldingPower is one token balance and the power it contributes.
+type HoldingPower struct {
+	Symbol  string          `json:"symbol"`
+	Balance decimal.Decimal `json:"balance"`
+	Weight  decimal.Decimal `json:"weight"`
+	Power   decimal.Decimal `json:"power"`
+}
+
+// LPPoolPower is one pool's LP amount and the power it contributes.
+type LPPoolPower struct {
+	PoolID   uuid.UUID       `json:"pool_id"`
+	LPAmount decimal.Decimal `json:"lp_amount"`
+	Weight   decimal.Decimal `json:"weight"`
+	Power    decimal.Decimal `json:"power"`
+}
+
+// BurnSourcePower is the burns of one token and the power they contribute
+// after decay.
+type BurnSourcePower struct {
+	Symbol string          `json:"symbol"`
+	Burned decimal.Decimal `json:"burned"`
+	Weight decimal.Decimal `json:"weight"`
+	Power  decimal.Decimal `json:"power"`
+}
+
+// BadgeMultiplier is one active badge's combat power multiplier.
+type BadgeMultiplier struct {
+	BadgeID    uuid.UUID       `json:"badge_id"`
+	Name       string          `json:"name"`
+	Multiplier decimal.Decimal `json:"multiplier"` // zero when the badge has none
+}
+
+// CombatPowerBreakdown explains how a user's personal power is made up:
+//
+//	personal = (holdings + lp_weight + burn_power) * multiplier
+//
+// where the three inputs are weighted by the combat power formula, and
+// multiplier combines the badge multipliers under the stacking policy and
+// is then capped.
+type CombatPowerBreakdown struct {
+	UserID             uuid.UUID         `json:"user_id"`
+	FormulaVersion     int32             `json:"formula_version"`
+	Holdings           []HoldingPower    `json:"holdings"`
+	HoldingsPower      decimal.Decimal   `json:"holdings_power"`
+	LPPools            []LPPoolPower     `json:"lp_pools"`
+	LPWeight           decimal.Decimal   `json:"lp_weight"`
+	Burns              []BurnSourcePower `json:"burns"`
+	BurnPower          decimal.Decimal   `json:"burn_power"`
+	BasePower          decimal.Decimal   `json:"base_power"`
+	Badges             []BadgeMultiplier `json:"badges"`
+	Stacking           string            `json:"stacking"`
+	MultiplierCap      decimal.Decimal   `json:"multiplier_cap"` // zero = uncapped
+	UncappedMultiplier decimal.Decimal   `json:"uncapped_multiplier"`
+	Multiplier         decimal.Decimal   `json:"multiplier"`
+	PersonalPower      decimal.Decimal   `json:"personal_power"`
+}
+
+// ExplainPersonalPower computes a user's personal combat power under the
+// active formula and returns every input that went into it.
+func (s *CombatPowerService) ExplainPersonalPower(ctx context.Context, userID uuid.UUID) (*CombatPowerBreakdown, error) {
+	f, err := s.ActiveFormula(ctx)
 	if err != nil {
-		return decimal.Zero, err
+		return nil, err
 	}
+	return s.explainWith(ctx, userID, f)
+}
 
-	// Get LP weight
-	lp, err := s.queries.GetUserLPWeight(ctx, userID)
+// explainWith computes a user's personal combat power under f.
+func (s *CombatPowerService) explainWith(ctx context.Context, userID uuid.UUID, f *VersionedFormula) (*CombatPowerBreakdown, error) {
+	balances, err := s.queries.GetUserBalances(ctx, userID)
 	if err != nil {
-		lp = decimal.Zero
+		return nil, err
 	}
-
-	// Get burn power
-	burn, err := s.queries.GetUserBurnPower(ctx, userID)
+	lpPools, err := s.queries.GetUserLPAmountsByPool(ctx, userID)
 	if err != nil {
-		burn = decimal.Zero
+		return nil, fmt.Errorf("failed to fetch LP positions: %w", err)
+	}
+	burns, err := s.queries.GetUserBurnsForPower(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch burns: %w", err)
 	}
 
-	// Get badge multipliers
-	badges, err := s.queries.GetUserActiveBadges(ctx, userID)
-	multiplier := decimal.NewFromInt(1)
-	for _, b := range badges {
-		// Parse benefits JSON to extract combat_power_multiplier
-		// Simplified: assume multiplier is additive
-		if b.Benefits != nil {
-			// parse multiplier logic
+	b := &CombatPowerBreakdown{
+		UserID:         userID,
+		FormulaVersion: f.Version,
+		Holdings:       []HoldingPower{},
+		HoldingsPower:  decimal.Zero,
+		LPPools:        []LPPoolPower{},
+		LPWeight:       decimal.Zero,
+		Burns:          []BurnSourcePower{},
+		BurnPower:      decimal.Zero,
+		Badges:         []BadgeMultiplier{},
+		Stacking:       s.stacking.Mode,
+		MultiplierCap:  s.stacking.Cap,
+	}
+
+	// Holdings of weighted tokens
+	for _, bal := range balances {
+		weight := f.tokenWeight(bal.Symbol)
+		if weight.IsZero() {
+			continue
 		}
+		power := bal.Balance.Mul(weight)
+		b.Holdings = append(b.Holdings, HoldingPower{Symbol: bal.Symbol, Balance: bal.Balance, Weight: weight, Power: power})
+		b.HoldingsPower = b.HoldingsPower.Add(power)
+	}
+
+	// LP positions, weighted per pool
+	for _, lp := range lpPools {
+		weight := f.lpWeight(lp.PoolID)
+		power := lp.LpAmount.Mul(weight)
+		b.LPPools = append(b.LPPools, LPPoolPower{PoolID: lp.PoolID, LPAmount: lp.LpAmount, Weight: weight, Power: power})
+		b.LPWeight = b.LPWeight.Add(power)
 	}
 
-	// Base power = holdings + LP weight + burn power
-	basePower := decimal.Zero
-	for _, b := range balances {
-		// Only LAN and CAN contribute to combat power
-		token, _ := s.queries.GetTokenByID(ctx, b.TokenID)
-		if token.Symbol == "LAN" || token.Symbol == "CAN" {
-			basePower = basePower.Add(b.Balance)
+	// Burns, weighted per burned token and decayed by age
+	now := time.Now()
+	bySymbol := make(map[string]int)
+	for _, burn := range burns {
+		i, ok := bySymbol[burn.Symbol]
+		if !ok {
+			i = len(b.Burns)
+			bySymbol[burn.Symbol] = i
+			b.Burns = append(b.Burns, BurnSourcePower{
+				Symbol: burn.Symbol,
+				Burned: decimal.Zero,
+				Weight: f.burnWeight(burn.Symbol),
+				Power:  decimal.Zero,
+			})
+		}
+		var age time.Duration
+		if burn.CreatedAt != nil {
+			age = now.Sub(*burn.CreatedAt)
+		}
+		power := burn.Amount.Mul(b.Burns[i].Weight).Mul(f.burnDecay(age))
+		b.Burns[i].Burned = b.Burns[i].Burned.Add(burn.Amount)
+		b.Burns[i].Power = b.Burns[i].Power.Add(power)
+		b.BurnPower = b.BurnPower.Add(power)
+	}
+
+	b.BasePower = b.HoldingsPower.Add(b.LPWeight).Add(b.BurnPower)
+
+	// Combine the multipliers of the active badges
+	badges, err := s.queries.GetUserActiveBadges(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch active badges: %w", err)
+	}
+	multipliers := make([]decimal.Decimal, 0, len(badges))
+	for _, ub := range badges {
+		benefits, err := parseBadgeBenefits(ub.Benefits)
+		if err != nil {
+			continue // skip malformed benefits
 		}
+		b.Badges = append(b.Badges, BadgeMultiplier{
+			BadgeID:    ub.BadgeID,
+			Name:       ub.BadgeName,
+			Multiplier: benefits.CombatPowerMultiplier,
+		})
+		multipliers = append(multipliers, benefits.CombatPowerMultiplier)
 	}
-	basePower = basePower.Add(lp).Add(burn)
+	b.UncappedMultiplier, b.Multiplier = s.stacking.Combine(multipliers)
 
-	// Apply multiplier
-	personalPower := basePower.Mul(multiplier)
+	b.PersonalPower = b.BasePower.Mul(b.Multiplier)
+	return b, nil
+}
+
+// GetCombatPower returns the user's stored combat power, or nil if it has
+// never been calculated.
+func (s *CombatPowerService) GetCombatPower(ctx context.Context, userID uuid.UUID) (*db.CombatPower, error) {
+	cp, err := s.queries.GetCombatPower(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get combat power: %w", err)
+	}
+	return &cp, nil
+}
+
+// GetNetworkCombatPower returns the sum of every user's personal power.
+func (s *CombatPowerService) GetNetworkCombatPower(ctx context.Context) (decimal.Decimal, error) {
+	return s.queries.GetNetworkCombatPower(ctx)
+}
 
-	return personalPower, nil
+// CalculatePersonalPower computes a user's combat power based on:
+// - Token holdings
+// - LP positions
+// - Burns
+// - Badge multipliers
+// weighted by the active combat power formula.
+func (s *CombatPowerService) CalculatePersonalPower(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+	b, err := s.ExplainPersonalPower(ctx, userID)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return b.PersonalPower, nil
 }
 
 // UpdateCombatPower refreshes a user's combat power and stores it
 func (s *CombatPowerService) UpdateCombatPower(ctx context.Context, userID uuid.UUID) error {
-	personal, err := s.CalculatePersonalPower(ctx, userID)
+	f, err := s.ActiveFormula(ctx)
 	if err != nil {
 		return err
 	}
+	_, err = s.updateWith(ctx, userID, f)
+	return err
+}
+
+// updateWith recomputes and stores a user's combat power under f. The
+// change in personal power is added to the team power of every ancestor in
+// the same transaction, so team power stays in step without walking the
+// tree; RecalculateAllTeamStats repairs any drift.
+func (s *CombatPowerService) updateWith(ctx context.Context, userID uuid.UUID, f *VersionedFormula) (*CombatPowerBreakdown, error) {
+	b, err := s.explainWith(ctx, userID, f)
+	if err != nil {
+		return nil, err
+	}
 
 	// Get network power (team power from referrals)
 	team, err := s.queries.GetTeamCombatPower(ctx, userID)
@@ -81,15 +259,138 @@ func (s *CombatPowerService) UpdateCombatPower(ctx context.Context, userID uuid.
 		team = decimal.Zero
 	}
 
-	// Get LP weight and burn power from DB
-	lp, _ := s.queries.GetUserLPWeight(ctx, userID)
-	burn, _ := s.queries.GetUserBurnPower(ctx, userID)
+	err = s.tx.WithTx(ctx, func(q *db.Queries) error {
+		previous, err := q.GetPersonalPowerForUpdate(ctx, userID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			previous = decimal.Zero // first calculation for this user
+		} else if err != nil {
+			return fmt.Errorf("failed to lock combat power: %w", err)
+		}
+
+		if err := q.UpsertCombatPower(ctx, db.UpsertCombatPowerParams{
+			UserID:          userID,
+			PersonalPower:   b.PersonalPower,
+			NetworkPower:    team,
+			LpWeight:        b.LPWeight,
+			BurnPower:       b.BurnPower,
+			BadgeMultiplier: b.Multiplier,
+			FormulaVersion:  f.Version,
+		}); err != nil {
+			return err
+		}
 
-	return s.queries.UpsertCombatPower(ctx, db.UpsertCombatPowerParams{
-		UserID:        userID,
-		PersonalPower: personal,
-		NetworkPower:  team,
-		LpWeight:      lp,
-		BurnPower:     burn,
+		if delta := b.PersonalPower.Sub(previous); !delta.IsZero() {
+			if _, err := q.PropagateTeamDelta(ctx, db.PropagateTeamDeltaParams{
+				UserID:      userID,
+				PowerDelta:  delta,
+				MemberDelta: 0,
+			}); err != nil {
+				return fmt.Errorf("failed to propagate team power: %w", err)
+			}
+		}
+		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ---------------------------------------------------------------------
+// History
+// ---------------------------------------------------------------------
+
+// ErrUnsupportedBucket is returned for a history bucket other than day,
+// week or month.
+var ErrUnsupportedBucket = errors.New("unsupported history bucket")
+
+// historyBuckets maps a bucket name to how many days one bucket spans.
+var historyBuckets = map[string]int{
+	"day":   1,
+	"week":  7,
+	"month": 30,
+}
+
+// CombatPowerPoint is a user's combat power at the end of one bucket and
+// its change since the previous bucket.
+type CombatPowerPoint struct {
+	Date            time.Time       `json:"date"`
+	PersonalPower   decimal.Decimal `json:"personal_power"`
+	NetworkPower    decimal.Decimal `json:"network_power"`
+	LPWeight        decimal.Decimal `json:"lp_weight"`
+	BurnPower       decimal.Decimal `json:"burn_power"`
+	BadgeMultiplier decimal.Decimal `json:"badge_multiplier"`
+	NetworkRank     *int32          `json:"network_rank,omitempty"`
+	PersonalDelta   decimal.Decimal `json:"personal_delta"`
+	NetworkDelta    decimal.Decimal `json:"network_delta"`
+	RankChange      int32           `json:"rank_change"` // positive = moved up
+}
+
+// GetCombatPowerHistory returns the user's combat power per bucket ("day",
+// "week" or "month") over the last points buckets, from the daily snapshots.
+func (s *CombatPowerService) GetCombatPowerHistory(ctx context.Context, userID uuid.UUID, bucket string, points int) ([]CombatPowerPoint, error) {
+	span, ok := historyBuckets[bucket]
+	if !ok {
+		return nil, ErrUnsupportedBucket
+	}
+	since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -(points-1)*span)
+
+	rows, err := s.queries.GetCombatPowerHistory(ctx, db.GetCombatPowerHistoryParams{
+		UserID: userID,
+		Bucket: bucket,
+		Since:  since,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch combat power history: %w", err)
+	}
+
+	history := make([]CombatPowerPoint, len(rows))
+	for i, row := range rows {
+		history[i] = CombatPowerPoint{
+			Date:            row.BucketStart,
+			PersonalPower:   row.PersonalPower,
+			NetworkPower:    row.NetworkPower,
+			LPWeight:        row.LpWeight,
+			BurnPower:       row.BurnPower,
+			BadgeMultiplier: row.BadgeMultiplier,
+			NetworkRank:     row.NetworkRank,
+			PersonalDelta:   row.PersonalDelta,
+			NetworkDelta:    row.NetworkDelta,
+			RankChange:      row.RankChange,
+		}
+	}
+	return history, nil
+}
+
+// TakeCombatPowerSnapshot records every user's combat power components and
+// network rank for day. Running it again for the same day replaces that
+// day's rows.
+func (s *CombatPowerService) TakeCombatPowerSnapshot(ctx context.Context, day time.Time) (int64, error) {
+	rows, err := s.queries.UpsertCombatPowerSnapshots(ctx, day)
+	if err != nil {
+		return 0, fmt.Errorf("failed to snapshot combat power: %w", err)
+	}
+	return rows, nil
+}
+
+// RunCombatPowerSnapshots snapshots the current day immediately and then
+// every CombatSnapshotInterval until ctx is cancelled.
+func (s *CombatPowerService) RunCombatPowerSnapshots(ctx context.Context) {
+	interval := s.cfg.App.CombatSnapshotInterval
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.TakeCombatPowerSnapshot(ctx, time.Now().UTC()); err != nil && ctx.Err() == nil {
+			log.Printf("combat power snapshot failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
@@ -0,0 +1,348 @@
+// internal/services/combat_formula.go
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/db"
+)
+
+// ErrInvalidCombatFormula is returned for a formula definition that cannot
+// be evaluated.
+var ErrInvalidCombatFormula = errors.New("invalid combat power formula")
+
+// formulaCacheTTL bounds how long a replica keeps using a formula after
+// another replica activated a newer version.
+const formulaCacheTTL = time.Minute
+
+// CombatFormula defines how holdings, LP positions and burns turn into
+// base combat power:
+//
+//	holdings = sum(balance * token_weights[symbol])
+//	lp       = sum(lp_amount * lp_pool_weights[pool] or lp_default_weight)
+//	burn     = sum(amount * burn_weights[symbol] or burn_default_weight * decay)
+//	decay    = 0.5 ^ (age_days / burn_half_life_days), 1 when the half-life is 0
+//
+// Tokens missing from token_weights do not count.
+type CombatFormula struct {
+	TokenWeights      map[string]decimal.Decimal    `json:"token_weights"`
+	LPPoolWeights     map[uuid.UUID]decimal.Decimal `json:"lp_pool_weights,omitempty"`
+	LPDefaultWeight   decimal.Decimal               `json:"lp_default_weight"`
+	BurnWeights       map[string]decimal.Decimal    `json:"burn_weights,omitempty"`
+	BurnDefaultWeight decimal.Decimal               `json:"burn_default_weight"`
+	BurnHalfLifeDays  float64                       `json:"burn_half_life_days,omitempty"`
+}
+
+// VersionedFormula is a formula stored in combat_power_formulas. Version 0
+// is an unsaved formula, e.g. one being tried out in a dry run.
+type VersionedFormula struct {
+	Version int32 `json:"version"`
+	CombatFormula
+}
+
+// DefaultCombatFormula reproduces the original rules: LAN and CAN holdings,
+// LP amounts and burned amounts all count 1:1. It is used when no formula
+// has been stored.
+func DefaultCombatFormula() CombatFormula {
+	one := decimal.NewFromInt(1)
+	return CombatFormula{
+		TokenWeights:      map[string]decimal.Decimal{"LAN": one, "CAN": one},
+		LPDefaultWeight:   one,
+		BurnDefaultWeight: one,
+	}
+}
+
+// Validate checks that every weight is non-negative and the half-life is
+// not negative.
+func (f *CombatFormula) Validate() error {
+	check := func(name string, w decimal.Decimal) error {
+		if w.IsNegative() {
+			return fmt.Errorf("%w: %s must not be negative", ErrInvalidCombatFormula, name)
+		}
+		return nil
+	}
+	for symbol, w := range f.TokenWeights {
+		if err := check("token weight "+symbol, w); err != nil {
+			return err
+		}
+	}
+	for pool, w := range f.LPPoolWeights {
+		if err := check("LP weight for pool "+pool.String(), w); err != nil {
+			return err
+		}
+	}
+	for symbol, w := range f.BurnWeights {
+		if err := check("burn weight "+symbol, w); err != nil {
+			return err
+		}
+	}
+	if err := check("lp_default_weight", f.LPDefaultWeight); err != nil {
+		return err
+	}
+	if err := check("burn_default_weight", f.BurnDefaultWeight); err != nil {
+		return err
+	}
+	if f.BurnHalfLifeDays < 0 || math.IsNaN(f.BurnHalfLifeDays) || math.IsInf(f.BurnHalfLifeDays, 0) {
+		return fmt.Errorf("%w: burn_half_life_days must be 0 or positive", ErrInvalidCombatFormula)
+	}
+	return nil
+}
+
+// DecodeCombatFormula parses and validates a formula definition.
+func DecodeCombatFormula(raw []byte) (*CombatFormula, error) {
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("%w: empty definition", ErrInvalidCombatFormula)
+	}
+	var f CombatFormula
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCombatFormula, err)
+	}
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// tokenWeight is the power per token held.
+func (f *CombatFormula) tokenWeight(symbol string) decimal.Decimal {
+	return f.TokenWeights[symbol]
+}
+
+// lpWeight is the power per LP unit in a pool.
+func (f *CombatFormula) lpWeight(poolID uuid.UUID) decimal.Decimal {
+	if w, ok := f.LPPoolWeights[poolID]; ok {
+		return w
+	}
+	return f.LPDefaultWeight
+}
+
+// burnWeight is the power per token burned, before decay.
+func (f *CombatFormula) burnWeight(symbol string) decimal.Decimal {
+	if w, ok := f.BurnWeights[symbol]; ok {
+		return w
+	}
+	return f.BurnDefaultWeight
+}
+
+// burnDecay is the share of a burn's power left after age.
+func (f *CombatFormula) burnDecay(age time.Duration) decimal.Decimal {
+	if f.BurnHalfLifeDays <= 0 || age <= 0 {
+		return decimal.NewFromInt(1)
+	}
+	days := age.Hours() / 24
+	return decimal.NewFromFloat(math.Pow(0.5, days/f.BurnHalfLifeDays))
+}
+
+// ---------------------------------------------------------------------
+// Versions
+// ---------------------------------------------------------------------
+
+// versionedFormula converts a stored row.
+func versionedFormula(row db.CombatPowerFormula) (*VersionedFormula, error) {
+	f, err := DecodeCombatFormula(row.Definition)
+	if err != nil {
+		return nil, fmt.Errorf("formula version %d: %w", row.Version, err)
+	}
+	return &VersionedFormula{Version: row.Version, CombatFormula: *f}, nil
+}
+
+// ActiveFormula returns the formula in force, cached for formulaCacheTTL.
+// Without any stored version it returns the default formula as version 0.
+// Lookup errors are returned and nothing is cached.
+func (s *CombatPowerService) ActiveFormula(ctx context.Context) (*VersionedFormula, error) {
+	s.formulaMu.Lock()
+	defer s.formulaMu.Unlock()
+
+	if s.formula != nil && time.Since(s.formulaLoadedAt) < formulaCacheTTL {
+		return s.formula, nil
+	}
+
+	var formula *VersionedFormula
+	row, err := s.queries.GetActiveCombatFormula(ctx)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		// No stored formula yet
+		formula = &VersionedFormula{CombatFormula: DefaultCombatFormula()}
+	case err != nil:
+		return nil, fmt.Errorf("failed to load active combat formula: %w", err)
+	default:
+		if formula, err = versionedFormula(row); err != nil {
+			return nil, err
+		}
+	}
+	s.formula = formula
+	s.formulaLoadedAt = time.Now()
+	return s.formula, nil
+}
+
+// GetFormula returns a stored formula version.
+func (s *CombatPowerService) GetFormula(ctx context.Context, version int32) (*VersionedFormula, error) {
+	row, err := s.queries.GetCombatFormula(ctx, version)
+	if err != nil {
+		return nil, fmt.Errorf("formula version %d not found: %w", version, err)
+	}
+	return versionedFormula(row)
+}
+
+// SetCombatFormula stores f as the next formula version using the caller's
+// transaction. It takes effect for every calculation from then on; stored
+// combat power only changes when users are recomputed.
+func (s *CombatPowerService) SetCombatFormula(ctx context.Context, q *db.Queries, f *CombatFormula, proposalID *uuid.UUID) (*VersionedFormula, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	definition, err := json.Marshal(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode formula: %w", err)
+	}
+	row, err := q.CreateCombatFormula(ctx, db.CreateCombatFormulaParams{
+		Definition: definition,
+		ProposalID: proposalID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store formula: %w", err)
+	}
+
+	s.formulaMu.Lock()
+	s.formula = nil // reload on next use
+	s.formulaMu.Unlock()
+
+	return &VersionedFormula{Version: row.Version, CombatFormula: *f}, nil
+}
+
+// BurnPowerFor returns the combat power a new burn of amount symbol earns
+// under the active formula, before any decay.
+func (s *CombatPowerService) BurnPowerFor(ctx context.Context, symbol string, amount decimal.Decimal) (decimal.Decimal, error) {
+	f, err := s.ActiveFormula(ctx)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return amount.Mul(f.burnWeight(symbol)), nil
+}
+
+// ---------------------------------------------------------------------
+// Batch Recomputation
+// ---------------------------------------------------------------------
+
+// recomputeBatch is how many users are recomputed per page.
+const recomputeBatch = 500
+
+// PowerChange is one user's personal power before and after a recompute.
+type PowerChange struct {
+	UserID uuid.UUID       `json:"user_id"`
+	Before decimal.Decimal `json:"before"`
+	After  decimal.Decimal `json:"after"`
+	Delta  decimal.Decimal `json:"delta"`
+}
+
+// RecomputeReport summarises a batch recomputation.
+type RecomputeReport struct {
+	FormulaVersion int32           `json:"formula_version"`
+	DryRun         bool            `json:"dry_run"`
+	Users          int             `json:"users"`
+	Changed        int             `json:"changed"`
+	Failed         int             `json:"failed"`
+	TotalBefore    decimal.Decimal `json:"total_before"`
+	TotalAfter     decimal.Decimal `json:"total_after"`
+	TopChanges     []PowerChange   `json:"top_changes"` // largest absolute deltas first
+}
+
+// RecomputeAll evaluates every user's personal power under f. In dry-run
+// mode nothing is written and the report shows what would change;
+// otherwise each user's combat power is stored with f's version. Only the
+// active formula may be applied for real. top limits how many of the
+// largest changes the report lists.
+func (s *CombatPowerService) RecomputeAll(ctx context.Context, f *VersionedFormula, dryRun bool, top int) (*RecomputeReport, error) {
+	if !dryRun {
+		active, err := s.ActiveFormula(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if f.Version == 0 || f.Version != active.Version {
+			return nil, fmt.Errorf("%w: only the active formula (version %d) can be applied", ErrInvalidCombatFormula, active.Version)
+		}
+	}
+
+	report := &RecomputeReport{
+		FormulaVersion: f.Version,
+		DryRun:         dryRun,
+		TotalBefore:    decimal.Zero,
+		TotalAfter:     decimal.Zero,
+		TopChanges:     []PowerChange{},
+	}
+
+	after := uuid.Nil
+	for {
+		page, err := s.queries.ListCombatPowerPage(ctx, db.ListCombatPowerPageParams{
+			AfterID:  after,
+			RowLimit: recomputeBatch,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list combat power: %w", err)
+		}
+
+		for _, row := range page {
+			report.Users++
+			report.TotalBefore = report.TotalBefore.Add(row.PersonalPower)
+
+			var power decimal.Decimal
+			if dryRun {
+				b, err := s.explainWith(ctx, row.UserID, f)
+				if err != nil {
+					return nil, fmt.Errorf("user %s: %w", row.UserID, err)
+				}
+				power = b.PersonalPower
+			} else {
+				b, err := s.updateWith(ctx, row.UserID, f)
+				if err != nil {
+					if ctx.Err() != nil {
+						return nil, ctx.Err()
+					}
+					log.Printf("combat recompute: user %s: %v", row.UserID, err)
+					report.Failed++
+					report.TotalAfter = report.TotalAfter.Add(row.PersonalPower)
+					continue
+				}
+				power = b.PersonalPower
+			}
+			report.TotalAfter = report.TotalAfter.Add(power)
+
+			if !power.Equal(row.PersonalPower) {
+				report.Changed++
+				report.TopChanges = append(report.TopChanges, PowerChange{
+					UserID: row.UserID,
+					Before: row.PersonalPower,
+					After:  power,
+					Delta:  power.Sub(row.PersonalPower),
+				})
+			}
+		}
+
+		if len(page) < recomputeBatch {
+			report.TopChanges = topPowerChanges(report.TopChanges, top)
+			return report, nil
+		}
+		after = page[len(page)-1].UserID
+	}
+}
+
+// topPowerChanges keeps the top largest absolute deltas, largest first.
+func topPowerChanges(changes []PowerChange, top int) []PowerChange {
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Delta.Abs().GreaterThan(changes[j].Delta.Abs())
+	})
+	if top >= 0 && len(changes) > top {
+		changes = changes[:top]
+	}
+	return changes
+}
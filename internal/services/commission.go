@@ -0,0 +1,242 @@
+// internal/services/commission.go
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/config"
+	"jd7008911/canlan.org/internal/db"
+)
+
+// Earnings that pay referral commissions, stored in
+// referral_earnings.earning_type.
+const (
+	CommissionSourcePurchase    = "purchase"
+	CommissionSourceMining      = "mining"
+	CommissionSourceBlockReward = "block_reward"
+)
+
+// How badges' referral reward share affects commissions.
+const (
+	ReferralBadgeOff   = "off"   // badges are ignored
+	ReferralBadgeGate  = "gate"  // only referrers with a referral reward share earn
+	ReferralBadgeBonus = "bonus" // the share is added to every tier's rate
+)
+
+// CommissionService pays multi-level referral commissions: when a user
+// earns or spends, each referrer up their invited_by chain earns the rate
+// configured for their depth, and every commission is recorded in
+// referral_earnings. Commissions involving an account flagged by the
+// referral risk checks are recorded but held until it is reviewed.
+type CommissionService struct {
+	queries  *db.Queries
+	tx       db.TxRunner
+	cfg      *config.Config
+	badgeSvc badgeBenefitsReader
+	vesting  *VestingService
+	risk     *ReferralRiskService
+	nodeSvc  levelRightsReader
+}
+
+// levelRightsReader and badgeBenefitsReader are what rateFor reads about a
+// referrer.
+type levelRightsReader interface {
+	GetLevelRights(ctx context.Context, level int32) (*NodeRights, error)
+}
+
+type badgeBenefitsReader interface {
+	GetUserActiveBadgeMultipliers(ctx context.Context, userID uuid.UUID) (*BadgeBenefits, error)
+}
+
+// NewCommissionService creates a new commission service.
+func NewCommissionService(queries *db.Queries, tx db.TxRunner, cfg *config.Config, badgeSvc *BadgeService, vesting *VestingService, risk *ReferralRiskService, nodeSvc *NodeService) *CommissionService {
+	return &CommissionService{
+		queries:  queries,
+		tx:       tx,
+		cfg:      cfg,
+		badgeSvc: badgeSvc,
+		vesting:  vesting,
+		risk:     risk,
+		nodeSvc:  nodeSvc,
+	}
+}
+
+// CommissionEvent is an earning or purchase commissions are paid on.
+type CommissionEvent struct {
+	UserID      uuid.UUID       // user whose upline earns
+	Source      string          // CommissionSource*
+	TokenID     uuid.UUID       // token commissions are paid in
+	From        LedgerAccount   // system account commissions are paid out of
+	Amount      decimal.Decimal // amount the rates apply to
+	ReferenceID *uuid.UUID      // purchase, mining machine or block reward
+}
+
+// Commission is one commission earned by a referrer.
+type Commission struct {
+	UserID  uuid.UUID       `json:"user_id"`
+	Depth   int32           `json:"depth"`
+	RateBps int32           `json:"rate_bps"`
+	Amount  decimal.Decimal `json:"amount"`
+	Status  string          `json:"status"` // ReferralEarning*
+}
+
+// tiers returns the commission rates by depth for an earning source.
+func (s *CommissionService) tiers(source string) []int {
+	switch source {
+	case CommissionSourcePurchase:
+		return s.cfg.Referral.PurchaseTiersBps
+	case CommissionSourceMining:
+		return s.cfg.Referral.MiningTiersBps
+	case CommissionSourceBlockReward:
+		return s.cfg.Referral.BlockRewardTiersBps
+	}
+	return nil
+}
+
+// rateFor returns the rate a referrer earns at their depth, or zero when
+// their node level or badges do not qualify them.
+func (s *CommissionService) rateFor(ctx context.Context, ref db.GetReferralUplineRow, tierBps int) (decimal.Decimal, error) {
+	if levels := s.cfg.Referral.MinNodeLevels; int(ref.Depth) <= len(levels) && int(ref.NodeLevel) < levels[ref.Depth-1] {
+		return decimal.Zero, nil
+	}
+	rights, err := s.nodeSvc.GetLevelRights(ctx, ref.NodeLevel)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to load node rights of %s: %w", ref.UserID, err)
+	}
+	if !rights.EarnsAtDepth(int(ref.Depth)) {
+		return decimal.Zero, nil
+	}
+	rate := decimal.NewFromInt(int64(tierBps))
+
+	mode := s.cfg.Referral.BadgeMode
+	if mode == ReferralBadgeOff {
+		return rate, nil
+	}
+	benefits, err := s.badgeSvc.GetUserActiveBadgeMultipliers(ctx, ref.UserID)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to load badges of %s: %w", ref.UserID, err)
+	}
+	share := benefits.ReferralRewardShare
+	switch mode {
+	case ReferralBadgeGate:
+		if !share.IsPositive() {
+			return decimal.Zero, nil
+		}
+	case ReferralBadgeBonus:
+		if share.IsPositive() && tierBps > 0 {
+			rate = decimal.Min(rate.Add(share.Mul(bpsDenominator)), bpsDenominator)
+		}
+	}
+	return rate, nil
+}
+
+// commissionAmount returns rate basis points of amount, truncated so
+// commissions never pay out more than their rate.
+func commissionAmount(amount, rate decimal.Decimal) decimal.Decimal {
+	return divDown(amount.Mul(rate), bpsDenominator)
+}
+
+// Pay pays the commissions on ev in their own transaction. Use PayTx when
+// they are part of a larger unit of work.
+func (s *CommissionService) Pay(ctx context.Context, ev CommissionEvent) ([]Commission, error) {
+	var paid []Commission
+	err := s.tx.WithTx(ctx, func(q *db.Queries) error {
+		var err error
+		paid, err = s.PayTx(ctx, q, ev)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paid, nil
+}
+
+// PayTx pays each qualifying referrer in ev.UserID's upline their tier's
+// share of ev.Amount, vesting it under the referral commission policy, and
+// records a referral_earnings row per commission. A commission the
+// referrer or ev.UserID is flagged for is recorded as held instead of
+// paid, and one either is rejected for as forfeited.
+func (s *CommissionService) PayTx(ctx context.Context, q *db.Queries, ev CommissionEvent) ([]Commission, error) {
+	tiers := s.tiers(ev.Source)
+	if len(tiers) == 0 || !ev.Amount.IsPositive() {
+		return nil, nil
+	}
+
+	upline, err := q.GetReferralUpline(ctx, db.GetReferralUplineParams{
+		UserID:   ev.UserID,
+		MaxDepth: int32(len(tiers)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load referral upline: %w", err)
+	}
+	accounts := []uuid.UUID{ev.UserID}
+	for _, ref := range upline {
+		accounts = append(accounts, ref.UserID)
+	}
+	held, err := s.risk.heldAccountsTx(ctx, q, accounts)
+	if err != nil {
+		return nil, err
+	}
+
+	paid := []Commission{}
+	for _, ref := range upline {
+		rate, err := s.rateFor(ctx, ref, tiers[ref.Depth-1])
+		if err != nil {
+			return nil, err
+		}
+		amount := commissionAmount(ev.Amount, rate)
+		if !amount.IsPositive() {
+			continue
+		}
+
+		status := commissionStatus(held, ref.UserID, ev.UserID)
+		var journalEntryID *uuid.UUID
+		if status == ReferralEarningPaid {
+			je, err := s.vesting.PayTx(ctx, q, Payout{
+				UserID:      ref.UserID,
+				TokenID:     ev.TokenID,
+				From:        ev.From,
+				Amount:      amount,
+				Source:      VestingSourceReferral,
+				EntryType:   "referral_commission",
+				ReferenceID: ev.ReferenceID,
+				Description: fmt.Sprintf("level %d referral commission on %s", ref.Depth, ev.Source),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to pay commission to %s: %w", ref.UserID, err)
+			}
+			journalEntryID = &je.ID
+		}
+
+		earningType := ev.Source
+		payoutAccount := string(ev.From.Type)
+		if _, err := q.CreateReferralEarning(ctx, db.CreateReferralEarningParams{
+			UserID:         &ref.UserID,
+			FromUserID:     &ev.UserID,
+			Amount:         amount,
+			TokenID:        &ev.TokenID,
+			EarningType:    &earningType,
+			Depth:          ref.Depth,
+			RateBps:        int32(rate.IntPart()),
+			SourceAmount:   ev.Amount,
+			ReferenceID:    ev.ReferenceID,
+			JournalEntryID: journalEntryID,
+			Status:         status,
+			PayoutAccount:  &payoutAccount,
+		}); err != nil {
+			return nil, fmt.Errorf("failed to record referral earning: %w", err)
+		}
+
+		paid = append(paid, Commission{
+			UserID:  ref.UserID,
+			Depth:   ref.Depth,
+			RateBps: int32(rate.IntPart()),
+			Amount:  amount,
+			Status:  status,
+		})
+	}
+	return paid, nil
+}
@@ -0,0 +1,174 @@
+// internal/services/commission_test.go
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/config"
+	"jd7008911/canlan.org/internal/db"
+)
+
+// fakeLevelRights returns the rights of each node level; levels it does
+// not know grant nothing, as an undefined level does.
+type fakeLevelRights struct {
+	levels map[int32]*NodeRights
+	err    error
+}
+
+func (f fakeLevelRights) GetLevelRights(ctx context.Context, level int32) (*NodeRights, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if rights, ok := f.levels[level]; ok {
+		return rights, nil
+	}
+	return noRights(), nil
+}
+
+// fakeBadgeBenefits returns each user's referral reward share.
+type fakeBadgeBenefits struct {
+	shares map[uuid.UUID]string
+	err    error
+}
+
+func (f fakeBadgeBenefits) GetUserActiveBadgeMultipliers(ctx context.Context, userID uuid.UUID) (*BadgeBenefits, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	share := decimal.Zero
+	if s, ok := f.shares[userID]; ok {
+		share = dec(s)
+	}
+	return &BadgeBenefits{ReferralRewardShare: share}, nil
+}
+
+func TestCommissionRateFor(t *testing.T) {
+	plain, badged, whale := uuid.New(), uuid.New(), uuid.New()
+	levels := map[int32]*NodeRights{
+		1: {CommissionDepth: intPtr(2)},
+		2: {},                           // every depth
+		3: {CommissionDepth: intPtr(0)}, // no commissions
+	}
+	shares := map[uuid.UUID]string{badged: "0.01", whale: "2"}
+
+	tests := []struct {
+		name      string
+		minLevels []int
+		badgeMode string
+		ref       db.GetReferralUplineRow
+		tierBps   int
+		want      string
+	}{
+		{"tier rate", nil, ReferralBadgeOff, db.GetReferralUplineRow{UserID: plain, Depth: 1, NodeLevel: 1}, 500, "500"},
+		{"below the minimum level for the depth", []int{0, 2}, ReferralBadgeOff, db.GetReferralUplineRow{UserID: plain, Depth: 2, NodeLevel: 1}, 300, "0"},
+		{"at the minimum level for the depth", []int{0, 2}, ReferralBadgeOff, db.GetReferralUplineRow{UserID: plain, Depth: 2, NodeLevel: 2}, 300, "300"},
+		{"depth beyond the minimum levels is ungated", []int{0, 2}, ReferralBadgeOff, db.GetReferralUplineRow{UserID: plain, Depth: 3, NodeLevel: 2}, 100, "100"},
+		{"within the level's commission depth", nil, ReferralBadgeOff, db.GetReferralUplineRow{UserID: plain, Depth: 2, NodeLevel: 1}, 300, "300"},
+		{"beyond the level's commission depth", nil, ReferralBadgeOff, db.GetReferralUplineRow{UserID: plain, Depth: 3, NodeLevel: 1}, 100, "0"},
+		{"level without commissions", nil, ReferralBadgeOff, db.GetReferralUplineRow{UserID: plain, Depth: 1, NodeLevel: 3}, 500, "0"},
+		{"undefined level earns nothing", nil, ReferralBadgeOff, db.GetReferralUplineRow{UserID: plain, Depth: 1, NodeLevel: 9}, 500, "0"},
+		{"badges ignored when off", nil, ReferralBadgeOff, db.GetReferralUplineRow{UserID: badged, Depth: 1, NodeLevel: 2}, 500, "500"},
+		{"gate without a referral badge", nil, ReferralBadgeGate, db.GetReferralUplineRow{UserID: plain, Depth: 1, NodeLevel: 2}, 500, "0"},
+		{"gate with a referral badge", nil, ReferralBadgeGate, db.GetReferralUplineRow{UserID: badged, Depth: 1, NodeLevel: 2}, 500, "500"},
+		{"bonus adds the share", nil, ReferralBadgeBonus, db.GetReferralUplineRow{UserID: badged, Depth: 1, NodeLevel: 2}, 500, "600"},
+		{"bonus without a referral badge", nil, ReferralBadgeBonus, db.GetReferralUplineRow{UserID: plain, Depth: 1, NodeLevel: 2}, 500, "500"},
+		{"bonus does not open a zero tier", nil, ReferralBadgeBonus, db.GetReferralUplineRow{UserID: badged, Depth: 1, NodeLevel: 2}, 0, "0"},
+		{"bonus capped at the whole amount", nil, ReferralBadgeBonus, db.GetReferralUplineRow{UserID: whale, Depth: 1, NodeLevel: 2}, 500, "10000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &CommissionService{
+				cfg:      &config.Config{Referral: config.ReferralConfig{MinNodeLevels: tt.minLevels, BadgeMode: tt.badgeMode}},
+				nodeSvc:  fakeLevelRights{levels: levels},
+				badgeSvc: fakeBadgeBenefits{shares: shares},
+			}
+			got, err := svc.rateFor(context.Background(), tt.ref, tt.tierBps)
+			if err != nil {
+				t.Fatalf("rateFor() error = %v", err)
+			}
+			if !got.Equal(dec(tt.want)) {
+				t.Errorf("rateFor() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommissionRateForErrors(t *testing.T) {
+	errLookup := errors.New("connection reset")
+	ref := db.GetReferralUplineRow{UserID: uuid.New(), Depth: 1, NodeLevel: 2}
+	tests := []struct {
+		name      string
+		badgeMode string
+		rights    fakeLevelRights
+		badges    fakeBadgeBenefits
+	}{
+		{"node rights", ReferralBadgeOff, fakeLevelRights{err: errLookup}, fakeBadgeBenefits{}},
+		{"badges", ReferralBadgeGate, fakeLevelRights{levels: map[int32]*NodeRights{2: {}}}, fakeBadgeBenefits{err: errLookup}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &CommissionService{
+				cfg:      &config.Config{Referral: config.ReferralConfig{BadgeMode: tt.badgeMode}},
+				nodeSvc:  tt.rights,
+				badgeSvc: tt.badges,
+			}
+			if _, err := svc.rateFor(context.Background(), ref, 500); !errors.Is(err, errLookup) {
+				t.Errorf("rateFor() error = %v, want %v", err, errLookup)
+			}
+		})
+	}
+}
+
+func TestCommissionAmount(t *testing.T) {
+	tests := []struct {
+		amount, rate, want string
+	}{
+		{"1000", "500", "50"},
+		{"1000", "0", "0"},
+		{"1000", "10000", "1000"},
+		{"0.000000000000000019", "500", "0"},
+		{"1", "3333", "0.3333"},
+		{"0.000000000000000999", "3333", "0.000000000000000332"},
+		{"123.456789012345678901", "250", "3.086419725308641972"},
+	}
+	for _, tt := range tests {
+		if got := commissionAmount(dec(tt.amount), dec(tt.rate)); !got.Equal(dec(tt.want)) {
+			t.Errorf("commissionAmount(%s, %s) = %s, want %s", tt.amount, tt.rate, got, tt.want)
+		}
+	}
+}
+
+func TestCommissionStatus(t *testing.T) {
+	earner, source := uuid.New(), uuid.New()
+	tests := []struct {
+		name   string
+		earner string
+		source string
+		want   string
+	}{
+		{"neither held", "", "", ReferralEarningPaid},
+		{"earner flagged", RiskStatusFlagged, "", ReferralEarningHeld},
+		{"source flagged", "", RiskStatusFlagged, ReferralEarningHeld},
+		{"earner rejected", RiskStatusRejected, "", ReferralEarningForfeited},
+		{"source rejected", "", RiskStatusRejected, ReferralEarningForfeited},
+		{"rejection outweighs a flag", RiskStatusFlagged, RiskStatusRejected, ReferralEarningForfeited},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			held := map[uuid.UUID]string{}
+			if tt.earner != "" {
+				held[earner] = tt.earner
+			}
+			if tt.source != "" {
+				held[source] = tt.source
+			}
+			if got := commissionStatus(held, earner, source); got != tt.want {
+				t.Errorf("commissionStatus() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+// internal/services/emission.go
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/config"
+	"jd7008911/canlan.org/internal/db"
+)
+
+// maxProjectionBlocks bounds GET /block/emission/projection.
+const maxProjectionBlocks = 100000
+
+var ErrInvalidEmissionSchedule = errors.New("invalid emission schedule")
+
+// ---------------------------------------------------------------------
+// Emission Schedule
+// ---------------------------------------------------------------------
+
+// EmissionSchedule determines the reward of every mint block. Block n
+// (1-based) belongs to epoch (n-1)/EpochLength. Its reward is the epoch's
+// override when one is set; otherwise InitialReward reduced by
+// EpochDecayBps once per epoch and halved every HalvingInterval blocks.
+// The total ever minted never exceeds SupplyCap.
+type EmissionSchedule struct {
+	InitialReward   decimal.Decimal           `json:"initial_reward"`
+	HalvingInterval int64                     `json:"halving_interval"`    // blocks between halvings, 0 disables
+	EpochLength     int64                     `json:"epoch_length"`        // blocks per epoch, 0 disables epochs
+	EpochDecayBps   int64                     `json:"epoch_decay_bps"`     // reward reduction per epoch
+	SupplyCap       decimal.Decimal           `json:"supply_cap"`          // zero means uncapped
+	Overrides       map[int64]decimal.Decimal `json:"overrides,omitempty"` // epoch -> reward per block
+}
+
+// EmissionScheduleFromConfig builds the default schedule from EMISSION_*
+// settings. Governance can replace it from a given block onwards.
+func EmissionScheduleFromConfig(cfg config.EmissionConfig) (*EmissionSchedule, error) {
+	initial, err := decimal.NewFromString(cfg.InitialReward)
+	if err != nil {
+		return nil, fmt.Errorf("%w: initial reward %q", ErrInvalidEmissionSchedule, cfg.InitialReward)
+	}
+	supplyCap := decimal.Zero
+	if cfg.SupplyCap != "" {
+		if supplyCap, err = decimal.NewFromString(cfg.SupplyCap); err != nil {
+			return nil, fmt.Errorf("%w: supply cap %q", ErrInvalidEmissionSchedule, cfg.SupplyCap)
+		}
+	}
+
+	schedule := &EmissionSchedule{
+		InitialReward:   initial,
+		HalvingInterval: cfg.HalvingBlocks,
+		EpochLength:     cfg.EpochBlocks,
+		EpochDecayBps:   cfg.EpochDecayBps,
+		SupplyCap:       supplyCap,
+		Overrides:       make(map[int64]decimal.Decimal),
+	}
+	// Overrides are "epoch=reward" pairs, e.g. "0=2000,1=1500"
+	for _, pair := range strings.Split(cfg.EpochOverrides, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		epochStr, rewardStr, ok := strings.Cut(pair, "=")
+		epoch, epochErr := strconv.ParseInt(strings.TrimSpace(epochStr), 10, 64)
+		reward, rewardErr := decimal.NewFromString(strings.TrimSpace(rewardStr))
+		if !ok || epochErr != nil || rewardErr != nil {
+			return nil, fmt.Errorf("%w: epoch override %q", ErrInvalidEmissionSchedule, pair)
+		}
+		schedule.Overrides[epoch] = reward
+	}
+
+	if err := schedule.Validate(); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// Validate checks the schedule for values that cannot produce rewards.
+func (e *EmissionSchedule) Validate() error {
+	switch {
+	case e.InitialReward.IsNegative():
+		return fmt.Errorf("%w: initial reward must not be negative", ErrInvalidEmissionSchedule)
+	case e.HalvingInterval < 0:
+		return fmt.Errorf("%w: halving interval must not be negative", ErrInvalidEmissionSchedule)
+	case e.EpochLength < 0:
+		return fmt.Errorf("%w: epoch length must not be negative", ErrInvalidEmissionSchedule)
+	case e.EpochDecayBps < 0 || e.EpochDecayBps > 10000:
+		return fmt.Errorf("%w: epoch decay must be between 0 and 10000 bps", ErrInvalidEmissionSchedule)
+	case e.SupplyCap.IsNegative():
+		return fmt.Errorf("%w: supply cap must not be negative", ErrInvalidEmissionSchedule)
+	case len(e.Overrides) > 0 && e.EpochLength == 0:
+		return fmt.Errorf("%w: epoch overrides require an epoch length", ErrInvalidEmissionSchedule)
+	}
+	for epoch, reward := range e.Overrides {
+		if epoch < 0 || reward.IsNegative() {
+			return fmt.Errorf("%w: bad override for epoch %d", ErrInvalidEmissionSchedule, epoch)
+		}
+	}
+	return nil
+}
+
+// Epoch returns the epoch of a block, or zero when epochs are disabled.
+func (e *EmissionSchedule) Epoch(blockNumber int64) int64 {
+	if e.EpochLength <= 0 || blockNumber < 1 {
+		return 0
+	}
+	return (blockNumber - 1) / e.EpochLength
+}
+
+// BaseReward is the scheduled reward of a block before the supply cap.
+func (e *EmissionSchedule) BaseReward(blockNumber int64) decimal.Decimal {
+	if blockNumber < 1 {
+		return decimal.Zero
+	}
+	epoch := e.Epoch(blockNumber)
+	if reward, ok := e.Overrides[epoch]; ok {
+		return reward
+	}
+
+	reward := e.InitialReward
+	if e.EpochDecayBps > 0 && epoch > 0 {
+		kept := bpsDenominator.Sub(decimal.NewFromInt(e.EpochDecayBps)).Div(bpsDenominator)
+		reward = reward.Mul(kept.Pow(decimal.NewFromInt(epoch)))
+	}
+	if e.HalvingInterval > 0 {
+		halvings := (blockNumber - 1) / e.HalvingInterval
+		reward = divDown(reward, decimal.NewFromInt(2).Pow(decimal.NewFromInt(halvings)))
+	}
+	return reward.Truncate(ammPrecision)
+}
+
+// RewardForBlock is the block's reward given the amount minted before it,
+// cut so the total never exceeds the supply cap.
+func (e *EmissionSchedule) RewardForBlock(blockNumber int64, minted decimal.Decimal) decimal.Decimal {
+	reward := e.BaseReward(blockNumber)
+	if e.SupplyCap.IsPositive() {
+		remaining := e.SupplyCap.Sub(minted)
+		if !remaining.IsPositive() {
+			return decimal.Zero
+		}
+		if reward.GreaterThan(remaining) {
+			return remaining
+		}
+	}
+	return reward
+}
+
+// ---------------------------------------------------------------------
+// Active Schedules
+// ---------------------------------------------------------------------
+
+// emissionPlan is the configured schedule followed by governance changes,
+// each taking effect from its block.
+type emissionPlan struct {
+	base    *EmissionSchedule
+	changes []scheduleChange
+}
+
+type scheduleChange struct {
+	fromBlock int64
+	schedule  *EmissionSchedule
+}
+
+// at returns the schedule in force for a block.
+func (p *emissionPlan) at(blockNumber int64) *EmissionSchedule {
+	schedule := p.base
+	for _, c := range p.changes {
+		if c.fromBlock > blockNumber {
+			break
+		}
+		schedule = c.schedule
+	}
+	return schedule
+}
+
+// loadEmissionPlan reads the governance schedule changes, oldest first.
+func (s *BlockRewardService) loadEmissionPlan(ctx context.Context, q *db.Queries) (*emissionPlan, error) {
+	rows, err := q.ListEmissionSchedules(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load emission schedules: %w", err)
+	}
+	plan := &emissionPlan{base: s.emission}
+	for _, row := range rows {
+		var schedule EmissionSchedule
+		if err := json.Unmarshal(row.Schedule, &schedule); err != nil {
+			return nil, fmt.Errorf("failed to decode emission schedule %s: %w", row.ID, err)
+		}
+		plan.changes = append(plan.changes, scheduleChange{fromBlock: row.EffectiveFromBlock, schedule: &schedule})
+	}
+	return plan, nil
+}
+
+// blockReward returns the reward for blockNumber under the schedule in force.
+func (s *BlockRewardService) blockReward(ctx context.Context, q *db.Queries, blockNumber int64) (decimal.Decimal, error) {
+	plan, err := s.loadEmissionPlan(ctx, q)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	minted, err := q.GetTotalMintedReward(ctx)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to sum minted rewards: %w", err)
+	}
+	return plan.at(blockNumber).RewardForBlock(blockNumber, minted), nil
+}
+
+// SetEmissionSchedule replaces the emission schedule from effectiveFrom
+// onwards, inside the caller's transaction. Governance calls this when an
+// emission proposal is executed.
+func (s *BlockRewardService) SetEmissionSchedule(ctx context.Context, q *db.Queries, schedule *EmissionSchedule, effectiveFrom int64, proposalID *uuid.UUID) error {
+	if err := schedule.Validate(); err != nil {
+		return err
+	}
+	if last, err := q.GetLastMintBlock(ctx); err == nil && effectiveFrom <= last.BlockNumber {
+		return fmt.Errorf("%w: block %d has already been minted", ErrInvalidEmissionSchedule, effectiveFrom)
+	}
+	payload, err := json.Marshal(schedule)
+	if err != nil {
+		return fmt.Errorf("failed to encode emission schedule: %w", err)
+	}
+	if _, err := q.CreateEmissionSchedule(ctx, db.CreateEmissionScheduleParams{
+		Schedule:           payload,
+		EffectiveFromBlock: effectiveFrom,
+		ProposalID:         proposalID,
+	}); err != nil {
+		return fmt.Errorf("failed to save emission schedule: %w", err)
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------
+// Projection
+// ---------------------------------------------------------------------
+
+// EmissionSegment is a run of consecutive blocks with the same reward.
+type EmissionSegment struct {
+	FromBlock      int64           `json:"from_block"`
+	ToBlock        int64           `json:"to_block"`
+	Epoch          int64           `json:"epoch"`
+	RewardPerBlock decimal.Decimal `json:"reward_per_block"`
+	Total          decimal.Decimal `json:"total"`
+}
+
+// EmissionProjection is the expected emission over the next blocks.
+type EmissionProjection struct {
+	FromBlock         int64             `json:"from_block"`
+	Blocks            int64             `json:"blocks"`
+	MintedToDate      decimal.Decimal   `json:"minted_to_date"`
+	ProjectedEmission decimal.Decimal   `json:"projected_emission"`
+	SupplyCap         *decimal.Decimal  `json:"supply_cap,omitempty"`       // nil when uncapped
+	RemainingSupply   *decimal.Decimal  `json:"remaining_supply,omitempty"` // after the projected blocks
+	CapReachedAtBlock *int64            `json:"cap_reached_at_block,omitempty"`
+	Schedule          *EmissionSchedule `json:"schedule"` // in force for the next block
+	Segments          []EmissionSegment `json:"segments"`
+}
+
+// ProjectEmissions projects rewards and remaining supply for the next
+// blocks blocks, including schedule changes already approved by governance.
+func (s *BlockRewardService) ProjectEmissions(ctx context.Context, blocks int64) (*EmissionProjection, error) {
+	if blocks <= 0 {
+		blocks = 1000
+	}
+	if blocks > maxProjectionBlocks {
+		blocks = maxProjectionBlocks
+	}
+
+	plan, err := s.loadEmissionPlan(ctx, s.queries)
+	if err != nil {
+		return nil, err
+	}
+	minted, err := s.queries.GetTotalMintedReward(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum minted rewards: %w", err)
+	}
+	next := int64(1)
+	if last, err := s.queries.GetLastMintBlock(ctx); err == nil {
+		next = last.BlockNumber + 1
+	}
+
+	projection := &EmissionProjection{
+		FromBlock:         next,
+		Blocks:            blocks,
+		MintedToDate:      minted,
+		ProjectedEmission: decimal.Zero,
+		Schedule:          plan.at(next),
+		Segments:          []EmissionSegment{},
+	}
+
+	total := minted
+	for n := next; n < next+blocks; n++ {
+		schedule := plan.at(n)
+		reward := schedule.RewardForBlock(n, total)
+		total = total.Add(reward)
+		projection.ProjectedEmission = projection.ProjectedEmission.Add(reward)
+
+		if schedule.SupplyCap.IsPositive() && projection.CapReachedAtBlock == nil && total.GreaterThanOrEqual(schedule.SupplyCap) {
+			block := n
+			projection.CapReachedAtBlock = &block
+		}
+
+		last := len(projection.Segments) - 1
+		if last >= 0 && projection.Segments[last].RewardPerBlock.Equal(reward) && projection.Segments[last].Epoch == schedule.Epoch(n) {
+			projection.Segments[last].ToBlock = n
+			projection.Segments[last].Total = projection.Segments[last].Total.Add(reward)
+			continue
+		}
+		projection.Segments = append(projection.Segments, EmissionSegment{
+			FromBlock:      n,
+			ToBlock:        n,
+			Epoch:          schedule.Epoch(n),
+			RewardPerBlock: reward,
+			Total:          reward,
+		})
+	}
+
+	if final := plan.at(next + blocks - 1); final.SupplyCap.IsPositive() {
+		supplyCap := final.SupplyCap
+		remaining := decimal.Max(supplyCap.Sub(total), decimal.Zero)
+		projection.SupplyCap = &supplyCap
+		projection.RemainingSupply = &remaining
+	}
+	return projection, nil
+}
@@ -0,0 +1,208 @@
+// internal/services/emission_test.go
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/config"
+)
+
+func TestEmissionEpoch(t *testing.T) {
+	e := &EmissionSchedule{EpochLength: 10}
+	tests := []struct {
+		block, want int64
+	}{
+		{0, 0},
+		{1, 0},
+		{10, 0},
+		{11, 1},
+		{100, 9},
+		{101, 10},
+	}
+	for _, tt := range tests {
+		if got := e.Epoch(tt.block); got != tt.want {
+			t.Errorf("Epoch(%d) = %d, want %d", tt.block, got, tt.want)
+		}
+	}
+	if got := (&EmissionSchedule{}).Epoch(1000); got != 0 {
+		t.Errorf("Epoch() without epochs = %d, want 0", got)
+	}
+}
+
+func TestBaseReward(t *testing.T) {
+	halving := &EmissionSchedule{InitialReward: dec("50"), HalvingInterval: 100}
+	decay := &EmissionSchedule{InitialReward: dec("50"), EpochLength: 10, EpochDecayBps: 1000}
+	both := &EmissionSchedule{InitialReward: dec("50"), HalvingInterval: 20, EpochLength: 10, EpochDecayBps: 1000}
+	overridden := &EmissionSchedule{
+		InitialReward: dec("50"),
+		EpochLength:   10,
+		EpochDecayBps: 1000,
+		Overrides:     map[int64]decimal.Decimal{1: dec("7"), 3: decimal.Zero},
+	}
+	dust := &EmissionSchedule{InitialReward: dec("0.00000000000000015"), HalvingInterval: 1}
+
+	tests := []struct {
+		name     string
+		schedule *EmissionSchedule
+		block    int64
+		want     string
+	}{
+		{"no block zero", halving, 0, "0"},
+		{"first block", halving, 1, "50"},
+		{"last block before halving", halving, 100, "50"},
+		{"first halving", halving, 101, "25"},
+		{"third halving", halving, 301, "6.25"},
+		{"halved below one unit", halving, 100*70 + 1, "0"},
+		{"first epoch keeps the full reward", decay, 10, "50"},
+		{"one epoch of decay", decay, 11, "45"},
+		{"two epochs of decay", decay, 21, "40.5"},
+		{"decay and halving combine", both, 21, "20.25"},
+		{"override replaces the epoch's reward", overridden, 11, "7"},
+		{"override does not carry into later epochs", overridden, 21, "40.5"},
+		{"zero override pauses emission", overridden, 31, "0"},
+		{"halving rounds down", dust, 2, "0.000000000000000075"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.schedule.BaseReward(tt.block); !got.Equal(dec(tt.want)) {
+				t.Errorf("BaseReward(%d) = %s, want %s", tt.block, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewardForBlock(t *testing.T) {
+	capped := &EmissionSchedule{InitialReward: dec("50"), SupplyCap: dec("100")}
+	uncapped := &EmissionSchedule{InitialReward: dec("50")}
+
+	tests := []struct {
+		name     string
+		schedule *EmissionSchedule
+		minted   string
+		want     string
+	}{
+		{"below the cap", capped, "0", "50"},
+		{"reaches the cap exactly", capped, "50", "50"},
+		{"cut to the cap", capped, "90.5", "9.5"},
+		{"at the cap", capped, "100", "0"},
+		{"past the cap", capped, "120", "0"},
+		{"uncapped", uncapped, "1000000000", "50"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.schedule.RewardForBlock(1, dec(tt.minted)); !got.Equal(dec(tt.want)) {
+				t.Errorf("RewardForBlock(1, %s) = %s, want %s", tt.minted, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestEmissionNeverExceedsCap mints block after block and checks the total
+// stops exactly at the cap.
+func TestEmissionNeverExceedsCap(t *testing.T) {
+	e := &EmissionSchedule{
+		InitialReward:   dec("33.333333333333333333"),
+		HalvingInterval: 7,
+		EpochLength:     3,
+		EpochDecayBps:   250,
+		SupplyCap:       dec("300"),
+	}
+	minted := decimal.Zero
+	for block := int64(1); block <= 200; block++ {
+		reward := e.RewardForBlock(block, minted)
+		if reward.IsNegative() {
+			t.Fatalf("block %d: negative reward %s", block, reward)
+		}
+		minted = minted.Add(reward)
+		if minted.GreaterThan(e.SupplyCap) {
+			t.Fatalf("block %d: minted %s exceeds cap %s", block, minted, e.SupplyCap)
+		}
+	}
+	if !minted.Equal(e.SupplyCap) {
+		t.Errorf("minted %s after 200 blocks, want the cap %s", minted, e.SupplyCap)
+	}
+}
+
+func TestEmissionScheduleValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule EmissionSchedule
+		wantErr  bool
+	}{
+		{"valid", EmissionSchedule{InitialReward: dec("50"), HalvingInterval: 100, EpochLength: 10, EpochDecayBps: 100}, false},
+		{"everything disabled", EmissionSchedule{}, false},
+		{"full decay", EmissionSchedule{EpochLength: 10, EpochDecayBps: 10000}, false},
+		{"negative reward", EmissionSchedule{InitialReward: dec("-1")}, true},
+		{"negative halving interval", EmissionSchedule{HalvingInterval: -1}, true},
+		{"negative epoch length", EmissionSchedule{EpochLength: -1}, true},
+		{"negative decay", EmissionSchedule{EpochLength: 10, EpochDecayBps: -1}, true},
+		{"decay above 100%", EmissionSchedule{EpochLength: 10, EpochDecayBps: 10001}, true},
+		{"negative supply cap", EmissionSchedule{SupplyCap: dec("-1")}, true},
+		{"overrides without epochs", EmissionSchedule{Overrides: map[int64]decimal.Decimal{0: dec("1")}}, true},
+		{"override for a negative epoch", EmissionSchedule{EpochLength: 10, Overrides: map[int64]decimal.Decimal{-1: dec("1")}}, true},
+		{"negative override", EmissionSchedule{EpochLength: 10, Overrides: map[int64]decimal.Decimal{0: dec("-1")}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.schedule.Validate()
+			if tt.wantErr && !errors.Is(err, ErrInvalidEmissionSchedule) {
+				t.Errorf("Validate() error = %v, want ErrInvalidEmissionSchedule", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Validate() error = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestEmissionScheduleFromConfig(t *testing.T) {
+	valid := config.EmissionConfig{
+		InitialReward:  "50",
+		HalvingBlocks:  1000,
+		EpochBlocks:    100,
+		EpochDecayBps:  200,
+		SupplyCap:      "21000000",
+		EpochOverrides: " 0=2000, 1 = 1500 ,",
+	}
+	schedule, err := EmissionScheduleFromConfig(valid)
+	if err != nil {
+		t.Fatalf("EmissionScheduleFromConfig() error = %v", err)
+	}
+	if !schedule.InitialReward.Equal(dec("50")) || !schedule.SupplyCap.Equal(dec("21000000")) ||
+		schedule.HalvingInterval != 1000 || schedule.EpochLength != 100 || schedule.EpochDecayBps != 200 {
+		t.Errorf("EmissionScheduleFromConfig() = %+v", schedule)
+	}
+	if len(schedule.Overrides) != 2 || !schedule.Overrides[0].Equal(dec("2000")) || !schedule.Overrides[1].Equal(dec("1500")) {
+		t.Errorf("Overrides = %v, want 0=2000 1=1500", schedule.Overrides)
+	}
+
+	uncapped := valid
+	uncapped.SupplyCap = ""
+	if schedule, err := EmissionScheduleFromConfig(uncapped); err != nil || !schedule.SupplyCap.IsZero() {
+		t.Errorf("empty supply cap = %v, %v; want uncapped", schedule, err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(c *config.EmissionConfig)
+	}{
+		{"bad initial reward", func(c *config.EmissionConfig) { c.InitialReward = "fifty" }},
+		{"bad supply cap", func(c *config.EmissionConfig) { c.SupplyCap = "lots" }},
+		{"override without reward", func(c *config.EmissionConfig) { c.EpochOverrides = "0" }},
+		{"override with bad epoch", func(c *config.EmissionConfig) { c.EpochOverrides = "x=1" }},
+		{"override with bad reward", func(c *config.EmissionConfig) { c.EpochOverrides = "0=x" }},
+		{"overrides without epochs", func(c *config.EmissionConfig) { c.EpochBlocks = 0 }},
+		{"decay above 100%", func(c *config.EmissionConfig) { c.EpochDecayBps = 20000 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.mutate(&cfg)
+			if _, err := EmissionScheduleFromConfig(cfg); !errors.Is(err, ErrInvalidEmissionSchedule) {
+				t.Errorf("EmissionScheduleFromConfig() error = %v, want ErrInvalidEmissionSchedule", err)
+			}
+		})
+	}
+}
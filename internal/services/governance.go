@@ -3,6 +3,8 @@ package services
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -13,20 +15,43 @@ import (
 
 // GovernanceService handles all governance-related business logic.
 type GovernanceService struct {
-	queries   *db.Queries
-	badgeSvc  *BadgeService
-	combatSvc *CombatPowerService
+	queries        *db.Queries
+	tx             db.TxRunner
+	badgeSvc       *BadgeService
+	combatSvc      *CombatPowerService
+	blockRewardSvc *BlockRewardService
+	nodeSvc        *NodeService
 }
 
+// ErrProposalNotPermitted is returned when the proposer's node level does
+// not grant proposal creation.
+var ErrProposalNotPermitted = errors.New("node level does not permit creating proposals")
+
 // NewGovernanceService creates a new governance service.
-func NewGovernanceService(queries *db.Queries, badgeSvc *BadgeService, combatSvc *CombatPowerService) *GovernanceService {
+func NewGovernanceService(queries *db.Queries, tx db.TxRunner, badgeSvc *BadgeService, combatSvc *CombatPowerService, blockRewardSvc *BlockRewardService, nodeSvc *NodeService) *GovernanceService {
 	return &GovernanceService{
-		queries:   queries,
-		badgeSvc:  badgeSvc,
-		combatSvc: combatSvc,
+		queries:        queries,
+		tx:             tx,
+		badgeSvc:       badgeSvc,
+		combatSvc:      combatSvc,
+		blockRewardSvc: blockRewardSvc,
+		nodeSvc:        nodeSvc,
 	}
 }
 
+// ProposalTypeEmission proposals replace the mint block emission schedule.
+const ProposalTypeEmission = "emission"
+
+// ProposalTypeCombatFormula proposals activate a new combat power formula.
+// Their payload is the formula definition.
+const ProposalTypeCombatFormula = "combat_formula"
+
+// EmissionProposalPayload is the payload of an emission proposal.
+type EmissionProposalPayload struct {
+	Schedule           EmissionSchedule `json:"schedule"`
+	EffectiveFromBlock int64            `json:"effective_from_block"`
+}
+
 // ---------------------------------------------------------------------
 // Proposal Creation & Retrieval
 // ---------------------------------------------------------------------
@@ -40,10 +65,20 @@ type CreateProposalParams struct {
 	VotingEnd    time.Time
 	Quorum       decimal.Decimal // percentage (e.g., 50.0 = 50%)
 	Threshold    decimal.Decimal // percentage (e.g., 50.0 = 50%)
+	Payload      json.RawMessage // the change the proposal makes, required for executable types
 }
 
-// CreateProposal creates a new governance proposal.
+// CreateProposal creates a new governance proposal. The proposer's node
+// level must grant the create_proposals right.
 func (s *GovernanceService) CreateProposal(ctx context.Context, params CreateProposalParams) (*db.GovernanceProposal, error) {
+	allowed, err := s.nodeSvc.HasPermission(ctx, params.ProposerID, PermissionCreateProposal)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrProposalNotPermitted
+	}
+
 	// Validate voting end is in the future
 	if params.VotingEnd.Before(time.Now()) {
 		return nil, fmt.Errorf("voting end time must be in the future")
@@ -57,6 +92,18 @@ func (s *GovernanceService) CreateProposal(ctx context.Context, params CreatePro
 		return nil, fmt.Errorf("threshold must be between 0 and 100")
 	}
 
+	// Executable proposals must carry a payload that can be applied as is
+	switch params.ProposalType {
+	case ProposalTypeEmission:
+		if _, err := decodeEmissionPayload(params.Payload); err != nil {
+			return nil, err
+		}
+	case ProposalTypeCombatFormula:
+		if _, err := DecodeCombatFormula(params.Payload); err != nil {
+			return nil, err
+		}
+	}
+
 	proposal, err := s.queries.CreateProposal(ctx, db.CreateProposalParams{
 		ProposerID:   params.ProposerID,
 		Title:        params.Title,
@@ -65,6 +112,7 @@ func (s *GovernanceService) CreateProposal(ctx context.Context, params CreatePro
 		VotingEnd:    params.VotingEnd,
 		Quorum:       params.Quorum,
 		Threshold:    params.Threshold,
+		Payload:      params.Payload,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create proposal: %w", err)
@@ -95,6 +143,21 @@ func (s *GovernanceService) ListProposals(ctx context.Context, limit, offset int
 	})
 }
 
+// ListProposalsByStatus paginates the proposals with the given status.
+func (s *GovernanceService) ListProposalsByStatus(ctx context.Context, status string, limit, offset int32) ([]db.GovernanceProposal, error) {
+	return s.queries.ListProposalsByStatus(ctx, db.ListProposalsByStatusParams{
+		Status:    status,
+		RowLimit:  limit,
+		RowOffset: offset,
+	})
+}
+
+// CountProposals counts the proposals with the given status, or all
+// proposals when status is empty.
+func (s *GovernanceService) CountProposals(ctx context.Context, status string) (int64, error) {
+	return s.queries.CountProposals(ctx, status)
+}
+
 // ---------------------------------------------------------------------
 // Voting & Vote Power
 // ---------------------------------------------------------------------
@@ -267,8 +330,10 @@ func (s *GovernanceService) CloseExpiredProposals(ctx context.Context) error {
 	return nil
 }
 
-// ExecuteProposal marks a passed proposal as executed.
-// In a real implementation, this would trigger on-chain execution or parameter changes.
+// ExecuteProposal applies a passed proposal and marks it executed in one
+// transaction. Emission proposals replace the emission schedule and combat
+// formula proposals activate a new formula version; other types only change
+// status until they gain an executor.
 func (s *GovernanceService) ExecuteProposal(ctx context.Context, proposalID uuid.UUID) (*db.GovernanceProposal, error) {
 	proposal, err := s.GetProposal(ctx, proposalID)
 	if err != nil {
@@ -278,16 +343,55 @@ func (s *GovernanceService) ExecuteProposal(ctx context.Context, proposalID uuid
 		return nil, fmt.Errorf("only passed proposals can be executed")
 	}
 
-	// TODO: Perform actual execution logic (e.g., update protocol parameters,
-	// call smart contract, etc.) based on proposal_type and description.
+	var executed db.GovernanceProposal
+	err = s.tx.WithTx(ctx, func(q *db.Queries) error {
+		var err error
+		executed, err = q.ExecuteProposal(ctx, proposalID)
+		if err != nil {
+			return fmt.Errorf("failed to execute proposal: %w", err)
+		}
 
-	executed, err := s.queries.ExecuteProposal(ctx, proposalID)
+		switch proposal.ProposalType {
+		case ProposalTypeEmission:
+			payload, err := decodeEmissionPayload(proposal.Payload)
+			if err != nil {
+				return err
+			}
+			return s.blockRewardSvc.SetEmissionSchedule(ctx, q, &payload.Schedule, payload.EffectiveFromBlock, &proposal.ID)
+		case ProposalTypeCombatFormula:
+			f, err := DecodeCombatFormula(proposal.Payload)
+			if err != nil {
+				return err
+			}
+			_, err = s.combatSvc.SetCombatFormula(ctx, q, f, &proposal.ID)
+			return err
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute proposal: %w", err)
+		return nil, err
 	}
 	return &executed, nil
 }
 
+// decodeEmissionPayload parses and validates an emission proposal payload.
+func decodeEmissionPayload(raw []byte) (*EmissionProposalPayload, error) {
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("%w: emission proposals require a payload", ErrInvalidEmissionSchedule)
+	}
+	var payload EmissionProposalPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEmissionSchedule, err)
+	}
+	if payload.EffectiveFromBlock < 1 {
+		return nil, fmt.Errorf("%w: effective_from_block is required", ErrInvalidEmissionSchedule)
+	}
+	if err := payload.Schedule.Validate(); err != nil {
+		return nil, err
+	}
+	return &payload, nil
+}
+
 // ---------------------------------------------------------------------
 // Query Helpers
 // ---------------------------------------------------------------------
@@ -310,6 +414,11 @@ func (s *GovernanceService) GetUserVotingHistory(ctx context.Context, userID uui
 	})
 }
 
+// CountUserVotes returns the number of proposals the user has voted on.
+func (s *GovernanceService) CountUserVotes(ctx context.Context, userID uuid.UUID) (int64, error) {
+	return s.queries.CountUserVotes(ctx, userID)
+}
+
 // GetProposalVotes lists all votes cast on a proposal.
 func (s *GovernanceService) GetProposalVotes(ctx context.Context, proposalID uuid.UUID) ([]db.GetProposalVotesRow, error) {
 	return s.queries.GetProposalVotes(ctx, proposalID)
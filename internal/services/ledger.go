@@ -0,0 +1,340 @@
+// internal/services/ledger.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/db"
+)
+
+// ErrInsufficientBalance is returned when a posting would take a user
+// account below zero.
+var ErrInsufficientBalance = errors.New("insufficient balance")
+
+// AccountType identifies the kind of ledger account a posting is made against.
+type AccountType string
+
+const (
+	AccountUser     AccountType = "user"     // a user's spendable balance (projected into user_balances)
+	AccountPool     AccountType = "pool"     // reserves held by a liquidity pool
+	AccountFee      AccountType = "fee"      // protocol fees collected
+	AccountTreasury AccountType = "treasury" // platform treasury (counterparty for sales, commissions)
+	AccountEmission AccountType = "emission" // source of newly minted tokens (block rewards, mining)
+	AccountBurn     AccountType = "burn"     // sink for burned tokens
+	AccountClearing AccountType = "clearing" // funds in flight to/from the chain (pending withdrawals)
+	AccountExternal AccountType = "external" // funds that have left for (or arrived from) the chain
+	AccountVesting  AccountType = "vesting"  // a user's earnings that have not vested yet
+)
+
+// LedgerAccount identifies a ledger account by type, owner and token.
+// OwnerID is the user ID for user and vesting accounts, the pool ID for
+// pool accounts and uuid.Nil for system accounts.
+type LedgerAccount struct {
+	Type    AccountType
+	OwnerID uuid.UUID
+	TokenID uuid.UUID
+}
+
+// UserAccount returns the spendable account of a user for a token.
+func UserAccount(userID, tokenID uuid.UUID) LedgerAccount {
+	return LedgerAccount{Type: AccountUser, OwnerID: userID, TokenID: tokenID}
+}
+
+// VestingAccount returns the account holding a user's unvested earnings for a token.
+func VestingAccount(userID, tokenID uuid.UUID) LedgerAccount {
+	return LedgerAccount{Type: AccountVesting, OwnerID: userID, TokenID: tokenID}
+}
+
+// PoolAccount returns the reserve account of a liquidity pool for a token.
+func PoolAccount(poolID, tokenID uuid.UUID) LedgerAccount {
+	return LedgerAccount{Type: AccountPool, OwnerID: poolID, TokenID: tokenID}
+}
+
+// SystemAccount returns a platform-wide account (fee, treasury, emission, burn, clearing, external).
+func SystemAccount(accountType AccountType, tokenID uuid.UUID) LedgerAccount {
+	return LedgerAccount{Type: accountType, TokenID: tokenID}
+}
+
+// Posting is a single signed line of a journal entry. A positive amount
+// debits (increases) the account, a negative amount credits (decreases) it.
+type Posting struct {
+	Account LedgerAccount
+	Amount  decimal.Decimal
+}
+
+// Transfer returns the pair of postings that moves amount from one account to another.
+// Both accounts must be denominated in the same token.
+func Transfer(from, to LedgerAccount, amount decimal.Decimal) []Posting {
+	return []Posting{
+		{Account: from, Amount: amount.Neg()},
+		{Account: to, Amount: amount},
+	}
+}
+
+// JournalEntry describes one business operation and its postings.
+type JournalEntry struct {
+	Type        string     // e.g., "swap", "purchase", "withdrawal"
+	ReferenceID *uuid.UUID // id of the business record, if any
+	Description string
+	Postings    []Posting
+}
+
+// Add appends postings to the entry and returns it for chaining.
+func (e *JournalEntry) Add(postings ...Posting) *JournalEntry {
+	e.Postings = append(e.Postings, postings...)
+	return e
+}
+
+// LedgerService records balanced journal entries and keeps the
+// user_balances projection in step with user account postings.
+type LedgerService struct {
+	queries *db.Queries
+	tx      db.TxRunner
+}
+
+// NewLedgerService creates a new ledger service.
+func NewLedgerService(queries *db.Queries, tx db.TxRunner) *LedgerService {
+	return &LedgerService{queries: queries, tx: tx}
+}
+
+// ---------------------------------------------------------------------
+// Posting
+// ---------------------------------------------------------------------
+
+// Post writes the entry in its own transaction. Use PostTx when the entry
+// is part of a larger unit of work.
+func (s *LedgerService) Post(ctx context.Context, entry JournalEntry) (*db.JournalEntry, error) {
+	var je *db.JournalEntry
+	err := s.tx.WithTx(ctx, func(q *db.Queries) error {
+		var err error
+		je, err = s.PostTx(ctx, q, entry)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return je, nil
+}
+
+// PostTx validates that the entry balances per token and writes it using
+// the caller's transaction-bound queries. Postings against user accounts
+// are applied to user_balances; a debit that would make a user balance
+// negative fails with ErrInsufficientBalance.
+func (s *LedgerService) PostTx(ctx context.Context, q *db.Queries, entry JournalEntry) (*db.JournalEntry, error) {
+	postings := compactPostings(entry.Postings)
+	if err := validateEntry(postings); err != nil {
+		return nil, err
+	}
+
+	var description *string
+	if entry.Description != "" {
+		description = &entry.Description
+	}
+	je, err := q.CreateJournalEntry(ctx, db.CreateJournalEntryParams{
+		EntryType:   entry.Type,
+		ReferenceID: entry.ReferenceID,
+		Description: description,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create journal entry: %w", err)
+	}
+
+	for _, p := range postings {
+		account, err := s.account(ctx, q, p.Account)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := q.CreateLedgerPosting(ctx, db.CreateLedgerPostingParams{
+			EntryID:   je.ID,
+			AccountID: account.ID,
+			TokenID:   p.Account.TokenID,
+			Amount:    p.Amount,
+		}); err != nil {
+			return nil, fmt.Errorf("failed to write posting: %w", err)
+		}
+		if p.Account.Type == AccountUser {
+			if err := s.applyToProjection(ctx, q, p); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &je, nil
+}
+
+// account resolves (creating on first use) the ledger account row.
+func (s *LedgerService) account(ctx context.Context, q *db.Queries, a LedgerAccount) (*db.LedgerAccount, error) {
+	var owner *uuid.UUID
+	if a.OwnerID != uuid.Nil {
+		owner = &a.OwnerID
+	}
+	account, err := q.GetOrCreateLedgerAccount(ctx, db.GetOrCreateLedgerAccountParams{
+		AccountType: string(a.Type),
+		OwnerID:     owner,
+		TokenID:     a.TokenID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s ledger account: %w", a.Type, err)
+	}
+	return &account, nil
+}
+
+// applyToProjection mirrors a user account posting into user_balances.
+func (s *LedgerService) applyToProjection(ctx context.Context, q *db.Queries, p Posting) error {
+	if p.Amount.IsPositive() {
+		if err := q.CreditUserBalanceProjection(ctx, db.CreditUserBalanceProjectionParams{
+			UserID:  p.Account.OwnerID,
+			TokenID: p.Account.TokenID,
+			Amount:  p.Amount,
+		}); err != nil {
+			return fmt.Errorf("failed to credit balance: %w", err)
+		}
+		return nil
+	}
+
+	rows, err := q.DebitUserBalanceProjection(ctx, db.DebitUserBalanceProjectionParams{
+		UserID:  p.Account.OwnerID,
+		TokenID: p.Account.TokenID,
+		Amount:  p.Amount.Neg(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to debit balance: %w", err)
+	}
+	if rows == 0 {
+		return ErrInsufficientBalance
+	}
+	return nil
+}
+
+// compactPostings drops zero-amount postings, which carry no information.
+func compactPostings(postings []Posting) []Posting {
+	out := make([]Posting, 0, len(postings))
+	for _, p := range postings {
+		if !p.Amount.IsZero() {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// validateEntry checks that an entry has postings and sums to zero per token.
+func validateEntry(postings []Posting) error {
+	if len(postings) < 2 {
+		return fmt.Errorf("journal entry needs at least two postings")
+	}
+	sums := make(map[uuid.UUID]decimal.Decimal)
+	for _, p := range postings {
+		if p.Account.TokenID == uuid.Nil {
+			return fmt.Errorf("posting against %s account has no token", p.Account.Type)
+		}
+		sums[p.Account.TokenID] = sums[p.Account.TokenID].Add(p.Amount)
+	}
+	for tokenID, sum := range sums {
+		if !sum.IsZero() {
+			return fmt.Errorf("journal entry is unbalanced for token %s by %s", tokenID, sum.String())
+		}
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------
+// Balance Locking
+// ---------------------------------------------------------------------
+
+// lockBalances locks the user's balance rows for tokenIDs until the
+// surrounding transaction ends (SELECT ... FOR UPDATE) and returns them.
+// Tokens without a row are reported as zero. Two concurrent operations on
+// the same balance serialise here, so both cannot pass the balance check.
+func lockBalances(ctx context.Context, q *db.Queries, userID uuid.UUID, tokenIDs ...uuid.UUID) (map[uuid.UUID]decimal.Decimal, error) {
+	rows, err := q.LockUserBalances(ctx, db.LockUserBalancesParams{
+		UserID:   userID,
+		TokenIds: tokenIDs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock balances: %w", err)
+	}
+	balances := make(map[uuid.UUID]decimal.Decimal, len(tokenIDs))
+	for _, id := range tokenIDs {
+		balances[id] = decimal.Zero
+	}
+	for _, row := range rows {
+		balances[row.TokenID] = row.Balance
+	}
+	return balances, nil
+}
+
+// requireBalance returns a descriptive error if a locked balance is below need.
+func requireBalance(balances map[uuid.UUID]decimal.Decimal, tokenID uuid.UUID, symbol string, need decimal.Decimal) error {
+	have := balances[tokenID]
+	if have.LessThan(need) {
+		return fmt.Errorf("%w: %s have %s, need %s", ErrInsufficientBalance, symbol, have.String(), need.String())
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------
+// History
+// ---------------------------------------------------------------------
+
+// GetUserLedgerHistory returns the postings that explain a user's balances,
+// newest first. An empty symbol returns postings for all tokens.
+func (s *LedgerService) GetUserLedgerHistory(ctx context.Context, userID uuid.UUID, symbol string, limit, offset int32) ([]db.GetUserLedgerHistoryRow, int64, error) {
+	var sym *string
+	if symbol != "" {
+		sym = &symbol
+	}
+	rows, err := s.queries.GetUserLedgerHistory(ctx, db.GetUserLedgerHistoryParams{
+		UserID:    userID,
+		Symbol:    sym,
+		RowLimit:  limit,
+		RowOffset: offset,
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch ledger history: %w", err)
+	}
+	total, err := s.queries.CountUserLedgerHistory(ctx, db.CountUserLedgerHistoryParams{
+		UserID: userID,
+		Symbol: sym,
+	})
+	if err != nil {
+		total = int64(len(rows))
+	}
+	return rows, total, nil
+}
+
+// ---------------------------------------------------------------------
+// Reconciliation
+// ---------------------------------------------------------------------
+
+// ReconciliationReport lists every discrepancy between the ledger and
+// the user_balances projection.
+type ReconciliationReport struct {
+	BalanceMismatches []db.ReconcileUserBalancesRow       `json:"balance_mismatches"`
+	UnbalancedEntries []db.GetUnbalancedJournalEntriesRow `json:"unbalanced_entries"`
+}
+
+// OK reports whether the ledger and projection agree completely.
+func (r *ReconciliationReport) OK() bool {
+	return len(r.BalanceMismatches) == 0 && len(r.UnbalancedEntries) == 0
+}
+
+// Reconcile proves that every user balance equals the sum of its postings
+// and that every journal entry balances.
+func (s *LedgerService) Reconcile(ctx context.Context) (*ReconciliationReport, error) {
+	mismatches, err := s.queries.ReconcileUserBalances(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconcile balances: %w", err)
+	}
+	unbalanced, err := s.queries.GetUnbalancedJournalEntries(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check journal entries: %w", err)
+	}
+	return &ReconciliationReport{
+		BalanceMismatches: mismatches,
+		UnbalancedEntries: unbalanced,
+	}, nil
+}
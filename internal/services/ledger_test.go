@@ -0,0 +1,131 @@
+// internal/services/ledger_test.go
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+func TestTransfer(t *testing.T) {
+	token := uuid.New()
+	from, to := UserAccount(uuid.New(), token), PoolAccount(uuid.New(), token)
+
+	postings := Transfer(from, to, decimal.RequireFromString("12.5"))
+	if len(postings) != 2 {
+		t.Fatalf("Transfer() returned %d postings, want 2", len(postings))
+	}
+	if postings[0].Account != from || !postings[0].Amount.Equal(decimal.RequireFromString("-12.5")) {
+		t.Errorf("first posting = %+v, want credit of 12.5 to %+v", postings[0], from)
+	}
+	if postings[1].Account != to || !postings[1].Amount.Equal(decimal.RequireFromString("12.5")) {
+		t.Errorf("second posting = %+v, want debit of 12.5 to %+v", postings[1], to)
+	}
+	if err := validateEntry(postings); err != nil {
+		t.Errorf("validateEntry(Transfer()) = %v, want nil", err)
+	}
+}
+
+func TestCompactPostings(t *testing.T) {
+	token := uuid.New()
+	user, treasury := UserAccount(uuid.New(), token), SystemAccount(AccountTreasury, token)
+
+	postings := append(Transfer(user, treasury, decimal.NewFromInt(5)), Transfer(treasury, user, decimal.Zero)...)
+	got := compactPostings(postings)
+	if len(got) != 2 {
+		t.Fatalf("compactPostings() kept %d postings, want 2", len(got))
+	}
+	for _, p := range got {
+		if p.Amount.IsZero() {
+			t.Errorf("compactPostings() kept zero posting %+v", p)
+		}
+	}
+}
+
+func TestValidateEntry(t *testing.T) {
+	usdt, lan := uuid.New(), uuid.New()
+	user, pool := uuid.New(), uuid.New()
+	amount := func(s string) decimal.Decimal { return decimal.RequireFromString(s) }
+
+	tests := []struct {
+		name     string
+		postings []Posting
+		wantErr  bool
+	}{
+		{"no postings", nil, true},
+		{"single posting", []Posting{{Account: UserAccount(user, usdt), Amount: amount("1")}}, true},
+		{"balanced transfer", Transfer(UserAccount(user, usdt), PoolAccount(pool, usdt), amount("10")), false},
+		{
+			"balanced swap across two tokens",
+			append(
+				Transfer(UserAccount(user, usdt), PoolAccount(pool, usdt), amount("10")),
+				Transfer(PoolAccount(pool, lan), UserAccount(user, lan), amount("3.333333333333333333"))...,
+			),
+			false,
+		},
+		{
+			"unbalanced by one wei",
+			[]Posting{
+				{Account: UserAccount(user, usdt), Amount: amount("-10")},
+				{Account: PoolAccount(pool, usdt), Amount: amount("9.999999999999999999")},
+			},
+			true,
+		},
+		{
+			// Each token must balance on its own, not just the grand total
+			"balanced total but not per token",
+			[]Posting{
+				{Account: UserAccount(user, usdt), Amount: amount("-10")},
+				{Account: PoolAccount(pool, lan), Amount: amount("10")},
+			},
+			true,
+		},
+		{
+			"missing token",
+			[]Posting{
+				{Account: SystemAccount(AccountTreasury, uuid.Nil), Amount: amount("-1")},
+				{Account: UserAccount(user, uuid.Nil), Amount: amount("1")},
+			},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateEntry(tt.postings)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateEntry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRequireBalance(t *testing.T) {
+	token := uuid.New()
+	balances := map[uuid.UUID]decimal.Decimal{token: decimal.NewFromInt(100)}
+
+	tests := []struct {
+		name    string
+		tokenID uuid.UUID
+		need    string
+		wantErr bool
+	}{
+		{"less than balance", token, "99.999999999999999999", false},
+		{"exact balance", token, "100", false},
+		{"more than balance", token, "100.000000000000000001", true},
+		{"token without a row", uuid.New(), "1", true},
+		{"nothing needed from a missing row", uuid.New(), "0", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := requireBalance(balances, tt.tokenID, "USDT", decimal.RequireFromString(tt.need))
+			if tt.wantErr && !errors.Is(err, ErrInsufficientBalance) {
+				t.Errorf("requireBalance() error = %v, want ErrInsufficientBalance", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("requireBalance() error = %v, want nil", err)
+			}
+		})
+	}
+}
@@ -13,16 +13,20 @@ import (
 // LPService handles all liquidity pool-related business logic.
 type LPService struct {
 	queries   *db.Queries
+	tx        db.TxRunner
 	assetSvc  *AssetService
 	combatSvc *CombatPowerService
+	ledger    *LedgerService
 }
 
 // NewLPService creates a new liquidity pool service.
-func NewLPService(queries *db.Queries, assetSvc *AssetService, combatSvc *CombatPowerService) *LPService {
+func NewLPService(queries *db.Queries, tx db.TxRunner, assetSvc *AssetService, combatSvc *CombatPowerService, ledger *LedgerService) *LPService {
 	return &LPService{
 		queries:   queries,
+		tx:        tx,
 		assetSvc:  assetSvc,
 		combatSvc: combatSvc,
+		ledger:    ledger,
 	}
 }
 
@@ -65,6 +69,11 @@ func (s *LPService) ListPools(ctx context.Context) ([]db.LiquidityPool, error) {
 	return s.queries.ListLiquidityPools(ctx)
 }
 
+// GetPoolUserCount returns the number of users providing liquidity to a pool.
+func (s *LPService) GetPoolUserCount(ctx context.Context, poolID uuid.UUID) (int64, error) {
+	return s.queries.GetPoolUserCount(ctx, poolID)
+}
+
 // ---------------------------------------------------------------------
 // User LP Positions
 // ---------------------------------------------------------------------
@@ -74,6 +83,30 @@ func (s *LPService) GetUserLPPositions(ctx context.Context, userID uuid.UUID) ([
 	return s.queries.GetUserLPPositions(ctx, userID)
 }
 
+// GetLPPosition returns an LP position by ID, ensuring it belongs to the user.
+func (s *LPService) GetLPPosition(ctx context.Context, positionID, userID uuid.UUID) (*db.LpPosition, error) {
+	position, err := s.queries.GetLPPosition(ctx, positionID)
+	if err != nil || position.UserID != userID {
+		return nil, fmt.Errorf("position not found: %w", err)
+	}
+	return &position, nil
+}
+
+// GetUserLPTransactions returns a page of the user's add and remove
+// transactions, newest first.
+func (s *LPService) GetUserLPTransactions(ctx context.Context, userID uuid.UUID, limit, offset int32) ([]db.GetUserLPTransactionsRow, error) {
+	return s.queries.GetUserLPTransactions(ctx, db.GetUserLPTransactionsParams{
+		UserID: userID,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// CountUserLPTransactions returns the number of LP transactions a user has made.
+func (s *LPService) CountUserLPTransactions(ctx context.Context, userID uuid.UUID) (int64, error) {
+	return s.queries.CountUserLPTransactions(ctx, userID)
+}
+
 // GetUserLPPosition returns a user's position in a specific pool.
 func (s *LPService) GetUserLPPosition(ctx context.Context, userID, poolID uuid.UUID) (*db.LpPosition, error) {
 	position, err := s.queries.GetUserLPPosition(ctx, db.GetUserLPPositionParams{
@@ -109,160 +142,122 @@ type AddLiquidityParams struct {
 	TxHash  string
 }
 
-// AddLiquidity adds liquidity to a pool and mints LP tokens for the user.
-// It deducts the required tokens from the user's balance and creates/updates an LP position.
+// AddLiquidity adds liquidity to a pool and mints LP shares for the user.
+// The deposit is scaled down to the pool's current reserve ratio, so the
+// user is only charged the amounts the pool actually takes (recorded on the
+// LP transaction). The pool row and the user's balances are locked for the
+// duration, so the whole operation is all-or-nothing.
 func (s *LPService) AddLiquidity(ctx context.Context, params AddLiquidityParams) (*db.LpPosition, error) {
-	// 1. Get pool and its tokens
-	pool, err := s.GetPoolByID(ctx, params.PoolID)
-	if err != nil {
-		return nil, err
-	}
-	if !pool.IsActive {
-		return nil, fmt.Errorf("pool is not active")
-	}
-
-	token0, err := s.queries.GetTokenByID(ctx, pool.Token0ID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch token0: %w", err)
-	}
-	token1, err := s.queries.GetTokenByID(ctx, pool.Token1ID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch token1: %w", err)
-	}
-
-	// 2. Verify user has sufficient balances
-	bal0, err := s.assetSvc.GetUserTokenBalance(ctx, params.UserID, token0.Symbol)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch %s balance: %w", token0.Symbol, err)
-	}
-	if bal0.LessThan(params.Amount0) {
-		return nil, fmt.Errorf("insufficient %s balance: have %s, need %s",
-			token0.Symbol, bal0.String(), params.Amount0.String())
-	}
-
-	bal1, err := s.assetSvc.GetUserTokenBalance(ctx, params.UserID, token1.Symbol)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch %s balance: %w", token1.Symbol, err)
-	}
-	if bal1.LessThan(params.Amount1) {
-		return nil, fmt.Errorf("insufficient %s balance: have %s, need %s",
-			token1.Symbol, bal1.String(), params.Amount1.String())
-	}
-
-	// 3. Deduct tokens from user's balance
-	err = s.queries.SubtractUserBalance(ctx, db.SubtractUserBalanceParams{
-		UserID:  params.UserID,
-		TokenID: token0.ID,
-		Balance: params.Amount0,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to deduct %s: %w", token0.Symbol, err)
-	}
-
-	err = s.queries.SubtractUserBalance(ctx, db.SubtractUserBalanceParams{
-		UserID:  params.UserID,
-		TokenID: token1.ID,
-		Balance: params.Amount1,
-	})
-	if err != nil {
-		// Attempt to rollback token0 deduction? For simplicity, we'll just fail.
-		// In production, use a database transaction.
-		return nil, fmt.Errorf("failed to deduct %s: %w", token1.Symbol, err)
-	}
+	var position db.LpPosition
+	err := s.tx.WithTx(ctx, func(q *db.Queries) error {
+		// 1. Lock the pool and get its tokens
+		pool, err := q.LockLiquidityPool(ctx, params.PoolID)
+		if err != nil {
+			return fmt.Errorf("pool not found: %w", err)
+		}
+		if !pool.IsActive {
+			return fmt.Errorf("pool is not active")
+		}
 
-	// 4. Compute pool's total liquidity and total LP supply
-	totalLiquidityUSD := pool.TotalLiquidityUsd
-	totalLPSupply, err := s.queries.GetPoolTotalLPAmount(ctx, params.PoolID)
-	if err != nil {
-		totalLPSupply = decimal.Zero
-	}
+		token0, err := q.GetTokenByID(ctx, pool.Token0ID)
+		if err != nil {
+			return fmt.Errorf("failed to fetch token0: %w", err)
+		}
+		token1, err := q.GetTokenByID(ctx, pool.Token1ID)
+		if err != nil {
+			return fmt.Errorf("failed to fetch token1: %w", err)
+		}
 
-	// 5. Compute USD value of added liquidity
-	price0 := token0.PriceUsd
-	price1 := token1.PriceUsd
-	addedValue := params.Amount0.Mul(price0).Add(params.Amount1.Mul(price1))
+		// 2. Match the deposit to the pool ratio and compute shares to mint
+		amount0, amount1 := optimalDeposit(params.Amount0, params.Amount1, pool.Reserve0, pool.Reserve1)
+		lpAmount, err := sharesToMint(amount0, amount1, pool.Reserve0, pool.Reserve1, pool.LpTotalSupply)
+		if err != nil {
+			return err
+		}
 
-	// 6. Calculate LP tokens to mint
-	var lpAmount decimal.Decimal
-	var sharePercentage decimal.Decimal
+		// 3. Lock and verify user balances
+		balances, err := lockBalances(ctx, q, params.UserID, token0.ID, token1.ID)
+		if err != nil {
+			return err
+		}
+		if err := requireBalance(balances, token0.ID, token0.Symbol, amount0); err != nil {
+			return err
+		}
+		if err := requireBalance(balances, token1.ID, token1.Symbol, amount1); err != nil {
+			return err
+		}
 
-	if totalLPSupply.IsZero() || totalLiquidityUSD.IsZero() {
-		// First depositor – set initial LP amount equal to added value (or 1:1)
-		lpAmount = addedValue
-		sharePercentage = decimal.NewFromInt(100) // 100%
-	} else {
-		// LP tokens = (addedValue / totalLiquidityUSD) * totalLPSupply
-		lpAmount = addedValue.Mul(totalLPSupply).Div(totalLiquidityUSD)
-		sharePercentage = lpAmount.Div(totalLPSupply.Add(lpAmount)).Mul(decimal.NewFromInt(100))
-	}
+		// 4. Move both tokens from the user into the pool's reserve accounts
+		entry := JournalEntry{
+			Type:        "lp_add",
+			ReferenceID: &pool.ID,
+			Description: fmt.Sprintf("add liquidity %s/%s (tx %s)", token0.Symbol, token1.Symbol, params.TxHash),
+		}
+		entry.Add(Transfer(UserAccount(params.UserID, token0.ID), PoolAccount(pool.ID, token0.ID), amount0)...)
+		entry.Add(Transfer(UserAccount(params.UserID, token1.ID), PoolAccount(pool.ID, token1.ID), amount1)...)
+		if _, err := s.ledger.PostTx(ctx, q, entry); err != nil {
+			return fmt.Errorf("failed to deduct liquidity tokens: %w", err)
+		}
 
-	// 7. Create or update user's LP position
-	var position db.LpPosition
-	existing, err := s.queries.GetUserLPPosition(ctx, db.GetUserLPPositionParams{
-		UserID: params.UserID,
-		PoolID: params.PoolID,
-	})
-	if err == nil && existing != nil {
-		// Update existing position
-		err = s.queries.AddLpAmount(ctx, db.AddLpAmountParams{
-			UserID:          params.UserID,
-			PoolID:          params.PoolID,
-			LpAmount:        lpAmount,
-			SharePercentage: sharePercentage,
-		})
-		if err != nil {
-			return nil, fmt.Errorf("failed to update LP position: %w", err)
+		// 5. Grow the reserves and LP supply
+		newSupply := pool.LpTotalSupply.Add(lpAmount)
+		if err := setPoolReserves(ctx, q, &pool, pool.Reserve0.Add(amount0), pool.Reserve1.Add(amount1), newSupply); err != nil {
+			return err
 		}
-		// Re-fetch updated position
-		updated, err := s.queries.GetUserLPPosition(ctx, db.GetUserLPPositionParams{
+
+		// 6. Create or update user's LP position
+		existing, err := q.GetUserLPPosition(ctx, db.GetUserLPPositionParams{
 			UserID: params.UserID,
 			PoolID: params.PoolID,
 		})
-		if err != nil {
-			return nil, err
-		}
-		position = *updated
-	} else {
-		// Create new position
-		newPos, err := s.queries.CreateLPPosition(ctx, db.CreateLPPositionParams{
-			UserID:          params.UserID,
-			PoolID:          params.PoolID,
-			LpAmount:        lpAmount,
-			SharePercentage: sharePercentage,
-		})
-		if err != nil {
-			return nil, fmt.Errorf("failed to create LP position: %w", err)
+		if err == nil {
+			sharePercentage := existing.LpAmount.Add(lpAmount).Div(newSupply).Mul(decimal.NewFromInt(100))
+			if err := q.AddLpAmount(ctx, db.AddLpAmountParams{
+				UserID:          params.UserID,
+				PoolID:          params.PoolID,
+				LpAmount:        lpAmount,
+				SharePercentage: sharePercentage,
+			}); err != nil {
+				return fmt.Errorf("failed to update LP position: %w", err)
+			}
+			position, err = q.GetUserLPPosition(ctx, db.GetUserLPPositionParams{
+				UserID: params.UserID,
+				PoolID: params.PoolID,
+			})
+			if err != nil {
+				return err
+			}
+		} else {
+			position, err = q.CreateLPPosition(ctx, db.CreateLPPositionParams{
+				UserID:          params.UserID,
+				PoolID:          params.PoolID,
+				LpAmount:        lpAmount,
+				SharePercentage: lpAmount.Div(newSupply).Mul(decimal.NewFromInt(100)),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to create LP position: %w", err)
+			}
 		}
-		position = newPos
-	}
-
-	// 8. Record transaction
-	_, err = s.queries.CreateLPTransaction(ctx, db.CreateLPTransactionParams{
-		UserID:       params.UserID,
-		PositionID:   position.ID,
-		Type:         "add",
-		Token0Amount: params.Amount0,
-		Token1Amount: params.Amount1,
-		LpAmount:     lpAmount,
-		TxHash:       &params.TxHash,
-	})
-	if err != nil {
-		// Non-critical, log but don't fail
-		// logger.Error("failed to record LP transaction", "error", err)
-	}
 
-	// 9. Update pool's total liquidity
-	newTotalLiquidity := totalLiquidityUSD.Add(addedValue)
-	err = s.queries.UpdateLiquidityPool(ctx, db.UpdateLiquidityPoolParams{
-		ID:                params.PoolID,
-		TotalLiquidityUsd: newTotalLiquidity,
-		Apr:               pool.Apr, // unchanged for now
+		// 7. Record transaction
+		if _, err := q.CreateLPTransaction(ctx, db.CreateLPTransactionParams{
+			UserID:       params.UserID,
+			PositionID:   position.ID,
+			Type:         "add",
+			Token0Amount: amount0,
+			Token1Amount: amount1,
+			LpAmount:     lpAmount,
+			TxHash:       &params.TxHash,
+		}); err != nil {
+			return fmt.Errorf("failed to record LP transaction: %w", err)
+		}
+		return nil
 	})
 	if err != nil {
-		// Non-critical, log
+		return nil, err
 	}
 
-	// 10. Update user's combat power (LP weight)
+	// 8. Update user's combat power (LP weight)
 	if s.combatSvc != nil {
 		if err := s.combatSvc.UpdateCombatPower(ctx, params.UserID); err != nil {
 			// Log but don't fail
@@ -285,137 +280,102 @@ type RemoveLiquidityParams struct {
 	TxHash   string
 }
 
-// RemoveLiquidity burns LP tokens and returns the underlying tokens to the user.
+// RemoveLiquidity burns LP shares and returns the user's pro-rata part of
+// both reserves. The pool row is locked for the duration and all writes
+// share one transaction.
 func (s *LPService) RemoveLiquidity(ctx context.Context, params RemoveLiquidityParams) (amount0, amount1 decimal.Decimal, err error) {
-	// 1. Get pool and user position
-	pool, err := s.GetPoolByID(ctx, params.PoolID)
-	if err != nil {
-		return decimal.Zero, decimal.Zero, err
-	}
-
-	position, err := s.GetUserLPPosition(ctx, params.UserID, params.PoolID)
-	if err != nil {
-		return decimal.Zero, decimal.Zero, fmt.Errorf("no LP position found")
-	}
-
-	if position.LpAmount.LessThan(params.LpAmount) {
-		return decimal.Zero, decimal.Zero, fmt.Errorf("insufficient LP tokens: have %s, want %s",
-			position.LpAmount.String(), params.LpAmount.String())
-	}
-
-	// 2. Get pool's total liquidity and total LP supply
-	totalLiquidityUSD := pool.TotalLiquidityUsd
-	totalLPSupply, err := s.queries.GetPoolTotalLPAmount(ctx, params.PoolID)
-	if err != nil || totalLPSupply.IsZero() {
-		return decimal.Zero, decimal.Zero, fmt.Errorf("cannot calculate pool share")
-	}
-
-	// 3. Compute user's share of the pool
-	share := params.LpAmount.Div(totalLPSupply)
-
-	// 4. Compute token amounts to return
-	// We need token0 and token1 reserves in the pool.
-	// Since we don't track reserves directly, we approximate from total liquidity and token prices.
-	// This is a simplification – ideally we would track reserves.
-	token0, err := s.queries.GetTokenByID(ctx, pool.Token0ID)
-	if err != nil {
-		return decimal.Zero, decimal.Zero, err
-	}
-	token1, err := s.queries.GetTokenByID(ctx, pool.Token1ID)
-	if err != nil {
-		return decimal.Zero, decimal.Zero, err
-	}
-
-	// Approximate reserves: assume the pool maintains a 50/50 value split.
-	// This is a simplification – real AMM would have constant product.
-	poolValue0 := totalLiquidityUSD.Div(decimal.NewFromInt(2)) // 50% of TVL in token0
-	poolValue1 := totalLiquidityUSD.Sub(poolValue0)            // remaining in token1
-
-	reserve0 := poolValue0.Div(token0.PriceUsd)
-	reserve1 := poolValue1.Div(token1.PriceUsd)
-
-	amount0 = share.Mul(reserve0)
-	amount1 = share.Mul(reserve1)
+	err = s.tx.WithTx(ctx, func(q *db.Queries) error {
+		// 1. Lock pool and get user position
+		pool, err := q.LockLiquidityPool(ctx, params.PoolID)
+		if err != nil {
+			return fmt.Errorf("pool not found: %w", err)
+		}
 
-	// 5. Deduct LP tokens from user's position
-	newLpAmount := position.LpAmount.Sub(params.LpAmount)
-	var newSharePercentage decimal.Decimal
-	if newLpAmount.IsZero() {
-		// Remove position entirely
-		err = s.queries.DeleteLPPosition(ctx, db.DeleteLPPositionParams{
+		position, err := q.GetUserLPPosition(ctx, db.GetUserLPPositionParams{
 			UserID: params.UserID,
 			PoolID: params.PoolID,
 		})
 		if err != nil {
-			return decimal.Zero, decimal.Zero, fmt.Errorf("failed to delete LP position: %w", err)
+			return fmt.Errorf("no LP position found")
 		}
-	} else {
-		newTotalSupply := totalLPSupply.Sub(params.LpAmount)
-		if newTotalSupply.IsZero() {
-			newSharePercentage = decimal.NewFromInt(100)
-		} else {
-			newSharePercentage = newLpAmount.Div(newTotalSupply).Mul(decimal.NewFromInt(100))
+		if position.LpAmount.LessThan(params.LpAmount) {
+			return fmt.Errorf("insufficient LP tokens: have %s, want %s",
+				position.LpAmount.String(), params.LpAmount.String())
 		}
-		err = s.queries.SubtractLpAmount(ctx, db.SubtractLpAmountParams{
-			UserID:          params.UserID,
-			PoolID:          params.PoolID,
-			LpAmount:        params.LpAmount,
-			SharePercentage: newSharePercentage,
-		})
+
+		// 2. Compute the reserves owed for the burned shares
+		amount0, amount1, err = amountsForShares(params.LpAmount, pool.Reserve0, pool.Reserve1, pool.LpTotalSupply)
 		if err != nil {
-			return decimal.Zero, decimal.Zero, fmt.Errorf("failed to update LP position: %w", err)
+			return fmt.Errorf("cannot calculate pool share: %w", err)
 		}
-	}
 
-	// 6. Add tokens to user's balance
-	err = s.queries.AddUserBalance(ctx, db.AddUserBalanceParams{
-		UserID:  params.UserID,
-		TokenID: token0.ID,
-		Balance: amount0,
-	})
-	if err != nil {
-		return decimal.Zero, decimal.Zero, fmt.Errorf("failed to credit %s: %w", token0.Symbol, err)
-	}
+		token0, err := q.GetTokenByID(ctx, pool.Token0ID)
+		if err != nil {
+			return err
+		}
+		token1, err := q.GetTokenByID(ctx, pool.Token1ID)
+		if err != nil {
+			return err
+		}
 
-	err = s.queries.AddUserBalance(ctx, db.AddUserBalanceParams{
-		UserID:  params.UserID,
-		TokenID: token1.ID,
-		Balance: amount1,
-	})
-	if err != nil {
-		return decimal.Zero, decimal.Zero, fmt.Errorf("failed to credit %s: %w", token1.Symbol, err)
-	}
+		// 3. Deduct LP tokens from user's position
+		newSupply := pool.LpTotalSupply.Sub(params.LpAmount)
+		newLpAmount := position.LpAmount.Sub(params.LpAmount)
+		if newLpAmount.IsZero() {
+			// Remove position entirely
+			if err := q.DeleteLPPosition(ctx, db.DeleteLPPositionParams{
+				UserID: params.UserID,
+				PoolID: params.PoolID,
+			}); err != nil {
+				return fmt.Errorf("failed to delete LP position: %w", err)
+			}
+		} else {
+			if err := q.SubtractLpAmount(ctx, db.SubtractLpAmountParams{
+				UserID:          params.UserID,
+				PoolID:          params.PoolID,
+				LpAmount:        params.LpAmount,
+				SharePercentage: newLpAmount.Div(newSupply).Mul(decimal.NewFromInt(100)),
+			}); err != nil {
+				return fmt.Errorf("failed to update LP position: %w", err)
+			}
+		}
 
-	// 7. Record transaction
-	_, err = s.queries.CreateLPTransaction(ctx, db.CreateLPTransactionParams{
-		UserID:       params.UserID,
-		PositionID:   position.ID,
-		Type:         "remove",
-		Token0Amount: amount0,
-		Token1Amount: amount1,
-		LpAmount:     params.LpAmount,
-		TxHash:       &params.TxHash,
-	})
-	if err != nil {
-		// Log only
-	}
+		// 4. Return tokens from the pool's reserve accounts to the user
+		entry := JournalEntry{
+			Type:        "lp_remove",
+			ReferenceID: &pool.ID,
+			Description: fmt.Sprintf("remove liquidity %s/%s (tx %s)", token0.Symbol, token1.Symbol, params.TxHash),
+		}
+		entry.Add(Transfer(PoolAccount(pool.ID, token0.ID), UserAccount(params.UserID, token0.ID), amount0)...)
+		entry.Add(Transfer(PoolAccount(pool.ID, token1.ID), UserAccount(params.UserID, token1.ID), amount1)...)
+		if _, err := s.ledger.PostTx(ctx, q, entry); err != nil {
+			return fmt.Errorf("failed to credit liquidity tokens: %w", err)
+		}
 
-	// 8. Update pool's total liquidity
-	removedValue := amount0.Mul(token0.PriceUsd).Add(amount1.Mul(token1.PriceUsd))
-	newTotalLiquidity := totalLiquidityUSD.Sub(removedValue)
-	if newTotalLiquidity.LessThan(decimal.Zero) {
-		newTotalLiquidity = decimal.Zero
-	}
-	err = s.queries.UpdateLiquidityPool(ctx, db.UpdateLiquidityPoolParams{
-		ID:                params.PoolID,
-		TotalLiquidityUsd: newTotalLiquidity,
-		Apr:               pool.Apr,
+		// 5. Shrink the reserves and LP supply
+		if err := setPoolReserves(ctx, q, &pool, pool.Reserve0.Sub(amount0), pool.Reserve1.Sub(amount1), newSupply); err != nil {
+			return err
+		}
+
+		// 6. Record transaction
+		if _, err := q.CreateLPTransaction(ctx, db.CreateLPTransactionParams{
+			UserID:       params.UserID,
+			PositionID:   position.ID,
+			Type:         "remove",
+			Token0Amount: amount0,
+			Token1Amount: amount1,
+			LpAmount:     params.LpAmount,
+			TxHash:       &params.TxHash,
+		}); err != nil {
+			return fmt.Errorf("failed to record LP transaction: %w", err)
+		}
+		return nil
 	})
 	if err != nil {
-		// Log only
+		return decimal.Zero, decimal.Zero, err
 	}
 
-	// 9. Update user's combat power
+	// 7. Update user's combat power
 	if s.combatSvc != nil {
 		if err := s.combatSvc.UpdateCombatPower(ctx, params.UserID); err != nil {
 			// Log only
@@ -448,8 +408,8 @@ func (s *LPService) UpdatePoolAPR(ctx context.Context, poolID uuid.UUID, volume2
 		return err
 	}
 
-	// Assume swap fee is 0.3%
-	feeRate := decimal.NewFromFloat(0.003)
+	// Fees earned by LPs at the pool's fee tier
+	feeRate := decimal.NewFromInt32(pool.FeeBps).Div(bpsDenominator)
 	dailyFees := volume24h.Mul(feeRate)
 	// APR = (dailyFees * 365) / totalLiquidity * 100
 	var apr decimal.Decimal
@@ -4,6 +4,7 @@ package services
 import (
 	"context"
 	"fmt"
+	"log"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,19 +15,27 @@ import (
 // MiningService handles mining machine operations, daily earnings,
 // machine upgrades, and acceleration release.
 type MiningService struct {
-	queries   *db.Queries
-	assetSvc  *AssetService
-	combatSvc *CombatPowerService
-	badgeSvc  *BadgeService
+	queries     *db.Queries
+	assetSvc    *AssetService
+	combatSvc   *CombatPowerService
+	badgeSvc    *BadgeService
+	ledger      *LedgerService
+	vesting     *VestingService
+	commissions *CommissionService
+	nodeSvc     *NodeService
 }
 
 // NewMiningService creates a new mining service.
-func NewMiningService(queries *db.Queries, assetSvc *AssetService, combatSvc *CombatPowerService, badgeSvc *BadgeService) *MiningService {
+func NewMiningService(queries *db.Queries, assetSvc *AssetService, combatSvc *CombatPowerService, badgeSvc *BadgeService, ledger *LedgerService, vesting *VestingService, commissions *CommissionService, nodeSvc *NodeService) *MiningService {
 	return &MiningService{
-		queries:   queries,
-		assetSvc:  assetSvc,
-		combatSvc: combatSvc,
-		badgeSvc:  badgeSvc,
+		queries:     queries,
+		assetSvc:    assetSvc,
+		combatSvc:   combatSvc,
+		badgeSvc:    badgeSvc,
+		ledger:      ledger,
+		vesting:     vesting,
+		commissions: commissions,
+		nodeSvc:     nodeSvc,
 	}
 }
 
@@ -76,12 +85,13 @@ func (s *MiningService) UpgradeMachine(ctx context.Context, userID uuid.UUID) (*
 	if err != nil {
 		return nil, fmt.Errorf("LAN token not found: %w", err)
 	}
-	err = s.queries.SubtractUserBalance(ctx, db.SubtractUserBalanceParams{
-		UserID:  userID,
-		TokenID: lanToken.ID,
-		Balance: upgradeCost,
-	})
-	if err != nil {
+	entry := JournalEntry{
+		Type:        "mining_upgrade",
+		ReferenceID: &machine.ID,
+		Description: fmt.Sprintf("mining machine upgrade from level %d", machine.Level),
+	}
+	entry.Add(Transfer(UserAccount(userID, lanToken.ID), SystemAccount(AccountTreasury, lanToken.ID), upgradeCost)...)
+	if _, err := s.ledger.Post(ctx, entry); err != nil {
 		return nil, fmt.Errorf("failed to deduct LAN: %w", err)
 	}
 
@@ -158,6 +168,15 @@ func (s *MiningService) CalculateDailyEarnings(ctx context.Context, userID uuid.
 		miningBoostMultiplier = decimal.NewFromInt(1).Add(multipliers.MiningBoost)
 	}
 
+	// Node level boost, in basis points, stacks on top of badges
+	rights, err := s.nodeSvc.GetUserRights(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if rights.MiningBoostBps > 0 {
+		miningBoostMultiplier = miningBoostMultiplier.Add(decimal.NewFromInt(int64(rights.MiningBoostBps)).Div(bpsDenominator))
+	}
+
 	staticEarning := staticRate.Mul(miningBoostMultiplier)
 	accelerationEarning := accelerationRate.Mul(miningBoostMultiplier)
 
@@ -175,12 +194,7 @@ func (s *MiningService) CalculateDailyEarnings(ctx context.Context, userID uuid.
 // Should be called once per day per user (by a cron job or on user action).
 func (s *MiningService) AccrueDailyEarnings(ctx context.Context, userID uuid.UUID) (*MiningEarnings, error) {
 	// Check if we already accrued earnings today (prevent double accrual)
-	existing, err := s.queries.GetTodayMiningEarnings(ctx, db.GetTodayMiningEarningsParams{
-		UserID: userID,
-		// We'll need to adapt this – the query from mining.sql might return all earnings types.
-		// For simplicity, we'll assume a function that checks if any record exists for today.
-		// This is a placeholder; adjust based on actual queries.
-	})
+	existing, err := s.queries.GetTodayMiningEarnings(ctx, userID)
 	if err == nil && len(existing) > 0 {
 		return nil, fmt.Errorf("earnings already accrued today")
 	}
@@ -200,42 +214,56 @@ func (s *MiningService) AccrueDailyEarnings(ctx context.Context, userID uuid.UUI
 		return nil, fmt.Errorf("LAN token not found: %w", err)
 	}
 
-	// Add earnings to user's LAN balance
-	err = s.queries.AddUserBalance(ctx, db.AddUserBalanceParams{
-		UserID:  userID,
-		TokenID: lanToken.ID,
-		Balance: earnings.Total,
-	})
-	if err != nil {
+	// Mint earnings from the emission account; the locked share vests
+	// before it reaches the user's LAN balance
+	if _, err := s.vesting.Pay(ctx, Payout{
+		UserID:      userID,
+		TokenID:     lanToken.ID,
+		From:        SystemAccount(AccountEmission, lanToken.ID),
+		Amount:      earnings.Total,
+		Source:      VestingSourceMining,
+		EntryType:   "mining",
+		ReferenceID: &machine.ID,
+		Description: "daily mining earnings",
+	}); err != nil {
 		return nil, fmt.Errorf("failed to credit LAN earnings: %w", err)
 	}
 
+	// The miner's upline earns commissions on the day's earnings
+	if _, err := s.commissions.Pay(ctx, CommissionEvent{
+		UserID:      userID,
+		Source:      CommissionSourceMining,
+		TokenID:     lanToken.ID,
+		From:        SystemAccount(AccountEmission, lanToken.ID),
+		Amount:      earnings.Total,
+		ReferenceID: &machine.ID,
+	}); err != nil {
+		log.Printf("mining: failed to pay referral commissions for %s: %v", userID, err)
+	}
+
 	// Record static release
-	_, err = s.queries.AddMiningEarning(ctx, db.AddMiningEarningParams{
+	err = s.queries.AddMiningEarning(ctx, db.AddMiningEarningParams{
 		UserID:      userID,
 		MachineID:   machine.ID,
 		Amount:      earnings.StaticRelease,
 		EarningType: "static",
-		Date:        time.Now(),
 	})
 	if err != nil {
 		// Non-critical, log but continue
 	}
 
 	// Record acceleration release
-	_, err = s.queries.AddMiningEarning(ctx, db.AddMiningEarningParams{
+	err = s.queries.AddMiningEarning(ctx, db.AddMiningEarningParams{
 		UserID:      userID,
 		MachineID:   machine.ID,
 		Amount:      earnings.AccelerationRelease,
 		EarningType: "acceleration",
-		Date:        time.Now(),
 	})
 	if err != nil {
 		// Log only
 	}
 
 	// Update machine's total mined
-	// This requires a custom query – we'll implement via a helper.
 	err = s.queries.AddTotalMined(ctx, db.AddTotalMinedParams{
 		UserID: userID,
 		Amount: earnings.Total,
@@ -247,6 +275,49 @@ func (s *MiningService) AccrueDailyEarnings(ctx context.Context, userID uuid.UUI
 	return earnings, nil
 }
 
+// GetTodayMiningEarnings returns the earnings already accrued today, or nil
+// if the day has not been accrued yet.
+func (s *MiningService) GetTodayMiningEarnings(ctx context.Context, userID uuid.UUID) (*MiningEarnings, error) {
+	rows, err := s.queries.GetTodayMiningEarnings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	earnings := &MiningEarnings{}
+	for _, row := range rows {
+		switch row.EarningType {
+		case "static":
+			earnings.StaticRelease = earnings.StaticRelease.Add(row.Amount)
+		case "acceleration":
+			earnings.AccelerationRelease = earnings.AccelerationRelease.Add(row.Amount)
+		}
+		earnings.Total = earnings.Total.Add(row.Amount)
+	}
+	return earnings, nil
+}
+
+// GetEarningsHistory returns a page of the user's accrued earnings over the
+// last days days, newest first.
+func (s *MiningService) GetEarningsHistory(ctx context.Context, userID uuid.UUID, limit, offset int32, days int) ([]db.MiningEarning, error) {
+	return s.queries.GetMiningEarningsHistory(ctx, db.GetMiningEarningsHistoryParams{
+		UserID:    userID,
+		Days:      int32(days),
+		RowLimit:  limit,
+		RowOffset: offset,
+	})
+}
+
+// CountEarningsHistory counts the earnings rows GetEarningsHistory pages over.
+func (s *MiningService) CountEarningsHistory(ctx context.Context, userID uuid.UUID, days int) (int64, error) {
+	return s.queries.CountMiningEarningsHistory(ctx, db.CountMiningEarningsHistoryParams{
+		UserID: userID,
+		Days:   int32(days),
+	})
+}
+
 // ---------------------------------------------------------------------
 // Mining Statistics
 // ---------------------------------------------------------------------
@@ -333,26 +404,3 @@ func (s *MiningService) ProcessDailyMiningForAllUsers(ctx context.Context) error
 	}
 	return nil
 }
-
-// ---------------------------------------------------------------------
-// Helper Queries (to be added to sqlc queries if not present)
-// ---------------------------------------------------------------------
-
-// The following are pseudo‑queries – they should be defined in mining.sql.
-// For completeness, we assume they exist or we add them.
-
-func (q *db.Queries) AddTotalMined(ctx context.Context, arg db.AddTotalMinedParams) error {
-	// Implementation via SQLC – will be generated.
-	// UPDATE mining_machines SET total_mined = total_mined + $2 WHERE user_id = $1
-	return nil
-}
-
-func (q *db.Queries) GetMiningEarningsByDate(ctx context.Context, arg db.GetMiningEarningsByDateParams) (decimal.Decimal, error) {
-	// SELECT COALESCE(SUM(amount),0) FROM mining_earnings WHERE user_id = $1 AND date = $2
-	return decimal.Zero, nil
-}
-
-func (q *db.Queries) GetMiningEarningsSumByDateRange(ctx context.Context, arg db.GetMiningEarningsSumByDateRangeParams) (decimal.Decimal, error) {
-	// SELECT COALESCE(SUM(amount),0) FROM mining_earnings WHERE user_id = $1 AND date BETWEEN $2 AND $3
-	return decimal.Zero, nil
-}
@@ -4,25 +4,33 @@ package services
 import (
 	"context"
 	"fmt"
+	"log"
+	"sort"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/config"
 	"jd7008911/canlan.org/internal/db"
 )
 
 // NodeService handles all node level and referral network business logic.
 type NodeService struct {
 	queries     *db.Queries
+	rights      rightsReader
 	referralSvc *ReferralService
 	combatSvc   *CombatPowerService
+	cfg         *config.Config
 }
 
 // NewNodeService creates a new node service.
-func NewNodeService(queries *db.Queries, referralSvc *ReferralService, combatSvc *CombatPowerService) *NodeService {
+func NewNodeService(queries *db.Queries, referralSvc *ReferralService, combatSvc *CombatPowerService, cfg *config.Config) *NodeService {
 	return &NodeService{
 		queries:     queries,
+		rights:      queries,
 		referralSvc: referralSvc,
 		combatSvc:   combatSvc,
+		cfg:         cfg,
 	}
 }
 
@@ -174,9 +182,11 @@ func (s *NodeService) UpgradeNode(ctx context.Context, userID uuid.UUID) (int32,
 	return eligibility.NextLevel, nil
 }
 
-// GrantNodeBenefits applies the rights and gift limits associated with a node level.
+// GrantNodeBenefits raises the user's withdrawal limits to those of the node
+// level. The level's other rights apply whenever they are checked, so they
+// need no granting.
 func (s *NodeService) GrantNodeBenefits(ctx context.Context, userID uuid.UUID, level int32) error {
-	nodeLevel, err := s.GetNodeLevel(ctx, level)
+	rights, err := s.GetLevelRights(ctx, level)
 	if err != nil {
 		return err
 	}
@@ -195,21 +205,17 @@ func (s *NodeService) GrantNodeBenefits(ctx context.Context, userID uuid.UUID, l
 		}
 	}
 
-	// If node level has a higher daily limit, apply it
-	if nodeLevel.GiftLimit.GreaterThan(limits.DailyLimit) {
-		err = s.queries.UpdateWithdrawalLimits(ctx, db.UpdateWithdrawalLimitsParams{
-			UserID:       userID,
-			DailyLimit:   nodeLevel.GiftLimit,
-			MonthlyLimit: limits.MonthlyLimit, // keep existing monthly limit
-		})
-		if err != nil {
-			return err
-		}
+	// Raise each limit the level sets higher; limits are never lowered
+	daily := decimal.Max(limits.DailyLimit, rights.WithdrawalDailyLimit)
+	monthly := decimal.Max(limits.MonthlyLimit, rights.WithdrawalMonthlyLimit)
+	if daily.Equal(limits.DailyLimit) && monthly.Equal(limits.MonthlyLimit) {
+		return nil
 	}
-
-	// Other rights (JSON) could be stored in user_profiles or a separate table.
-	// For now, we just log that rights were granted.
-	return nil
+	return s.queries.UpdateWithdrawalLimits(ctx, db.UpdateWithdrawalLimitsParams{
+		UserID:       userID,
+		DailyLimit:   daily,
+		MonthlyLimit: monthly,
+	})
 }
 
 // ---------------------------------------------------------------------
@@ -222,11 +228,56 @@ func (s *NodeService) GetReferralAncestors(ctx context.Context, userID uuid.UUID
 }
 
 // GetReferralSubtree returns all users in the downline of a referrer.
-// Useful for team management views.
+// Large downlines should be browsed with GetTreePage or streamed with
+// ExportSubtree instead.
 func (s *NodeService) GetReferralSubtree(ctx context.Context, userID uuid.UUID) ([]db.GetReferralSubtreeRow, error) {
 	return s.queries.GetReferralSubtree(ctx, userID)
 }
 
+// GetReferralSubtreePage returns one page of the user's downline down to
+// maxDepth levels below them, nearest levels first.
+func (s *NodeService) GetReferralSubtreePage(ctx context.Context, userID uuid.UUID, maxDepth, limit, offset int32) ([]db.GetReferralSubtreePageRow, error) {
+	return s.queries.GetReferralSubtreePage(ctx, db.GetReferralSubtreePageParams{
+		UserID:    userID,
+		MaxDepth:  maxDepth,
+		RowLimit:  limit,
+		RowOffset: offset,
+	})
+}
+
+// CountReferralSubtree returns the size of the user's downline down to
+// maxDepth levels below them.
+func (s *NodeService) CountReferralSubtree(ctx context.Context, userID uuid.UUID, maxDepth int32) (int64, error) {
+	return s.queries.CountReferralSubtree(ctx, db.CountReferralSubtreeParams{
+		UserID:   userID,
+		MaxDepth: maxDepth,
+	})
+}
+
+// GetDirectReferrals returns a page of the users the user invited, newest first.
+func (s *NodeService) GetDirectReferrals(ctx context.Context, userID uuid.UUID, limit, offset int32) ([]db.User, error) {
+	return s.queries.GetDirectReferralsPage(ctx, db.GetDirectReferralsPageParams{
+		InvitedBy: userID,
+		RowLimit:  limit,
+		RowOffset: offset,
+	})
+}
+
+// CountDirectReferrals returns the number of users the user invited.
+func (s *NodeService) CountDirectReferrals(ctx context.Context, userID uuid.UUID) (int64, error) {
+	return s.queries.GetDirectReferralCount(ctx, userID)
+}
+
+// GetTeamPower returns the total personal power of the user's downline.
+func (s *NodeService) GetTeamPower(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+	return s.queries.GetTeamPower(ctx, userID)
+}
+
+// GetTeamMemberCount returns the number of users in the user's downline.
+func (s *NodeService) GetTeamMemberCount(ctx context.Context, userID uuid.UUID) (int64, error) {
+	return s.queries.GetTeamMemberCount(ctx, userID)
+}
+
 // GetReferralTreeDepth returns the maximum depth of the referral tree under a user.
 func (s *NodeService) GetReferralTreeDepth(ctx context.Context, userID uuid.UUID) (int32, error) {
 	depth, err := s.queries.GetReferralTreeDepth(ctx, userID)
@@ -237,18 +288,9 @@ func (s *NodeService) GetReferralTreeDepth(ctx context.Context, userID uuid.UUID
 }
 
 // ---------------------------------------------------------------------
-// Node Rights & Gift Limits
+// Gift Limits
 // ---------------------------------------------------------------------
 
-// GetUserRights returns the rights JSON for the user's current node level.
-func (s *NodeService) GetUserRights(ctx context.Context, userID uuid.UUID) ([]byte, error) {
-	rights, err := s.queries.GetUserRights(ctx, userID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user rights: %w", err)
-	}
-	return rights, nil
-}
-
 // GetUserGiftLimit returns the gift limit for the user's current node level.
 func (s *NodeService) GetUserGiftLimit(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
 	limit, err := s.queries.GetUserGiftLimit(ctx, userID)
@@ -328,23 +370,101 @@ func (s *NodeService) GetNetworkNodeStats(ctx context.Context) (*NetworkNodeStat
 // Maintenance & Batch Operations
 // ---------------------------------------------------------------------
 
-// RecalculateAllTeamStats updates team power and member counts for all users.
-// Should be run periodically or after major referral events.
-func (s *NodeService) RecalculateAllTeamStats(ctx context.Context) error {
-	return s.queries.RecalculateAllTeamStats(ctx)
+// teamDriftTop is how many of the largest drifts a repair report lists.
+const teamDriftTop = 20
+
+// TeamStatsDrift is one user's team stats before and after a repair.
+type TeamStatsDrift struct {
+	UserID              uuid.UUID       `json:"user_id"`
+	PreviousTeamPower   decimal.Decimal `json:"previous_team_power"`
+	TeamPower           decimal.Decimal `json:"team_power"`
+	PowerDrift          decimal.Decimal `json:"power_drift"` // stored minus recomputed
+	PreviousTeamMembers int32           `json:"previous_team_members"`
+	TeamMembers         int32           `json:"team_members"`
+}
+
+// TeamStatsDriftReport summarises how far incrementally maintained team
+// stats had drifted from a full recomputation.
+type TeamStatsDriftReport struct {
+	Repaired        int              `json:"repaired"`
+	TotalPowerDrift decimal.Decimal  `json:"total_power_drift"` // sum of absolute drifts
+	TopDrifts       []TeamStatsDrift `json:"top_drifts"`        // largest absolute drifts first
+}
+
+// RecalculateAllTeamStats recomputes team power and member counts for all
+// users from scratch and repairs every row that drifted. Team stats are
+// normally kept current by propagating deltas, so this is the periodic
+// repair job and the report should be empty.
+func (s *NodeService) RecalculateAllTeamStats(ctx context.Context) (*TeamStatsDriftReport, error) {
+	rows, err := s.queries.RecalculateAllTeamStats(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recalculate team stats: %w", err)
+	}
+
+	report := &TeamStatsDriftReport{
+		Repaired:        len(rows),
+		TotalPowerDrift: decimal.Zero,
+		TopDrifts:       make([]TeamStatsDrift, 0, len(rows)),
+	}
+	for _, row := range rows {
+		drift := row.PreviousTeamPower.Sub(row.TeamPower)
+		report.TotalPowerDrift = report.TotalPowerDrift.Add(drift.Abs())
+		report.TopDrifts = append(report.TopDrifts, TeamStatsDrift{
+			UserID:              row.UserID,
+			PreviousTeamPower:   row.PreviousTeamPower,
+			TeamPower:           row.TeamPower,
+			PowerDrift:          drift,
+			PreviousTeamMembers: row.PreviousTeamMembers,
+			TeamMembers:         row.TeamMembers,
+		})
+	}
+	sort.SliceStable(report.TopDrifts, func(i, j int) bool {
+		return report.TopDrifts[i].PowerDrift.Abs().GreaterThan(report.TopDrifts[j].PowerDrift.Abs())
+	})
+	if len(report.TopDrifts) > teamDriftTop {
+		report.TopDrifts = report.TopDrifts[:teamDriftTop]
+	}
+	return report, nil
+}
+
+// RunTeamStatsRepair runs RecalculateAllTeamStats every TeamRepairInterval
+// until ctx is cancelled, logging any drift it repairs.
+func (s *NodeService) RunTeamStatsRepair(ctx context.Context) {
+	interval := s.cfg.App.TeamRepairInterval
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		report, err := s.RecalculateAllTeamStats(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("team stats repair failed: %v", err)
+			}
+			continue
+		}
+		if report.Repaired > 0 {
+			log.Printf("team stats repair: fixed %d users, total power drift %s", report.Repaired, report.TotalPowerDrift.String())
+			for _, d := range report.TopDrifts {
+				log.Printf("team stats drift: user=%s power %s -> %s, members %d -> %d",
+					d.UserID, d.PreviousTeamPower.String(), d.TeamPower.String(), d.PreviousTeamMembers, d.TeamMembers)
+			}
+		}
+	}
 }
 
 // BatchUpgradeNodes attempts to upgrade all eligible users.
 // Returns count of users upgraded.
 func (s *NodeService) BatchUpgradeNodes(ctx context.Context) (int64, error) {
-	result, err := s.queries.BatchUpgradeNodes(ctx)
-	if err != nil {
-		return 0, err
-	}
-	// result.RowsAffected() – need to adapt based on sqlc/pgx.
-	// For simplicity, we'll re‑query and count.
-	// This is a placeholder – actual implementation may vary.
-	return 0, nil
+	return s.queries.BatchUpgradeNodes(ctx)
 }
 
 // ---------------------------------------------------------------------
@@ -352,12 +472,16 @@ func (s *NodeService) BatchUpgradeNodes(ctx context.Context) (int64, error) {
 // ---------------------------------------------------------------------
 
 // CreateNodeLevel adds a new node level definition.
-func (s *NodeService) CreateNodeLevel(ctx context.Context, level int32, requiredTeamPower decimal.Decimal, requiredDirectMembers int32, rights []byte, giftLimit decimal.Decimal) (*db.NodeLevel, error) {
+func (s *NodeService) CreateNodeLevel(ctx context.Context, level int32, requiredTeamPower decimal.Decimal, requiredDirectMembers int32, rights NodeRights, giftLimit decimal.Decimal) (*db.NodeLevel, error) {
+	encoded, err := encodeNodeRights(rights)
+	if err != nil {
+		return nil, err
+	}
 	nodeLevel, err := s.queries.CreateNodeLevel(ctx, db.CreateNodeLevelParams{
 		Level:                 level,
 		RequiredTeamPower:     requiredTeamPower,
 		RequiredDirectMembers: requiredDirectMembers,
-		Rights:                rights,
+		Rights:                encoded,
 		GiftLimit:             giftLimit,
 	})
 	if err != nil {
@@ -366,13 +490,18 @@ func (s *NodeService) CreateNodeLevel(ctx context.Context, level int32, required
 	return &nodeLevel, nil
 }
 
-// UpdateNodeLevel modifies an existing node level.
-func (s *NodeService) UpdateNodeLevel(ctx context.Context, level int32, requiredTeamPower decimal.Decimal, requiredDirectMembers int32, rights []byte, giftLimit decimal.Decimal) (*db.NodeLevel, error) {
+// UpdateNodeLevel modifies an existing node level. New withdrawal limits
+// apply to users reaching the level from now on.
+func (s *NodeService) UpdateNodeLevel(ctx context.Context, level int32, requiredTeamPower decimal.Decimal, requiredDirectMembers int32, rights NodeRights, giftLimit decimal.Decimal) (*db.NodeLevel, error) {
+	encoded, err := encodeNodeRights(rights)
+	if err != nil {
+		return nil, err
+	}
 	nodeLevel, err := s.queries.UpdateNodeLevel(ctx, db.UpdateNodeLevelParams{
 		Level:                 level,
 		RequiredTeamPower:     requiredTeamPower,
 		RequiredDirectMembers: requiredDirectMembers,
-		Rights:                rights,
+		Rights:                encoded,
 		GiftLimit:             giftLimit,
 	})
 	if err != nil {
@@ -0,0 +1,286 @@
+// internal/services/node_rights_test.go
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"jd7008911/canlan.org/internal/auth"
+)
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestNodeRightsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rights  NodeRights
+		wantErr bool
+	}{
+		{"zero value", NodeRights{}, false},
+		{"full discount", NodeRights{SwapFeeDiscountBps: 10000}, false},
+		{"no commission depth", NodeRights{CommissionDepth: intPtr(0)}, false},
+		{"limits and boost", NodeRights{WithdrawalDailyLimit: dec("1000"), WithdrawalMonthlyLimit: dec("20000"), MiningBoostBps: 2500}, false},
+		{"negative discount", NodeRights{SwapFeeDiscountBps: -1}, true},
+		{"discount above 100%", NodeRights{SwapFeeDiscountBps: 10001}, true},
+		{"negative commission depth", NodeRights{CommissionDepth: intPtr(-1)}, true},
+		{"negative daily limit", NodeRights{WithdrawalDailyLimit: dec("-1")}, true},
+		{"negative monthly limit", NodeRights{WithdrawalMonthlyLimit: dec("-0.000000000000000001")}, true},
+		{"negative mining boost", NodeRights{MiningBoostBps: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rights.Validate()
+			if tt.wantErr && !errors.Is(err, ErrInvalidNodeRights) {
+				t.Errorf("Validate() error = %v, want ErrInvalidNodeRights", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Validate() error = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestNodeRightsAllows(t *testing.T) {
+	full := &NodeRights{
+		SwapFeeDiscountBps:   500,
+		CommissionDepth:      intPtr(3),
+		CreateProposals:      true,
+		WithdrawalDailyLimit: dec("1000"),
+		MiningBoostBps:       1000,
+	}
+	monthlyOnly := &NodeRights{WithdrawalMonthlyLimit: dec("5000")}
+
+	tests := []struct {
+		name       string
+		rights     *NodeRights
+		permission string
+		want       bool
+		wantErr    bool
+	}{
+		{"create proposal granted", full, PermissionCreateProposal, true, false},
+		{"create proposal denied", &NodeRights{}, PermissionCreateProposal, false, false},
+		{"fee discount granted", full, PermissionSwapFeeDiscount, true, false},
+		{"fee discount denied", &NodeRights{}, PermissionSwapFeeDiscount, false, false},
+		{"mining boost granted", full, PermissionMiningBoost, true, false},
+		{"mining boost denied", &NodeRights{}, PermissionMiningBoost, false, false},
+		{"raised daily limit", full, PermissionWithdrawalLimits, true, false},
+		{"raised monthly limit", monthlyOnly, PermissionWithdrawalLimits, true, false},
+		{"limits unchanged", &NodeRights{}, PermissionWithdrawalLimits, false, false},
+		{"commissions at every tier", &NodeRights{}, PermissionCommissionDepth, true, false},
+		{"commissions at first level", full, PermissionCommissionDepth, true, false},
+		{"commissions switched off", &NodeRights{CommissionDepth: intPtr(0)}, PermissionCommissionDepth, false, false},
+		{"within commission depth", full, PermissionCommissionDepth + ":3", true, false},
+		{"beyond commission depth", full, PermissionCommissionDepth + ":4", false, false},
+		{"any depth without a limit", &NodeRights{}, PermissionCommissionDepth + ":50", true, false},
+		{"depth zero", full, PermissionCommissionDepth + ":0", false, true},
+		{"depth not a number", full, PermissionCommissionDepth + ":x", false, true},
+		{"empty depth", full, PermissionCommissionDepth + ":", false, true},
+		{"unknown permission", full, "governance:veto", false, true},
+		{"empty permission", full, "", false, true},
+		{"undefined level denies commissions", noRights(), PermissionCommissionDepth, false, false},
+		{"undefined level denies proposals", noRights(), PermissionCreateProposal, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.rights.Allows(tt.permission)
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnknownPermission) {
+					t.Errorf("Allows(%q) error = %v, want ErrUnknownPermission", tt.permission, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Allows(%q) error = %v", tt.permission, err)
+			}
+			if got != tt.want {
+				t.Errorf("Allows(%q) = %v, want %v", tt.permission, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEarnsAtDepth(t *testing.T) {
+	tests := []struct {
+		name   string
+		rights *NodeRights
+		depth  int
+		want   bool
+	}{
+		{"unlimited", &NodeRights{}, 10, true},
+		{"within depth", &NodeRights{CommissionDepth: intPtr(2)}, 2, true},
+		{"beyond depth", &NodeRights{CommissionDepth: intPtr(2)}, 3, false},
+		{"undefined level", noRights(), 1, false},
+	}
+	for _, tt := range tests {
+		if got := tt.rights.EarnsAtDepth(tt.depth); got != tt.want {
+			t.Errorf("%s: EarnsAtDepth(%d) = %v, want %v", tt.name, tt.depth, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeNodeRights(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    NodeRights
+		wantErr bool
+	}{
+		{"missing", "", NodeRights{}, false},
+		{"null", "null", NodeRights{}, false},
+		{"empty object", "{}", NodeRights{}, false},
+		{
+			"all rights",
+			`{"swap_fee_discount_bps":500,"commission_depth":2,"create_proposals":true,"withdrawal_daily_limit":"1000","withdrawal_monthly_limit":20000,"mining_boost_bps":100}`,
+			NodeRights{SwapFeeDiscountBps: 500, CommissionDepth: intPtr(2), CreateProposals: true, WithdrawalDailyLimit: dec("1000"), WithdrawalMonthlyLimit: dec("20000"), MiningBoostBps: 100},
+			false,
+		},
+		{"unknown keys ignored", `{"create_proposals":true,"vip_lounge":true}`, NodeRights{CreateProposals: true}, false},
+		{"not JSON", "{", NodeRights{}, true},
+		{"wrong type", `{"create_proposals":"yes"}`, NodeRights{}, true},
+		{"out of range", `{"swap_fee_discount_bps":20000}`, NodeRights{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeNodeRights([]byte(tt.raw))
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidNodeRights) {
+					t.Errorf("DecodeNodeRights() error = %v, want ErrInvalidNodeRights", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeNodeRights() error = %v", err)
+			}
+			if !equalNodeRights(*got, tt.want) {
+				t.Errorf("DecodeNodeRights() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeNodeRights(t *testing.T) {
+	rights := NodeRights{SwapFeeDiscountBps: 250, CommissionDepth: intPtr(0), WithdrawalDailyLimit: dec("10.5")}
+	raw, err := encodeNodeRights(rights)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeNodeRights(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalNodeRights(*got, rights) {
+		t.Errorf("round trip = %+v, want %+v", *got, rights)
+	}
+
+	if _, err := encodeNodeRights(NodeRights{MiningBoostBps: -1}); !errors.Is(err, ErrInvalidNodeRights) {
+		t.Errorf("encodeNodeRights() error = %v, want ErrInvalidNodeRights", err)
+	}
+}
+
+func equalNodeRights(a, b NodeRights) bool {
+	if (a.CommissionDepth == nil) != (b.CommissionDepth == nil) ||
+		(a.CommissionDepth != nil && *a.CommissionDepth != *b.CommissionDepth) {
+		return false
+	}
+	return a.SwapFeeDiscountBps == b.SwapFeeDiscountBps &&
+		a.CreateProposals == b.CreateProposals &&
+		a.WithdrawalDailyLimit.Equal(b.WithdrawalDailyLimit) &&
+		a.WithdrawalMonthlyLimit.Equal(b.WithdrawalMonthlyLimit) &&
+		a.MiningBoostBps == b.MiningBoostBps
+}
+
+func TestFeeRebate(t *testing.T) {
+	tests := []struct {
+		fee         string
+		discountBps int
+		want        string
+	}{
+		{"3", 0, "0"},
+		{"3", 2500, "0.75"},
+		{"3", 10000, "3"},
+		// Rounded down, so the treasury never refunds more than the discount
+		{"0.00000000000000015", 5000, "0.000000000000000075"},
+		{"0.000000000000000099", 5000, "0.000000000000000049"},
+	}
+	for _, tt := range tests {
+		got := feeRebate(dec(tt.fee), &NodeRights{SwapFeeDiscountBps: tt.discountBps})
+		if !got.Equal(dec(tt.want)) {
+			t.Errorf("feeRebate(%s, %d) = %s, want %s", tt.fee, tt.discountBps, got, tt.want)
+		}
+	}
+}
+
+// fakeRights serves node_levels.rights per user; users not in the map have
+// a level without a definition.
+type fakeRights struct {
+	byUser map[uuid.UUID][]byte
+	err    error
+}
+
+func (f fakeRights) GetUserRights(ctx context.Context, id uuid.UUID) ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	raw, ok := f.byUser[id]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+	return raw, nil
+}
+
+func TestRequirePermissionWithNodeRights(t *testing.T) {
+	proposer, member, undefined, corrupt := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	rights := fakeRights{byUser: map[uuid.UUID][]byte{
+		proposer: []byte(`{"create_proposals":true}`),
+		member:   []byte(`{"swap_fee_discount_bps":500}`),
+		corrupt:  []byte(`{"swap_fee_discount_bps":-1}`),
+	}}
+
+	tests := []struct {
+		name       string
+		rights     fakeRights
+		userID     *uuid.UUID
+		permission string
+		wantCode   int
+	}{
+		{"level grants the permission", rights, &proposer, PermissionCreateProposal, http.StatusOK},
+		{"level lacks the permission", rights, &member, PermissionCreateProposal, http.StatusForbidden},
+		{"level has no definition", rights, &undefined, PermissionCreateProposal, http.StatusForbidden},
+		{"rights fail validation", rights, &corrupt, PermissionCreateProposal, http.StatusForbidden},
+		{"unknown permission", rights, &proposer, "governance:veto", http.StatusForbidden},
+		{"lookup fails", fakeRights{err: errors.New("connection reset")}, &proposer, PermissionCreateProposal, http.StatusForbidden},
+		{"unauthenticated", rights, nil, PermissionCreateProposal, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodeSvc := &NodeService{rights: tt.rights}
+			walletAuth := auth.NewWalletAuth(auth.WalletAuthOptions{JWTSecret: "test", Store: auth.NewMemoryStore()})
+
+			reached := false
+			h := walletAuth.RequirePermission(tt.permission, nodeSvc.HasPermission)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+			}))
+
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/governance/proposals", nil)
+			if tt.userID != nil {
+				r = r.WithContext(context.WithValue(r.Context(), auth.UserIDKey, *tt.userID))
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if reached != (tt.wantCode == http.StatusOK) {
+				t.Errorf("handler reached = %v with status %d", reached, w.Code)
+			}
+		})
+	}
+}
@@ -13,27 +13,39 @@ import (
 
 // PurchaseService handles token purchase/subscription operations.
 type PurchaseService struct {
-	queries   *db.Queries
-	assetSvc  *AssetService
-	nodeSvc   *NodeService
-	badgeSvc  *BadgeService
-	combatSvc *CombatPowerService
+	queries     *db.Queries
+	tx          db.TxRunner
+	assetSvc    *AssetService
+	nodeSvc     *NodeService
+	badgeSvc    *BadgeService
+	combatSvc   *CombatPowerService
+	ledger      *LedgerService
+	vesting     *VestingService
+	commissions *CommissionService
 }
 
 // NewPurchaseService creates a new purchase service.
 func NewPurchaseService(
 	queries *db.Queries,
+	tx db.TxRunner,
 	assetSvc *AssetService,
 	nodeSvc *NodeService,
 	badgeSvc *BadgeService,
 	combatSvc *CombatPowerService,
+	ledger *LedgerService,
+	vesting *VestingService,
+	commissions *CommissionService,
 ) *PurchaseService {
 	return &PurchaseService{
-		queries:   queries,
-		assetSvc:  assetSvc,
-		nodeSvc:   nodeSvc,
-		badgeSvc:  badgeSvc,
-		combatSvc: combatSvc,
+		queries:     queries,
+		tx:          tx,
+		assetSvc:    assetSvc,
+		nodeSvc:     nodeSvc,
+		badgeSvc:    badgeSvc,
+		combatSvc:   combatSvc,
+		ledger:      ledger,
+		vesting:     vesting,
+		commissions: commissions,
 	}
 }
 
@@ -49,8 +61,8 @@ type SubscribeParams struct {
 	PaymentToken string          // usually "USDT"
 }
 
-// Subscribe creates a pending purchase record and deducts the payment.
-// It returns the purchase record and the total cost.
+// Subscribe creates a pending purchase record and deducts the payment
+// atomically. It returns the purchase record and the total cost.
 func (s *PurchaseService) Subscribe(ctx context.Context, params SubscribeParams) (*db.Purchase, decimal.Decimal, error) {
 	// 1. Validate token exists and is purchasable
 	token, err := s.queries.GetTokenBySymbol(ctx, params.TokenSymbol)
@@ -61,11 +73,11 @@ func (s *PurchaseService) Subscribe(ctx context.Context, params SubscribeParams)
 		return nil, decimal.Zero, fmt.Errorf("token %s is not active", params.TokenSymbol)
 	}
 
-	// 2. Get price and calculate total cost
-	price := token.PriceUsd
-	if price.IsZero() {
-		return nil, decimal.Zero, fmt.Errorf("token price not available")
+	// 2. Get price and calculate total cost; never sell at a stale price
+	if err := s.assetSvc.RequireFreshPrice(token); err != nil {
+		return nil, decimal.Zero, err
 	}
+	price := token.PriceUsd
 	totalValue := params.Amount.Mul(price)
 
 	// 3. Get payment token ID
@@ -74,42 +86,45 @@ func (s *PurchaseService) Subscribe(ctx context.Context, params SubscribeParams)
 		return nil, decimal.Zero, fmt.Errorf("payment token not supported: %s", params.PaymentToken)
 	}
 
-	// 4. Check user's balance of payment token
-	balance, err := s.assetSvc.GetUserTokenBalance(ctx, params.UserID, params.PaymentToken)
-	if err != nil {
-		return nil, decimal.Zero, fmt.Errorf("failed to fetch balance: %w", err)
-	}
-	if balance.LessThan(totalValue) {
-		return nil, decimal.Zero, fmt.Errorf("insufficient %s balance: have %s, need %s",
-			params.PaymentToken, balance.String(), totalValue.String())
-	}
+	// 4. Lock the payment balance, create the pending purchase and move the
+	// payment to the treasury in one transaction.
+	var purchase db.Purchase
+	err = s.tx.WithTx(ctx, func(q *db.Queries) error {
+		balances, err := lockBalances(ctx, q, params.UserID, paymentToken.ID)
+		if err != nil {
+			return err
+		}
+		if err := requireBalance(balances, paymentToken.ID, params.PaymentToken, totalValue); err != nil {
+			return err
+		}
 
-	// 5. Deduct payment token from user's balance
-	err = s.queries.SubtractUserBalance(ctx, db.SubtractUserBalanceParams{
-		UserID:  params.UserID,
-		TokenID: paymentToken.ID,
-		Balance: totalValue,
-	})
-	if err != nil {
-		return nil, decimal.Zero, fmt.Errorf("failed to deduct payment: %w", err)
-	}
+		// 5. Create purchase record (pending, 15 minutes to complete on‑chain)
+		purchase, err = q.CreatePurchase(ctx, db.CreatePurchaseParams{
+			UserID:         params.UserID,
+			TokenID:        token.ID,
+			Amount:         params.Amount,
+			PriceUsd:       price,
+			TotalValue:     totalValue,
+			PaymentTokenID: paymentToken.ID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create purchase record: %w", err)
+		}
 
-	// 6. Create purchase record (pending)
-	expiry := time.Now().Add(15 * time.Minute) // 15 minutes to complete on‑chain
-	purchase, err := s.queries.CreatePurchase(ctx, db.CreatePurchaseParams{
-		UserID:         params.UserID,
-		TokenID:        token.ID,
-		Amount:         params.Amount,
-		PriceUsd:       price,
-		TotalValue:     totalValue,
-		PaymentTokenID: paymentToken.ID,
-		Status:         "pending",
-		ExpiryDate:     &expiry,
+		// 6. Move the payment from the user to the treasury
+		entry := JournalEntry{
+			Type:        "purchase_payment",
+			ReferenceID: &purchase.ID,
+			Description: fmt.Sprintf("payment for %s %s", params.Amount.String(), params.TokenSymbol),
+		}
+		entry.Add(Transfer(UserAccount(params.UserID, paymentToken.ID), SystemAccount(AccountTreasury, paymentToken.ID), totalValue)...)
+		if _, err := s.ledger.PostTx(ctx, q, entry); err != nil {
+			return fmt.Errorf("failed to deduct payment: %w", err)
+		}
+		return nil
 	})
 	if err != nil {
-		// Attempt to rollback the balance deduction? For simplicity, we return error.
-		// In production, use a database transaction.
-		return nil, decimal.Zero, fmt.Errorf("failed to create purchase record: %w", err)
+		return nil, decimal.Zero, err
 	}
 
 	return &purchase, totalValue, nil
@@ -119,44 +134,80 @@ func (s *PurchaseService) Subscribe(ctx context.Context, params SubscribeParams)
 // It adds the purchased tokens to the user's balance, updates purchase status,
 // and triggers post‑purchase effects (badges, node stats, combat power).
 func (s *PurchaseService) CompletePurchase(ctx context.Context, purchaseID uuid.UUID, txHash string) (*db.Purchase, error) {
-	// 1. Get purchase record
-	purchase, err := s.queries.GetPurchaseByID(ctx, purchaseID)
-	if err != nil {
-		return nil, fmt.Errorf("purchase not found: %w", err)
-	}
+	// 1-4. Lock the purchase, deliver the tokens, pay referral commissions
+	// and mark it completed in one transaction, so a purchase cannot be
+	// completed (and paid out) twice or completed without its commissions.
+	var purchase db.Purchase
+	expired := false
+	err := s.tx.WithTx(ctx, func(q *db.Queries) error {
+		var err error
+		purchase, err = q.GetPurchaseForUpdate(ctx, purchaseID)
+		if err != nil {
+			return fmt.Errorf("purchase not found: %w", err)
+		}
 
-	// 2. Verify it's still pending and not expired
-	if purchase.Status != "pending" {
-		return nil, fmt.Errorf("purchase is not pending (status: %s)", purchase.Status)
-	}
-	if purchase.ExpiryDate != nil && purchase.ExpiryDate.Before(time.Now()) {
-		// Mark as expired and return error
-		s.queries.UpdatePurchaseStatus(ctx, db.UpdatePurchaseStatusParams{
-			ID:     purchaseID,
-			Status: "expired",
-			TxHash: nil,
-		})
-		return nil, fmt.Errorf("purchase has expired")
-	}
+		// Verify it's still pending and not expired
+		if purchase.Status != "pending" {
+			return fmt.Errorf("purchase is not pending (status: %s)", purchase.Status)
+		}
+		if purchase.ExpiryDate != nil && purchase.ExpiryDate.Before(time.Now()) {
+			expired = true
+			return q.UpdatePurchaseStatus(ctx, db.UpdatePurchaseStatusParams{
+				ID:     purchaseID,
+				Status: "expired",
+				TxHash: nil,
+			})
+		}
 
-	// 3. Add purchased tokens to user's balance
-	err = s.queries.AddUserBalance(ctx, db.AddUserBalanceParams{
-		UserID:  purchase.UserID,
-		TokenID: purchase.TokenID,
-		Balance: purchase.Amount,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to credit tokens: %w", err)
-	}
+		// Deliver purchased tokens from the treasury to the user
+		entry := JournalEntry{
+			Type:        "purchase",
+			ReferenceID: &purchase.ID,
+			Description: fmt.Sprintf("purchase completed (tx %s)", txHash),
+		}
+		entry.Add(Transfer(SystemAccount(AccountTreasury, purchase.TokenID), UserAccount(purchase.UserID, purchase.TokenID), purchase.Amount)...)
+		if _, err := s.ledger.PostTx(ctx, q, entry); err != nil {
+			return fmt.Errorf("failed to credit tokens: %w", err)
+		}
 
-	// 4. Update purchase status to completed
-	err = s.queries.UpdatePurchaseStatus(ctx, db.UpdatePurchaseStatusParams{
-		ID:     purchaseID,
-		Status: "completed",
-		TxHash: &txHash,
+		// Pay referral commissions on the purchase value to the purchaser's
+		// upline
+		buyer, err := q.GetUserByID(ctx, purchase.UserID)
+		if err != nil {
+			return fmt.Errorf("user not found: %w", err)
+		}
+		if buyer.InvitedBy != nil {
+			usdtToken, err := q.GetTokenBySymbol(ctx, "USDT")
+			if err != nil {
+				return fmt.Errorf("failed to load USDT token: %w", err)
+			}
+			if _, err := s.commissions.PayTx(ctx, q, CommissionEvent{
+				UserID:      purchase.UserID,
+				Source:      CommissionSourcePurchase,
+				TokenID:     usdtToken.ID,
+				From:        SystemAccount(AccountTreasury, usdtToken.ID),
+				Amount:      purchase.TotalValue,
+				ReferenceID: &purchase.ID,
+			}); err != nil {
+				return fmt.Errorf("failed to pay referral commissions: %w", err)
+			}
+		}
+
+		// Update purchase status to completed
+		if err := q.UpdatePurchaseStatus(ctx, db.UpdatePurchaseStatusParams{
+			ID:     purchaseID,
+			Status: "completed",
+			TxHash: &txHash,
+		}); err != nil {
+			return fmt.Errorf("failed to update purchase status: %w", err)
+		}
+		return nil
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to update purchase status: %w", err)
+		return nil, err
+	}
+	if expired {
+		return nil, fmt.Errorf("purchase has expired")
 	}
 
 	// 5. Update combat power (user now holds more tokens)
@@ -167,43 +218,19 @@ func (s *PurchaseService) CompletePurchase(ctx context.Context, purchaseID uuid.
 		}
 	}
 
-	// 6. Process referral rewards if the purchaser was referred
+	// 6. Update node team power for ancestors (since user now has more combat power)
 	user, err := s.queries.GetUserByID(ctx, purchase.UserID)
-	if err == nil && user.InvitedBy != nil {
-		// Award referrer a percentage of the purchase value (e.g., 10%)
-		referrerID := *user.InvitedBy
-		rewardAmount := purchase.TotalValue.Mul(decimal.NewFromFloat(0.10)) // 10% commission
-
-		// Get USDT token ID
-		usdtToken, _ := s.queries.GetTokenBySymbol(ctx, "USDT")
-
-		// Add reward to referrer's balance
-		_ = s.queries.AddUserBalance(ctx, db.AddUserBalanceParams{
-			UserID:  referrerID,
-			TokenID: usdtToken.ID,
-			Balance: rewardAmount,
-		})
-
-		// Record referral earning (optional)
-		// This would require a table and query; we skip for brevity.
-	}
-
-	// 7. Check and award badges (e.g., "First Purchase", "Silver Investor", etc.)
-	if s.badgeSvc != nil {
-		go func() {
-			_ = s.badgeSvc.CheckAndAwardPurchaseBadges(context.Background(), purchase.UserID)
-		}()
-	}
-
-	// 8. Update node team power for ancestors (since user now has more combat power)
-	if user != nil && user.InvitedBy != nil && s.nodeSvc != nil {
+	if err == nil && user.InvitedBy != nil && s.nodeSvc != nil {
 		go func() {
 			_ = s.nodeSvc.RecalculateTeamStats(context.Background(), *user.InvitedBy)
 		}()
 	}
 
 	// Refresh purchase record to get updated status
-	updated, _ := s.queries.GetPurchaseByID(ctx, purchaseID)
+	updated, _ := s.queries.GetPurchaseByID(ctx, db.GetPurchaseByIDParams{
+		ID:     purchaseID,
+		UserID: purchase.UserID,
+	})
 	return &updated, nil
 }
 
@@ -232,6 +259,44 @@ func (s *PurchaseService) GetUserPurchases(ctx context.Context, userID uuid.UUID
 	})
 }
 
+// CountUserPurchases returns the number of purchases a user has made.
+func (s *PurchaseService) CountUserPurchases(ctx context.Context, userID uuid.UUID) (int64, error) {
+	return s.queries.CountUserPurchases(ctx, userID)
+}
+
+// CancelPurchase cancels a pending purchase and refunds the payment taken
+// when it was subscribed.
+func (s *PurchaseService) CancelPurchase(ctx context.Context, purchaseID, userID uuid.UUID) error {
+	return s.tx.WithTx(ctx, func(q *db.Queries) error {
+		purchase, err := q.GetPurchaseForUpdate(ctx, purchaseID)
+		if err != nil || purchase.UserID != userID {
+			return fmt.Errorf("purchase not found: %w", err)
+		}
+		if purchase.Status != "pending" {
+			return fmt.Errorf("purchase is not pending (status: %s)", purchase.Status)
+		}
+
+		entry := JournalEntry{
+			Type:        "purchase_refund",
+			ReferenceID: &purchase.ID,
+			Description: "purchase cancelled",
+		}
+		entry.Add(Transfer(SystemAccount(AccountTreasury, purchase.PaymentTokenID), UserAccount(purchase.UserID, purchase.PaymentTokenID), purchase.TotalValue)...)
+		if _, err := s.ledger.PostTx(ctx, q, entry); err != nil {
+			return fmt.Errorf("failed to refund payment: %w", err)
+		}
+
+		if err := q.UpdatePurchaseStatus(ctx, db.UpdatePurchaseStatusParams{
+			ID:     purchaseID,
+			Status: "cancelled",
+			TxHash: nil,
+		}); err != nil {
+			return fmt.Errorf("failed to update purchase status: %w", err)
+		}
+		return nil
+	})
+}
+
 // ---------------------------------------------------------------------
 // Admin / Maintenance
 // ---------------------------------------------------------------------
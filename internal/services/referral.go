@@ -4,20 +4,26 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
 
 	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/config"
 	"jd7008911/canlan.org/internal/db"
 )
 
 type ReferralService struct {
 	queries *db.Queries
+	tx      db.TxRunner
+	cfg     *config.Config
 }
 
-func NewReferralService(queries *db.Queries) *ReferralService {
-	return &ReferralService{queries: queries}
+func NewReferralService(queries *db.Queries, tx db.TxRunner, cfg *config.Config) *ReferralService {
+	return &ReferralService{queries: queries, tx: tx, cfg: cfg}
 }
 
-// GenerateReferralCode creates a unique referral code
+// GenerateReferralCode creates a random referral code. Use NewRandomCode
+// for one that is not taken yet.
 func (s *ReferralService) GenerateReferralCode() (string, error) {
 	b := make([]byte, 6)
 	_, err := rand.Read(b)
@@ -27,21 +33,25 @@ func (s *ReferralService) GenerateReferralCode() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
-// ProcessReferral handles new user registration with referrer
+// ProcessReferral adds a new user to every ancestor's team member count and
+// to the inviter's direct referrals, together. The user's power is not
+// added here: creating their combat power already added it to every
+// ancestor's team power.
 func (s *ReferralService) ProcessReferral(ctx context.Context, newUserID, referrerID uuid.UUID, parentID *uuid.UUID) error {
-	// Update inviter's direct referrals count
-	node, err := s.queries.GetUserNode(ctx, referrerID)
-	if err != nil {
-		node = &db.UserNode{UserID: referrerID}
-	}
-	node.DirectReferrals++
-	s.queries.UpsertUserNode(ctx, db.UpsertUserNodeParams{
-		UserID:          referrerID,
-		DirectReferrals: int32(node.DirectReferrals),
+	return s.tx.WithTx(ctx, func(q *db.Queries) error {
+		// This also creates the inviter's node row if it has none yet
+		if _, err := q.PropagateTeamDelta(ctx, db.PropagateTeamDeltaParams{
+			UserID:      newUserID,
+			PowerDelta:  decimal.Zero,
+			MemberDelta: 1,
+		}); err != nil {
+			return fmt.Errorf("failed to propagate team stats: %w", err)
+		}
+		if err := q.IncrementDirectReferrals(ctx, referrerID); err != nil {
+			return fmt.Errorf("failed to count direct referral: %w", err)
+		}
+		return nil
 	})
-
-	// Update team combat power for all ancestors
-	return s.updateAncestorsTeamPower(ctx, newUserID, referrerID)
 }
 
 // GetReferralNetwork returns a user's referral tree
@@ -57,35 +67,43 @@ func (s *ReferralService) GetReferralNetwork(ctx context.Context, userID uuid.UU
 	return node, directs, nil
 }
 
-// updateAncestorsTeamPower recalculates team power for all ancestors
-func (s *ReferralService) updateAncestorsTeamPower(ctx context.Context, userID, inviterID uuid.UUID) error {
-	// Get user's combat power
-	cp, err := s.queries.GetCombatPower(ctx, userID)
+// ReferralEarningTotal is a user's lifetime commissions in one token from
+// one kind of earning, in one state (paid, held for review or forfeited).
+type ReferralEarningTotal struct {
+	Symbol      string          `json:"symbol"`
+	EarningType string          `json:"earning_type"`
+	Status      string          `json:"status"`
+	Total       decimal.Decimal `json:"total"`
+}
+
+// GetTotalEarnings returns a user's lifetime referral commissions per
+// token, earning type and state.
+func (s *ReferralService) GetTotalEarnings(ctx context.Context, userID uuid.UUID) ([]ReferralEarningTotal, error) {
+	rows, err := s.queries.GetReferralEarningTotals(ctx, &userID)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to load referral earnings: %w", err)
 	}
-
-	// Walk up the referral chain
-	currentID := inviterID
-	for currentID != uuid.Nil {
-		// Add user's power to ancestor's team power
-		err = s.queries.AddTeamPower(ctx, db.AddTeamPowerParams{
-			UserID:    currentID,
-			TeamPower: cp.PersonalPower,
-		})
-		if err != nil {
-			return err
+	totals := make([]ReferralEarningTotal, len(rows))
+	for i, row := range rows {
+		totals[i] = ReferralEarningTotal{Symbol: row.Symbol, Status: row.Status, Total: row.Total}
+		if row.EarningType != nil {
+			totals[i].EarningType = *row.EarningType
 		}
-
-		// Get parent of current
-		user, err := s.queries.GetUserByID(ctx, currentID)
-		if err != nil {
-			break
-		}
-		if user.ParentID == nil {
-			break
-		}
-		currentID = *user.ParentID
 	}
-	return nil
+	return totals, nil
+}
+
+// GetReferralHistory returns a user's referral commissions, newest first.
+func (s *ReferralService) GetReferralHistory(ctx context.Context, userID uuid.UUID, limit, offset int32) ([]db.GetReferralEarningsHistoryRow, error) {
+	return s.queries.GetReferralEarningsHistory(ctx, db.GetReferralEarningsHistoryParams{
+		UserID: &userID,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// CountReferralHistory returns how many referral commissions a user has
+// received.
+func (s *ReferralService) CountReferralHistory(ctx context.Context, userID uuid.UUID) (int64, error) {
+	return s.queries.CountReferralEarnings(ctx, &userID)
 }
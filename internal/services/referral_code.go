@@ -0,0 +1,375 @@
+// internal/services/referral_code.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"jd7008911/canlan.org/internal/db"
+)
+
+var (
+	ErrInvalidReferralCode   = errors.New("invalid referral code")
+	ErrReferralCodeTaken     = errors.New("referral code is already taken")
+	ErrReferralCodeNotFound  = errors.New("referral code not found")
+	ErrTooManyReferralCodes  = errors.New("referral code limit reached")
+	ErrReferralWindowExpired = errors.New("referral click is outside the attribution window")
+	ErrReferralClickUsed     = errors.New("referral click already led to a signup")
+)
+
+// vanityCodePattern allows 4-20 letters, digits, '_' and '-', starting and
+// ending with a letter or digit.
+var vanityCodePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{2,18}[A-Za-z0-9]$`)
+
+// maxCampaignLength matches referral_codes.campaign.
+const maxCampaignLength = 50
+
+// blockedCodeWords may not appear anywhere in a vanity code, after the code
+// is lower-cased, stripped of separators and common digit substitutions are
+// undone. They are profanity that is not part of everyday words.
+var blockedCodeWords = []string{
+	"fuck", "shit", "bitch", "cunt", "pussy", "whore", "slut",
+	"nigger", "nigga", "faggot", "nazi", "hitler",
+}
+
+// blockedCodeTokens may not be a word of a vanity code (see codeTokens).
+// They are names that could pass for the platform and profanity hidden in
+// everyday words, so badminton, peacock and grapefruit stay allowed.
+var blockedCodeTokens = []string{
+	"dick", "cock", "rape",
+	"admin", "support", "official", "moderator", "canglanfu",
+}
+
+// codeLeet undoes common digit substitutions before matching blocked words.
+var codeLeet = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "8", "b", "_", "", "-", "")
+
+// codeTokens splits a vanity code into its words, at separators and where
+// a lower-case letter is followed by an upper-case one, each lower-cased
+// with digit substitutions undone.
+func codeTokens(code string) []string {
+	var tokens []string
+	start := 0
+	for i := 1; i <= len(code); i++ {
+		separator := i < len(code) && (code[i] == '_' || code[i] == '-')
+		camel := i < len(code) && isLowerASCII(code[i-1]) && isUpperASCII(code[i])
+		if i < len(code) && !separator && !camel {
+			continue
+		}
+		if start < i {
+			tokens = append(tokens, codeLeet.Replace(strings.ToLower(code[start:i])))
+		}
+		start = i
+		if separator {
+			start++
+		}
+	}
+	return tokens
+}
+
+func isLowerASCII(c byte) bool { return c >= 'a' && c <= 'z' }
+func isUpperASCII(c byte) bool { return c >= 'A' && c <= 'Z' }
+
+// hasTokenRun reports whether word is one or more consecutive tokens
+// joined, so a blocked word split by separators ("ad_m-in") still matches.
+func hasTokenRun(tokens []string, word string) bool {
+	for i := range tokens {
+		joined := ""
+		for _, token := range tokens[i:] {
+			joined += token
+			if joined == word {
+				return true
+			}
+			if len(joined) >= len(word) {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// ValidateVanityCode checks a requested code's format and that it contains
+// no blocked word. Configured words are matched anywhere in the code.
+func (s *ReferralService) ValidateVanityCode(code string) error {
+	if !vanityCodePattern.MatchString(code) {
+		return fmt.Errorf("%w: use 4-20 letters, digits, '_' or '-', starting and ending with a letter or digit", ErrInvalidReferralCode)
+	}
+	normalized := codeLeet.Replace(strings.ToLower(code))
+	for _, words := range [][]string{blockedCodeWords, s.cfg.Referral.BlockedWords} {
+		for _, word := range words {
+			if word = strings.ToLower(word); word != "" && strings.Contains(normalized, word) {
+				return fmt.Errorf("%w: code contains a blocked word", ErrInvalidReferralCode)
+			}
+		}
+	}
+	tokens := codeTokens(code)
+	for _, word := range blockedCodeTokens {
+		if hasTokenRun(tokens, word) {
+			return fmt.Errorf("%w: code contains a blocked word", ErrInvalidReferralCode)
+		}
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------
+// Codes
+// ---------------------------------------------------------------------
+
+// NewRandomCode returns a random code that no user holds yet.
+func (s *ReferralService) NewRandomCode(ctx context.Context) (string, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		code, err := s.GenerateReferralCode()
+		if err != nil {
+			return "", err
+		}
+		taken, err := s.queries.ReferralCodeExists(ctx, code)
+		if err != nil {
+			return "", fmt.Errorf("failed to check referral code: %w", err)
+		}
+		if !taken {
+			return code, nil
+		}
+	}
+	return "", fmt.Errorf("failed to find a free referral code")
+}
+
+// CreateUser creates a user and records the code they were created with as
+// their first, primary code, in one transaction so no user is left without
+// a code to share.
+func (s *ReferralService) CreateUser(ctx context.Context, params db.CreateUserParams) (*db.User, error) {
+	var user db.User
+	err := s.tx.WithTx(ctx, func(q *db.Queries) error {
+		var err error
+		user, err = q.CreateUser(ctx, params)
+		if err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+		if _, err := q.CreateReferralCode(ctx, db.CreateReferralCodeParams{
+			UserID: user.ID,
+			Code:   params.ReferralCode,
+		}); err != nil {
+			return fmt.Errorf("failed to register referral code: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetReferralCode returns the user's primary referral code.
+func (s *ReferralService) GetReferralCode(ctx context.Context, userID uuid.UUID) (string, error) {
+	user, err := s.queries.GetUserByID(ctx, userID)
+	if err != nil {
+		return "", fmt.Errorf("user not found: %w", err)
+	}
+	return user.ReferralCode, nil
+}
+
+// ListReferralCodes returns all of the user's codes, newest first, with
+// their campaign, counters and when they stopped being primary.
+func (s *ReferralService) ListReferralCodes(ctx context.Context, userID uuid.UUID) ([]db.ReferralCode, error) {
+	return s.queries.ListUserReferralCodes(ctx, userID)
+}
+
+// CreateCode adds a referral code for the user: the vanity code requested,
+// or a random one when code is empty. The primary code does not change.
+func (s *ReferralService) CreateCode(ctx context.Context, userID uuid.UUID, code, campaign string) (*db.ReferralCode, error) {
+	return s.createCodeTx(ctx, s.queries, userID, code, campaign)
+}
+
+func (s *ReferralService) createCodeTx(ctx context.Context, q *db.Queries, userID uuid.UUID, code, campaign string) (*db.ReferralCode, error) {
+	count, err := q.CountUserReferralCodes(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count referral codes: %w", err)
+	}
+	if count >= int64(s.cfg.Referral.MaxCodes) {
+		return nil, ErrTooManyReferralCodes
+	}
+
+	var label *string
+	if campaign = strings.TrimSpace(campaign); campaign != "" {
+		if len(campaign) > maxCampaignLength {
+			return nil, fmt.Errorf("%w: campaign must be at most %d characters", ErrInvalidReferralCode, maxCampaignLength)
+		}
+		label = &campaign
+	}
+
+	vanity := code != ""
+	if vanity {
+		if err := s.ValidateVanityCode(code); err != nil {
+			return nil, err
+		}
+		taken, err := q.ReferralCodeExists(ctx, code)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check referral code: %w", err)
+		}
+		if taken {
+			return nil, ErrReferralCodeTaken
+		}
+	} else if code, err = s.NewRandomCode(ctx); err != nil {
+		return nil, err
+	}
+
+	created, err := q.CreateReferralCode(ctx, db.CreateReferralCodeParams{
+		UserID:   userID,
+		Code:     code,
+		Campaign: label,
+		Vanity:   vanity,
+	})
+	if err != nil {
+		// Lost a race for the same code
+		return nil, fmt.Errorf("%w: %v", ErrReferralCodeTaken, err)
+	}
+	return &created, nil
+}
+
+// SetPrimaryCode makes one of the user's codes their primary code. The
+// previous primary code keeps resolving to the user.
+func (s *ReferralService) SetPrimaryCode(ctx context.Context, userID, codeID uuid.UUID) (*db.ReferralCode, error) {
+	var code db.ReferralCode
+	err := s.tx.WithTx(ctx, func(q *db.Queries) error {
+		var err error
+		code, err = s.setPrimaryCodeTx(ctx, q, userID, codeID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+func (s *ReferralService) setPrimaryCodeTx(ctx context.Context, q *db.Queries, userID, codeID uuid.UUID) (db.ReferralCode, error) {
+	code, err := q.GetReferralCodeForUser(ctx, db.GetReferralCodeForUserParams{
+		ID:     codeID,
+		UserID: userID,
+	})
+	if err != nil {
+		return db.ReferralCode{}, ErrReferralCodeNotFound
+	}
+	if err := q.SetPrimaryReferralCode(ctx, db.SetPrimaryReferralCodeParams{
+		UserID: userID,
+		Code:   code.Code,
+	}); err != nil {
+		return db.ReferralCode{}, fmt.Errorf("failed to set primary referral code: %w", err)
+	}
+	if err := q.ClearReferralCodeReplaced(ctx, code.ID); err != nil {
+		return db.ReferralCode{}, fmt.Errorf("failed to set primary referral code: %w", err)
+	}
+	code.ReplacedAt = nil
+	return code, nil
+}
+
+// RotateReferralCode gives the user a new random primary code. Links with
+// the old code keep working.
+func (s *ReferralService) RotateReferralCode(ctx context.Context, userID uuid.UUID) (*db.ReferralCode, error) {
+	var code db.ReferralCode
+	err := s.tx.WithTx(ctx, func(q *db.Queries) error {
+		created, err := s.createCodeTx(ctx, q, userID, "", "")
+		if err != nil {
+			return err
+		}
+		code, err = s.setPrimaryCodeTx(ctx, q, userID, created.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+// ---------------------------------------------------------------------
+// Attribution
+// ---------------------------------------------------------------------
+
+// ReferralClick is a recorded referral link click. The client sends its ID
+// back at signup so the signup is credited to the link.
+type ReferralClick struct {
+	ClickID   uuid.UUID  `json:"click_id"`
+	Code      string     `json:"code"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // end of the attribution window
+}
+
+// RecordClick counts a click on a referral link.
+func (s *ReferralService) RecordClick(ctx context.Context, code string) (*ReferralClick, error) {
+	rc, err := s.queries.ResolveReferralCode(ctx, code)
+	if err != nil {
+		return nil, ErrReferralCodeNotFound
+	}
+	click, err := s.queries.CreateReferralClick(ctx, rc.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record click: %w", err)
+	}
+
+	result := &ReferralClick{ClickID: click.ID, Code: rc.Code}
+	if window := s.cfg.Referral.AttributionWindow; window > 0 {
+		expires := click.CreatedAt.Add(window)
+		result.ExpiresAt = &expires
+	}
+	return result, nil
+}
+
+// ReferralAttribution is the code, and click if any, a signup is credited
+// to.
+type ReferralAttribution struct {
+	CodeID  uuid.UUID
+	UserID  uuid.UUID // the code's owner
+	ClickID *uuid.UUID
+}
+
+// ResolveReferral works out who referred a signup. A click is honoured
+// only within the attribution window and only once; without a click, a
+// code entered directly is always honoured, including codes that have
+// since been replaced.
+func (s *ReferralService) ResolveReferral(ctx context.Context, code string, clickID *uuid.UUID) (*ReferralAttribution, error) {
+	if clickID != nil {
+		click, err := s.queries.GetReferralClick(ctx, *clickID)
+		if err != nil {
+			return nil, ErrReferralCodeNotFound
+		}
+		if click.SignupUserID != nil {
+			return nil, ErrReferralClickUsed
+		}
+		if window := s.cfg.Referral.AttributionWindow; window > 0 && time.Since(click.CreatedAt) > window {
+			return nil, ErrReferralWindowExpired
+		}
+		rc, err := s.queries.GetReferralCode(ctx, click.CodeID)
+		if err != nil {
+			return nil, ErrReferralCodeNotFound
+		}
+		return &ReferralAttribution{CodeID: rc.ID, UserID: rc.UserID, ClickID: &click.ID}, nil
+	}
+
+	if code == "" {
+		return nil, ErrReferralCodeNotFound
+	}
+	rc, err := s.queries.ResolveReferralCode(ctx, code)
+	if err != nil {
+		return nil, ErrReferralCodeNotFound
+	}
+	return &ReferralAttribution{CodeID: rc.ID, UserID: rc.UserID}, nil
+}
+
+// RecordSignup counts a signup against the code, and click, it was
+// credited to.
+func (s *ReferralService) RecordSignup(ctx context.Context, a *ReferralAttribution, newUserID uuid.UUID) error {
+	return s.tx.WithTx(ctx, func(q *db.Queries) error {
+		if err := q.RecordReferralSignup(ctx, a.CodeID); err != nil {
+			return fmt.Errorf("failed to record referral signup: %w", err)
+		}
+		if a.ClickID != nil {
+			if err := q.MarkReferralClickSignup(ctx, db.MarkReferralClickSignupParams{
+				ID:           *a.ClickID,
+				SignupUserID: &newUserID,
+			}); err != nil {
+				return fmt.Errorf("failed to record referral signup: %w", err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,55 @@
+// internal/services/referral_code_test.go
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"jd7008911/canlan.org/internal/config"
+)
+
+func TestValidateVanityCode(t *testing.T) {
+	s := &ReferralService{cfg: &config.Config{Referral: config.ReferralConfig{BlockedWords: []string{"Scam", ""}}}}
+
+	tests := []struct {
+		name    string
+		code    string
+		wantErr bool
+	}{
+		{"letters", "alice", false},
+		{"mixed case, digits and separators", "Alice_2024-x", false},
+		{"shortest", "ab1c", false},
+		{"longest", "abcdefghij0123456789", false},
+		{"too short", "abc", true},
+		{"too long", "abcdefghij0123456789x", true},
+		{"leading separator", "_alice", true},
+		{"trailing separator", "alice-", true},
+		{"space", "ali ce", true},
+		{"non-ASCII letter", "alicé", true},
+		{"empty", "", true},
+		{"blocked word", "shithead", true},
+		{"blocked word in any case", "MyADMIN", true},
+		{"blocked word split by separators", "ad_m-in", true},
+		{"blocked word in leet speak", "5upp0rt", true},
+		{"platform name in leet speak", "CangLanFu_0ff1c1al", true},
+		{"blocked word in camel case", "CanglanfuTeam", true},
+		{"blocked word as a token", "the_official", true},
+		{"reserved word inside a word", "badminton", false},
+		{"profanity inside a word", "peacock", false},
+		{"profanity inside a longer word", "grapefruit", false},
+		{"tokens only match whole words", "cocktail_bar", false},
+		{"configured word", "noscam1", true},
+		{"configured word in leet speak", "5cam_king", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.ValidateVanityCode(tt.code)
+			if tt.wantErr && !errors.Is(err, ErrInvalidReferralCode) {
+				t.Errorf("ValidateVanityCode(%q) error = %v, want ErrInvalidReferralCode", tt.code, err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("ValidateVanityCode(%q) error = %v, want nil", tt.code, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,486 @@
+// internal/services/referral_risk.go
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"jd7008911/canlan.org/internal/config"
+	"jd7008911/canlan.org/internal/db"
+)
+
+var (
+	ErrSelfReferral         = errors.New("self-referral is not allowed")
+	ErrInvalidRiskStatus    = errors.New("invalid risk review status")
+	ErrRiskReviewNotPending = errors.New("account is not awaiting review")
+	ErrOwnRiskReview        = errors.New("reviewers cannot review their own account")
+)
+
+// Kinds of risk signals, stored in user_risk_signals.kind.
+const (
+	RiskSignalIP     = "ip"
+	RiskSignalDevice = "device"
+)
+
+// Review states of a referred account, stored in referral_risk.status.
+const (
+	RiskStatusClear    = "clear"
+	RiskStatusFlagged  = "flagged"  // commissions held until reviewed
+	RiskStatusApproved = "approved" // held commissions released
+	RiskStatusRejected = "rejected" // held and future commissions forfeited
+)
+
+// States of a commission, stored in referral_earnings.status.
+const (
+	ReferralEarningPaid      = "paid"
+	ReferralEarningHeld      = "held"
+	ReferralEarningForfeited = "forfeited"
+)
+
+// riskWeights scores a signal value the referred account shares with its
+// referrer, which points at someone referring themselves, or with other
+// accounts, which points at a wallet cluster. Scores are capped at 100.
+var riskWeights = map[string]struct{ referrer, others int }{
+	RiskSignalDevice: {referrer: 70, others: 40},
+	RiskSignalIP:     {referrer: 30, others: 15},
+}
+
+// riskWeightBurst scores a signup that is part of a burst under one code.
+const riskWeightBurst = 30
+
+// ReferralRiskService guards referral commissions against self-referral
+// and sybil farming. Hard violations are rejected at signup; softer
+// signals are scored, and commissions earned by or on an account whose
+// score reaches the configured threshold are held for review.
+type ReferralRiskService struct {
+	queries *db.Queries
+	store   riskStore
+	tx      db.TxRunner
+	cfg     *config.Config
+	vesting *VestingService
+}
+
+// NewReferralRiskService creates a new referral risk service.
+func NewReferralRiskService(queries *db.Queries, tx db.TxRunner, cfg *config.Config, vesting *VestingService) *ReferralRiskService {
+	return &ReferralRiskService{
+		queries: queries,
+		store:   queries,
+		tx:      tx,
+		cfg:     cfg,
+		vesting: vesting,
+	}
+}
+
+// riskStore is what the signup checks and scoring read and write.
+type riskStore interface {
+	GetUserByID(ctx context.Context, id uuid.UUID) (db.User, error)
+	HasRiskSignal(ctx context.Context, arg db.HasRiskSignalParams) (bool, error)
+	GetRiskSignalOverlaps(ctx context.Context, userID uuid.UUID) ([]db.GetRiskSignalOverlapsRow, error)
+	GetReferralRisk(ctx context.Context, userID uuid.UUID) (db.ReferralRisk, error)
+	CountCodeSignupsBetween(ctx context.Context, arg db.CountCodeSignupsBetweenParams) (int64, error)
+	UpsertReferralRisk(ctx context.Context, arg db.UpsertReferralRiskParams) (db.ReferralRisk, error)
+}
+
+// RiskSignals are what a client reveals about itself on signup or login.
+type RiskSignals struct {
+	IP                string
+	DeviceFingerprint string
+}
+
+// RiskReason is one signal that contributed to an account's score.
+type RiskReason struct {
+	Signal string `json:"signal"`
+	Weight int    `json:"weight"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// values returns the signals to store by kind, skipping empty or malformed
+// ones. Fingerprints are hashed so they have a fixed size.
+func (sig RiskSignals) values() map[string]string {
+	values := make(map[string]string, 2)
+	if ip := net.ParseIP(strings.TrimSpace(sig.IP)); ip != nil {
+		values[RiskSignalIP] = ip.String()
+	}
+	if fp := strings.TrimSpace(sig.DeviceFingerprint); fp != "" {
+		values[RiskSignalDevice] = hashFingerprint(fp)
+	}
+	return values
+}
+
+func hashFingerprint(fp string) string {
+	sum := sha256.Sum256([]byte(fp))
+	return hex.EncodeToString(sum[:])
+}
+
+// ---------------------------------------------------------------------
+// Signup checks and signals
+// ---------------------------------------------------------------------
+
+// CheckReferral rejects crediting a new signup from wallet to referrerID
+// when it is a self-referral: the referrer's own wallet, or a device the
+// referrer has used. Cycles through existing accounts are rejected by the
+// database whenever parent_id or invited_by is written.
+func (s *ReferralRiskService) CheckReferral(ctx context.Context, wallet string, referrerID uuid.UUID, sig RiskSignals) error {
+	referrer, err := s.store.GetUserByID(ctx, referrerID)
+	if err != nil {
+		return ErrReferralCodeNotFound
+	}
+	if strings.EqualFold(referrer.WalletAddress, wallet) {
+		return ErrSelfReferral
+	}
+	if device, ok := sig.values()[RiskSignalDevice]; ok {
+		shared, err := s.store.HasRiskSignal(ctx, db.HasRiskSignalParams{
+			UserID: referrerID,
+			Kind:   RiskSignalDevice,
+			Value:  device,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to check referrer devices: %w", err)
+		}
+		if shared {
+			return fmt.Errorf("%w: this device belongs to the referrer", ErrSelfReferral)
+		}
+	}
+	return nil
+}
+
+// RecordSignals stores the signals seen on a signup or login and rescores
+// the account. codeID is the referral code a signup was credited to, nil
+// on later logins.
+func (s *ReferralRiskService) RecordSignals(ctx context.Context, userID uuid.UUID, sig RiskSignals, codeID *uuid.UUID) (*db.ReferralRisk, error) {
+	for kind, value := range sig.values() {
+		if err := s.queries.UpsertRiskSignal(ctx, db.UpsertRiskSignalParams{
+			UserID: userID,
+			Kind:   kind,
+			Value:  value,
+		}); err != nil {
+			return nil, fmt.Errorf("failed to record %s signal: %w", kind, err)
+		}
+	}
+	return s.Assess(ctx, userID, codeID)
+}
+
+// Assess scores a referred account and flags it once the score reaches
+// REFERRAL_RISK_FLAG_SCORE. Flagged and reviewed accounts keep their status
+// when rescored. Accounts nobody referred are not scored and nil is
+// returned.
+func (s *ReferralRiskService) Assess(ctx context.Context, userID uuid.UUID, codeID *uuid.UUID) (*db.ReferralRisk, error) {
+	user, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("user not found: %w", err)
+	}
+	if user.InvitedBy == nil {
+		return nil, nil
+	}
+
+	reasons := []RiskReason{}
+	overlaps, err := s.store.GetRiskSignalOverlaps(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare risk signals: %w", err)
+	}
+	for _, o := range overlaps {
+		w, ok := riskWeights[o.Kind]
+		if !ok {
+			continue
+		}
+		if o.SharedWithReferrer {
+			reasons = append(reasons, RiskReason{Signal: o.Kind + "_shared_with_referrer", Weight: w.referrer})
+		}
+		if o.SharedAccounts > 0 {
+			reasons = append(reasons, RiskReason{
+				Signal: o.Kind + "_shared_with_accounts",
+				Weight: w.others,
+				Detail: fmt.Sprintf("%d other accounts", o.SharedAccounts),
+			})
+		}
+	}
+
+	// Burst: signups under the same code in the window ending at this one
+	signedUp := time.Now()
+	counted := int64(1) // this signup, until it has a row
+	previous := RiskStatusClear
+	if existing, err := s.store.GetReferralRisk(ctx, userID); err == nil {
+		signedUp = existing.CreatedAt
+		counted = 0
+		previous = existing.Status
+		if codeID == nil {
+			codeID = existing.ReferralCodeID
+		}
+	}
+	if n := s.cfg.Referral.RiskBurstSignups; n > 0 && codeID != nil {
+		signups, err := s.store.CountCodeSignupsBetween(ctx, db.CountCodeSignupsBetweenParams{
+			ReferralCodeID: codeID,
+			Since:          signedUp.Add(-s.cfg.Referral.RiskBurstWindow),
+			Until:          signedUp,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to count code signups: %w", err)
+		}
+		if signups+counted >= int64(n) {
+			reasons = append(reasons, RiskReason{
+				Signal: "burst_signups",
+				Weight: riskWeightBurst,
+				Detail: fmt.Sprintf("%d signups under one code within %s", signups+counted, s.cfg.Referral.RiskBurstWindow),
+			})
+		}
+	}
+
+	score := 0
+	for _, r := range reasons {
+		score += r.Weight
+	}
+	score = min(score, 100)
+	encoded, err := json.Marshal(reasons)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode risk reasons: %w", err)
+	}
+
+	threshold := s.cfg.Referral.RiskFlagScore
+	risk, err := s.store.UpsertReferralRisk(ctx, db.UpsertReferralRiskParams{
+		UserID:         userID,
+		ReferralCodeID: codeID,
+		Score:          int32(score),
+		Reasons:        encoded,
+		Flag:           threshold > 0 && score >= threshold,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store risk score: %w", err)
+	}
+	if risk.Status == RiskStatusFlagged && previous != RiskStatusFlagged {
+		log.Printf("referral risk: flagged %s (score %d), holding commissions", userID, score)
+	}
+	return &risk, nil
+}
+
+// ---------------------------------------------------------------------
+// Commission holds
+// ---------------------------------------------------------------------
+
+// heldAccountsTx returns the flagged or rejected accounts among ids with
+// their status.
+func (s *ReferralRiskService) heldAccountsTx(ctx context.Context, q *db.Queries, ids []uuid.UUID) (map[uuid.UUID]string, error) {
+	rows, err := q.GetReferralRiskStatuses(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load referral risk: %w", err)
+	}
+	held := make(map[uuid.UUID]string, len(rows))
+	for _, row := range rows {
+		held[row.UserID] = row.Status
+	}
+	return held, nil
+}
+
+// commissionStatus settles a commission earned by earner on source's
+// activity: forfeited when either account is rejected, held while either
+// is flagged, paid otherwise.
+func commissionStatus(held map[uuid.UUID]string, earner, source uuid.UUID) string {
+	switch a, b := held[earner], held[source]; {
+	case a == RiskStatusRejected || b == RiskStatusRejected:
+		return ReferralEarningForfeited
+	case a == RiskStatusFlagged || b == RiskStatusFlagged:
+		return ReferralEarningHeld
+	}
+	return ReferralEarningPaid
+}
+
+// ---------------------------------------------------------------------
+// Review queue
+// ---------------------------------------------------------------------
+
+// IsReviewer reports whether the user may work the review queue.
+func (s *ReferralRiskService) IsReviewer(ctx context.Context, userID uuid.UUID) (bool, error) {
+	if len(s.cfg.Referral.RiskReviewers) == 0 {
+		return false, nil
+	}
+	user, err := s.queries.GetUserByID(ctx, userID)
+	if err != nil {
+		return false, fmt.Errorf("user not found: %w", err)
+	}
+	for _, wallet := range s.cfg.Referral.RiskReviewers {
+		if strings.EqualFold(wallet, user.WalletAddress) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RiskReviewItem is a scored account in the review queue.
+type RiskReviewItem struct {
+	UserID          uuid.UUID    `json:"user_id"`
+	WalletAddress   string       `json:"wallet_address"`
+	InvitedBy       *uuid.UUID   `json:"invited_by,omitempty"`
+	Score           int32        `json:"score"`
+	Reasons         []RiskReason `json:"reasons"`
+	Status          string       `json:"status"`
+	FlaggedAt       *time.Time   `json:"flagged_at,omitempty"`
+	ReviewedBy      *uuid.UUID   `json:"reviewed_by,omitempty"`
+	ReviewedAt      *time.Time   `json:"reviewed_at,omitempty"`
+	ReviewNote      *string      `json:"review_note,omitempty"`
+	HeldCommissions int64        `json:"held_commissions"`
+}
+
+func validRiskStatus(status string) bool {
+	switch status {
+	case RiskStatusClear, RiskStatusFlagged, RiskStatusApproved, RiskStatusRejected:
+		return true
+	}
+	return false
+}
+
+// ListReviewQueue returns accounts in a review state, highest score first.
+func (s *ReferralRiskService) ListReviewQueue(ctx context.Context, status string, limit, offset int32) ([]RiskReviewItem, error) {
+	if !validRiskStatus(status) {
+		return nil, ErrInvalidRiskStatus
+	}
+	rows, err := s.queries.ListReferralRiskQueue(ctx, db.ListReferralRiskQueueParams{
+		Status: status,
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load review queue: %w", err)
+	}
+	items := make([]RiskReviewItem, len(rows))
+	for i, row := range rows {
+		items[i] = RiskReviewItem{
+			UserID:          row.UserID,
+			WalletAddress:   row.WalletAddress,
+			InvitedBy:       row.InvitedBy,
+			Score:           row.Score,
+			Status:          row.Status,
+			FlaggedAt:       row.FlaggedAt,
+			ReviewedBy:      row.ReviewedBy,
+			ReviewedAt:      row.ReviewedAt,
+			ReviewNote:      row.ReviewNote,
+			HeldCommissions: row.HeldCommissions,
+		}
+		if err := json.Unmarshal(row.Reasons, &items[i].Reasons); err != nil {
+			return nil, fmt.Errorf("failed to decode risk reasons of %s: %w", row.UserID, err)
+		}
+	}
+	return items, nil
+}
+
+// CountReviewQueue returns how many accounts are in a review state.
+func (s *ReferralRiskService) CountReviewQueue(ctx context.Context, status string) (int64, error) {
+	if !validRiskStatus(status) {
+		return 0, ErrInvalidRiskStatus
+	}
+	return s.queries.CountReferralRiskQueue(ctx, status)
+}
+
+// RiskReviewResult is the outcome of a review decision.
+type RiskReviewResult struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Status    string    `json:"status"`
+	Released  int       `json:"released"`  // held commissions paid out
+	Forfeited int64     `json:"forfeited"` // held commissions cancelled
+}
+
+// Approve clears a flagged account and pays out the commissions it held,
+// except those another flagged or rejected account is party to.
+func (s *ReferralRiskService) Approve(ctx context.Context, reviewerID, userID uuid.UUID, note string) (*RiskReviewResult, error) {
+	result := &RiskReviewResult{UserID: userID, Status: RiskStatusApproved}
+	err := s.tx.WithTx(ctx, func(q *db.Queries) error {
+		if err := s.reviewTx(ctx, q, reviewerID, userID, RiskStatusApproved, note); err != nil {
+			return err
+		}
+		held, err := q.GetReleasableReferralEarnings(ctx, &userID)
+		if err != nil {
+			return fmt.Errorf("failed to load held commissions: %w", err)
+		}
+		for _, re := range held {
+			if err := s.releaseTx(ctx, q, re); err != nil {
+				return err
+			}
+		}
+		result.Released = len(held)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Reject confirms a flagged account as abusive and forfeits the
+// commissions held by or on it. Commissions it would earn or generate from
+// now on are forfeited as they happen.
+func (s *ReferralRiskService) Reject(ctx context.Context, reviewerID, userID uuid.UUID, note string) (*RiskReviewResult, error) {
+	result := &RiskReviewResult{UserID: userID, Status: RiskStatusRejected}
+	err := s.tx.WithTx(ctx, func(q *db.Queries) error {
+		if err := s.reviewTx(ctx, q, reviewerID, userID, RiskStatusRejected, note); err != nil {
+			return err
+		}
+		n, err := q.ForfeitHeldReferralEarnings(ctx, &userID)
+		if err != nil {
+			return fmt.Errorf("failed to forfeit held commissions: %w", err)
+		}
+		result.Forfeited = n
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *ReferralRiskService) reviewTx(ctx context.Context, q *db.Queries, reviewerID, userID uuid.UUID, status, note string) error {
+	if reviewerID == userID {
+		return ErrOwnRiskReview
+	}
+	var reviewNote *string
+	if note = strings.TrimSpace(note); note != "" {
+		reviewNote = &note
+	}
+	if _, err := q.ReviewReferralRisk(ctx, db.ReviewReferralRiskParams{
+		UserID:     userID,
+		Status:     status,
+		ReviewedBy: &reviewerID,
+		ReviewNote: reviewNote,
+	}); err != nil {
+		return ErrRiskReviewNotPending
+	}
+	return nil
+}
+
+// releaseTx pays out a held commission from the account it was to be paid
+// from when it was earned.
+func (s *ReferralRiskService) releaseTx(ctx context.Context, q *db.Queries, re db.ReferralEarning) error {
+	if re.UserID == nil || re.TokenID == nil || re.PayoutAccount == nil {
+		return fmt.Errorf("held commission %s is missing its payee, token or payout account", re.ID)
+	}
+	source := ""
+	if re.EarningType != nil {
+		source = *re.EarningType
+	}
+	je, err := s.vesting.PayTx(ctx, q, Payout{
+		UserID:      *re.UserID,
+		TokenID:     *re.TokenID,
+		From:        SystemAccount(AccountType(*re.PayoutAccount), *re.TokenID),
+		Amount:      re.Amount,
+		Source:      VestingSourceReferral,
+		EntryType:   "referral_commission",
+		ReferenceID: re.ReferenceID,
+		Description: fmt.Sprintf("level %d referral commission on %s, released after review", re.Depth, source),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release commission %s: %w", re.ID, err)
+	}
+	if err := q.ReleaseReferralEarning(ctx, db.ReleaseReferralEarningParams{
+		ID:             re.ID,
+		JournalEntryID: &je.ID,
+	}); err != nil {
+		return fmt.Errorf("failed to release commission %s: %w", re.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+// internal/services/referral_risk_test.go
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"jd7008911/canlan.org/internal/config"
+	"jd7008911/canlan.org/internal/db"
+)
+
+// fakeRiskStore keeps users, signals and scores in memory. Upserts follow
+// UpsertReferralRisk: only a clear account changes status.
+type fakeRiskStore struct {
+	users    map[uuid.UUID]db.User
+	signals  map[uuid.UUID]map[string]string // user -> value -> kind
+	overlaps []db.GetRiskSignalOverlapsRow
+	signups  int64 // other signups under the code in the burst window
+	risk     map[uuid.UUID]db.ReferralRisk
+	counted  []db.CountCodeSignupsBetweenParams
+}
+
+func (f *fakeRiskStore) GetUserByID(ctx context.Context, id uuid.UUID) (db.User, error) {
+	u, ok := f.users[id]
+	if !ok {
+		return db.User{}, pgx.ErrNoRows
+	}
+	return u, nil
+}
+
+func (f *fakeRiskStore) HasRiskSignal(ctx context.Context, arg db.HasRiskSignalParams) (bool, error) {
+	return f.signals[arg.UserID][arg.Value] == arg.Kind, nil
+}
+
+func (f *fakeRiskStore) GetRiskSignalOverlaps(ctx context.Context, userID uuid.UUID) ([]db.GetRiskSignalOverlapsRow, error) {
+	return f.overlaps, nil
+}
+
+func (f *fakeRiskStore) GetReferralRisk(ctx context.Context, userID uuid.UUID) (db.ReferralRisk, error) {
+	r, ok := f.risk[userID]
+	if !ok {
+		return db.ReferralRisk{}, pgx.ErrNoRows
+	}
+	return r, nil
+}
+
+func (f *fakeRiskStore) CountCodeSignupsBetween(ctx context.Context, arg db.CountCodeSignupsBetweenParams) (int64, error) {
+	f.counted = append(f.counted, arg)
+	return f.signups, nil
+}
+
+func (f *fakeRiskStore) UpsertReferralRisk(ctx context.Context, arg db.UpsertReferralRiskParams) (db.ReferralRisk, error) {
+	r, ok := f.risk[arg.UserID]
+	if !ok {
+		r = db.ReferralRisk{UserID: arg.UserID, Status: RiskStatusClear, CreatedAt: time.Now()}
+	}
+	if r.ReferralCodeID == nil {
+		r.ReferralCodeID = arg.ReferralCodeID
+	}
+	r.Score, r.Reasons = arg.Score, arg.Reasons
+	if r.Status == RiskStatusClear && arg.Flag {
+		r.Status = RiskStatusFlagged
+	}
+	f.risk[arg.UserID] = r
+	return r, nil
+}
+
+func newRiskTest(referral config.ReferralConfig) (*ReferralRiskService, *fakeRiskStore, db.User, db.User) {
+	referrer := db.User{ID: uuid.New(), WalletAddress: "0xAbC0000000000000000000000000000000000001"}
+	user := db.User{ID: uuid.New(), WalletAddress: "0xabc0000000000000000000000000000000000002", InvitedBy: &referrer.ID}
+	store := &fakeRiskStore{
+		users:   map[uuid.UUID]db.User{referrer.ID: referrer, user.ID: user},
+		signals: map[uuid.UUID]map[string]string{},
+		risk:    map[uuid.UUID]db.ReferralRisk{},
+	}
+	svc := &ReferralRiskService{store: store, cfg: &config.Config{Referral: referral}}
+	return svc, store, referrer, user
+}
+
+func TestCheckReferral(t *testing.T) {
+	svc, store, referrer, _ := newRiskTest(config.ReferralConfig{})
+	store.signals[referrer.ID] = map[string]string{hashFingerprint("referrer-phone"): RiskSignalDevice}
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		wallet     string
+		referrerID uuid.UUID
+		sig        RiskSignals
+		wantErr    error
+	}{
+		{"new wallet and device", "0x0000000000000000000000000000000000000009", referrer.ID, RiskSignals{DeviceFingerprint: "other-phone"}, nil},
+		{"no fingerprint", "0x0000000000000000000000000000000000000009", referrer.ID, RiskSignals{IP: "203.0.113.7"}, nil},
+		{"referrer's own wallet in another case", "0xabc0000000000000000000000000000000000001", referrer.ID, RiskSignals{}, ErrSelfReferral},
+		{"referrer's device", "0x0000000000000000000000000000000000000009", referrer.ID, RiskSignals{DeviceFingerprint: " referrer-phone "}, ErrSelfReferral},
+		{"unknown referrer", "0x0000000000000000000000000000000000000009", uuid.New(), RiskSignals{}, ErrReferralCodeNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := svc.CheckReferral(ctx, tt.wallet, tt.referrerID, tt.sig)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("CheckReferral() error = %v, want nil", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckReferral() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAssessScoring(t *testing.T) {
+	codeID := uuid.New()
+	tests := []struct {
+		name       string
+		overlaps   []db.GetRiskSignalOverlapsRow
+		signups    int64
+		wantScore  int32
+		wantStatus string
+		wantReason []string
+	}{
+		{"no signals", nil, 0, 0, RiskStatusClear, []string{}},
+		{"shared IP stays under the threshold", []db.GetRiskSignalOverlapsRow{
+			{Kind: RiskSignalIP, SharedWithReferrer: true, SharedAccounts: 2},
+		}, 0, 45, RiskStatusClear, []string{"ip_shared_with_referrer", "ip_shared_with_accounts"}},
+		{"device shared with the referrer flags", []db.GetRiskSignalOverlapsRow{
+			{Kind: RiskSignalDevice, SharedWithReferrer: true},
+		}, 0, 70, RiskStatusFlagged, []string{"device_shared_with_referrer"}},
+		{"score reaches the threshold exactly", []db.GetRiskSignalOverlapsRow{
+			{Kind: RiskSignalDevice, SharedAccounts: 1},
+			{Kind: RiskSignalIP, SharedAccounts: 5},
+		}, 0, 55, RiskStatusFlagged, []string{"device_shared_with_accounts", "ip_shared_with_accounts"}},
+		{"burst counts this signup", nil, 3, 30, RiskStatusClear, []string{"burst_signups"}},
+		{"burst one signup short", []db.GetRiskSignalOverlapsRow{
+			{Kind: RiskSignalDevice, SharedAccounts: 1},
+		}, 2, 40, RiskStatusClear, []string{"device_shared_with_accounts"}},
+		{"score capped at 100", []db.GetRiskSignalOverlapsRow{
+			{Kind: RiskSignalDevice, SharedWithReferrer: true, SharedAccounts: 3},
+			{Kind: RiskSignalIP, SharedWithReferrer: true},
+		}, 10, 100, RiskStatusFlagged, []string{"device_shared_with_referrer", "device_shared_with_accounts", "ip_shared_with_referrer", "burst_signups"}},
+		{"unknown signal kinds are ignored", []db.GetRiskSignalOverlapsRow{
+			{Kind: "email", SharedWithReferrer: true, SharedAccounts: 9},
+		}, 0, 0, RiskStatusClear, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, store, _, user := newRiskTest(config.ReferralConfig{
+				RiskFlagScore:    55,
+				RiskBurstSignups: 4,
+				RiskBurstWindow:  time.Hour,
+			})
+			store.overlaps, store.signups = tt.overlaps, tt.signups
+
+			risk, err := svc.Assess(context.Background(), user.ID, &codeID)
+			if err != nil {
+				t.Fatalf("Assess() error = %v", err)
+			}
+			if risk.Score != tt.wantScore || risk.Status != tt.wantStatus {
+				t.Errorf("Assess() = score %d %s, want %d %s", risk.Score, risk.Status, tt.wantScore, tt.wantStatus)
+			}
+			var reasons []RiskReason
+			if err := json.Unmarshal(risk.Reasons, &reasons); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, len(reasons))
+			for i, r := range reasons {
+				got[i] = r.Signal
+			}
+			if len(got) != len(tt.wantReason) {
+				t.Fatalf("reasons = %v, want %v", got, tt.wantReason)
+			}
+			for i := range got {
+				if got[i] != tt.wantReason[i] {
+					t.Fatalf("reasons = %v, want %v", got, tt.wantReason)
+				}
+			}
+		})
+	}
+}
+
+func TestAssessThresholdOff(t *testing.T) {
+	svc, store, _, user := newRiskTest(config.ReferralConfig{})
+	store.overlaps = []db.GetRiskSignalOverlapsRow{{Kind: RiskSignalDevice, SharedWithReferrer: true, SharedAccounts: 1}}
+
+	risk, err := svc.Assess(context.Background(), user.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if risk.Score != 100 || risk.Status != RiskStatusClear {
+		t.Errorf("Assess() = score %d %s, want 100 clear with no threshold", risk.Score, risk.Status)
+	}
+	if len(store.counted) != 0 {
+		t.Errorf("signups were counted with bursts off")
+	}
+}
+
+func TestAssessKeepsStatus(t *testing.T) {
+	codeID := uuid.New()
+	for _, status := range []string{RiskStatusFlagged, RiskStatusApproved, RiskStatusRejected} {
+		t.Run(status, func(t *testing.T) {
+			svc, store, _, user := newRiskTest(config.ReferralConfig{
+				RiskFlagScore:    50,
+				RiskBurstSignups: 3,
+				RiskBurstWindow:  time.Hour,
+			})
+			signedUp := time.Now().Add(-48 * time.Hour)
+			store.risk[user.ID] = db.ReferralRisk{
+				UserID:         user.ID,
+				ReferralCodeID: &codeID,
+				Score:          70,
+				Status:         status,
+				CreatedAt:      signedUp,
+			}
+
+			// Rescored on a later login, with nothing shared any more
+			risk, err := svc.Assess(context.Background(), user.ID, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if risk.Score != 0 || risk.Status != status {
+				t.Errorf("Assess() = score %d %s, want 0 %s", risk.Score, risk.Status, status)
+			}
+
+			// The burst is counted under the stored code, in the window
+			// ending at the signup, which already has its row
+			if len(store.counted) != 1 {
+				t.Fatalf("counted signups %d times, want 1", len(store.counted))
+			}
+			c := store.counted[0]
+			if c.ReferralCodeID == nil || *c.ReferralCodeID != codeID || !c.Until.Equal(signedUp) || !c.Since.Equal(signedUp.Add(-time.Hour)) {
+				t.Errorf("counted signups %+v, want code %s in the hour before %s", c, codeID, signedUp)
+			}
+		})
+	}
+}
+
+func TestAssessUnreferred(t *testing.T) {
+	svc, store, referrer, _ := newRiskTest(config.ReferralConfig{RiskFlagScore: 1})
+	store.overlaps = []db.GetRiskSignalOverlapsRow{{Kind: RiskSignalDevice, SharedAccounts: 1}}
+
+	risk, err := svc.Assess(context.Background(), referrer.ID, nil)
+	if risk != nil || err != nil {
+		t.Errorf("Assess() = %v, %v; want nil, nil for an account nobody referred", risk, err)
+	}
+	if len(store.risk) != 0 {
+		t.Errorf("an account nobody referred was scored")
+	}
+}
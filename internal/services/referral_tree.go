@@ -0,0 +1,261 @@
+// internal/services/referral_tree.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/db"
+)
+
+var (
+	// ErrNotInDownline is returned when a node outside the caller's
+	// downline is requested.
+	ErrNotInDownline        = errors.New("user is not in your downline")
+	ErrSearchPrefixTooShort = errors.New("wallet search prefix is too short")
+)
+
+const (
+	treeVolumeWindow    = 30 * 24 * time.Hour
+	treeMaxPageSize     = 200
+	treeMinSearchPrefix = 6 // "0x" and four hex digits
+	treeSearchLimit     = 20
+	treeExportBatch     = 1000
+	treeMaxDepth        = 1000 // matches the hop limit of the tree queries
+)
+
+// TreeNode is one user in the referral tree with the aggregates of the
+// subtree below them. Depth is relative to the user exploring the tree.
+type TreeNode struct {
+	UserID         uuid.UUID       `json:"user_id"`
+	WalletAddress  string          `json:"wallet_address"`
+	Depth          int32           `json:"depth"`
+	NodeLevel      int32           `json:"node_level"`
+	JoinedAt       *time.Time      `json:"joined_at,omitempty"`
+	PersonalPower  decimal.Decimal `json:"personal_power"`
+	TeamPower      decimal.Decimal `json:"team_power"`
+	TeamMembers    int32           `json:"team_members"`
+	DirectChildren int64           `json:"direct_children"`
+	Volume30d      decimal.Decimal `json:"volume_30d"` // completed purchases in the subtree, node included
+}
+
+// TreePage is a node and one page of its children.
+type TreePage struct {
+	Node      TreeNode   `json:"node"`
+	Children  []TreeNode `json:"children"`
+	NextAfter *uuid.UUID `json:"next_after,omitempty"` // pass as after for the next page
+}
+
+// treeDepth returns how far nodeID is below rootID, or ErrNotInDownline.
+func (s *NodeService) treeDepth(ctx context.Context, rootID, nodeID uuid.UUID) (int32, error) {
+	depth, err := s.queries.GetTreeDepthBelow(ctx, db.GetTreeDepthBelowParams{
+		NodeID: nodeID,
+		RootID: rootID,
+	})
+	if err != nil {
+		return 0, ErrNotInDownline
+	}
+	return depth, nil
+}
+
+// CheckInDownline returns ErrNotInDownline unless nodeID is rootID or in
+// rootID's downline.
+func (s *NodeService) CheckInDownline(ctx context.Context, rootID, nodeID uuid.UUID) error {
+	_, err := s.treeDepth(ctx, rootID, nodeID)
+	return err
+}
+
+// GetTreePage returns nodeID, which must be rootID or in rootID's downline,
+// and up to limit of its children with IDs after after. Children are
+// ordered by ID so pages stay stable while the tree grows.
+func (s *NodeService) GetTreePage(ctx context.Context, rootID, nodeID, after uuid.UUID, limit int) (*TreePage, error) {
+	if limit <= 0 || limit > treeMaxPageSize {
+		limit = treeMaxPageSize
+	}
+	depth, err := s.treeDepth(ctx, rootID, nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	row, err := s.queries.GetTreeNode(ctx, nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load node: %w", err)
+	}
+	children, err := s.queries.GetTreeChildren(ctx, db.GetTreeChildrenParams{
+		ParentID: nodeID,
+		AfterID:  after,
+		RowLimit: int32(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load children: %w", err)
+	}
+
+	page := &TreePage{
+		Node: TreeNode{
+			UserID:         row.UserID,
+			WalletAddress:  row.WalletAddress,
+			Depth:          depth,
+			NodeLevel:      row.NodeLevel,
+			JoinedAt:       row.CreatedAt,
+			PersonalPower:  row.PersonalPower,
+			TeamPower:      row.TeamPower,
+			TeamMembers:    row.TeamMembers,
+			DirectChildren: row.DirectChildren,
+			Volume30d:      decimal.Zero,
+		},
+		Children: make([]TreeNode, len(children)),
+	}
+	ids := []uuid.UUID{nodeID}
+	for i, c := range children {
+		page.Children[i] = TreeNode{
+			UserID:         c.UserID,
+			WalletAddress:  c.WalletAddress,
+			Depth:          depth + 1,
+			NodeLevel:      c.NodeLevel,
+			JoinedAt:       c.CreatedAt,
+			PersonalPower:  c.PersonalPower,
+			TeamPower:      c.TeamPower,
+			TeamMembers:    c.TeamMembers,
+			DirectChildren: c.DirectChildren,
+			Volume30d:      decimal.Zero,
+		}
+		ids = append(ids, c.UserID)
+	}
+	if len(children) == limit {
+		next := children[len(children)-1].UserID
+		page.NextAfter = &next
+	}
+
+	volumes, err := s.queries.GetSubtreeVolumes(ctx, db.GetSubtreeVolumesParams{
+		RootIds: ids,
+		Since:   time.Now().Add(-treeVolumeWindow),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load subtree volumes: %w", err)
+	}
+	byRoot := make(map[uuid.UUID]decimal.Decimal, len(volumes))
+	for _, v := range volumes {
+		byRoot[v.RootID] = v.Volume
+	}
+	page.Node.Volume30d = byRoot[nodeID]
+	for i := range page.Children {
+		page.Children[i].Volume30d = byRoot[page.Children[i].UserID]
+	}
+	return page, nil
+}
+
+// TreeSearchResult is a downline user found by wallet, with the user IDs
+// from the searcher down to them so the tree can be expanded to the match.
+type TreeSearchResult struct {
+	UserID        uuid.UUID   `json:"user_id"`
+	WalletAddress string      `json:"wallet_address"`
+	Depth         int32       `json:"depth"`
+	Path          []uuid.UUID `json:"path"`
+}
+
+// SearchDownline finds users in rootID's downline whose wallet starts with
+// prefix, nearest first.
+func (s *NodeService) SearchDownline(ctx context.Context, rootID uuid.UUID, prefix string) ([]TreeSearchResult, error) {
+	prefix = strings.TrimSpace(prefix)
+	if len(prefix) < treeMinSearchPrefix {
+		return nil, fmt.Errorf("%w: use at least %d characters", ErrSearchPrefixTooShort, treeMinSearchPrefix)
+	}
+	// The prefix is a LIKE pattern; keep wildcards literal
+	prefix = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix)
+
+	rows, err := s.queries.SearchDownlineByWallet(ctx, db.SearchDownlineByWalletParams{
+		WalletPrefix: prefix,
+		RootID:       rootID,
+		RowLimit:     treeSearchLimit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search downline: %w", err)
+	}
+	results := make([]TreeSearchResult, len(rows))
+	for i, row := range rows {
+		results[i] = TreeSearchResult{
+			UserID:        row.UserID,
+			WalletAddress: row.WalletAddress,
+			Depth:         row.Depth,
+			Path:          row.Path,
+		}
+	}
+	return results, nil
+}
+
+// TreeExportRow is one user in a subtree export.
+type TreeExportRow struct {
+	UserID        uuid.UUID       `json:"user_id"`
+	ParentID      uuid.UUID       `json:"parent_id"`
+	WalletAddress string          `json:"wallet_address"`
+	Depth         int32           `json:"depth"`
+	NodeLevel     int32           `json:"node_level"`
+	JoinedAt      *time.Time      `json:"joined_at,omitempty"`
+	PersonalPower decimal.Decimal `json:"personal_power"`
+	TeamPower     decimal.Decimal `json:"team_power"`
+	TeamMembers   int32           `json:"team_members"`
+}
+
+// ExportSubtree calls emit for every user below nodeID, which must be
+// rootID or in its downline, level by level. Rows are read in batches and
+// only the IDs of one level are kept, so large subtrees stream.
+func (s *NodeService) ExportSubtree(ctx context.Context, rootID, nodeID uuid.UUID, emit func(TreeExportRow) error) error {
+	depth, err := s.treeDepth(ctx, rootID, nodeID)
+	if err != nil {
+		return err
+	}
+
+	level := []uuid.UUID{nodeID}
+	for len(level) > 0 && depth < treeMaxDepth {
+		depth++
+		var next []uuid.UUID
+		for start := 0; start < len(level); start += treeExportBatch {
+			parents := level[start:min(start+treeExportBatch, len(level))]
+			after := uuid.Nil
+			for {
+				rows, err := s.queries.GetTreeChildrenOf(ctx, db.GetTreeChildrenOfParams{
+					ParentIds: parents,
+					AfterID:   after,
+					RowLimit:  treeExportBatch,
+				})
+				if err != nil {
+					return fmt.Errorf("failed to load subtree: %w", err)
+				}
+				for _, row := range rows {
+					parent := row.ParentID
+					if parent == nil {
+						parent = row.InvitedBy
+					}
+					out := TreeExportRow{
+						UserID:        row.UserID,
+						WalletAddress: row.WalletAddress,
+						Depth:         depth,
+						NodeLevel:     row.NodeLevel,
+						JoinedAt:      row.CreatedAt,
+						PersonalPower: row.PersonalPower,
+						TeamPower:     row.TeamPower,
+						TeamMembers:   row.TeamMembers,
+					}
+					if parent != nil {
+						out.ParentID = *parent
+					}
+					if err := emit(out); err != nil {
+						return err
+					}
+					next = append(next, row.UserID)
+				}
+				if len(rows) < treeExportBatch {
+					break
+				}
+				after = rows[len(rows)-1].UserID
+			}
+		}
+		level = next
+	}
+	return nil
+}
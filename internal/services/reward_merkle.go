@@ -0,0 +1,216 @@
+// internal/services/reward_merkle.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/db"
+	"jd7008911/canlan.org/internal/merkle"
+)
+
+var (
+	ErrRewardsClaimedOnChain = errors.New("rewards are claimed on chain")
+	ErrNoMerkleRoot          = errors.New("no merkle root published for block")
+	ErrNotInMerkleTree       = errors.New("wallet has no rewards in merkle tree")
+)
+
+// rewardTree is the Merkle tree published for one block, kept in memory so
+// proofs do not rebuild it on every request.
+type rewardTree struct {
+	blockID uuid.UUID
+	tree    *merkle.Tree
+	index   map[string]int // lower-case wallet -> leaf index
+	amounts []decimal.Decimal
+}
+
+// rewardTreeCache holds the most recently used tree.
+type rewardTreeCache struct {
+	mu   sync.Mutex
+	tree *rewardTree
+}
+
+// ---------------------------------------------------------------------
+// Publishing
+// ---------------------------------------------------------------------
+
+// publishesMerkleRoot reports whether a root is published for blockNumber.
+func (s *BlockRewardService) publishesMerkleRoot(blockNumber int64) bool {
+	r := s.cfg.Rewards
+	return r.MerkleEnabled && blockNumber%r.MerkleEveryBlocks == 0
+}
+
+// buildRewardTree builds the tree of every wallet's cumulative rewards up to
+// and including blockNumber, one leaf per wallet in wallet order. Rewards
+// already claimed into internal balances are not included.
+func buildRewardTree(ctx context.Context, q *db.Queries, blockID uuid.UUID, blockNumber int64) (*rewardTree, error) {
+	rows, err := q.GetCumulativeRewardsAtBlock(ctx, blockNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cumulative rewards: %w", err)
+	}
+
+	leaves := make([]common.Hash, len(rows))
+	rt := &rewardTree{
+		blockID: blockID,
+		index:   make(map[string]int, len(rows)),
+		amounts: make([]decimal.Decimal, len(rows)),
+	}
+	for i, row := range rows {
+		wei, err := merkle.ToWei(row.CumulativeAmount)
+		if err != nil {
+			return nil, fmt.Errorf("wallet %s: %w", row.WalletAddress, err)
+		}
+		leaves[i] = merkle.Leaf(common.HexToAddress(row.WalletAddress), wei)
+		rt.index[row.WalletAddress] = i
+		rt.amounts[i] = row.CumulativeAmount
+	}
+	rt.tree = merkle.New(leaves)
+	return rt, nil
+}
+
+// publishMerkleRoot stores the reward tree root on a freshly distributed
+// block. It runs in the distribution transaction so the root always covers
+// exactly the rewards that were committed.
+func (s *BlockRewardService) publishMerkleRoot(ctx context.Context, q *db.Queries, block db.MintBlock) error {
+	rt, err := buildRewardTree(ctx, q, block.ID, block.BlockNumber)
+	if err != nil {
+		return err
+	}
+	root := rt.tree.Root().Hex()
+	leafCount := int32(rt.tree.Len())
+	if err := q.SetMintBlockMerkleRoot(ctx, db.SetMintBlockMerkleRootParams{
+		ID:              block.ID,
+		MerkleRoot:      &root,
+		MerkleLeafCount: &leafCount,
+	}); err != nil {
+		return fmt.Errorf("failed to store merkle root: %w", err)
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------
+// Proofs
+// ---------------------------------------------------------------------
+
+// MerkleRoot is a root published for the distributor contract.
+type MerkleRoot struct {
+	BlockNumber int64  `json:"block_number"`
+	MerkleRoot  string `json:"merkle_root"`
+	LeafCount   int32  `json:"leaf_count"`
+}
+
+// GetMerkleRoot returns the root published for blockNumber, or the latest
+// published root when blockNumber is 0.
+func (s *BlockRewardService) GetMerkleRoot(ctx context.Context, blockNumber int64) (*MerkleRoot, error) {
+	block, err := s.merkleBlock(ctx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	root := &MerkleRoot{BlockNumber: block.BlockNumber, MerkleRoot: *block.MerkleRoot}
+	if block.MerkleLeafCount != nil {
+		root.LeafCount = *block.MerkleLeafCount
+	}
+	return root, nil
+}
+
+// merkleBlock loads the block whose root GetMerkleRoot and GetRewardProof
+// answer for.
+func (s *BlockRewardService) merkleBlock(ctx context.Context, blockNumber int64) (db.MintBlock, error) {
+	var block db.MintBlock
+	var err error
+	if blockNumber > 0 {
+		block, err = s.queries.GetMintBlockByNumber(ctx, blockNumber)
+	} else {
+		block, err = s.queries.GetLatestMerkleBlock(ctx)
+	}
+	if err != nil || block.MerkleRoot == nil {
+		return db.MintBlock{}, ErrNoMerkleRoot
+	}
+	return block, nil
+}
+
+// RewardProof is what a wallet submits to the distributor contract.
+type RewardProof struct {
+	BlockNumber         int64           `json:"block_number"`
+	MerkleRoot          string          `json:"merkle_root"`
+	Account             string          `json:"account"`
+	CumulativeAmount    decimal.Decimal `json:"cumulative_amount"`
+	CumulativeAmountWei string          `json:"cumulative_amount_wei"`
+	Leaf                string          `json:"leaf"`
+	Proof               []string        `json:"proof"`
+}
+
+// GetRewardProof returns the user's proof against the root published for
+// blockNumber, or against the latest published root when blockNumber is 0.
+func (s *BlockRewardService) GetRewardProof(ctx context.Context, userID uuid.UUID, blockNumber int64) (*RewardProof, error) {
+	block, err := s.merkleBlock(ctx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.queries.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("user not found: %w", err)
+	}
+	wallet := strings.ToLower(user.WalletAddress)
+
+	rt, err := s.rewardTree(ctx, block)
+	if err != nil {
+		return nil, err
+	}
+	i, ok := rt.index[wallet]
+	if !ok {
+		return nil, ErrNotInMerkleTree
+	}
+	if root := rt.tree.Root().Hex(); root != *block.MerkleRoot {
+		// block_rewards changed after publishing; never hand out a bad proof
+		return nil, fmt.Errorf("rebuilt merkle root %s does not match published %s", root, *block.MerkleRoot)
+	}
+
+	wei, err := merkle.ToWei(rt.amounts[i])
+	if err != nil {
+		return nil, err
+	}
+	proof, err := rt.tree.Proof(i)
+	if err != nil {
+		return nil, err
+	}
+	leaf := merkle.Leaf(common.HexToAddress(wallet), wei)
+
+	hexProof := make([]string, len(proof))
+	for j, h := range proof {
+		hexProof[j] = h.Hex()
+	}
+	return &RewardProof{
+		BlockNumber:         block.BlockNumber,
+		MerkleRoot:          *block.MerkleRoot,
+		Account:             common.HexToAddress(wallet).Hex(),
+		CumulativeAmount:    rt.amounts[i],
+		CumulativeAmountWei: wei.String(),
+		Leaf:                leaf.Hex(),
+		Proof:               hexProof,
+	}, nil
+}
+
+// rewardTree returns the cached tree for block, rebuilding it if the cache
+// holds a different block.
+func (s *BlockRewardService) rewardTree(ctx context.Context, block db.MintBlock) (*rewardTree, error) {
+	s.trees.mu.Lock()
+	defer s.trees.mu.Unlock()
+
+	if s.trees.tree != nil && s.trees.tree.blockID == block.ID {
+		return s.trees.tree, nil
+	}
+	rt, err := buildRewardTree(ctx, s.queries, block.ID, block.BlockNumber)
+	if err != nil {
+		return nil, err
+	}
+	s.trees.tree = rt
+	return rt, nil
+}
@@ -8,20 +8,29 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/config"
 	"jd7008911/canlan.org/internal/db"
 )
 
 // SwapService handles token swap operations.
 type SwapService struct {
 	queries  *db.Queries
+	tx       db.TxRunner
+	cfg      *config.Config
 	assetSvc *AssetService
+	ledger   *LedgerService
+	nodeSvc  *NodeService
 }
 
 // NewSwapService creates a new swap service.
-func NewSwapService(queries *db.Queries, assetSvc *AssetService) *SwapService {
+func NewSwapService(queries *db.Queries, tx db.TxRunner, cfg *config.Config, assetSvc *AssetService, ledger *LedgerService, nodeSvc *NodeService) *SwapService {
 	return &SwapService{
 		queries:  queries,
+		tx:       tx,
+		cfg:      cfg,
 		assetSvc: assetSvc,
+		ledger:   ledger,
+		nodeSvc:  nodeSvc,
 	}
 }
 
@@ -36,21 +45,45 @@ type SwapParams struct {
 	ToToken   string          // e.g., "CAN"
 	Amount    decimal.Decimal // amount of fromToken to swap
 	TxHash    string          // on‑chain transaction hash
+
+	// Slippage protection: either a quote ID from QuoteSwap, or a minimum
+	// output and a deadline chosen by the client.
+	QuoteID      string
+	MinAmountOut decimal.Decimal
+	Deadline     time.Time
 }
 
 // SwapResult contains the outcome of a swap.
 type SwapResult struct {
-	FromAmount decimal.Decimal `json:"from_amount"`
-	ToAmount   decimal.Decimal `json:"to_amount"`
-	Rate       decimal.Decimal `json:"rate"`
-	TxHash     string          `json:"tx_hash"`
+	SwapID      uuid.UUID       `json:"swap_id"`
+	FromAmount  decimal.Decimal `json:"from_amount"`
+	ToAmount    decimal.Decimal `json:"to_amount"`
+	Rate        decimal.Decimal `json:"rate"`
+	Fee         decimal.Decimal `json:"fee"`          // LP fees of all hops, in fromToken
+	FeeRebate   decimal.Decimal `json:"fee_rebate"`   // part of Fee refunded by the node level's discount
+	PriceImpact decimal.Decimal `json:"price_impact"` // fraction, 0.01 = 1%
+	Route       []SwapHop       `json:"route"`
+	TxHash      string          `json:"tx_hash"`
 }
 
-// ExecuteSwap converts one token to another at the current market rate.
-// It deducts the fromToken from the user's balance and adds the toToken,
-// then records the swap transaction.
+// ExecuteSwap converts one token to another. The swap is routed through the
+// active pools (directly or over up to SwapConfig.MaxHops pools) along the
+// path with the best output, and moves every pool's reserves; when no pools
+// connect the pair the treasury fills it at the static price ratio. The
+// balance check, the pool updates, the swap record and the postings happen
+// in one transaction. The swap is rejected if it would pay out less than the
+// quoted (or client-supplied) minimum, or runs past its deadline. A node
+// level with a swap fee discount has that share of the fee rebated by the
+// treasury in fromToken.
 func (s *SwapService) ExecuteSwap(ctx context.Context, params SwapParams) (*SwapResult, error) {
-	// 1. Validate tokens and get current rate
+	// 1. Resolve slippage protection and validate tokens
+	if err := s.resolveProtection(&params); err != nil {
+		return nil, err
+	}
+	if time.Now().After(params.Deadline) {
+		return nil, ErrDeadlinePassed
+	}
+
 	fromToken, err := s.queries.GetTokenBySymbol(ctx, params.FromToken)
 	if err != nil {
 		return nil, fmt.Errorf("from token not found: %s", params.FromToken)
@@ -59,81 +92,231 @@ func (s *SwapService) ExecuteSwap(ctx context.Context, params SwapParams) (*Swap
 	if err != nil {
 		return nil, fmt.Errorf("to token not found: %s", params.ToToken)
 	}
-
-	// 2. Get swap rate (from token → to token)
-	rate, err := s.queries.GetSwapRate(ctx, db.GetSwapRateParams{
-		FromTokenID: fromToken.ID,
-		ToTokenID:   toToken.ID,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get swap rate: %w", err)
+	if fromToken.ID == toToken.ID {
+		return nil, fmt.Errorf("cannot swap a token for itself")
 	}
-	if rate.IsZero() {
-		return nil, fmt.Errorf("swap rate is zero – price unavailable")
+	rights, err := s.nodeSvc.GetUserRights(ctx, params.UserID)
+	if err != nil {
+		return nil, err
 	}
 
-	// 3. Calculate output amount
-	toAmount := params.Amount.Mul(rate)
+	var result *SwapResult
+	err = s.tx.WithTx(ctx, func(q *db.Queries) error {
+		// 2. Lock and check the user's fromToken balance
+		balances, err := lockBalances(ctx, q, params.UserID, fromToken.ID)
+		if err != nil {
+			return err
+		}
+		if err := requireBalance(balances, fromToken.ID, params.FromToken, params.Amount); err != nil {
+			return err
+		}
 
-	// 4. Check user's fromToken balance
-	fromBalance, err := s.assetSvc.GetUserTokenBalance(ctx, params.UserID, params.FromToken)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch %s balance: %w", params.FromToken, err)
-	}
-	if fromBalance.LessThan(params.Amount) {
-		return nil, fmt.Errorf("insufficient %s balance: have %s, need %s",
-			params.FromToken, fromBalance.String(), params.Amount.String())
-	}
+		// 3. Price the swap; the route's pools are locked, so the price is final
+		plan, err := s.planSwap(ctx, q, fromToken.ID, toToken.ID, params.Amount, true)
+		if err != nil {
+			return err
+		}
+		if plan.amountOut.LessThan(params.MinAmountOut) {
+			return fmt.Errorf("%w: would receive %s %s, minimum %s",
+				ErrSlippageExceeded, plan.amountOut.String(), params.ToToken, params.MinAmountOut.String())
+		}
+		if time.Now().After(params.Deadline) {
+			return ErrDeadlinePassed
+		}
+
+		// 4. Move the pools' reserves
+		if err := applyRoute(ctx, q, plan.legs); err != nil {
+			return err
+		}
+
+		// 5. Record the swap and its legs
+		var poolID *uuid.UUID
+		if len(plan.legs) == 1 {
+			poolID = &plan.legs[0].pool.ID
+		}
+		swap, err := q.CreateSwap(ctx, db.CreateSwapParams{
+			UserID:      params.UserID,
+			FromTokenID: fromToken.ID,
+			ToTokenID:   toToken.ID,
+			FromAmount:  params.Amount,
+			ToAmount:    plan.amountOut,
+			Rate:        plan.rate,
+			TxHash:      params.TxHash,
+			PoolID:      poolID,
+			FeeAmount:   plan.fee,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record swap: %w", err)
+		}
+
+		symbols, err := tokenSymbols(ctx, q)
+		if err != nil {
+			return err
+		}
+		route := plan.route(symbols, fromToken, toToken, params.Amount)
+		for i, hop := range route {
+			if _, err := q.CreateSwapLeg(ctx, db.CreateSwapLegParams{
+				SwapID:      swap.ID,
+				LegIndex:    int32(i),
+				PoolID:      hop.PoolID,
+				FromTokenID: plan.legTokenIn(i, fromToken.ID),
+				ToTokenID:   plan.legTokenOut(i, toToken.ID),
+				AmountIn:    hop.AmountIn,
+				AmountOut:   hop.AmountOut,
+				FeeAmount:   hop.Fee,
+			}); err != nil {
+				return fmt.Errorf("failed to record swap leg: %w", err)
+			}
+		}
 
-	// 5. Deduct fromToken, add toToken (atomic operation – should be in a transaction)
-	err = s.queries.SubtractUserBalance(ctx, db.SubtractUserBalanceParams{
-		UserID:  params.UserID,
-		TokenID: fromToken.ID,
-		Balance: params.Amount,
+		// 6. Settle: user -> pools -> user, or user <-> treasury
+		entry := JournalEntry{
+			Type:        "swap",
+			ReferenceID: &swap.ID,
+			Description: fmt.Sprintf("swap %s %s -> %s %s (tx %s)", params.Amount.String(), params.FromToken, plan.amountOut.String(), params.ToToken, params.TxHash),
+		}
+		rebate := feeRebate(plan.fee, rights)
+		if len(plan.legs) > 0 {
+			entry.Add(routePostings(params.UserID, plan.legs)...)
+			if rebate.IsPositive() {
+				entry.Add(Transfer(SystemAccount(AccountTreasury, fromToken.ID), UserAccount(params.UserID, fromToken.ID), rebate)...)
+			}
+		} else {
+			entry.Add(Transfer(UserAccount(params.UserID, fromToken.ID), SystemAccount(AccountTreasury, fromToken.ID), params.Amount)...)
+			entry.Add(Transfer(SystemAccount(AccountTreasury, toToken.ID), UserAccount(params.UserID, toToken.ID), plan.amountOut)...)
+		}
+		if _, err := s.ledger.PostTx(ctx, q, entry); err != nil {
+			return fmt.Errorf("failed to settle swap: %w", err)
+		}
+
+		result = &SwapResult{
+			SwapID:      swap.ID,
+			FromAmount:  params.Amount,
+			ToAmount:    plan.amountOut,
+			Rate:        plan.rate,
+			Fee:         plan.fee,
+			FeeRebate:   rebate,
+			PriceImpact: plan.priceImpact,
+			Route:       route,
+			TxHash:      params.TxHash,
+		}
+		return nil
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to deduct %s: %w", params.FromToken, err)
+		return nil, err
 	}
 
-	err = s.queries.AddUserBalance(ctx, db.AddUserBalanceParams{
-		UserID:  params.UserID,
-		TokenID: toToken.ID,
-		Balance: toAmount,
-	})
+	return result, nil
+}
+
+// ---------------------------------------------------------------------
+// Pricing
+// ---------------------------------------------------------------------
+
+// swapPlan is a priced swap: a route through pools, or a treasury fill at
+// the static price ratio when legs is empty.
+type swapPlan struct {
+	legs        []routeLeg
+	amountOut   decimal.Decimal
+	fee         decimal.Decimal
+	rate        decimal.Decimal
+	priceImpact decimal.Decimal
+}
+
+// planSwap prices a swap along the best route through the active pools.
+// With lock set, the route's pools are locked in the caller's transaction
+// and the route is re-priced against the locked rows.
+func (s *SwapService) planSwap(ctx context.Context, q *db.Queries, fromTokenID, toTokenID uuid.UUID, amount decimal.Decimal, lock bool) (*swapPlan, error) {
+	pools, err := q.ListLiquidityPools(ctx)
 	if err != nil {
-		// Attempt to rollback the deduction – in production use a real transaction.
-		_ = s.queries.AddUserBalance(ctx, db.AddUserBalanceParams{
-			UserID:  params.UserID,
-			TokenID: fromToken.ID,
-			Balance: params.Amount,
+		return nil, fmt.Errorf("failed to list pools: %w", err)
+	}
+
+	legs := findBestRoute(pools, fromTokenID, toTokenID, amount, s.cfg.Swap.MaxHops)
+	if legs == nil {
+		// The treasury fills at the stored USD prices, so both must be fresh.
+		for _, id := range []uuid.UUID{fromTokenID, toTokenID} {
+			token, err := q.GetTokenByID(ctx, id)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get token: %w", err)
+			}
+			if err := s.assetSvc.RequireFreshPrice(token); err != nil {
+				return nil, err
+			}
+		}
+		rate, err := q.GetSwapRate(ctx, db.GetSwapRateParams{
+			FromTokenID: fromTokenID,
+			ToTokenID:   toTokenID,
 		})
-		return nil, fmt.Errorf("failed to credit %s: %w", params.ToToken, err)
-	}
-
-	// 6. Record the swap
-	swap, err := s.queries.CreateSwap(ctx, db.CreateSwapParams{
-		UserID:      params.UserID,
-		FromTokenID: fromToken.ID,
-		ToTokenID:   toToken.ID,
-		FromAmount:  params.Amount,
-		ToAmount:    toAmount,
-		Rate:        rate,
-		TxHash:      params.TxHash,
-		Status:      "completed",
-	})
-	if err != nil {
-		// Non‑critical – log but don't revert the balance changes.
-		// logger.Error("failed to record swap transaction", "error", err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get swap rate: %w", err)
+		}
+		if rate.IsZero() {
+			return nil, fmt.Errorf("swap rate is zero – price unavailable")
+		}
+		return &swapPlan{
+			amountOut:   amount.Mul(rate),
+			fee:         decimal.Zero,
+			rate:        rate,
+			priceImpact: decimal.Zero,
+		}, nil
+	}
+
+	if lock {
+		if err := lockRoutePools(ctx, q, legs); err != nil {
+			return nil, err
+		}
+		if err := repriceRoute(legs, amount); err != nil {
+			return nil, err
+		}
 	}
 
-	return &SwapResult{
-		FromAmount: params.Amount,
-		ToAmount:   toAmount,
-		Rate:       rate,
-		TxHash:     params.TxHash,
+	out := routeOutput(legs)
+	return &swapPlan{
+		legs:        legs,
+		amountOut:   out,
+		fee:         routeFee(legs),
+		rate:        out.DivRound(amount, ammPrecision),
+		priceImpact: routePriceImpact(legs),
 	}, nil
 }
 
+// feeRebate is the share of a swap's fee the node level's discount refunds.
+func feeRebate(fee decimal.Decimal, rights *NodeRights) decimal.Decimal {
+	if rights.SwapFeeDiscountBps <= 0 {
+		return decimal.Zero
+	}
+	return divDown(fee.Mul(decimal.NewFromInt(int64(rights.SwapFeeDiscountBps))), bpsDenominator)
+}
+
+// route describes the plan's hops; a treasury fill is a single hop without a pool.
+func (p *swapPlan) route(symbols map[uuid.UUID]string, fromToken, toToken db.Token, amount decimal.Decimal) []SwapHop {
+	if len(p.legs) > 0 {
+		return routeHops(p.legs, symbols)
+	}
+	return []SwapHop{{
+		FromToken: fromToken.Symbol,
+		ToToken:   toToken.Symbol,
+		AmountIn:  amount,
+		AmountOut: p.amountOut,
+		Fee:       decimal.Zero,
+	}}
+}
+
+func (p *swapPlan) legTokenIn(i int, fromTokenID uuid.UUID) uuid.UUID {
+	if len(p.legs) > 0 {
+		return p.legs[i].tokenIn
+	}
+	return fromTokenID
+}
+
+func (p *swapPlan) legTokenOut(i int, toTokenID uuid.UUID) uuid.UUID {
+	if len(p.legs) > 0 {
+		return p.legs[i].tokenOut
+	}
+	return toTokenID
+}
+
 // ---------------------------------------------------------------------
 // Queries
 // ---------------------------------------------------------------------
@@ -147,6 +330,43 @@ func (s *SwapService) GetUserSwaps(ctx context.Context, userID uuid.UUID, limit,
 	})
 }
 
+// CountUserSwaps returns the number of swaps a user has made.
+func (s *SwapService) CountUserSwaps(ctx context.Context, userID uuid.UUID) (int64, error) {
+	return s.queries.CountUserSwaps(ctx, userID)
+}
+
+// GetUserSwapSummary returns how many swaps a user has completed, the fees
+// they paid and when they first and last swapped.
+func (s *SwapService) GetUserSwapSummary(ctx context.Context, userID uuid.UUID) (*db.GetUserSwapSummaryRow, error) {
+	summary, err := s.queries.GetUserSwapSummary(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get swap summary: %w", err)
+	}
+	return &summary, nil
+}
+
+// SwapDetail is a swap together with the legs of its route.
+type SwapDetail struct {
+	db.Swap
+	Legs []db.GetSwapLegsRow `json:"legs"`
+}
+
+// GetSwapByID returns one of the user's swaps with its legs in route order.
+func (s *SwapService) GetSwapByID(ctx context.Context, swapID, userID uuid.UUID) (*SwapDetail, error) {
+	swap, err := s.queries.GetSwapByID(ctx, swapID)
+	if err != nil {
+		return nil, fmt.Errorf("swap not found: %w", err)
+	}
+	if swap.UserID != userID {
+		return nil, fmt.Errorf("swap not found")
+	}
+	legs, err := s.queries.GetSwapLegs(ctx, swapID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch swap legs: %w", err)
+	}
+	return &SwapDetail{Swap: swap, Legs: legs}, nil
+}
+
 // GetUserSwapsByTimeRange returns swaps within a specific time range.
 func (s *SwapService) GetUserSwapsByTimeRange(ctx context.Context, userID uuid.UUID, start, end time.Time) ([]db.GetUserSwapsByTimeRangeRow, error) {
 	return s.queries.GetUserSwapsByTimeRange(ctx, db.GetUserSwapsByTimeRangeParams{
@@ -156,8 +376,24 @@ func (s *SwapService) GetUserSwapsByTimeRange(ctx context.Context, userID uuid.U
 	})
 }
 
-// GetSwapRate returns the current conversion rate between two tokens.
+// GetSwapRate returns the current conversion rate between two tokens: the
+// spot price along the best pool route when one exists (direct or multi-hop),
+// otherwise the static price ratio.
 func (s *SwapService) GetSwapRate(ctx context.Context, fromSymbol, toSymbol string) (decimal.Decimal, error) {
+	fromToken, err := s.queries.GetTokenBySymbol(ctx, fromSymbol)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("token %s not found", fromSymbol)
+	}
+	toToken, err := s.queries.GetTokenBySymbol(ctx, toSymbol)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("token %s not found", toSymbol)
+	}
+	if pools, err := s.queries.ListLiquidityPools(ctx); err == nil {
+		if legs := findBestRoute(pools, fromToken.ID, toToken.ID, decimal.NewFromInt(1), s.cfg.Swap.MaxHops); legs != nil {
+			return routeSpotPrice(legs), nil
+		}
+	}
+
 	rate, err := s.queries.GetSwapRateWithSymbols(ctx, db.GetSwapRateWithSymbolsParams{
 		FromSymbol: fromSymbol,
 		ToSymbol:   toSymbol,
@@ -184,12 +420,7 @@ type SwapVolumeStats struct {
 func (s *SwapService) GetSwapStats(ctx context.Context) (*SwapVolumeStats, error) {
 	volume24h, err := s.queries.GetSwapVolume24h(ctx)
 	if err != nil {
-		volume24h = decimal.Zero
-	}
-
-	swapCount24h, err := s.queries.GetSwapCount24h(ctx) // You would need to add this query
-	if err != nil {
-		swapCount24h = 0
+		volume24h = db.GetSwapVolume24hRow{Volume24h: decimal.Zero}
 	}
 
 	volumeByToken, err := s.queries.GetSwapVolumeByToken(ctx)
@@ -203,8 +434,8 @@ func (s *SwapService) GetSwapStats(ctx context.Context) (*SwapVolumeStats, error
 	}
 
 	return &SwapVolumeStats{
-		TotalVolume24h: volume24h,
-		SwapCount24h:   swapCount24h,
+		TotalVolume24h: volume24h.Volume24h,
+		SwapCount24h:   volume24h.SwapCount24h,
 		VolumeByToken:  volumeByToken,
 		PopularPairs:   popularPairs,
 	}, nil
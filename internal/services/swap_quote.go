@@ -0,0 +1,215 @@
+// internal/services/swap_quote.go
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrInvalidQuote      = errors.New("invalid swap quote")
+	ErrQuoteExpired      = errors.New("swap quote expired")
+	ErrDeadlinePassed    = errors.New("swap deadline passed")
+	ErrSlippageExceeded  = errors.New("swap output below minimum")
+	ErrSlippageProtected = errors.New("quote_id or min_amount_out and deadline are required")
+)
+
+// ---------------------------------------------------------------------
+// Quotes
+// ---------------------------------------------------------------------
+
+// SwapHop is one leg of a swap route.
+type SwapHop struct {
+	PoolID    *uuid.UUID      `json:"pool_id,omitempty"` // nil when filled by the treasury
+	FromToken string          `json:"from_token"`
+	ToToken   string          `json:"to_token"`
+	AmountIn  decimal.Decimal `json:"amount_in"`
+	AmountOut decimal.Decimal `json:"amount_out"`
+	Fee       decimal.Decimal `json:"fee"` // in FromToken
+}
+
+// SwapQuote is the expected outcome of a swap at current reserves.
+type SwapQuote struct {
+	QuoteID      string          `json:"quote_id"`
+	FromToken    string          `json:"from_token"`
+	ToToken      string          `json:"to_token"`
+	AmountIn     decimal.Decimal `json:"amount_in"`
+	AmountOut    decimal.Decimal `json:"amount_out"`
+	MinAmountOut decimal.Decimal `json:"min_amount_out"` // AmountOut less the slippage tolerance
+	Fee          decimal.Decimal `json:"fee"`            // in FromToken
+	FeeRebate    decimal.Decimal `json:"fee_rebate"`     // part of Fee refunded by the node level's discount
+	Rate         decimal.Decimal `json:"rate"`
+	PriceImpact  decimal.Decimal `json:"price_impact"` // fraction, 0.01 = 1%
+	SlippageBps  int             `json:"slippage_bps"`
+	Route        []SwapHop       `json:"route"`
+	ExpiresAt    time.Time       `json:"expires_at"`
+}
+
+// QuoteSwap prices a swap without executing it and returns a signed quote
+// ID that ExecuteSwap accepts until it expires. A nil slippage uses the
+// configured default; zero asks for no slippage at all.
+func (s *SwapService) QuoteSwap(ctx context.Context, userID uuid.UUID, fromSymbol, toSymbol string, amount decimal.Decimal, slippage *int) (*SwapQuote, error) {
+	if !amount.IsPositive() {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+	slippageBps := s.cfg.Swap.DefaultSlippageBps
+	if slippage != nil {
+		slippageBps = *slippage
+	}
+	if slippageBps < 0 || slippageBps > s.cfg.Swap.MaxSlippageBps {
+		return nil, fmt.Errorf("slippage must be between 0 and %d bps", s.cfg.Swap.MaxSlippageBps)
+	}
+
+	fromToken, err := s.queries.GetTokenBySymbol(ctx, fromSymbol)
+	if err != nil {
+		return nil, fmt.Errorf("from token not found: %s", fromSymbol)
+	}
+	toToken, err := s.queries.GetTokenBySymbol(ctx, toSymbol)
+	if err != nil {
+		return nil, fmt.Errorf("to token not found: %s", toSymbol)
+	}
+	if fromToken.ID == toToken.ID {
+		return nil, fmt.Errorf("cannot swap a token for itself")
+	}
+
+	plan, err := s.planSwap(ctx, s.queries, fromToken.ID, toToken.ID, amount, false)
+	if err != nil {
+		return nil, err
+	}
+	rights, err := s.nodeSvc.GetUserRights(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	symbols, err := tokenSymbols(ctx, s.queries)
+	if err != nil {
+		return nil, err
+	}
+
+	quote := &SwapQuote{
+		FromToken:    fromSymbol,
+		ToToken:      toSymbol,
+		AmountIn:     amount,
+		AmountOut:    plan.amountOut,
+		MinAmountOut: applySlippage(plan.amountOut, slippageBps),
+		Fee:          plan.fee,
+		FeeRebate:    feeRebate(plan.fee, rights),
+		Rate:         plan.rate,
+		PriceImpact:  plan.priceImpact,
+		SlippageBps:  slippageBps,
+		Route:        plan.route(symbols, fromToken, toToken, amount),
+		ExpiresAt:    time.Now().Add(s.cfg.Swap.QuoteTTL).UTC().Truncate(time.Second),
+	}
+	quote.QuoteID, err = s.signQuote(quoteClaims{
+		UserID:       userID,
+		FromToken:    fromSymbol,
+		ToToken:      toSymbol,
+		AmountIn:     amount,
+		MinAmountOut: quote.MinAmountOut,
+		ExpiresAt:    quote.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return quote, nil
+}
+
+// applySlippage returns amount reduced by slippageBps, rounded down.
+func applySlippage(amount decimal.Decimal, slippageBps int) decimal.Decimal {
+	return divDown(amount.Mul(bpsDenominator.Sub(decimal.NewFromInt(int64(slippageBps)))), bpsDenominator)
+}
+
+// ---------------------------------------------------------------------
+// Quote Signing
+// ---------------------------------------------------------------------
+
+// quoteClaims are the terms a quote ID commits to. Only the terms needed to
+// protect the user are signed; the output itself is recomputed at execution.
+type quoteClaims struct {
+	UserID       uuid.UUID       `json:"uid"`
+	FromToken    string          `json:"from"`
+	ToToken      string          `json:"to"`
+	AmountIn     decimal.Decimal `json:"in"`
+	MinAmountOut decimal.Decimal `json:"min_out"`
+	ExpiresAt    int64           `json:"exp"`
+}
+
+// signQuote encodes claims as base64url(JSON) "." base64url(HMAC-SHA256).
+func (s *SwapService) signQuote(claims quoteClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode quote: %w", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.quoteMAC(encoded)), nil
+}
+
+// verifyQuote checks a quote ID's signature and expiry and returns its terms.
+func (s *SwapService) verifyQuote(quoteID string) (*quoteClaims, error) {
+	encoded, sig, ok := strings.Cut(quoteID, ".")
+	if !ok {
+		return nil, ErrInvalidQuote
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, s.quoteMAC(encoded)) {
+		return nil, ErrInvalidQuote
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidQuote
+	}
+	var claims quoteClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidQuote
+	}
+	if time.Now().Unix() > claims.ExpiresAt {
+		return nil, ErrQuoteExpired
+	}
+	return &claims, nil
+}
+
+func (s *SwapService) quoteMAC(encoded string) []byte {
+	h := hmac.New(sha256.New, []byte(s.cfg.Swap.QuoteSecret))
+	h.Write([]byte("swap-quote:" + encoded))
+	return h.Sum(nil)
+}
+
+// ---------------------------------------------------------------------
+// Execution Guard
+// ---------------------------------------------------------------------
+
+// resolveProtection fills params.MinAmountOut and params.Deadline from a
+// quote ID, never lowering a stricter minimum the caller sent with it, or
+// checks that the caller supplied them directly.
+func (s *SwapService) resolveProtection(params *SwapParams) error {
+	if params.QuoteID != "" {
+		claims, err := s.verifyQuote(params.QuoteID)
+		if err != nil {
+			return err
+		}
+		if claims.UserID != params.UserID ||
+			!strings.EqualFold(claims.FromToken, params.FromToken) ||
+			!strings.EqualFold(claims.ToToken, params.ToToken) ||
+			!claims.AmountIn.Equal(params.Amount) {
+			return fmt.Errorf("%w: swap does not match the quoted terms", ErrInvalidQuote)
+		}
+		// The quote sets a floor; a client asking for more keeps its own
+		params.MinAmountOut = decimal.Max(claims.MinAmountOut, params.MinAmountOut)
+		params.Deadline = time.Unix(claims.ExpiresAt, 0)
+		return nil
+	}
+
+	if params.Deadline.IsZero() || params.MinAmountOut.IsNegative() {
+		return ErrSlippageProtected
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+// internal/services/swap_quote_test.go
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/config"
+)
+
+func newQuoteTestService(secret string) *SwapService {
+	return &SwapService{cfg: &config.Config{Swap: config.SwapConfig{QuoteSecret: secret}}}
+}
+
+func testClaims(userID uuid.UUID, expiresAt time.Time) quoteClaims {
+	return quoteClaims{
+		UserID:       userID,
+		FromToken:    "USDT",
+		ToToken:      "CAN",
+		AmountIn:     dec("100"),
+		MinAmountOut: dec("395.5"),
+		ExpiresAt:    expiresAt.Unix(),
+	}
+}
+
+func TestApplySlippage(t *testing.T) {
+	tests := []struct {
+		amount      string
+		slippageBps int
+		want        string
+	}{
+		{"400", 0, "400"},
+		{"400", 50, "398"},
+		{"400", 10000, "0"},
+		{"1.000000000000000019", 50, "0.995000000000000018"},
+		// Rounding to nearest here would raise the minimum above the quote
+		{"0.000000000000000099", 50, "0.000000000000000098"},
+	}
+	for _, tt := range tests {
+		if got := applySlippage(dec(tt.amount), tt.slippageBps); !got.Equal(dec(tt.want)) {
+			t.Errorf("applySlippage(%s, %d) = %s, want %s", tt.amount, tt.slippageBps, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteSignature(t *testing.T) {
+	s := newQuoteTestService("test-secret")
+	userID := uuid.New()
+	claims := testClaims(userID, time.Now().Add(time.Minute))
+
+	quoteID, err := s.signQuote(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.verifyQuote(quoteID)
+	if err != nil {
+		t.Fatalf("verifyQuote() error = %v", err)
+	}
+	if got.UserID != userID || !got.MinAmountOut.Equal(claims.MinAmountOut) || got.ExpiresAt != claims.ExpiresAt {
+		t.Errorf("verifyQuote() = %+v, want %+v", got, claims)
+	}
+
+	encoded, sig, _ := strings.Cut(quoteID, ".")
+	forged := claims
+	forged.MinAmountOut = decimal.Zero
+	payload, _ := json.Marshal(forged)
+
+	tests := []struct {
+		name    string
+		svc     *SwapService
+		quoteID string
+		want    error
+	}{
+		{"changed terms keep the old signature", s, base64.RawURLEncoding.EncodeToString(payload) + "." + sig, ErrInvalidQuote},
+		{"changed signature", s, encoded + "." + base64.RawURLEncoding.EncodeToString([]byte("not a mac")), ErrInvalidQuote},
+		{"signature not base64", s, encoded + ".!!!", ErrInvalidQuote},
+		{"no signature", s, encoded, ErrInvalidQuote},
+		{"empty", s, "", ErrInvalidQuote},
+		{"signed with another secret", newQuoteTestService("other-secret"), quoteID, ErrInvalidQuote},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.svc.verifyQuote(tt.quoteID); !errors.Is(err, tt.want) {
+				t.Errorf("verifyQuote() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuoteExpiry(t *testing.T) {
+	s := newQuoteTestService("test-secret")
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		want      error
+	}{
+		{"valid", time.Now().Add(time.Minute), nil},
+		{"expired", time.Now().Add(-2 * time.Second), ErrQuoteExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quoteID, err := s.signQuote(testClaims(uuid.New(), tt.expiresAt))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.verifyQuote(quoteID); !errors.Is(err, tt.want) {
+				t.Errorf("verifyQuote() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveProtection(t *testing.T) {
+	s := newQuoteTestService("test-secret")
+	userID := uuid.New()
+	expiresAt := time.Now().Add(time.Minute).Truncate(time.Second)
+	quoteID, err := s.signQuote(testClaims(userID, expiresAt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiredID, err := s.signQuote(testClaims(userID, time.Now().Add(-2*time.Second)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	quoted := func(mutate func(p *SwapParams)) SwapParams {
+		p := SwapParams{UserID: userID, FromToken: "USDT", ToToken: "CAN", Amount: dec("100"), QuoteID: quoteID}
+		if mutate != nil {
+			mutate(&p)
+		}
+		return p
+	}
+
+	tests := []struct {
+		name   string
+		params SwapParams
+		want   error
+	}{
+		{"matching quote", quoted(nil), nil},
+		{"symbols match case-insensitively", quoted(func(p *SwapParams) { p.FromToken, p.ToToken = "usdt", "can" }), nil},
+		{"quote raises a lower client minimum", quoted(func(p *SwapParams) { p.MinAmountOut = dec("1") }), nil},
+		{"higher client minimum is kept", quoted(func(p *SwapParams) { p.MinAmountOut = dec("399") }), nil},
+		{"another user", quoted(func(p *SwapParams) { p.UserID = uuid.New() }), ErrInvalidQuote},
+		{"another input token", quoted(func(p *SwapParams) { p.FromToken = "LAN" }), ErrInvalidQuote},
+		{"another output token", quoted(func(p *SwapParams) { p.ToToken = "LAN" }), ErrInvalidQuote},
+		{"another amount", quoted(func(p *SwapParams) { p.Amount = dec("100.000000000000000001") }), ErrInvalidQuote},
+		{"expired quote", quoted(func(p *SwapParams) { p.QuoteID = expiredID }), ErrQuoteExpired},
+		{"client terms", SwapParams{MinAmountOut: dec("1"), Deadline: time.Now().Add(time.Minute)}, nil},
+		{"client accepts any output", SwapParams{MinAmountOut: decimal.Zero, Deadline: time.Now().Add(time.Minute)}, nil},
+		{"no deadline", SwapParams{MinAmountOut: dec("1")}, ErrSlippageProtected},
+		{"negative minimum", SwapParams{MinAmountOut: dec("-1"), Deadline: time.Now().Add(time.Minute)}, ErrSlippageProtected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := tt.params
+			err := s.resolveProtection(&params)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("resolveProtection() error = %v, want %v", err, tt.want)
+			}
+			if err == nil && params.QuoteID != "" {
+				wantMin := decimal.Max(dec("395.5"), tt.params.MinAmountOut)
+				if !params.MinAmountOut.Equal(wantMin) || !params.Deadline.Equal(expiresAt) {
+					t.Errorf("resolveProtection() set min %s deadline %s, want %s %s", params.MinAmountOut, params.Deadline, wantMin, expiresAt)
+				}
+			}
+		})
+	}
+}
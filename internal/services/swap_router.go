@@ -0,0 +1,241 @@
+// internal/services/swap_router.go
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/db"
+)
+
+// Multi-hop routing: a swap may pass through up to SwapConfig.MaxHops pools
+// (e.g. LAN -> USDT -> CAN) when no direct pool trades the pair. Each pool
+// and each token appears at most once on a route.
+
+// routeLeg is one priced hop of a route through a pool.
+type routeLeg struct {
+	pool      *db.LiquidityPool
+	tokenIn   uuid.UUID
+	tokenOut  uuid.UUID
+	amountIn  decimal.Decimal
+	amountOut decimal.Decimal
+	fee       decimal.Decimal // in tokenIn
+}
+
+// ---------------------------------------------------------------------
+// Path Finding
+// ---------------------------------------------------------------------
+
+// findBestRoute searches every path of at most maxHops pools from tokenIn
+// to tokenOut and returns the legs of the one with the largest output, or
+// nil when the pools do not connect the pair.
+func findBestRoute(pools []db.LiquidityPool, tokenIn, tokenOut uuid.UUID, amountIn decimal.Decimal, maxHops int) []routeLeg {
+	if maxHops < 1 {
+		maxHops = 1
+	}
+
+	var best []routeLeg
+	visited := map[uuid.UUID]bool{tokenIn: true}
+	used := make(map[uuid.UUID]bool)
+
+	var search func(current uuid.UUID, amount decimal.Decimal, path []routeLeg)
+	search = func(current uuid.UUID, amount decimal.Decimal, path []routeLeg) {
+		if len(path) == maxHops {
+			return
+		}
+		for i := range pools {
+			pool := &pools[i]
+			if used[pool.ID] || !pool.Reserve0.IsPositive() || !pool.Reserve1.IsPositive() {
+				continue
+			}
+			var next uuid.UUID
+			switch current {
+			case pool.Token0ID:
+				next = pool.Token1ID
+			case pool.Token1ID:
+				next = pool.Token0ID
+			default:
+				continue
+			}
+			if visited[next] {
+				continue
+			}
+
+			reserveIn, reserveOut, _ := orientReserves(pool, current)
+			out, fee, err := getAmountOut(amount, reserveIn, reserveOut, pool.FeeBps)
+			if err != nil {
+				continue
+			}
+			leg := routeLeg{pool: pool, tokenIn: current, tokenOut: next, amountIn: amount, amountOut: out, fee: fee}
+			extended := append(path[:len(path):len(path)], leg)
+
+			if next == tokenOut {
+				if best == nil || out.GreaterThan(best[len(best)-1].amountOut) {
+					best = extended
+				}
+				continue
+			}
+
+			visited[next], used[pool.ID] = true, true
+			search(next, out, extended)
+			visited[next], used[pool.ID] = false, false
+		}
+	}
+	search(tokenIn, amountIn, nil)
+	return best
+}
+
+// repriceRoute recomputes every leg of a route from amountIn using the
+// current state of its pools.
+func repriceRoute(legs []routeLeg, amountIn decimal.Decimal) error {
+	amount := amountIn
+	for i := range legs {
+		reserveIn, reserveOut, _ := orientReserves(legs[i].pool, legs[i].tokenIn)
+		out, fee, err := getAmountOut(amount, reserveIn, reserveOut, legs[i].pool.FeeBps)
+		if err != nil {
+			return err
+		}
+		legs[i].amountIn, legs[i].amountOut, legs[i].fee = amount, out, fee
+		amount = out
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------
+// Route Metrics
+// ---------------------------------------------------------------------
+
+// routeOutput is the amount the last leg pays out.
+func routeOutput(legs []routeLeg) decimal.Decimal {
+	return legs[len(legs)-1].amountOut
+}
+
+// routeSpotPrice is the product of the spot prices of every leg.
+func routeSpotPrice(legs []routeLeg) decimal.Decimal {
+	price := decimal.NewFromInt(1)
+	for _, leg := range legs {
+		reserveIn, reserveOut, _ := orientReserves(leg.pool, leg.tokenIn)
+		price = price.Mul(spotPrice(reserveIn, reserveOut))
+	}
+	return price.Round(ammPrecision)
+}
+
+// routeFee expresses the fees of all legs as a share of the input:
+// amountIn * (1 - prod(1 - fee_i)).
+func routeFee(legs []routeLeg) decimal.Decimal {
+	kept := decimal.NewFromInt(1)
+	for _, leg := range legs {
+		kept = kept.Mul(bpsDenominator.Sub(decimal.NewFromInt32(leg.pool.FeeBps))).Div(bpsDenominator)
+	}
+	return legs[0].amountIn.Mul(decimal.NewFromInt(1).Sub(kept)).Truncate(ammPrecision)
+}
+
+// routePriceImpact compares the route's output with what the input would
+// buy at every pool's spot price after fees.
+func routePriceImpact(legs []routeLeg) decimal.Decimal {
+	ideal := legs[0].amountIn
+	for _, leg := range legs {
+		reserveIn, reserveOut, _ := orientReserves(leg.pool, leg.tokenIn)
+		afterFee := ideal.Mul(bpsDenominator.Sub(decimal.NewFromInt32(leg.pool.FeeBps))).Div(bpsDenominator)
+		ideal = afterFee.Mul(reserveOut).DivRound(reserveIn, ammPrecision)
+	}
+	if ideal.IsZero() {
+		return decimal.Zero
+	}
+	return decimal.NewFromInt(1).Sub(routeOutput(legs).DivRound(ideal, ammPrecision)).Round(6)
+}
+
+// ---------------------------------------------------------------------
+// Locking & Settlement
+// ---------------------------------------------------------------------
+
+// lockRoutePools locks every pool on the route in ID order (so concurrent
+// swaps over overlapping routes cannot deadlock) and points the legs at the
+// locked rows.
+func lockRoutePools(ctx context.Context, q *db.Queries, legs []routeLeg) error {
+	order := make([]int, len(legs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return legs[order[a]].pool.ID.String() < legs[order[b]].pool.ID.String()
+	})
+
+	for _, i := range order {
+		pool, err := q.LockLiquidityPool(ctx, legs[i].pool.ID)
+		if err != nil {
+			return fmt.Errorf("failed to lock pool: %w", err)
+		}
+		if !pool.IsActive {
+			return fmt.Errorf("pool %s is not active", pool.Name)
+		}
+		legs[i].pool = &pool
+	}
+	return nil
+}
+
+// applyRoute moves the reserves of every pool on a priced, locked route.
+func applyRoute(ctx context.Context, q *db.Queries, legs []routeLeg) error {
+	for _, leg := range legs {
+		_, _, zeroForOne := orientReserves(leg.pool, leg.tokenIn)
+		reserve0 := leg.pool.Reserve0.Add(leg.amountIn)
+		reserve1 := leg.pool.Reserve1.Sub(leg.amountOut)
+		if !zeroForOne {
+			reserve0 = leg.pool.Reserve0.Sub(leg.amountOut)
+			reserve1 = leg.pool.Reserve1.Add(leg.amountIn)
+		}
+		if err := setPoolReserves(ctx, q, leg.pool, reserve0, reserve1, leg.pool.LpTotalSupply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// routePostings moves the input from the user through each pool on the
+// route and the output back to the user. Intermediate tokens pass directly
+// from one pool's reserve account to the next.
+func routePostings(userID uuid.UUID, legs []routeLeg) []Posting {
+	first, last := legs[0], legs[len(legs)-1]
+	postings := Transfer(UserAccount(userID, first.tokenIn), PoolAccount(first.pool.ID, first.tokenIn), first.amountIn)
+	for i := 0; i < len(legs)-1; i++ {
+		postings = append(postings, Transfer(
+			PoolAccount(legs[i].pool.ID, legs[i].tokenOut),
+			PoolAccount(legs[i+1].pool.ID, legs[i].tokenOut),
+			legs[i].amountOut,
+		)...)
+	}
+	return append(postings, Transfer(PoolAccount(last.pool.ID, last.tokenOut), UserAccount(userID, last.tokenOut), last.amountOut)...)
+}
+
+// routeHops describes a route for API responses.
+func routeHops(legs []routeLeg, symbols map[uuid.UUID]string) []SwapHop {
+	hops := make([]SwapHop, len(legs))
+	for i, leg := range legs {
+		poolID := leg.pool.ID
+		hops[i] = SwapHop{
+			PoolID:    &poolID,
+			FromToken: symbols[leg.tokenIn],
+			ToToken:   symbols[leg.tokenOut],
+			AmountIn:  leg.amountIn,
+			AmountOut: leg.amountOut,
+			Fee:       leg.fee,
+		}
+	}
+	return hops
+}
+
+// tokenSymbols maps token IDs to symbols for describing routes.
+func tokenSymbols(ctx context.Context, q *db.Queries) (map[uuid.UUID]string, error) {
+	tokens, err := q.ListAllTokens(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tokens: %w", err)
+	}
+	symbols := make(map[uuid.UUID]string, len(tokens))
+	for _, t := range tokens {
+		symbols[t.ID] = t.Symbol
+	}
+	return symbols, nil
+}
@@ -0,0 +1,202 @@
+// internal/services/swap_router_test.go
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/db"
+)
+
+func testPool(token0, token1 uuid.UUID, reserve0, reserve1 string) db.LiquidityPool {
+	return db.LiquidityPool{
+		ID:       uuid.New(),
+		Token0ID: token0,
+		Token1ID: token1,
+		Reserve0: dec(reserve0),
+		Reserve1: dec(reserve1),
+		FeeBps:   30,
+		IsActive: true,
+	}
+}
+
+// routeOf lists the pool IDs a route passes through.
+func routeOf(legs []routeLeg) []uuid.UUID {
+	ids := make([]uuid.UUID, len(legs))
+	for i, leg := range legs {
+		ids[i] = leg.pool.ID
+	}
+	return ids
+}
+
+func TestFindBestRoute(t *testing.T) {
+	lan, usdt, can, btc := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	shallow := testPool(lan, can, "10", "10")
+	lanUSDT := testPool(lan, usdt, "1000", "1000")
+	usdtCAN := testPool(can, usdt, "1000", "1000") // reversed orientation
+	emptyBTC := testPool(lan, btc, "0", "0")
+	pools := []db.LiquidityPool{shallow, lanUSDT, usdtCAN, emptyBTC}
+
+	tests := []struct {
+		name     string
+		from, to uuid.UUID
+		amount   string
+		maxHops  int
+		want     []uuid.UUID
+	}{
+		{"deeper two-hop route beats a shallow direct pool", lan, can, "1", 2, []uuid.UUID{lanUSDT.ID, usdtCAN.ID}},
+		{"hop limit keeps the direct pool", lan, can, "1", 1, []uuid.UUID{shallow.ID}},
+		{"hop limit below one means direct only", lan, can, "1", 0, []uuid.UUID{shallow.ID}},
+		{"reverse direction", can, lan, "1", 2, []uuid.UUID{usdtCAN.ID, lanUSDT.ID}},
+		{"direct pool when it pays more", usdt, lan, "1", 3, []uuid.UUID{lanUSDT.ID}},
+		{"empty pool is skipped", lan, btc, "1", 3, nil},
+		{"unconnected token", usdt, btc, "1", 3, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			legs := findBestRoute(pools, tt.from, tt.to, dec(tt.amount), tt.maxHops)
+			got := routeOf(legs)
+			if len(got) != len(tt.want) {
+				t.Fatalf("findBestRoute() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("findBestRoute() = %v, want %v", got, tt.want)
+				}
+			}
+			if legs == nil {
+				return
+			}
+			if legs[0].tokenIn != tt.from || legs[len(legs)-1].tokenOut != tt.to {
+				t.Errorf("route runs %s -> %s, want %s -> %s", legs[0].tokenIn, legs[len(legs)-1].tokenOut, tt.from, tt.to)
+			}
+
+			// Each leg must spend exactly what the previous one paid out
+			amount := dec(tt.amount)
+			for i, leg := range legs {
+				reserveIn, reserveOut, _ := orientReserves(leg.pool, leg.tokenIn)
+				out, _, err := getAmountOut(amount, reserveIn, reserveOut, leg.pool.FeeBps)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !leg.amountIn.Equal(amount) || !leg.amountOut.Equal(out) {
+					t.Errorf("leg %d: %s -> %s, want %s -> %s", i, leg.amountIn, leg.amountOut, amount, out)
+				}
+				if i > 0 && leg.tokenIn != legs[i-1].tokenOut {
+					t.Errorf("leg %d starts with %s, previous leg paid %s", i, leg.tokenIn, legs[i-1].tokenOut)
+				}
+				amount = out
+			}
+		})
+	}
+}
+
+func TestFindBestRouteVisitsTokensOnce(t *testing.T) {
+	a, b, c := uuid.New(), uuid.New(), uuid.New()
+	// A second, much deeper A-B pool must not be used to loop back to A
+	pools := []db.LiquidityPool{
+		testPool(a, b, "1000", "1000"),
+		testPool(a, b, "1000000", "1000000"),
+		testPool(b, c, "1000", "1000"),
+	}
+	legs := findBestRoute(pools, a, c, dec("1"), 4)
+	if len(legs) != 2 {
+		t.Fatalf("findBestRoute() has %d legs, want 2", len(legs))
+	}
+	if legs[0].pool.ID != pools[1].ID {
+		t.Errorf("first leg uses pool %s, want the deeper pool %s", legs[0].pool.ID, pools[1].ID)
+	}
+}
+
+func TestRepriceRoute(t *testing.T) {
+	lan, usdt, can := uuid.New(), uuid.New(), uuid.New()
+	pools := []db.LiquidityPool{testPool(lan, usdt, "1000", "1000"), testPool(can, usdt, "1000", "1000")}
+	legs := findBestRoute(pools, lan, can, dec("10"), 2)
+	if len(legs) != 2 {
+		t.Fatalf("findBestRoute() has %d legs, want 2", len(legs))
+	}
+	quoted := routeOutput(legs)
+
+	// Another swap drained USDT from the first pool since the quote
+	pools[0].Reserve0, pools[0].Reserve1 = dec("1500"), dec("666.666666666666666667")
+	if err := repriceRoute(legs, dec("10")); err != nil {
+		t.Fatal(err)
+	}
+	if !routeOutput(legs).LessThan(quoted) {
+		t.Errorf("repriced output %s, want less than quoted %s", routeOutput(legs), quoted)
+	}
+	if !legs[1].amountIn.Equal(legs[0].amountOut) {
+		t.Errorf("second leg spends %s, first leg paid %s", legs[1].amountIn, legs[0].amountOut)
+	}
+
+	pools[1].Reserve0 = decimal.Zero
+	if err := repriceRoute(legs, dec("10")); !errors.Is(err, ErrInsufficientLiquidity) {
+		t.Errorf("repriceRoute() on an empty pool error = %v, want ErrInsufficientLiquidity", err)
+	}
+}
+
+func TestRouteFee(t *testing.T) {
+	lan, usdt, can := uuid.New(), uuid.New(), uuid.New()
+	direct := testPool(lan, can, "1000", "1000")
+	first, second := testPool(lan, usdt, "1000", "1000"), testPool(usdt, can, "1000", "1000")
+	second.FeeBps = 100
+
+	tests := []struct {
+		name   string
+		legs   []routeLeg
+		amount string
+		want   string
+	}{
+		{"one hop", []routeLeg{{pool: &direct, amountIn: dec("100")}}, "100", "0.3"},
+		// 100 * (1 - 0.997 * 0.99)
+		{"two hops compound", []routeLeg{{pool: &first, amountIn: dec("100")}, {pool: &second}}, "100", "1.297"},
+	}
+	for _, tt := range tests {
+		if got := routeFee(tt.legs); !got.Equal(dec(tt.want)) {
+			t.Errorf("%s: routeFee() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRoutePostings(t *testing.T) {
+	lan, usdt, can := uuid.New(), uuid.New(), uuid.New()
+	pools := []db.LiquidityPool{
+		testPool(lan, usdt, "1000", "2000"),
+		testPool(usdt, can, "3000", "1000"),
+		testPool(lan, can, "1", "1"),
+	}
+	userID := uuid.New()
+
+	for _, maxHops := range []int{1, 2} {
+		legs := findBestRoute(pools, lan, can, dec("5"), maxHops)
+		if len(legs) != maxHops {
+			t.Fatalf("maxHops %d: route has %d legs", maxHops, len(legs))
+		}
+		postings := routePostings(userID, legs)
+		if err := validateEntry(postings); err != nil {
+			t.Errorf("maxHops %d: routePostings() does not balance: %v", maxHops, err)
+		}
+
+		// The user pays the input and receives the output; pools keep the rest
+		net := make(map[LedgerAccount]decimal.Decimal)
+		for _, p := range postings {
+			net[p.Account] = net[p.Account].Add(p.Amount)
+		}
+		if got := net[UserAccount(userID, lan)]; !got.Equal(dec("-5")) {
+			t.Errorf("maxHops %d: user LAN moves %s, want -5", maxHops, got)
+		}
+		if got := net[UserAccount(userID, can)]; !got.Equal(routeOutput(legs)) {
+			t.Errorf("maxHops %d: user CAN moves %s, want %s", maxHops, got, routeOutput(legs))
+		}
+		for _, leg := range legs {
+			if got := net[PoolAccount(leg.pool.ID, leg.tokenIn)]; !got.Equal(leg.amountIn) {
+				t.Errorf("maxHops %d: pool receives %s, want %s", maxHops, got, leg.amountIn)
+			}
+			if got := net[PoolAccount(leg.pool.ID, leg.tokenOut)]; !got.Equal(leg.amountOut.Neg()) {
+				t.Errorf("maxHops %d: pool pays %s, want %s", maxHops, got.Neg(), leg.amountOut)
+			}
+		}
+	}
+}
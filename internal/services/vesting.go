@@ -0,0 +1,382 @@
+// internal/services/vesting.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/config"
+	"jd7008911/canlan.org/internal/db"
+)
+
+// Earnings that can vest, stored in vesting_schedules.source.
+const (
+	VestingSourceBlockReward = "block_reward"
+	VestingSourceMining      = "mining"
+	VestingSourceReferral    = "referral_commission"
+)
+
+// Vesting schedule statuses.
+const (
+	vestingActive    = "active"
+	vestingCompleted = "completed"
+	vestingUnlocked  = "unlocked"
+)
+
+// vestingReleaseBatch is how many schedule IDs the release job reads at once.
+const vestingReleaseBatch = 500
+
+var (
+	ErrEarlyUnlockDisabled = errors.New("early unlock is not allowed")
+	ErrVestingNotFound     = errors.New("vesting schedule not found")
+)
+
+// VestingService pays earnings to users according to the configured
+// vesting policies and releases vested amounts over time.
+type VestingService struct {
+	queries *db.Queries
+	tx      db.TxRunner
+	cfg     *config.Config
+	ledger  *LedgerService
+}
+
+// NewVestingService creates a new vesting service.
+func NewVestingService(queries *db.Queries, tx db.TxRunner, cfg *config.Config, ledger *LedgerService) *VestingService {
+	return &VestingService{
+		queries: queries,
+		tx:      tx,
+		cfg:     cfg,
+		ledger:  ledger,
+	}
+}
+
+// ---------------------------------------------------------------------
+// Paying Earnings
+// ---------------------------------------------------------------------
+
+// Payout is an earning paid to a user out of a system account.
+type Payout struct {
+	UserID      uuid.UUID
+	TokenID     uuid.UUID
+	From        LedgerAccount
+	Amount      decimal.Decimal
+	Source      string // VestingSource*
+	EntryType   string // journal entry type, e.g. "reward_claim"
+	ReferenceID *uuid.UUID
+	Description string
+}
+
+// policy returns the vesting policy for an earning source.
+func (s *VestingService) policy(source string) config.VestingPolicy {
+	switch source {
+	case VestingSourceBlockReward:
+		return s.cfg.Vesting.BlockRewards
+	case VestingSourceMining:
+		return s.cfg.Vesting.Mining
+	case VestingSourceReferral:
+		return s.cfg.Vesting.ReferralCommissions
+	}
+	return config.VestingPolicy{ImmediateBps: 10000}
+}
+
+// Pay posts a payout in its own transaction. Use PayTx when the payout is
+// part of a larger unit of work.
+func (s *VestingService) Pay(ctx context.Context, p Payout) (*db.JournalEntry, error) {
+	var je *db.JournalEntry
+	err := s.tx.WithTx(ctx, func(q *db.Queries) error {
+		var err error
+		je, err = s.PayTx(ctx, q, p)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return je, nil
+}
+
+// PayTx credits the immediate share of a payout to the user's balance and
+// the rest to their vesting account, in one journal entry, and opens a
+// vesting schedule for the locked part.
+func (s *VestingService) PayTx(ctx context.Context, q *db.Queries, p Payout) (*db.JournalEntry, error) {
+	policy := s.policy(p.Source)
+	immediate, locked := splitPayout(p.Amount, policy.ImmediateBps)
+
+	entry := JournalEntry{
+		Type:        p.EntryType,
+		ReferenceID: p.ReferenceID,
+		Description: p.Description,
+	}
+	entry.Add(Transfer(p.From, UserAccount(p.UserID, p.TokenID), immediate)...)
+	entry.Add(Transfer(p.From, VestingAccount(p.UserID, p.TokenID), locked)...)
+	je, err := s.ledger.PostTx(ctx, q, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if locked.IsPositive() {
+		now := time.Now()
+		if _, err := q.CreateVestingSchedule(ctx, db.CreateVestingScheduleParams{
+			UserID:         p.UserID,
+			TokenID:        p.TokenID,
+			Source:         p.Source,
+			ReferenceID:    p.ReferenceID,
+			JournalEntryID: je.ID,
+			Amount:         locked,
+			StartAt:        now,
+			EndAt:          now.Add(policy.Duration),
+		}); err != nil {
+			return nil, fmt.Errorf("failed to create vesting schedule: %w", err)
+		}
+	}
+	return je, nil
+}
+
+// splitPayout divides an amount into the part paid at once and the part
+// that vests. The immediate part is rounded down.
+func splitPayout(amount decimal.Decimal, immediateBps int) (immediate, locked decimal.Decimal) {
+	immediate = divDown(amount.Mul(decimal.NewFromInt(int64(immediateBps))), bpsDenominator)
+	return immediate, amount.Sub(immediate)
+}
+
+// ---------------------------------------------------------------------
+// Releasing
+// ---------------------------------------------------------------------
+
+// vestedAmount is the part of a schedule that has vested by now.
+func vestedAmount(sch db.VestingSchedule, now time.Time) decimal.Decimal {
+	if !now.Before(sch.EndAt) {
+		return sch.Amount
+	}
+	if !now.After(sch.StartAt) {
+		return decimal.Zero
+	}
+	elapsed := decimal.NewFromInt(int64(now.Sub(sch.StartAt)))
+	total := decimal.NewFromInt(int64(sch.EndAt.Sub(sch.StartAt)))
+	return divDown(sch.Amount.Mul(elapsed), total)
+}
+
+// releaseTx moves whatever has vested since the last release from the
+// vesting account to the user's balance. The schedule must be locked.
+func (s *VestingService) releaseTx(ctx context.Context, q *db.Queries, sch db.VestingSchedule, now time.Time) (decimal.Decimal, error) {
+	releasable := vestedAmount(sch, now).Sub(sch.ReleasedAmount)
+	if !releasable.IsPositive() {
+		return decimal.Zero, nil
+	}
+
+	entry := JournalEntry{
+		Type:        "vesting_release",
+		ReferenceID: &sch.ID,
+		Description: fmt.Sprintf("%s vesting release", sch.Source),
+	}
+	entry.Add(Transfer(VestingAccount(sch.UserID, sch.TokenID), UserAccount(sch.UserID, sch.TokenID), releasable)...)
+	if _, err := s.ledger.PostTx(ctx, q, entry); err != nil {
+		return decimal.Zero, err
+	}
+
+	released := sch.ReleasedAmount.Add(releasable)
+	status := vestingActive
+	if released.Equal(sch.Amount) {
+		status = vestingCompleted
+	}
+	if err := q.UpdateVestingRelease(ctx, db.UpdateVestingReleaseParams{
+		ID:             sch.ID,
+		ReleasedAmount: released,
+		PenaltyAmount:  sch.PenaltyAmount,
+		Status:         status,
+	}); err != nil {
+		return decimal.Zero, fmt.Errorf("failed to update vesting schedule: %w", err)
+	}
+	return releasable, nil
+}
+
+// ReleaseDue releases the vested part of every active schedule as of now
+// and returns how many schedules released something. Each schedule is
+// released in its own transaction; a failure is logged and retried on the
+// next run.
+func (s *VestingService) ReleaseDue(ctx context.Context, now time.Time) (int, error) {
+	released := 0
+	after := uuid.Nil
+	for {
+		ids, err := s.queries.GetActiveVestingScheduleIDs(ctx, db.GetActiveVestingScheduleIDsParams{
+			AsOf:     now,
+			AfterID:  after,
+			RowLimit: vestingReleaseBatch,
+		})
+		if err != nil {
+			return released, fmt.Errorf("failed to list vesting schedules: %w", err)
+		}
+
+		for _, id := range ids {
+			var amount decimal.Decimal
+			err := s.tx.WithTx(ctx, func(q *db.Queries) error {
+				sch, err := q.LockVestingSchedule(ctx, id)
+				if err != nil {
+					return fmt.Errorf("failed to lock vesting schedule: %w", err)
+				}
+				if sch.Status != vestingActive {
+					return nil
+				}
+				amount, err = s.releaseTx(ctx, q, sch, now)
+				return err
+			})
+			if err != nil {
+				if ctx.Err() != nil {
+					return released, ctx.Err()
+				}
+				log.Printf("vesting: release of %s failed: %v", id, err)
+				continue
+			}
+			if amount.IsPositive() {
+				released++
+			}
+		}
+
+		if len(ids) < vestingReleaseBatch {
+			return released, nil
+		}
+		after = ids[len(ids)-1]
+	}
+}
+
+// RunReleases releases vested amounts immediately and then every
+// ReleaseInterval until ctx is cancelled.
+func (s *VestingService) RunReleases(ctx context.Context) {
+	interval := s.cfg.Vesting.ReleaseInterval
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.ReleaseDue(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("vesting: release run failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// VestingUnlock is the outcome of an early unlock.
+type VestingUnlock struct {
+	ScheduleID uuid.UUID       `json:"schedule_id"`
+	Released   decimal.Decimal `json:"released"`
+	Penalty    decimal.Decimal `json:"penalty"`
+}
+
+// EarlyUnlock releases everything still locked in a user's schedule. The
+// part that has already vested is released in full; the penalty share of
+// the unvested rest is burned.
+func (s *VestingService) EarlyUnlock(ctx context.Context, userID, scheduleID uuid.UUID) (*VestingUnlock, error) {
+	penaltyBps := s.cfg.Vesting.EarlyUnlockPenaltyBps
+	if penaltyBps < 0 {
+		return nil, ErrEarlyUnlockDisabled
+	}
+
+	var result *VestingUnlock
+	err := s.tx.WithTx(ctx, func(q *db.Queries) error {
+		sch, err := q.LockVestingSchedule(ctx, scheduleID)
+		if err != nil || sch.UserID != userID || sch.Status != vestingActive {
+			return ErrVestingNotFound
+		}
+
+		now := time.Now()
+		vested, err := s.releaseTx(ctx, q, sch, now)
+		if err != nil {
+			return err
+		}
+		sch.ReleasedAmount = sch.ReleasedAmount.Add(vested)
+
+		unvested := sch.Amount.Sub(sch.ReleasedAmount)
+		penalty := divDown(unvested.Mul(decimal.NewFromInt(int64(penaltyBps))), bpsDenominator)
+		rest := unvested.Sub(penalty)
+
+		entry := JournalEntry{
+			Type:        "vesting_early_unlock",
+			ReferenceID: &sch.ID,
+			Description: fmt.Sprintf("%s early unlock", sch.Source),
+		}
+		entry.Add(Transfer(VestingAccount(userID, sch.TokenID), UserAccount(userID, sch.TokenID), rest)...)
+		entry.Add(Transfer(VestingAccount(userID, sch.TokenID), SystemAccount(AccountBurn, sch.TokenID), penalty)...)
+		if unvested.IsPositive() {
+			if _, err := s.ledger.PostTx(ctx, q, entry); err != nil {
+				return err
+			}
+		}
+
+		if err := q.UpdateVestingRelease(ctx, db.UpdateVestingReleaseParams{
+			ID:             sch.ID,
+			ReleasedAmount: sch.ReleasedAmount.Add(rest),
+			PenaltyAmount:  penalty,
+			Status:         vestingUnlocked,
+		}); err != nil {
+			return fmt.Errorf("failed to update vesting schedule: %w", err)
+		}
+
+		result = &VestingUnlock{
+			ScheduleID: sch.ID,
+			Released:   vested.Add(rest),
+			Penalty:    penalty,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ---------------------------------------------------------------------
+// Queries
+// ---------------------------------------------------------------------
+
+// VestingScheduleDetail is a schedule with its progress as of now.
+type VestingScheduleDetail struct {
+	db.GetUserVestingSchedulesRow
+	Vested decimal.Decimal `json:"vested"`
+	Locked decimal.Decimal `json:"locked"` // not yet released to the balance
+}
+
+// GetUserVestingSchedules lists a user's schedules, optionally filtered by
+// status, newest first.
+func (s *VestingService) GetUserVestingSchedules(ctx context.Context, userID uuid.UUID, status string) ([]VestingScheduleDetail, error) {
+	var st *string
+	if status != "" {
+		st = &status
+	}
+	rows, err := s.queries.GetUserVestingSchedules(ctx, db.GetUserVestingSchedulesParams{
+		UserID: userID,
+		Status: st,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch vesting schedules: %w", err)
+	}
+
+	now := time.Now()
+	details := make([]VestingScheduleDetail, len(rows))
+	for i, row := range rows {
+		sch := db.VestingSchedule{
+			Amount:  row.Amount,
+			StartAt: row.StartAt,
+			EndAt:   row.EndAt,
+		}
+		vested := vestedAmount(sch, now)
+		if row.Status != vestingActive {
+			vested = row.Amount
+		}
+		details[i] = VestingScheduleDetail{
+			GetUserVestingSchedulesRow: row,
+			Vested:                     vested,
+			Locked:                     row.Amount.Sub(row.ReleasedAmount).Sub(row.PenaltyAmount),
+		}
+	}
+	return details, nil
+}
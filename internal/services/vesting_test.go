@@ -0,0 +1,89 @@
+// internal/services/vesting_test.go
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"jd7008911/canlan.org/internal/db"
+)
+
+func TestVestedAmount(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(100 * time.Hour)
+	schedule := func(amount string) db.VestingSchedule {
+		return db.VestingSchedule{Amount: dec(amount), StartAt: start, EndAt: end}
+	}
+
+	tests := []struct {
+		name   string
+		amount string
+		now    time.Time
+		want   string
+	}{
+		{"before start", "1000", start.Add(-time.Hour), "0"},
+		{"at start", "1000", start, "0"},
+		{"a quarter through", "1000", start.Add(25 * time.Hour), "250"},
+		{"halfway", "1000", start.Add(50 * time.Hour), "500"},
+		{"at end", "1000", end, "1000"},
+		{"after end", "1000", end.Add(time.Hour), "1000"},
+		{"one nanosecond in", "1000", start.Add(time.Nanosecond), "0.000000000002777777"},
+		{"a third through, truncated", "1", start.Add(100 * time.Hour / 3), "0.333333333333333333"},
+		// Rounding to nearest here would vest more than the schedule holds
+		{"dust near the end", "0.000000000000000099", end.Add(-time.Hour), "0.000000000000000098"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := vestedAmount(schedule(tt.amount), tt.now); !got.Equal(dec(tt.want)) {
+				t.Errorf("vestedAmount() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestVestingReleasesSumToAmount releases a schedule at uneven intervals
+// and checks the releases never decrease, never exceed the amount and add
+// up to it exactly at the end.
+func TestVestingReleasesSumToAmount(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sch := db.VestingSchedule{Amount: dec("1234.567890123456789"), StartAt: start, EndAt: start.Add(30 * 24 * time.Hour)}
+
+	released := decimal.Zero
+	for now := start.Add(-time.Hour); now.Before(sch.EndAt.Add(2 * time.Hour)); now = now.Add(7*time.Hour + 13*time.Minute) {
+		releasable := vestedAmount(sch, now).Sub(released)
+		if releasable.IsNegative() {
+			t.Fatalf("at %s: vested amount went down by %s", now, releasable.Neg())
+		}
+		released = released.Add(releasable)
+		if released.GreaterThan(sch.Amount) {
+			t.Fatalf("at %s: released %s of %s", now, released, sch.Amount)
+		}
+	}
+	if !released.Equal(sch.Amount) {
+		t.Errorf("released %s after the end, want %s", released, sch.Amount)
+	}
+}
+
+func TestSplitPayout(t *testing.T) {
+	tests := []struct {
+		amount                  string
+		immediateBps            int
+		wantImmediate, wantLock string
+	}{
+		{"100", 10000, "100", "0"},
+		{"100", 0, "0", "100"},
+		{"100", 2500, "25", "75"},
+		{"0.000000000000000003", 5000, "0.000000000000000001", "0.000000000000000002"},
+		{"0.00000000000000015", 5000, "0.000000000000000075", "0.000000000000000075"},
+	}
+	for _, tt := range tests {
+		immediate, locked := splitPayout(dec(tt.amount), tt.immediateBps)
+		if !immediate.Equal(dec(tt.wantImmediate)) || !locked.Equal(dec(tt.wantLock)) {
+			t.Errorf("splitPayout(%s, %d) = %s, %s; want %s, %s", tt.amount, tt.immediateBps, immediate, locked, tt.wantImmediate, tt.wantLock)
+		}
+		if !immediate.Add(locked).Equal(dec(tt.amount)) {
+			t.Errorf("splitPayout(%s, %d) parts do not add up", tt.amount, tt.immediateBps)
+		}
+	}
+}
@@ -13,14 +13,18 @@ import (
 // WithdrawalService handles all withdrawal-related business logic.
 type WithdrawalService struct {
 	queries  *db.Queries
+	tx       db.TxRunner
 	assetSvc *AssetService
+	ledger   *LedgerService
 }
 
 // NewWithdrawalService creates a new withdrawal service.
-func NewWithdrawalService(queries *db.Queries, assetSvc *AssetService) *WithdrawalService {
+func NewWithdrawalService(queries *db.Queries, tx db.TxRunner, assetSvc *AssetService, ledger *LedgerService) *WithdrawalService {
 	return &WithdrawalService{
 		queries:  queries,
+		tx:       tx,
 		assetSvc: assetSvc,
+		ledger:   ledger,
 	}
 }
 
@@ -36,6 +40,7 @@ type WithdrawalRequestParams struct {
 }
 
 // CreateWithdrawalRequest creates a new pending withdrawal after validating balance and limits.
+// The balance check, limit check, request and fund lock happen in one transaction.
 func (s *WithdrawalService) CreateWithdrawalRequest(ctx context.Context, params WithdrawalRequestParams) (*db.Withdrawal, error) {
 	// 1. Validate token exists
 	token, err := s.queries.GetTokenBySymbol(ctx, params.TokenSymbol)
@@ -43,67 +48,70 @@ func (s *WithdrawalService) CreateWithdrawalRequest(ctx context.Context, params
 		return nil, fmt.Errorf("token not supported: %s", params.TokenSymbol)
 	}
 
-	// 2. Check user's balance
-	balance, err := s.assetSvc.GetUserTokenBalance(ctx, params.UserID, params.TokenSymbol)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch balance: %w", err)
-	}
-	if balance.LessThan(params.Amount) {
-		return nil, fmt.Errorf("insufficient %s balance: have %s, need %s",
-			params.TokenSymbol, balance.String(), params.Amount.String())
-	}
-
-	// 3. Ensure withdrawal limits are up‑to‑date (reset if needed)
-	if err := s.resetLimitsIfNeeded(ctx, params.UserID); err != nil {
-		return nil, fmt.Errorf("failed to update limits: %w", err)
-	}
+	var withdrawal db.Withdrawal
+	err = s.tx.WithTx(ctx, func(q *db.Queries) error {
+		// 2. Lock and check user's balance
+		balances, err := lockBalances(ctx, q, params.UserID, token.ID)
+		if err != nil {
+			return err
+		}
+		if err := requireBalance(balances, token.ID, params.TokenSymbol, params.Amount); err != nil {
+			return err
+		}
 
-	// 4. Check withdrawal limits
-	ok, err := s.queries.CheckWithdrawalLimit(ctx, db.CheckWithdrawalLimitParams{
-		UserID: params.UserID,
-		Amount: params.Amount,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to check withdrawal limit: %w", err)
-	}
-	if !ok {
-		// Get remaining limits for better error message
-		limits, _ := s.queries.GetRemainingWithdrawalLimits(ctx, params.UserID)
-		return nil, fmt.Errorf("withdrawal exceeds daily/monthly limit (daily left: %s, monthly left: %s)",
-			limits.RemainingDaily.String(), limits.RemainingMonthly.String())
-	}
+		// 3. Ensure withdrawal limits are up‑to‑date (reset if needed)
+		if err := s.resetLimitsIfNeeded(ctx, q, params.UserID); err != nil {
+			return fmt.Errorf("failed to update limits: %w", err)
+		}
 
-	// 5. Deduct balance immediately (if you want to lock funds; alternatively, deduct on approval)
-	err = s.queries.SubtractUserBalance(ctx, db.SubtractUserBalanceParams{
-		UserID:  params.UserID,
-		TokenID: token.ID,
-		Balance: params.Amount,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to deduct balance: %w", err)
-	}
+		// 4. Check withdrawal limits
+		ok, err := q.CheckWithdrawalLimit(ctx, db.CheckWithdrawalLimitParams{
+			UserID: params.UserID,
+			Amount: params.Amount,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to check withdrawal limit: %w", err)
+		}
+		if !ok {
+			// Get remaining limits for better error message
+			limits, _ := q.GetRemainingWithdrawalLimits(ctx, params.UserID)
+			return fmt.Errorf("withdrawal exceeds daily/monthly limit (daily left: %s, monthly left: %s)",
+				limits.RemainingDaily.String(), limits.RemainingMonthly.String())
+		}
 
-	// 6. Create withdrawal request
-	withdrawal, err := s.queries.CreateWithdrawal(ctx, db.CreateWithdrawalParams{
-		UserID:  params.UserID,
-		TokenID: token.ID,
-		Amount:  params.Amount,
-	})
-	if err != nil {
-		// Attempt to rollback the balance deduction
-		_ = s.queries.AddUserBalance(ctx, db.AddUserBalanceParams{
+		// 5. Create withdrawal request
+		withdrawal, err = q.CreateWithdrawal(ctx, db.CreateWithdrawalParams{
 			UserID:  params.UserID,
 			TokenID: token.ID,
-			Balance: params.Amount,
+			Amount:  params.Amount,
 		})
-		return nil, fmt.Errorf("failed to create withdrawal request: %w", err)
-	}
+		if err != nil {
+			return fmt.Errorf("failed to create withdrawal request: %w", err)
+		}
 
-	// 7. Increment usage counters (daily/monthly)
-	_ = s.queries.IncrementWithdrawalUsage(ctx, db.IncrementWithdrawalUsageParams{
-		UserID: params.UserID,
-		Amount: params.Amount,
+		// 6. Lock the funds in the clearing account until the withdrawal is approved or rejected
+		entry := JournalEntry{
+			Type:        "withdrawal",
+			ReferenceID: &withdrawal.ID,
+			Description: "withdrawal requested",
+		}
+		entry.Add(Transfer(UserAccount(params.UserID, token.ID), SystemAccount(AccountClearing, token.ID), params.Amount)...)
+		if _, err := s.ledger.PostTx(ctx, q, entry); err != nil {
+			return fmt.Errorf("failed to deduct balance: %w", err)
+		}
+
+		// 7. Increment usage counters (daily/monthly)
+		if err := q.IncrementWithdrawalUsage(ctx, db.IncrementWithdrawalUsageParams{
+			UserID: params.UserID,
+			Amount: params.Amount,
+		}); err != nil {
+			return fmt.Errorf("failed to record withdrawal usage: %w", err)
+		}
+		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
 
 	return &withdrawal, nil
 }
@@ -114,60 +122,90 @@ func (s *WithdrawalService) CreateWithdrawalRequest(ctx context.Context, params
 
 // ApproveWithdrawal marks a pending withdrawal as completed and records the transaction hash.
 func (s *WithdrawalService) ApproveWithdrawal(ctx context.Context, withdrawalID uuid.UUID, txHash string) error {
-	withdrawal, err := s.queries.GetWithdrawalByID(ctx, withdrawalID)
-	if err != nil {
-		return fmt.Errorf("withdrawal not found: %w", err)
-	}
-	if withdrawal.Status != "pending" {
-		return fmt.Errorf("withdrawal is not pending (status: %s)", withdrawal.Status)
-	}
+	return s.tx.WithTx(ctx, func(q *db.Queries) error {
+		withdrawal, err := q.GetWithdrawalForUpdate(ctx, withdrawalID)
+		if err != nil {
+			return fmt.Errorf("withdrawal not found: %w", err)
+		}
+		if withdrawal.Status != "pending" {
+			return fmt.Errorf("withdrawal is not pending (status: %s)", withdrawal.Status)
+		}
 
-	// Update status to completed
-	err = s.queries.UpdateWithdrawalStatus(ctx, db.UpdateWithdrawalStatusParams{
-		ID:     withdrawalID,
-		Status: "completed",
-		TxHash: &txHash,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to update withdrawal status: %w", err)
-	}
+		// Release the locked funds from clearing to the chain
+		entry := JournalEntry{
+			Type:        "withdrawal_settled",
+			ReferenceID: &withdrawal.ID,
+			Description: fmt.Sprintf("withdrawal sent on chain (tx %s)", txHash),
+		}
+		entry.Add(Transfer(SystemAccount(AccountClearing, withdrawal.TokenID), SystemAccount(AccountExternal, withdrawal.TokenID), withdrawal.Amount)...)
+		if _, err := s.ledger.PostTx(ctx, q, entry); err != nil {
+			return fmt.Errorf("failed to settle withdrawal: %w", err)
+		}
 
-	return nil
+		// Update status to completed
+		if err := q.UpdateWithdrawalStatus(ctx, db.UpdateWithdrawalStatusParams{
+			ID:     withdrawalID,
+			Status: "completed",
+			TxHash: &txHash,
+		}); err != nil {
+			return fmt.Errorf("failed to update withdrawal status: %w", err)
+		}
+		return nil
+	})
 }
 
 // RejectWithdrawal cancels a pending withdrawal and refunds the user's balance.
 func (s *WithdrawalService) RejectWithdrawal(ctx context.Context, withdrawalID uuid.UUID, reason string) error {
-	withdrawal, err := s.queries.GetWithdrawalByID(ctx, withdrawalID)
-	if err != nil {
-		return fmt.Errorf("withdrawal not found: %w", err)
-	}
+	return s.tx.WithTx(ctx, func(q *db.Queries) error {
+		withdrawal, err := q.GetWithdrawalForUpdate(ctx, withdrawalID)
+		if err != nil {
+			return fmt.Errorf("withdrawal not found: %w", err)
+		}
+		return s.refundTx(ctx, q, withdrawal, "withdrawal rejected: "+reason)
+	})
+}
+
+// CancelWithdrawal lets a user withdraw their own pending request and
+// refunds the locked balance.
+func (s *WithdrawalService) CancelWithdrawal(ctx context.Context, withdrawalID, userID uuid.UUID) error {
+	return s.tx.WithTx(ctx, func(q *db.Queries) error {
+		withdrawal, err := q.GetWithdrawalForUpdate(ctx, withdrawalID)
+		if err != nil || withdrawal.UserID != userID {
+			return fmt.Errorf("withdrawal not found: %w", err)
+		}
+		return s.refundTx(ctx, q, withdrawal, "withdrawal cancelled by user")
+	})
+}
+
+// refundTx returns a locked pending withdrawal's funds from the clearing
+// account to the user and marks it cancelled.
+func (s *WithdrawalService) refundTx(ctx context.Context, q *db.Queries, withdrawal db.Withdrawal, description string) error {
 	if withdrawal.Status != "pending" {
 		return fmt.Errorf("withdrawal is not pending (status: %s)", withdrawal.Status)
 	}
 
-	// Refund the user's balance
-	err = s.queries.AddUserBalance(ctx, db.AddUserBalanceParams{
-		UserID:  withdrawal.UserID,
-		TokenID: withdrawal.TokenID,
-		Balance: withdrawal.Amount,
-	})
-	if err != nil {
+	// Refund the user's balance from the clearing account
+	entry := JournalEntry{
+		Type:        "withdrawal_refund",
+		ReferenceID: &withdrawal.ID,
+		Description: description,
+	}
+	entry.Add(Transfer(SystemAccount(AccountClearing, withdrawal.TokenID), UserAccount(withdrawal.UserID, withdrawal.TokenID), withdrawal.Amount)...)
+	if _, err := s.ledger.PostTx(ctx, q, entry); err != nil {
 		return fmt.Errorf("failed to refund balance: %w", err)
 	}
 
 	// Update status to cancelled
-	err = s.queries.CancelWithdrawal(ctx, db.CancelWithdrawalParams{
-		ID:     withdrawalID,
+	if err := q.CancelWithdrawal(ctx, db.CancelWithdrawalParams{
+		ID:     withdrawal.ID,
 		UserID: withdrawal.UserID,
-	})
-	if err != nil {
+	}); err != nil {
 		return fmt.Errorf("failed to cancel withdrawal: %w", err)
 	}
 
 	// Decrement usage counters (since we already incremented on creation)
 	// This requires a negative increment query – we'll just not increment on rejection.
 	// In a real system, you might store the usage increment and roll it back.
-
 	return nil
 }
 
@@ -184,6 +222,20 @@ func (s *WithdrawalService) GetUserWithdrawals(ctx context.Context, userID uuid.
 	})
 }
 
+// GetUserWithdrawalByID returns a withdrawal, ensuring it belongs to the user.
+func (s *WithdrawalService) GetUserWithdrawalByID(ctx context.Context, withdrawalID, userID uuid.UUID) (*db.Withdrawal, error) {
+	withdrawal, err := s.queries.GetWithdrawalByID(ctx, withdrawalID)
+	if err != nil || withdrawal.UserID != userID {
+		return nil, fmt.Errorf("withdrawal not found: %w", err)
+	}
+	return &withdrawal, nil
+}
+
+// CountUserWithdrawals returns the number of withdrawals a user has requested.
+func (s *WithdrawalService) CountUserWithdrawals(ctx context.Context, userID uuid.UUID) (int64, error) {
+	return s.queries.CountUserWithdrawals(ctx, userID)
+}
+
 // GetUserWithdrawalsByStatus returns withdrawals filtered by status.
 func (s *WithdrawalService) GetUserWithdrawalsByStatus(ctx context.Context, userID uuid.UUID, status string, limit, offset int32) ([]db.GetUserWithdrawalsByStatusRow, error) {
 	return s.queries.GetUserWithdrawalsByStatus(ctx, db.GetUserWithdrawalsByStatusParams{
@@ -202,6 +254,11 @@ func (s *WithdrawalService) ListPendingWithdrawals(ctx context.Context, limit, o
 	})
 }
 
+// CountPendingWithdrawals returns the number of withdrawals awaiting review.
+func (s *WithdrawalService) CountPendingWithdrawals(ctx context.Context) (int64, error) {
+	return s.queries.GetPendingWithdrawalsCount(ctx)
+}
+
 // ---------------------------------------------------------------------
 // Withdrawal Limits
 // ---------------------------------------------------------------------
@@ -223,7 +280,7 @@ func (s *WithdrawalService) GetWithdrawalLimits(ctx context.Context, userID uuid
 	}
 
 	// Reset if needed
-	s.resetLimitsIfNeeded(ctx, userID)
+	s.resetLimitsIfNeeded(ctx, s.queries, userID)
 
 	// Get remaining limits
 	limits, err := s.queries.GetRemainingWithdrawalLimits(ctx, userID)
@@ -243,14 +300,14 @@ func (s *WithdrawalService) UpdateWithdrawalLimits(ctx context.Context, userID u
 }
 
 // resetLimitsIfNeeded checks and resets daily/monthly counters if the period has rolled over.
-func (s *WithdrawalService) resetLimitsIfNeeded(ctx context.Context, userID uuid.UUID) error {
+func (s *WithdrawalService) resetLimitsIfNeeded(ctx context.Context, q *db.Queries, userID uuid.UUID) error {
 	// Reset daily if last reset was before today
-	err := s.queries.ResetDailyWithdrawalLimit(ctx, userID)
+	err := q.ResetDailyWithdrawalLimit(ctx, userID)
 	if err != nil {
 		return err
 	}
 	// Reset monthly if last reset was before this month
-	err = s.queries.ResetMonthlyWithdrawalLimit(ctx, userID)
+	err = q.ResetMonthlyWithdrawalLimit(ctx, userID)
 	if err != nil {
 		return err
 	}